@@ -17,6 +17,7 @@ import (
 	abcitypes "github.com/tendermint/tendermint/abci/types"
 
 	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+	"github.com/shutter-network/shutter/shuttermint/medley"
 	"github.com/shutter-network/shutter/shuttermint/shmsg"
 )
 
@@ -72,16 +73,17 @@ func (app *ShutterApp) CheckTx(req abcitypes.RequestCheckTx) abcitypes.ResponseC
 // NewShutterApp creates a new ShutterApp.
 func NewShutterApp() *ShutterApp {
 	return &ShutterApp{
-		Configs:         []*BatchConfig{{}},
-		BatchStates:     make(map[uint64]BatchState),
-		DKGMap:          make(map[uint64]*DKGInstance),
-		ConfigVoting:    NewConfigVoting(),
-		EonStartVotings: make(map[uint64]*EonStartVoting),
-		Identities:      make(map[common.Address]ValidatorPubkey),
-		StartedVotes:    make(map[common.Address]struct{}),
-		CheckTxState:    NewCheckTxState(),
-		NonceTracker:    NewNonceTracker(),
-		ChainID:         "", // will be set in InitChain
+		Configs:              []*BatchConfig{{}},
+		BatchStates:          make(map[uint64]BatchState),
+		DKGMap:               make(map[uint64]*DKGInstance),
+		ConfigVoting:         NewConfigVoting(),
+		EonStartVotings:      make(map[uint64]*EonStartVoting),
+		Identities:           make(map[common.Address]ValidatorPubkey),
+		PendingValidatorKeys: make(map[common.Address]PendingValidatorKey),
+		StartedVotes:         make(map[common.Address]struct{}),
+		CheckTxState:         NewCheckTxState(),
+		NonceTracker:         NewNonceTracker(),
+		ChainID:              "", // will be set in InitChain
 	}
 }
 
@@ -257,6 +259,9 @@ func (app *ShutterApp) InitChain(req abcitypes.RequestInitChain) abcitypes.Respo
 
 		app.CheckTxState = NewCheckTxState()
 		app.updateCheckTxMembers()
+		if genesisState.UpgradeHeight != 0 {
+			app.UpgradeHeight = genesisState.UpgradeHeight
+		}
 	} else if !reflect.DeepEqual(bc, *app.Configs[0]) {
 		log.Fatalf("Mismatch between stored app state and initial app state, stored=%+v initial=%+v", app.Configs[0], bc)
 	}
@@ -291,7 +296,35 @@ func (ShutterApp) decodeTx(tx []byte) (signer common.Address, msg *shmsg.Message
 	return
 }
 
+// DeliverTx processes the given transaction. Once upgraded, the data field of the result holds
+// the hash of the events generated, so that keypers can verify them.
 func (app *ShutterApp) DeliverTx(req abcitypes.RequestDeliverTx) abcitypes.ResponseDeliverTx {
+	res := app.deliverTx(req)
+	if app.isUpgraded() {
+		res.Data = shutterevents.EventsHash(res.Events)
+	}
+	return res
+}
+
+// isUpgraded checks if the block currently being processed is at or after the upgrade height.
+func (app *ShutterApp) isUpgraded() bool {
+	return app.UpgradeHeight != 0 && app.LastBlockHeight+1 >= app.UpgradeHeight
+}
+
+// SetUpgradeHeight sets the upgrade height of an app loaded from disk. All nodes of a chain must
+// use the same one. It fails if the app already has a different one.
+func (app *ShutterApp) SetUpgradeHeight(height int64) error {
+	if app.UpgradeHeight != 0 && app.UpgradeHeight != height {
+		return errors.Errorf("app has been upgraded at height %d already", app.UpgradeHeight)
+	}
+	if app.UpgradeHeight == 0 {
+		log.Printf("Upgrading at height %d", height)
+	}
+	app.UpgradeHeight = height
+	return nil
+}
+
+func (app *ShutterApp) deliverTx(req abcitypes.RequestDeliverTx) abcitypes.ResponseDeliverTx {
 	signer, msg, err := app.decodeTx(req.Tx)
 	if err != nil {
 		msg := fmt.Sprintf("Error while decoding transaction: %s", err)
@@ -369,12 +402,9 @@ func (app *ShutterApp) deliverBatchConfig(msg *shmsg.BatchConfig, sender common.
 
 		events = append(events, bc.MakeABCIEvent())
 		if app.ShouldStartDKG(bc) {
-			dkg := app.StartDKG(bc)
 			batchIndex := app.LastConfig().StartBatchIndex
-			events = append(events, shutterevents.EonStarted{
-				Eon:        dkg.Eon,
-				BatchIndex: batchIndex,
-			}.MakeABCIEvent())
+			dkg := app.StartDKG(bc, batchIndex)
+			events = append(events, app.eonStartedEvent(dkg, batchIndex))
 		}
 	}
 
@@ -415,17 +445,112 @@ func (app *ShutterApp) deliverCheckIn(msg *shmsg.CheckIn, sender common.Address)
 		return makeErrorResponse(fmt.Sprintf("malformed encryption public key: %s", err))
 	}
 	encryptionPublicKey := ecies.ImportECDSAPublic(encryptionPublicKeyECDSA)
+	// Before the upgrade, keypers were allowed to share a validator key
+	if owner, ok := app.validatorKeyOwner(validatorPublicKey, sender); ok && app.isUpgraded() {
+		return makeErrorResponse(fmt.Sprintf(
+			"validator key already registered to keyper %s", owner.Hex()))
+	}
 
 	app.Identities[sender] = validatorPublicKey
 
+	ev := shutterevents.CheckIn{
+		Sender:              sender,
+		EncryptionPublicKey: encryptionPublicKey,
+	}
+	if app.isUpgraded() {
+		ev.ValidatorPublicKey = msg.ValidatorPublicKey
+	}
 	return abcitypes.ResponseDeliverTx{
-		Code: 0,
-		Events: []abcitypes.Event{
-			shutterevents.CheckIn{
-				Sender:              sender,
-				EncryptionPublicKey: encryptionPublicKey,
-			}.MakeABCIEvent(),
-		},
+		Code:   0,
+		Events: []abcitypes.Event{ev.MakeABCIEvent()},
+	}
+}
+
+func (app *ShutterApp) deliverKeyRotation(msg *shmsg.KeyRotation, sender common.Address) abcitypes.ResponseDeliverTx {
+	// Before the upgrade, key rotations were rejected as unknown messages
+	if !app.isUpgraded() {
+		return makeErrorResponse("key rotations are not supported before the upgrade")
+	}
+	if _, ok := app.Identities[sender]; !ok {
+		return makeErrorResponse(fmt.Sprintf("sender %s has not checked in yet", sender.Hex()))
+	}
+	if !app.isKeyper(sender) {
+		return notAKeyper(sender)
+	}
+	activationHeight := int64(msg.ActivationHeight)
+	if activationHeight <= app.LastBlockHeight+1 {
+		return makeErrorResponse(fmt.Sprintf(
+			"activation height %d is not in the future", msg.ActivationHeight))
+	}
+	if len(msg.ValidatorPublicKey) == 0 && len(msg.EncryptionPublicKey) == 0 {
+		return makeErrorResponse("no key to rotate")
+	}
+
+	ev := shutterevents.KeyRotation{
+		Sender:           sender,
+		ActivationHeight: activationHeight,
+	}
+	if len(msg.EncryptionPublicKey) > 0 {
+		encryptionPublicKeyECDSA, err := crypto.DecompressPubkey(msg.EncryptionPublicKey)
+		if err != nil {
+			return makeErrorResponse(fmt.Sprintf("malformed encryption public key: %s", err))
+		}
+		ev.EncryptionPublicKey = ecies.ImportECDSAPublic(encryptionPublicKeyECDSA)
+	}
+	if len(msg.ValidatorPublicKey) > 0 {
+		validatorPublicKey, err := NewValidatorPubkey(msg.ValidatorPublicKey)
+		if err != nil {
+			return makeErrorResponse(fmt.Sprintf(
+				"malformed validator public key: %s", err))
+		}
+		if owner, ok := app.validatorKeyOwner(validatorPublicKey, sender); ok {
+			return makeErrorResponse(fmt.Sprintf(
+				"validator key already registered to keyper %s", owner.Hex()))
+		}
+		if app.PendingValidatorKeys == nil {
+			app.PendingValidatorKeys = make(map[common.Address]PendingValidatorKey) // state from before key rotations existed
+		}
+		// a new rotation replaces a pending one
+		app.PendingValidatorKeys[sender] = PendingValidatorKey{
+			ActivationHeight: activationHeight,
+			Pubkey:           validatorPublicKey,
+		}
+		ev.ValidatorPublicKey = msg.ValidatorPublicKey
+	}
+
+	return abcitypes.ResponseDeliverTx{
+		Code:   0,
+		Events: []abcitypes.Event{ev.MakeABCIEvent()},
+	}
+}
+
+// validatorKeyOwner returns a keyper other than the given one that has checked in with or rotated
+// to the given validator key, if there is one.
+func (app *ShutterApp) validatorKeyOwner(key ValidatorPubkey, sender common.Address) (common.Address, bool) {
+	for keyper, k := range app.Identities {
+		if keyper != sender && k == key {
+			return keyper, true
+		}
+	}
+	for keyper, pending := range app.PendingValidatorKeys {
+		if keyper != sender && pending.Pubkey == key {
+			return keyper, true
+		}
+	}
+	return common.Address{}, false
+}
+
+// activateValidatorKeys replaces the validator keys of the keypers whose scheduled key rotation
+// becomes active at the given height. It's called at the end of the block, so tendermint uses the
+// new keys to sign from two blocks later on.
+func (app *ShutterApp) activateValidatorKeys(height int64) {
+	for sender, pending := range app.PendingValidatorKeys {
+		if pending.ActivationHeight > height {
+			continue
+		}
+		log.Printf("Activating new validator key %s of keyper %s", pending.Pubkey, sender.Hex())
+		app.Identities[sender] = pending.Pubkey
+		delete(app.PendingValidatorKeys, sender)
 	}
 }
 
@@ -460,6 +585,18 @@ func (app *ShutterApp) deliverEonStartVoteMsg(msg *shmsg.EonStartVote, sender co
 	if !config.IsKeyper(sender) {
 		return notAKeyper(sender)
 	}
+	// Before the upgrade, neither the start batch index nor the time between retries was
+	// checked, so these votes must still be accepted there
+	dkg := app.DKGMap[app.EONCounter]
+	if app.isUpgraded() && dkg != nil && msg.StartBatchIndex < dkg.StartBatchIndex {
+		return makeErrorResponse(fmt.Sprintf(
+			"cannot start eon at batch %d before the start of the current eon at batch %d",
+			msg.StartBatchIndex, dkg.StartBatchIndex))
+	}
+	if wait := app.eonRetryWait(config, msg.StartBatchIndex); wait > 0 && app.isUpgraded() {
+		return makeErrorResponse(fmt.Sprintf(
+			"cannot start another eon for config %d yet, retry in %d blocks", config.ConfigIndex, wait))
+	}
 
 	app.countEonStartVote(sender, config, msg.StartBatchIndex)
 	dkg, startBatchIndex, started := app.maybeStartEon(config)
@@ -470,14 +607,39 @@ func (app *ShutterApp) deliverEonStartVoteMsg(msg *shmsg.EonStartVote, sender co
 		}
 	}
 	return abcitypes.ResponseDeliverTx{
-		Code: 0,
-		Events: []abcitypes.Event{
-			shutterevents.EonStarted{
-				Eon:        dkg.Eon,
-				BatchIndex: startBatchIndex,
-			}.MakeABCIEvent(),
-		},
+		Code:   0,
+		Events: []abcitypes.Event{app.eonStartedEvent(dkg, startBatchIndex)},
+	}
+}
+
+// eonStartedEvent creates the event for the given eon, which has been started at startBatchIndex.
+// The attempt is only included once upgraded, since the events before didn't have it.
+func (app *ShutterApp) eonStartedEvent(dkg *DKGInstance, startBatchIndex uint64) abcitypes.Event {
+	ev := shutterevents.EonStarted{
+		Eon:        dkg.Eon,
+		BatchIndex: startBatchIndex,
+	}
+	if app.isUpgraded() {
+		ev.Attempt = dkg.Attempt
 	}
+	return ev.MakeABCIEvent()
+}
+
+// eonRetryWait returns the number of blocks to wait before another eon can be started for the
+// given config and start batch index. Eons for the same config and start batch index are only
+// restarted if their DKG failed, with the delay between attempts given by medley.EonRetryDelay.
+// Eons with a later start batch index are rotations of the eon key and can be started at any time.
+func (app *ShutterApp) eonRetryWait(config *BatchConfig, startBatchIndex uint64) int64 {
+	dkg := app.DKGMap[app.EONCounter]
+	if !dkg.isRetry(config, startBatchIndex) {
+		return 0
+	}
+	height := app.LastBlockHeight + 1
+	retryHeight := dkg.StartHeight + medley.EonRetryDelay(dkg.Attempt)
+	if height >= retryHeight {
+		return 0
+	}
+	return retryHeight - height
 }
 
 func (app *ShutterApp) countEonStartVote(sender common.Address, config *BatchConfig, startBatchIndex uint64) {
@@ -502,7 +664,7 @@ func (app *ShutterApp) maybeStartEon(config *BatchConfig) (*DKGInstance, uint64,
 	}
 
 	delete(app.EonStartVotings, config.ConfigIndex)
-	dkg := app.StartDKG(*config)
+	dkg := app.StartDKG(*config, startBatchIndex)
 	return dkg, startBatchIndex, true
 }
 
@@ -668,6 +830,9 @@ func (app *ShutterApp) deliverMessage(msg *shmsg.Message, sender common.Address)
 	if msg.GetCheckIn() != nil {
 		return app.deliverCheckIn(msg.GetCheckIn(), sender)
 	}
+	if msg.GetKeyRotation() != nil {
+		return app.deliverKeyRotation(msg.GetKeyRotation(), sender)
+	}
 	if msg.GetEonStartVote() != nil {
 		return app.deliverEonStartVoteMsg(msg.GetEonStartVote(), sender)
 	}
@@ -708,9 +873,17 @@ func (app *ShutterApp) ShouldStartDKG(config BatchConfig) bool {
 	return !reflect.DeepEqual(previousConfig.Keypers, config.Keypers)
 }
 
-func (app *ShutterApp) StartDKG(config BatchConfig) *DKGInstance {
+// StartDKG starts a new eon for the given config, whose first batch is startBatchIndex.
+func (app *ShutterApp) StartDKG(config BatchConfig, startBatchIndex uint64) *DKGInstance {
+	var attempt uint64
+	if previous := app.DKGMap[app.EONCounter]; previous.isRetry(&config, startBatchIndex) {
+		attempt = previous.Attempt + 1
+	}
 	app.EONCounter++
 	dkg := NewDKGInstance(config, app.EONCounter)
+	dkg.StartBatchIndex = startBatchIndex
+	dkg.StartHeight = app.LastBlockHeight + 1
+	dkg.Attempt = attempt
 	app.DKGMap[dkg.Eon] = &dkg
 	return &dkg
 }
@@ -777,6 +950,10 @@ func (app *ShutterApp) EndBlock(req abcitypes.RequestEndBlock) abcitypes.Respons
 		lastConfig.ValidatorsUpdated = true
 	}
 
+	// the validator updates we return here are applied by tendermint at req.Height+2
+	if app.isUpgraded() {
+		app.activateValidatorKeys(req.Height)
+	}
 	newValidators := app.CurrentValidators()
 	validatorUpdates := DiffPowermaps(app.Validators, newValidators).ValidatorUpdates()
 	app.Validators = newValidators
@@ -5,10 +5,14 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
 	"gotest.tools/v3/assert"
 	is "gotest.tools/v3/assert/cmp"
 
 	"github.com/shutter-network/shutter/shlib/shtest"
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+	"github.com/shutter-network/shutter/shuttermint/medley"
 	"github.com/shutter-network/shutter/shuttermint/shmsg"
 )
 
@@ -186,3 +190,158 @@ func TestGobDKG(t *testing.T) {
 
 	shtest.EnsureGobable(t, &dkg, new(DKGInstance))
 }
+
+func TestEonRetry(t *testing.T) {
+	app := NewShutterApp()
+	app.UpgradeHeight = 1
+	config := BatchConfig{
+		ConfigIndex:     1,
+		StartBatchIndex: 100,
+		Threshold:       1,
+		Keypers:         addr,
+	}
+	err := app.addConfig(config)
+	assert.NilError(t, err)
+
+	app.LastBlockHeight = 9
+	dkg := app.StartDKG(config, 100)
+	assert.Equal(t, dkg.StartHeight, int64(10))
+	assert.Equal(t, dkg.Attempt, uint64(0))
+
+	vote := &shmsg.EonStartVote{StartBatchIndex: 100}
+	res := app.deliverEonStartVoteMsg(vote, addr[0])
+	assert.Assert(t, res.IsErr(), "vote before the retry delay should be rejected")
+
+	app.LastBlockHeight = 9 + medley.EonRetryDelay(0)
+	res = app.deliverEonStartVoteMsg(vote, addr[0])
+	assert.Assert(t, res.IsOK())
+	assert.Equal(t, len(res.Events), 1)
+	retry := app.DKGMap[app.EONCounter]
+	assert.Equal(t, retry.Attempt, uint64(1))
+	assert.Equal(t, app.eonRetryWait(&config, 100), medley.EonRetryDelay(1))
+	ev, err := shutterevents.MakeEvent(res.Events[0], app.LastBlockHeight+1)
+	assert.NilError(t, err)
+	assert.Equal(t, ev.(*shutterevents.EonStarted).Attempt, uint64(1))
+
+	// before the upgrade, retries are neither delayed nor counted in the events
+	app.UpgradeHeight = 0
+	res = app.deliverEonStartVoteMsg(vote, addr[0])
+	assert.Assert(t, res.IsOK())
+	assert.Equal(t, len(res.Events), 1)
+	assert.Equal(t, len(res.Events[0].Attributes), 2)
+}
+
+func TestEonRotation(t *testing.T) {
+	app := NewShutterApp()
+	app.UpgradeHeight = 1
+	config := BatchConfig{
+		ConfigIndex:     1,
+		StartBatchIndex: 100,
+		Threshold:       1,
+		Keypers:         addr,
+	}
+	err := app.addConfig(config)
+	assert.NilError(t, err)
+	app.StartDKG(config, 100)
+
+	// rotating the key for later batches doesn't have to wait for the retry delay
+	res := app.deliverEonStartVoteMsg(&shmsg.EonStartVote{StartBatchIndex: 200}, addr[0])
+	assert.Assert(t, res.IsOK())
+	assert.Equal(t, len(res.Events), 1)
+	dkg := app.DKGMap[app.EONCounter]
+	assert.Equal(t, dkg.StartBatchIndex, uint64(200))
+	assert.Equal(t, dkg.Attempt, uint64(0))
+
+	// eons cannot start before the current one, but before the upgrade, this wasn't checked
+	res = app.deliverEonStartVoteMsg(&shmsg.EonStartVote{StartBatchIndex: 150}, addr[0])
+	assert.Assert(t, res.IsErr())
+	app.UpgradeHeight = 0
+	res = app.deliverEonStartVoteMsg(&shmsg.EonStartVote{StartBatchIndex: 150}, addr[0])
+	assert.Assert(t, res.IsOK())
+}
+
+func TestKeyRotation(t *testing.T) {
+	app := NewShutterApp()
+	keyper := addr[0]
+	err := app.addConfig(BatchConfig{
+		ConfigIndex:     1,
+		StartBatchIndex: 100,
+		Threshold:       1,
+		Keypers:         []common.Address{keyper, addr[1]},
+	})
+	assert.NilError(t, err)
+	encryptionKey, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	oldKey := newpk(1)
+	newKey := newpk(2)
+	app.LastBlockHeight = 10
+
+	rotation := shmsg.NewKeyRotation(20, makeKey(2), nil).GetKeyRotation()
+	res := app.deliverKeyRotation(rotation, keyper)
+	assert.Assert(t, res.IsErr(), "key rotations are only accepted after the upgrade")
+	app.UpgradeHeight = 1
+	res = app.deliverKeyRotation(rotation, keyper)
+	assert.Assert(t, res.IsErr(), "keypers must check in before rotating keys")
+
+	checkIn := shmsg.NewCheckIn(makeKey(1), ecies.ImportECDSAPublic(&encryptionKey.PublicKey)).GetCheckIn()
+	res = app.deliverCheckIn(checkIn, keyper)
+	assert.Assert(t, res.IsOK())
+
+	res = app.deliverKeyRotation(shmsg.NewKeyRotation(11, makeKey(2), nil).GetKeyRotation(), keyper)
+	assert.Assert(t, res.IsErr(), "activation height must be in the future")
+	res = app.deliverKeyRotation(shmsg.NewKeyRotation(20, nil, nil).GetKeyRotation(), keyper)
+	assert.Assert(t, res.IsErr(), "at least one key must be rotated")
+
+	res = app.deliverKeyRotation(rotation, keyper)
+	assert.Assert(t, res.IsOK())
+	assert.Equal(t, len(res.Events), 1)
+
+	// validator keys can't be shared, neither the checked in nor the scheduled ones
+	app.Identities[addr[1]] = newpk(3)
+	res = app.deliverKeyRotation(shmsg.NewKeyRotation(20, makeKey(3), nil).GetKeyRotation(), keyper)
+	assert.Assert(t, res.IsErr())
+	res = app.deliverKeyRotation(shmsg.NewKeyRotation(20, makeKey(2), nil).GetKeyRotation(), addr[1])
+	assert.Assert(t, res.IsErr())
+	delete(app.Identities, addr[1])
+
+	app.activateValidatorKeys(19)
+	assert.Equal(t, app.Identities[keyper], oldKey)
+	app.activateValidatorKeys(20)
+	assert.Equal(t, app.Identities[keyper], newKey)
+	assert.Equal(t, len(app.PendingValidatorKeys), 0)
+	assert.DeepEqual(t, app.makePowermap([]common.Address{keyper}), Powermap{newKey: 10})
+}
+
+func TestUpgradeHeight(t *testing.T) {
+	app := NewShutterApp()
+	keypers := addr[:2]
+	err := app.addConfig(BatchConfig{
+		ConfigIndex:     1,
+		StartBatchIndex: 100,
+		Threshold:       1,
+		Keypers:         keypers,
+	})
+	assert.NilError(t, err)
+	encryptionKey, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	checkIn := shmsg.NewCheckIn(makeKey(1), ecies.ImportECDSAPublic(&encryptionKey.PublicKey)).GetCheckIn()
+
+	// chains started before the upgrade keep the old tx results
+	app.LastBlockHeight = 10
+	assert.Assert(t, !app.isUpgraded())
+	res := app.deliverCheckIn(checkIn, keypers[0])
+	assert.Assert(t, res.IsOK())
+	assert.Equal(t, len(res.Events[0].Attributes), 2)
+
+	assert.NilError(t, app.SetUpgradeHeight(12))
+	assert.Assert(t, app.SetUpgradeHeight(13) != nil)
+	assert.Assert(t, !app.isUpgraded())
+	app.LastBlockHeight = 11
+	assert.Assert(t, app.isUpgraded())
+	res = app.deliverCheckIn(checkIn, keypers[1])
+	assert.Assert(t, res.IsErr(), "validator key already registered")
+	checkIn = shmsg.NewCheckIn(makeKey(2), ecies.ImportECDSAPublic(&encryptionKey.PublicKey)).GetCheckIn()
+	res = app.deliverCheckIn(checkIn, keypers[1])
+	assert.Assert(t, res.IsOK())
+	assert.Equal(t, len(res.Events[0].Attributes), 3)
+}
@@ -17,6 +17,12 @@ func NewDKGInstance(config BatchConfig, eon uint64) DKGInstance {
 	}
 }
 
+// isRetry checks if starting an eon for the given config and start batch index would retry this
+// instance, i.e. generate a key for the same batches again. It's safe to call on nil.
+func (dkg *DKGInstance) isRetry(config *BatchConfig, startBatchIndex uint64) bool {
+	return dkg != nil && dkg.Config.ConfigIndex == config.ConfigIndex && dkg.StartBatchIndex == startBatchIndex
+}
+
 // RegisterPolyEvalMsg adds a polynomial evaluation message to the instance. It makes sure the
 // message meets the basic requirements, i.e. the sender and receivers are keypers and we do not
 // send multiple messages from one sender to one receiver.
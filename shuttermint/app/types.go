@@ -14,14 +14,18 @@ import (
 
 // GenesisAppState is used to hold the initial list of keypers, who will bootstrap the system by
 // providing the first real BatchConfig to be used. We use common.MixedcaseAddress to hold the list
-// of keypers as that one serializes as checksum address.
+// of keypers as that one serializes as checksum address. UpgradeHeight is copied to the
+// ShutterApp, it's missing in the genesis files of chains started before it existed.
 type GenesisAppState struct {
-	Keypers   []common.MixedcaseAddress `json:"keypers"`
-	Threshold uint64                    `json:"threshold"`
+	Keypers       []common.MixedcaseAddress `json:"keypers"`
+	Threshold     uint64                    `json:"threshold"`
+	UpgradeHeight int64                     `json:"upgradeHeight,omitempty"`
 }
 
+// NewGenesisAppState creates the genesis app state of a new chain, on which the upgrade is active
+// right from the start.
 func NewGenesisAppState(keypers []common.Address, threshold int) GenesisAppState {
-	appState := GenesisAppState{Threshold: uint64(threshold)}
+	appState := GenesisAppState{Threshold: uint64(threshold), UpgradeHeight: 1}
 	for _, k := range keypers {
 		appState.Keypers = append(appState.Keypers, common.NewMixedcaseAddress(k))
 	}
@@ -92,22 +96,35 @@ func NewValidatorPubkey(pubkey []byte) (ValidatorPubkey, error) {
 
 // ShutterApp holds our data structures used for the tendermint app.
 type ShutterApp struct {
-	Configs         []*BatchConfig
-	BatchStates     map[uint64]BatchState
-	DKGMap          map[uint64]*DKGInstance
-	ConfigVoting    ConfigVoting
-	EonStartVotings map[uint64]*EonStartVoting
-	Gobpath         string
-	LastSaved       time.Time
-	LastBlockHeight int64
-	Identities      map[common.Address]ValidatorPubkey
-	StartedVotes    map[common.Address]struct{}
-	Validators      Powermap
-	EONCounter      uint64
-	DevMode         bool
-	CheckTxState    *CheckTxState
-	NonceTracker    *NonceTracker
-	ChainID         string
+	Configs              []*BatchConfig
+	BatchStates          map[uint64]BatchState
+	DKGMap               map[uint64]*DKGInstance
+	ConfigVoting         ConfigVoting
+	EonStartVotings      map[uint64]*EonStartVoting
+	Gobpath              string
+	LastSaved            time.Time
+	LastBlockHeight      int64
+	Identities           map[common.Address]ValidatorPubkey
+	PendingValidatorKeys map[common.Address]PendingValidatorKey
+	StartedVotes         map[common.Address]struct{}
+	Validators           Powermap
+	EONCounter           uint64
+	DevMode              bool
+	CheckTxState         *CheckTxState
+	NonceTracker         *NonceTracker
+	ChainID              string
+
+	// UpgradeHeight is the shuttermint block from which on the changes to the tx results and the
+	// validation rules that nodes of older versions don't know about apply. Zero means never, so
+	// that nodes replaying the blocks of a chain started before stay in consensus.
+	UpgradeHeight int64
+}
+
+// PendingValidatorKey is a validator key a keyper has scheduled to replace their current one from
+// ActivationHeight on.
+type PendingValidatorKey struct {
+	ActivationHeight int64
+	Pubkey           ValidatorPubkey
 }
 
 // CheckTxState is a part of the state used by CheckTx calls that is reset at every commit.
@@ -128,8 +145,11 @@ type SenderReceiverPair struct {
 
 // DKGInstance manages the state of one eon key generation instance.
 type DKGInstance struct {
-	Config BatchConfig
-	Eon    uint64
+	Config          BatchConfig
+	Eon             uint64
+	StartBatchIndex uint64 // first batch that belongs to the eon
+	StartHeight     int64  // shuttermint block at which the eon has been started
+	Attempt         uint64 // number of previous eons for the same config and batch whose DKG failed
 
 	PolyEvalsSeen       map[SenderReceiverPair]struct{}
 	PolyCommitmentsSeen map[common.Address]struct{}
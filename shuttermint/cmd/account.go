@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/pkg/errors"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper"
+	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
+	"github.com/shutter-network/shutter/shuttermint/medley"
+)
+
+// keyperAccount is used by commands that send transactions from the keyper's account outside of
+// the keyper itself.
+type keyperAccount struct {
+	config keyper.Config
+	client *ethclient.Client
+	caller contract.Caller
+}
+
+// openKeyperAccount reads the keyper config and connects to the keyper's Ethereum node.
+func openKeyperAccount(ctx context.Context) (*keyperAccount, error) {
+	kc, err := readKeyperConfig()
+	if err != nil {
+		return nil, errors.WithMessage(err, "Please check your configuration")
+	}
+	err = setupGasPricer(kc)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Please check your configuration")
+	}
+
+	client, err := ethclient.DialContext(ctx, kc.EthereumURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to Ethereum node at %s", kc.EthereumURL)
+	}
+	caller, err := keyper.NewContractCallerFromConfig(kc, client)
+	if err != nil {
+		return nil, err
+	}
+	return &keyperAccount{config: kc, client: client, caller: caller}, nil
+}
+
+// sendTX sends a transaction from the keyper's account and waits for it to be mined.
+func (a *keyperAccount) sendTX(
+	ctx context.Context,
+	description string,
+	send func(auth *bind.TransactOpts) (*types.Transaction, error),
+) error {
+	nonce, err := a.client.PendingNonceAt(ctx, a.caller.Address())
+	if err != nil {
+		return errors.Wrap(err, "failed to query nonce")
+	}
+	auth, err := a.caller.Auth(nonce, gaspricer.UrgencyNormal)
+	if err != nil {
+		return err
+	}
+	auth.Context = ctx
+
+	// The gas limit is left at zero, so that the transaction is estimated and would-be reverts are
+	// reported before sending it.
+	tx, err := send(auth)
+	if err != nil {
+		return errors.Wrapf(err, "failed to send %s transaction", description)
+	}
+	log.Printf("Sent %s transaction %s, waiting for it to be mined", description, tx.Hash().Hex())
+
+	receipt, err := medley.WaitMined(ctx, a.client, tx.Hash())
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		reason := medley.GetRevertReason(ctx, a.client, a.caller.Address(), tx, receipt.BlockNumber)
+		return errors.Errorf("%s transaction %s failed: %v", description, tx.Hash().Hex(), reason)
+	}
+	log.Printf("%s transaction mined in block %d", description, receipt.BlockNumber.Uint64())
+	return nil
+}
@@ -10,11 +10,13 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/spf13/cobra"
+	"github.com/tendermint/tendermint/rpc/client"
 	"github.com/tendermint/tendermint/rpc/client/http"
 
 	"github.com/shutter-network/shutter/shuttermint/cmd/deploy"
 	"github.com/shutter-network/shutter/shuttermint/contract"
 	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/signer"
 	"github.com/shutter-network/shutter/shuttermint/shmsg"
 )
 
@@ -141,7 +143,7 @@ func bootstrap() {
 	}
 	keypers := bc.Keypers
 
-	ms := fx.NewRPCMessageSender(shmcl, signingKey)
+	ms := fx.NewRPCMessageSender([]client.Client{shmcl}, signer.NewLocalSigner(signingKey))
 	batchConfigMsg := shmsg.NewBatchConfig(
 		bc.StartBatchIndex,
 		keypers,
@@ -33,9 +33,17 @@ var chainCmd = &cobra.Command{
 	},
 }
 
+var upgradeHeight int64
+
 func init() {
 	chainCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (required)")
 	chainCmd.MarkPersistentFlagRequired("config")
+	chainCmd.PersistentFlags().Int64Var(
+		&upgradeHeight,
+		"upgrade-height",
+		0,
+		"block from which on to apply the changes that older versions don't know about, must be the same on all nodes of a chain started by an older version",
+	)
 }
 
 func chainMain() {
@@ -96,6 +104,11 @@ func newTendermint(configFile string) (*nm.Node, error) {
 	if err != nil {
 		return nil, err
 	}
+	if upgradeHeight != 0 {
+		if err := shapp.SetUpgradeHeight(upgradeHeight); err != nil {
+			return nil, err
+		}
+	}
 
 	// read private validator
 	pv := privval.LoadFilePV(
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/medley"
+)
+
+var depositCmd = &cobra.Command{
+	Use:   "deposit",
+	Short: "Manage the keyper's deposit in the deposit contract",
+	Long: `These commands manage the deposit of a keyper in the deposit contract. They read the keyper
+config file and send transactions from the keyper's account.
+
+A deposit is made by sending tokens to the deposit contract. Withdrawing it takes two steps:
+First, a withdrawal has to be requested. After the withdrawal delay chosen when depositing has
+passed, the deposit can be withdrawn. Until then, it can still be slashed.`,
+	Args: cobra.NoArgs,
+	Run:  medley.ShowHelpAndExit,
+}
+
+var depositStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the keyper's deposit",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDepositCommand(depositStatus)
+	},
+}
+
+var depositDepositCmd = &cobra.Command{
+	Use:   "deposit AMOUNT",
+	Short: "Deposit the given amount of tokens",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDepositCommand(func(ctx context.Context, d *depositor) error {
+			return d.deposit(ctx, args[0])
+		})
+	},
+}
+
+var depositRequestWithdrawalCmd = &cobra.Command{
+	Use:   "request-withdrawal",
+	Short: "Request the withdrawal of the deposit",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDepositCommand(func(ctx context.Context, d *depositor) error {
+			return d.requestWithdrawal(ctx)
+		})
+	},
+}
+
+var depositWithdrawCmd = &cobra.Command{
+	Use:   "withdraw",
+	Short: "Withdraw the deposit after the withdrawal delay has passed",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDepositCommand(func(ctx context.Context, d *depositor) error {
+			return d.withdraw(ctx)
+		})
+	},
+}
+
+var depositFlags struct {
+	WithdrawalDelay uint64
+	Recipient       string
+}
+
+func init() {
+	depositCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "keyper config file")
+	depositCmd.AddCommand(depositStatusCmd)
+	depositCmd.AddCommand(depositDepositCmd)
+	depositCmd.AddCommand(depositRequestWithdrawalCmd)
+	depositCmd.AddCommand(depositWithdrawCmd)
+
+	depositDepositCmd.Flags().Uint64Var(
+		&depositFlags.WithdrawalDelay,
+		"withdrawal-delay",
+		0,
+		"number of main chain blocks between requesting a withdrawal and withdrawing (default: keep the current one)",
+	)
+	depositWithdrawCmd.Flags().StringVar(
+		&depositFlags.Recipient,
+		"recipient",
+		"",
+		"address the tokens are sent to (default: the keyper's address)",
+	)
+}
+
+// depositor sends deposit related transactions from the keyper's account.
+type depositor struct {
+	*keyperAccount
+	depositContract common.Address
+	// token is the ERC777 token accepted by the deposit contract. The test token binding covers
+	// the ERC777 interface.
+	token    *contract.TestDepositTokenContract
+	decimals uint8
+	symbol   string
+}
+
+// depositState describes the deposit of an account.
+type depositState struct {
+	Amount                   *big.Int
+	WithdrawalDelayBlocks    uint64
+	WithdrawalRequestedBlock uint64
+	Slashed                  bool
+}
+
+func runDepositCommand(run func(ctx context.Context, d *depositor) error) error {
+	ctx := context.Background()
+	account, err := openKeyperAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	callOpts := &bind.CallOpts{Context: ctx}
+	tokenAddress, err := account.caller.DepositContract.Token(callOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to query token of deposit contract")
+	}
+	token, err := contract.NewTestDepositTokenContract(tokenAddress, account.client)
+	if err != nil {
+		return err
+	}
+	decimals, err := token.Decimals(callOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to query token decimals")
+	}
+	symbol, err := token.Symbol(callOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to query token symbol")
+	}
+
+	return run(ctx, &depositor{
+		keyperAccount:   account,
+		depositContract: account.config.DepositContractAddress,
+		token:           token,
+		decimals:        decimals,
+		symbol:          symbol,
+	})
+}
+
+func (d *depositor) formatAmount(amount *big.Int) string {
+	return fmt.Sprintf("%s %s", medley.FormatTokenAmount(amount, d.decimals), d.symbol)
+}
+
+func (d *depositor) state(ctx context.Context) (depositState, error) {
+	callOpts := &bind.CallOpts{Context: ctx}
+	account := d.caller.Address()
+	state := depositState{}
+	var err error
+
+	state.Amount, err = d.caller.DepositContract.GetDepositAmount(callOpts, account)
+	if err != nil {
+		return state, errors.Wrap(err, "failed to query deposit amount")
+	}
+	state.WithdrawalDelayBlocks, err = d.caller.DepositContract.GetWithdrawalDelayBlocks(callOpts, account)
+	if err != nil {
+		return state, errors.Wrap(err, "failed to query withdrawal delay")
+	}
+	state.WithdrawalRequestedBlock, err = d.caller.DepositContract.GetWithdrawalRequestedBlock(callOpts, account)
+	if err != nil {
+		return state, errors.Wrap(err, "failed to query withdrawal request")
+	}
+	state.Slashed, err = d.caller.DepositContract.IsSlashed(callOpts, account)
+	if err != nil {
+		return state, errors.Wrap(err, "failed to query slashing status")
+	}
+	return state, nil
+}
+
+func depositStatus(ctx context.Context, d *depositor) error {
+	state, err := d.state(ctx)
+	if err != nil {
+		return err
+	}
+	balance, err := d.token.BalanceOf(&bind.CallOpts{Context: ctx}, d.caller.Address())
+	if err != nil {
+		return errors.Wrap(err, "failed to query token balance")
+	}
+	blockNumber, err := d.client.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "Account:\t%s\n", d.caller.Address().Hex())
+	fmt.Fprintf(w, "Deposit:\t%s\n", d.formatAmount(state.Amount))
+	fmt.Fprintf(w, "Token balance:\t%s\n", d.formatAmount(balance))
+	fmt.Fprintf(w, "Slashed:\t%t\n", state.Slashed)
+	fmt.Fprintf(w, "Withdrawal delay:\t%d blocks\n", state.WithdrawalDelayBlocks)
+	if state.WithdrawalRequestedBlock == 0 {
+		fmt.Fprintf(w, "Withdrawal requested:\tno\n")
+		return nil
+	}
+	withdrawableBlock := state.WithdrawalRequestedBlock + state.WithdrawalDelayBlocks
+	fmt.Fprintf(w, "Withdrawal requested:\tat block %d\n", state.WithdrawalRequestedBlock)
+	if blockNumber >= withdrawableBlock {
+		fmt.Fprintf(w, "Withdrawable:\tnow\n")
+	} else {
+		fmt.Fprintf(w, "Withdrawable:\tat block %d (current block %d)\n", withdrawableBlock, blockNumber)
+	}
+	return nil
+}
+
+// encodeWithdrawalDelay encodes the withdrawal delay as the user data the deposit contract
+// expects with the tokens it receives.
+func encodeWithdrawalDelay(blocks uint64) []byte {
+	return math.U256Bytes(new(big.Int).SetUint64(blocks))
+}
+
+func (d *depositor) deposit(ctx context.Context, amountString string) error {
+	amount, err := medley.ParseTokenAmount(amountString, d.decimals)
+	if err != nil {
+		return err
+	}
+	if amount.Sign() == 0 {
+		return errors.Errorf("amount must be positive")
+	}
+	state, err := d.state(ctx)
+	if err != nil {
+		return err
+	}
+	if state.Slashed {
+		return errors.Errorf("account %s has been slashed and cannot deposit", d.caller.Address().Hex())
+	}
+	if state.WithdrawalRequestedBlock != 0 {
+		return errors.Errorf("cannot deposit while a withdrawal is in progress")
+	}
+	delay := depositFlags.WithdrawalDelay
+	if delay < state.WithdrawalDelayBlocks {
+		if delay != 0 {
+			return errors.Errorf(
+				"withdrawal delay cannot be decreased from %d blocks", state.WithdrawalDelayBlocks,
+			)
+		}
+		delay = state.WithdrawalDelayBlocks
+	}
+	balance, err := d.token.BalanceOf(&bind.CallOpts{Context: ctx}, d.caller.Address())
+	if err != nil {
+		return errors.Wrap(err, "failed to query token balance")
+	}
+	if balance.Cmp(amount) < 0 {
+		return errors.Errorf("insufficient token balance of %s", d.formatAmount(balance))
+	}
+
+	log.Printf("Depositing %s with a withdrawal delay of %d blocks", d.formatAmount(amount), delay)
+	return d.sendTX(ctx, "deposit", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return d.token.Send(auth, d.depositContract, amount, encodeWithdrawalDelay(delay))
+	})
+}
+
+func (d *depositor) requestWithdrawal(ctx context.Context) error {
+	state, err := d.state(ctx)
+	if err != nil {
+		return err
+	}
+	if state.Amount.Sign() == 0 {
+		return errors.Errorf("no deposit to withdraw")
+	}
+	if state.WithdrawalRequestedBlock != 0 {
+		return errors.Errorf("withdrawal already requested at block %d", state.WithdrawalRequestedBlock)
+	}
+	log.Printf(
+		"Requesting withdrawal of %s, it can be withdrawn %d blocks after the request is mined",
+		d.formatAmount(state.Amount),
+		state.WithdrawalDelayBlocks,
+	)
+	return d.sendTX(ctx, "withdrawal request", d.caller.DepositContract.RequestWithdrawal)
+}
+
+func (d *depositor) withdraw(ctx context.Context) error {
+	recipient := d.caller.Address()
+	if depositFlags.Recipient != "" {
+		if !common.IsHexAddress(depositFlags.Recipient) {
+			return errors.Errorf("--recipient: invalid address '%s'", depositFlags.Recipient)
+		}
+		recipient = common.HexToAddress(depositFlags.Recipient)
+	}
+
+	state, err := d.state(ctx)
+	if err != nil {
+		return err
+	}
+	if state.Amount.Sign() == 0 {
+		return errors.Errorf("no deposit to withdraw")
+	}
+	if state.WithdrawalRequestedBlock == 0 {
+		return errors.Errorf("withdrawal not requested yet, run request-withdrawal first")
+	}
+	blockNumber, err := d.client.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	// the transaction will be included in the next block at the earliest
+	withdrawableBlock := state.WithdrawalRequestedBlock + state.WithdrawalDelayBlocks
+	if blockNumber+1 < withdrawableBlock {
+		return errors.Errorf(
+			"withdrawal delay not passed yet, the deposit can be withdrawn at block %d (current block %d)",
+			withdrawableBlock,
+			blockNumber,
+		)
+	}
+
+	log.Printf("Withdrawing %s to %s", d.formatAmount(state.Amount), recipient.Hex())
+	return d.sendTX(ctx, "withdrawal", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return d.caller.DepositContract.Withdraw(auth, recipient)
+	})
+}
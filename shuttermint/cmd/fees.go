@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/medley"
+)
+
+var feesCmd = &cobra.Command{
+	Use:   "fees",
+	Short: "Manage the fees collected in the fee bank",
+	Long: `These commands manage the fees the batcher contract collects in the fee bank for the fee
+receivers of the batch configs. They read the keyper config file and send transactions from the
+keyper's account, so they are only useful if the keyper is the fee receiver of some config.`,
+	Args: cobra.NoArgs,
+	Run:  medley.ShowHelpAndExit,
+}
+
+var feesBalanceCmd = &cobra.Command{
+	Use:   "balance",
+	Short: "Show the keyper's balance in the fee bank",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFeesCommand(feesBalance)
+	},
+}
+
+var feesWithdrawCmd = &cobra.Command{
+	Use:   "withdraw",
+	Short: "Withdraw fees from the fee bank",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFeesCommand(feesWithdraw)
+	},
+}
+
+var feesReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Compare the fees earned with the gas spent on execution per config",
+	Long: `This command lists for each batch config the fees paid to the config's fee receiver and the
+gas the keyper has spent on executing and skipping batches of the config in the given block
+range.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFeesCommand(feesReport)
+	},
+}
+
+var feesFlags struct {
+	Recipient string
+	Amount    string
+	FromBlock uint64
+	ToBlock   int64
+}
+
+func init() {
+	feesCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "keyper config file")
+	feesCmd.AddCommand(feesBalanceCmd)
+	feesCmd.AddCommand(feesWithdrawCmd)
+	feesCmd.AddCommand(feesReportCmd)
+
+	feesWithdrawCmd.Flags().StringVar(
+		&feesFlags.Recipient,
+		"recipient",
+		"",
+		"address the fees are sent to (default: FeeWithdrawalAddress from the config or the keyper's address)",
+	)
+	feesWithdrawCmd.Flags().StringVar(
+		&feesFlags.Amount,
+		"amount",
+		"",
+		"amount in ETH to withdraw (default: everything)",
+	)
+	feesReportCmd.Flags().Uint64Var(&feesFlags.FromBlock, "from-block", 0, "first main chain block to consider")
+	feesReportCmd.Flags().Int64Var(&feesFlags.ToBlock, "to-block", -1, "last main chain block to consider (default: latest)")
+}
+
+func runFeesCommand(run func(ctx context.Context, account *keyperAccount) error) error {
+	ctx := context.Background()
+	account, err := openKeyperAccount(ctx)
+	if err != nil {
+		return err
+	}
+	return run(ctx, account)
+}
+
+func formatEther(wei *big.Int) string {
+	return medley.FormatTokenAmount(wei, 18) + " ETH"
+}
+
+func feesBalance(ctx context.Context, account *keyperAccount) error {
+	callOpts := &bind.CallOpts{Context: ctx}
+	balance, err := account.caller.FeeBankContract.Deposits(callOpts, account.caller.Address())
+	if err != nil {
+		return errors.Wrap(err, "failed to query fee bank balance")
+	}
+	configs, err := fetchBatchConfigs(ctx, account.caller.ConfigContract)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "Account:\t%s\n", account.caller.Address().Hex())
+	fmt.Fprintf(w, "Fee bank balance:\t%s\n", formatEther(new(big.Int).SetUint64(balance)))
+	receiverOf := []uint64{}
+	for i, config := range configs {
+		if config.FeeReceiver == account.caller.Address() {
+			receiverOf = append(receiverOf, uint64(i))
+		}
+	}
+	fmt.Fprintf(w, "Fee receiver of configs:\t%v\n", receiverOf)
+	if account.config.FeeWithdrawalAddress != (common.Address{}) {
+		fmt.Fprintf(
+			w,
+			"Automatic withdrawal:\tto %s above %g ETH\n",
+			account.config.FeeWithdrawalAddress.Hex(),
+			account.config.FeeWithdrawalThreshold,
+		)
+	} else {
+		fmt.Fprintf(w, "Automatic withdrawal:\tdisabled\n")
+	}
+	return nil
+}
+
+func feesWithdraw(ctx context.Context, account *keyperAccount) error {
+	recipient := account.caller.Address()
+	if account.config.FeeWithdrawalAddress != (common.Address{}) {
+		recipient = account.config.FeeWithdrawalAddress
+	}
+	if feesFlags.Recipient != "" {
+		if !common.IsHexAddress(feesFlags.Recipient) {
+			return errors.Errorf("--recipient: invalid address '%s'", feesFlags.Recipient)
+		}
+		recipient = common.HexToAddress(feesFlags.Recipient)
+	}
+
+	balance, err := account.caller.FeeBankContract.Deposits(&bind.CallOpts{Context: ctx}, account.caller.Address())
+	if err != nil {
+		return errors.Wrap(err, "failed to query fee bank balance")
+	}
+	if balance == 0 {
+		return errors.Errorf("no fees to withdraw")
+	}
+	amount := balance
+	if feesFlags.Amount != "" {
+		wei, err := medley.ParseTokenAmount(feesFlags.Amount, 18)
+		if err != nil {
+			return errors.WithMessage(err, "--amount")
+		}
+		if wei.Sign() == 0 || wei.Cmp(new(big.Int).SetUint64(balance)) > 0 {
+			return errors.Errorf(
+				"--amount: must be positive and at most the balance of %s",
+				formatEther(new(big.Int).SetUint64(balance)),
+			)
+		}
+		amount = wei.Uint64()
+	}
+
+	log.Printf("Withdrawing %s to %s", formatEther(new(big.Int).SetUint64(amount)), recipient.Hex())
+	return account.sendTX(ctx, "fee withdrawal", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return account.caller.FeeBankContract.Withdraw0(auth, recipient, amount)
+	})
+}
+
+// fetchBatchConfigs fetches all batch configs from the config contract.
+func fetchBatchConfigs(ctx context.Context, cc *contract.ConfigContract) ([]contract.BatchConfig, error) {
+	callOpts := &bind.CallOpts{Context: ctx}
+	numConfigs, err := cc.NumConfigs(callOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query number of configs")
+	}
+	configs := []contract.BatchConfig{}
+	for i := uint64(0); i < numConfigs; i++ {
+		config, err := cc.GetConfigByIndex(callOpts, i)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query config %d", i)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// configFeeReport summarizes the fees and execution costs of a single batch config.
+type configFeeReport struct {
+	Fees          *big.Int
+	NumExecutions int
+	GasUsed       uint64
+	GasCost       *big.Int
+}
+
+func feesReport(ctx context.Context, account *keyperAccount) error {
+	configs, err := fetchBatchConfigs(ctx, account.caller.ConfigContract)
+	if err != nil {
+		return err
+	}
+	if len(configs) == 0 {
+		return errors.Errorf("no batch configs")
+	}
+	// we only use the config lookup methods of the main chain
+	mainChain := observe.NewMainChain(0)
+	mainChain.BatchConfigs = configs
+
+	var end uint64
+	if feesFlags.ToBlock >= 0 {
+		end = uint64(feesFlags.ToBlock)
+	} else {
+		end, err = account.client.BlockNumber(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	filter := &bind.FilterOpts{Start: feesFlags.FromBlock, End: &end, Context: ctx}
+
+	reports := make([]configFeeReport, len(configs))
+	for i := range reports {
+		reports[i] = configFeeReport{Fees: new(big.Int), GasCost: new(big.Int)}
+	}
+	err = addFeesToReports(account, filter, mainChain, reports)
+	if err != nil {
+		return err
+	}
+	err = addExecutionCostsToReports(ctx, account, filter, mainChain, reports)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Printf("Blocks %d to %d, executions by %s\n\n", filter.Start, end, account.caller.Address().Hex())
+	fmt.Fprintf(w, "Config\tFee receiver\tFees\tExecutions\tGas used\tGas cost\tFees - gas cost\n")
+	for i, report := range reports {
+		net := new(big.Int).Sub(report.Fees, report.GasCost)
+		netString := formatEther(net)
+		if net.Sign() < 0 {
+			netString = "-" + formatEther(new(big.Int).Neg(net))
+		}
+		fmt.Fprintf(
+			w,
+			"%d\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			i,
+			configs[i].FeeReceiver.Hex(),
+			formatEther(report.Fees),
+			report.NumExecutions,
+			report.GasUsed,
+			formatEther(report.GasCost),
+			netString,
+		)
+	}
+	return nil
+}
+
+// addFeesToReports adds the fees deposited in the fee bank for the fee receiver of the config
+// active at the time of the deposit.
+func addFeesToReports(
+	account *keyperAccount,
+	filter *bind.FilterOpts,
+	mainChain *observe.MainChain,
+	reports []configFeeReport,
+) error {
+	it, err := account.caller.FeeBankContract.FilterDepositEvent(filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to filter fee bank deposit events")
+	}
+	defer it.Close()
+	for it.Next() {
+		ev := it.Event
+		if ev.Raw.BlockNumber < mainChain.BatchConfigs[0].StartBlockNumber {
+			continue
+		}
+		configIndex := mainChain.ActiveConfigIndex(ev.Raw.BlockNumber)
+		if ev.Receiver != mainChain.BatchConfigs[configIndex].FeeReceiver {
+			continue // not paid by the batcher contract
+		}
+		reports[configIndex].Fees.Add(reports[configIndex].Fees, new(big.Int).SetUint64(ev.Amount))
+	}
+	return errors.Wrap(it.Error(), "failed to iterate fee bank deposit events")
+}
+
+// addExecutionCostsToReports adds the costs of the execution transactions sent from the keyper's
+// account to the report of the config the executed batch belongs to.
+func addExecutionCostsToReports(
+	ctx context.Context,
+	account *keyperAccount,
+	filter *bind.FilterOpts,
+	mainChain *observe.MainChain,
+	reports []configFeeReport,
+) error {
+	halfSteps := make(map[common.Hash]uint64) // by tx hash
+	executedIt, err := account.caller.ExecutorContract.FilterBatchExecuted(filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to filter batch executed events")
+	}
+	defer executedIt.Close()
+	for executedIt.Next() {
+		halfSteps[executedIt.Event.Raw.TxHash] = executedIt.Event.NumExecutionHalfSteps - 1
+	}
+	if executedIt.Error() != nil {
+		return errors.Wrap(executedIt.Error(), "failed to iterate batch executed events")
+	}
+	skippedIt, err := account.caller.ExecutorContract.FilterCipherExecutionSkipped(filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to filter cipher execution skipped events")
+	}
+	defer skippedIt.Close()
+	for skippedIt.Next() {
+		halfSteps[skippedIt.Event.Raw.TxHash] = skippedIt.Event.NumExecutionHalfSteps - 1
+	}
+	if skippedIt.Error() != nil {
+		return errors.Wrap(skippedIt.Error(), "failed to iterate cipher execution skipped events")
+	}
+
+	chainID, err := account.client.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+	signer := types.LatestSignerForChainID(chainID)
+	baseFees := make(map[uint64]*big.Int) // by block number
+	for txHash, halfStep := range halfSteps {
+		tx, _, err := account.client.TransactionByHash(ctx, txHash)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get transaction %s", txHash.Hex())
+		}
+		sender, err := types.Sender(signer, tx)
+		if err != nil {
+			return errors.Wrapf(err, "failed to determine sender of transaction %s", txHash.Hex())
+		}
+		if sender != account.caller.Address() {
+			continue
+		}
+		configIndex, ok := mainChain.ConfigIndexForBatchIndex(halfStep / 2)
+		if !ok {
+			continue
+		}
+		receipt, err := account.client.TransactionReceipt(ctx, txHash)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get receipt of transaction %s", txHash.Hex())
+		}
+		blockNumber := receipt.BlockNumber.Uint64()
+		baseFee, ok := baseFees[blockNumber]
+		if !ok {
+			header, err := account.client.HeaderByNumber(ctx, receipt.BlockNumber)
+			if err != nil {
+				return errors.Wrapf(err, "failed to get header of block %d", blockNumber)
+			}
+			baseFee = header.BaseFee
+			baseFees[blockNumber] = baseFee
+		}
+
+		report := &reports[configIndex]
+		report.NumExecutions++
+		if report.GasUsed > math.MaxUint64-receipt.GasUsed {
+			return errors.Errorf("gas used of config %d overflows", configIndex)
+		}
+		report.GasUsed += receipt.GasUsed
+		cost := new(big.Int).Mul(gaspricer.EffectiveGasPrice(tx, baseFee), new(big.Int).SetUint64(receipt.GasUsed))
+		report.GasCost.Add(report.GasCost, cost)
+	}
+	return nil
+}
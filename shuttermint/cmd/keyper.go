@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/pkg/errors"
@@ -29,26 +30,60 @@ Shuttermint node which have to be started separately in advance.`,
 	},
 }
 
+var shadowMode bool
+
 func init() {
 	keyperCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file")
+	keyperCmd.PersistentFlags().BoolVar(
+		&shadowMode,
+		"shadow",
+		false,
+		"record actions and compare them with what happened on chain instead of running them",
+	)
 }
 
 func readKeyperConfig() (keyper.Config, error) {
 	viper.SetEnvPrefix("KEYPER")
 	viper.BindEnv("ShuttermintURL")
+	viper.BindEnv("FallbackShuttermintURLs")
+	viper.BindEnv("VerifyShuttermint")
+	viper.BindEnv("ShuttermintGenesis")
 	viper.BindEnv("EthereumURL")
+	viper.BindEnv("FallbackEthereumURLs")
+	viper.BindEnv("EthereumQuorum")
+	viper.BindEnv("EthereumPollInterval")
 	viper.BindEnv("SigningKey")
 	viper.BindEnv("ValidatorSeed")
 	viper.BindEnv("EncryptionKey")
+	viper.BindEnv("PreviousEncryptionKeys")
+	viper.BindEnv("SigningKeystore")
+	viper.BindEnv("ValidatorKeystore")
+	viper.BindEnv("EncryptionKeystore")
+	viper.BindEnv("PreviousEncryptionKeystores")
+	viper.BindEnv("PassphraseFile")
+	viper.BindEnv("SignerURL")
 	viper.BindEnv("ConfigContract")
 	viper.BindEnv("BatcherContract")
 	viper.BindEnv("KeyBroadcastContract")
 	viper.BindEnv("ExecutorContract")
 	viper.BindEnv("DepositContract")
 	viper.BindEnv("KeyperSlasher")
+	viper.BindEnv("FeeBankContract")
 	viper.BindEnv("MainChainFollowDistance")
 	viper.BindEnv("ExecutionStaggering")
 	viper.BindEnv("DKGPhaseLength")
+	viper.BindEnv("EonRotationBatches")
+	viper.BindEnv("GasPriceMode")
+	viper.BindEnv("MaxFeePerGas")
+	viper.BindEnv("PriorityFee")
+	viper.BindEnv("ResendTXAfterBlocks")
+	viper.BindEnv("RequireDeposit")
+	viper.BindEnv("Journal")
+	viper.BindEnv("NotifyWebhookURL")
+	viper.BindEnv("NotifyCommand")
+	viper.BindEnv("MinBalance")
+	viper.BindEnv("FeeWithdrawalAddress")
+	viper.BindEnv("FeeWithdrawalThreshold")
 
 	viper.SetDefault("ShuttermintURL", "http://localhost:26657")
 
@@ -81,28 +116,57 @@ func readKeyperConfig() (keyper.Config, error) {
 		return config, err
 	}
 
-	if !filepath.IsAbs(config.DBDir) {
-		r := filepath.Dir(viper.ConfigFileUsed())
-		dbdir, err := filepath.Abs(filepath.Join(r, config.DBDir))
-		if err != nil {
-			return config, err
-		}
-		config.DBDir = dbdir
+	err = config.ResolvePaths(filepath.Dir(viper.ConfigFileUsed()))
+	if err != nil {
+		return config, err
+	}
+
+	err = config.UnlockKeys(func() (string, error) {
+		return keyper.ReadPassphrase(config.PassphraseFile, false)
+	})
+	if err != nil {
+		return config, err
+	}
+
+	err = config.SetupSigner(context.Background())
+	if err != nil {
+		return config, err
 	}
 
-	if !keyper.IsWebsocketURL(config.EthereumURL) {
-		return config, errors.Errorf("field EthereumURL must start with ws:// or wss://")
+	if config.VerifyShuttermint && config.ShuttermintGenesis == "" {
+		return config, errors.Errorf("field ShuttermintGenesis is required to verify shuttermint data")
+	}
+	if config.EthereumPollInterval < 0 {
+		return config, errors.Errorf("field EthereumPollInterval must not be negative")
+	}
+	if config.EthereumQuorum < 0 || config.EthereumQuorum > len(config.EthereumURLs()) {
+		return config, errors.Errorf("field EthereumQuorum must be between 0 and the number of Ethereum URLs")
 	}
 
 	return config, err
 }
 
+// setupGasPricer configures the gas price settings from the keyper config.
+func setupGasPricer(kc keyper.Config) error {
+	if err := gaspricer.SetMultiplier(kc.GasPriceMultiplier); err != nil {
+		return err
+	}
+	if err := gaspricer.SetMode(kc.GasPriceMode); err != nil {
+		return err
+	}
+	if err := gaspricer.SetMaxFeePerGas(kc.MaxFeePerGas); err != nil {
+		return err
+	}
+	return gaspricer.SetPriorityFee(kc.PriorityFee)
+}
+
 func keyperMain() error {
 	kc, err := readKeyperConfig()
 	if err != nil {
 		return errors.WithMessage(err, "Please check your configuration")
 	}
-	err = gaspricer.SetMultiplier(kc.GasPriceMultiplier)
+	kc.Shadow = shadowMode
+	err = setupGasPricer(kc)
 	if err != nil {
 		return errors.WithMessage(err, "Please check your configuration")
 	}
@@ -111,9 +175,12 @@ func keyperMain() error {
 		"Starting keyper version %s with signing key %s, using %s for Shuttermint and %s for Ethereum",
 		shversion.Version(),
 		kc.Address().Hex(),
-		kc.ShuttermintURL,
-		kc.EthereumURL,
+		strings.Join(kc.ShuttermintURLs(), ", "),
+		strings.Join(kc.EthereumURLs(), ", "),
 	)
+	if kc.Shadow {
+		log.Printf("Running in shadow mode, actions will be recorded, but not executed")
+	}
 	kpr := keyper.NewKeyper(kc)
 	err = kpr.LoadState()
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/pkg/errors"
@@ -15,6 +16,7 @@ import (
 	"github.com/shutter-network/shutter/shuttermint/cmd/deploy"
 	"github.com/shutter-network/shutter/shuttermint/contract"
 	"github.com/shutter-network/shutter/shuttermint/keyper"
+	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
 )
 
 var contractsJSON deploy.Contracts
@@ -25,6 +27,8 @@ var configFlags struct {
 	EthereumURL    string
 	ShuttermintURL string
 	ContractsPath  string
+	PassphraseFile string
+	LightKDF       bool
 }
 
 var configCmd = &cobra.Command{
@@ -84,6 +88,20 @@ func init() {
 	)
 
 	configCmd.MarkFlagRequired("contracts")
+
+	configCmd.Flags().StringVar(
+		&configFlags.PassphraseFile,
+		"passphrase-file",
+		"",
+		"file containing the passphrase used to encrypt the keystore files (default: use "+
+			keyper.PassphraseEnvVar+" or prompt)",
+	)
+	configCmd.Flags().BoolVar(
+		&configFlags.LightKDF,
+		"light-kdf",
+		false,
+		"use cheap key derivation for the keystore files, insecure but fast (for testing only)",
+	)
 }
 
 func validateConfigFlags() error {
@@ -134,6 +152,11 @@ func generateConfigJSON(keypers []common.Address) error {
 }
 
 func configs() error {
+	passphrase, err := keyper.ReadPassphrase(configFlags.PassphraseFile, true)
+	if err != nil {
+		return err
+	}
+
 	keypers := []common.Address{}
 	for i := 0; i < configFlags.NumKeypers; i++ {
 		config, err := newConfig()
@@ -141,7 +164,7 @@ func configs() error {
 			return err
 		}
 		dir := filepath.Join(configFlags.Dir, "keyper"+strconv.Itoa(i))
-		err = saveConfig(config, dir)
+		err = saveConfig(config, dir, passphrase)
 		if err != nil {
 			return err
 		}
@@ -154,6 +177,7 @@ func newConfig() (*keyper.Config, error) {
 	config := keyper.Config{
 		ShuttermintURL:              configFlags.ShuttermintURL,
 		EthereumURL:                 configFlags.EthereumURL,
+		EthereumPollInterval:        4 * time.Second,
 		DBDir:                       "",
 		ConfigContractAddress:       contractsJSON.ConfigContract,
 		BatcherContractAddress:      contractsJSON.BatcherContract,
@@ -161,10 +185,13 @@ func newConfig() (*keyper.Config, error) {
 		ExecutorContractAddress:     contractsJSON.ExecutorContract,
 		DepositContractAddress:      contractsJSON.DepositContract,
 		KeyperSlasherAddress:        contractsJSON.KeyperSlasherContract,
+		FeeBankContractAddress:      contractsJSON.FeeBankContract,
 		MainChainFollowDistance:     0,
 		ExecutionStaggering:         5,
 		DKGPhaseLength:              30,
 		GasPriceMultiplier:          1.5,
+		GasPriceMode:                string(gaspricer.ModeAuto),
+		ResendTXAfterBlocks:         5,
 	}
 	err := config.GenerateNewKeys()
 	if err != nil {
@@ -173,11 +200,19 @@ func newConfig() (*keyper.Config, error) {
 	return &config, nil
 }
 
-func saveConfig(c *keyper.Config, dir string) error {
+func saveConfig(c *keyper.Config, dir string, passphrase string) error {
 	var err error
 	if err = os.MkdirAll(dir, 0o755); err != nil {
 		return errors.Wrap(err, "failed to create keyper directory")
 	}
+
+	scryptParams := keyper.StandardScryptParams
+	if configFlags.LightKDF {
+		scryptParams = keyper.LightScryptParams
+	}
+	if err = c.WriteKeystores(dir, passphrase, scryptParams); err != nil {
+		return errors.Wrap(err, "failed to write keystore files")
+	}
 	path := filepath.Join(dir, "config.toml")
 
 	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
@@ -6,6 +6,7 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"path/filepath"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -157,6 +158,19 @@ func readKeyFromConfig(path string) (*ecdsa.PrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = config.ResolvePaths(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	err = config.UnlockKeys(func() (string, error) {
+		return keyper.ReadPassphrase(config.PassphraseFile, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.SigningKey == nil {
+		return nil, errors.Errorf("%s does not contain a signing key", path)
+	}
 	return config.SigningKey, nil
 }
 
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/kr/pretty"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper"
+)
+
+var replayFlags struct {
+	Journal string
+	Batch   int64
+	Eon     int64
+	Verbose bool
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay the decisions recorded in a keyper's journal",
+	Long: `This command reads the decision journal a keyper writes to its database directory and
+re-runs the decider on the recorded inputs. The journal is only written if Journal is enabled in
+the keyper config. It reports steps in which the replayed actions or the resulting state differ
+from the recorded ones. Note that DKG messages contain random values, so steps in which the
+keyper takes part in a DKG are expected to differ.
+
+If --batch or --eon is given, the command stops at every step concerning the given batch or eon,
+prints the recorded inputs and the result, and waits for enter to be pressed. When running
+under a debugger, a breakpoint can be set on the function replayBreakpoint instead.
+
+The keyper config is required, since the decider needs the keyper's keys.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return replayMain()
+	},
+}
+
+func init() {
+	keyperCmd.AddCommand(replayCmd)
+	replayCmd.Flags().StringVar(
+		&replayFlags.Journal,
+		"journal",
+		"",
+		"journal directory (defaults to the journal in the keyper's database directory)",
+	)
+	replayCmd.Flags().Int64Var(&replayFlags.Batch, "batch", -1, "stop at steps concerning this batch")
+	replayCmd.Flags().Int64Var(&replayFlags.Eon, "eon", -1, "stop at steps concerning this eon")
+	replayCmd.Flags().BoolVarP(&replayFlags.Verbose, "verbose", "v", false, "print every replayed step")
+}
+
+// replayBreakpoint is called whenever the replay stops at a step. It exists so that a debugger
+// breakpoint can be set on it.
+func replayBreakpoint(step *keyper.ReplayStep) {
+	fmt.Printf("Shutter state:\n%# v\n", pretty.Formatter(step.Entry.Shutter))
+	fmt.Printf("Main chain state:\n%# v\n", pretty.Formatter(step.Entry.MainChain))
+	fmt.Printf("State before:\n%# v\n", pretty.Formatter(step.Entry.StateBefore))
+	fmt.Printf("State after replay:\n%# v\n", pretty.Formatter(step.State))
+	fmt.Print("Press enter to continue")
+	_, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+}
+
+func printReplayStep(step *keyper.ReplayStep) {
+	entry := step.Entry
+	fmt.Printf(
+		"%s: shutter block %d, main chain block %d, %d actions recorded, %d replayed\n",
+		entry.Time.Format("2006-01-02 15:04:05.000"),
+		entry.ShutterBlock,
+		entry.MainChainBlock,
+		len(entry.Actions),
+		len(step.Actions),
+	)
+	for _, a := range step.Actions {
+		fmt.Printf("    %s\n", a)
+	}
+	for _, d := range step.Divergences {
+		fmt.Printf("    divergence: %s\n", d)
+	}
+}
+
+func replayMain() error {
+	kc, err := readKeyperConfig()
+	if err != nil {
+		return errors.WithMessage(err, "Please check your configuration")
+	}
+	dir := replayFlags.Journal
+	if dir == "" {
+		dir = filepath.Join(kc.DBDir, "journal")
+	}
+
+	numSteps := 0
+	numDiverged := 0
+	err = keyper.Replay(kc, dir, func(step *keyper.ReplayStep) error {
+		numSteps++
+		if len(step.Divergences) > 0 {
+			numDiverged++
+		}
+		stop := replayFlags.Batch >= 0 && step.Entry.TouchesBatch(uint64(replayFlags.Batch)) ||
+			replayFlags.Eon >= 0 && step.Entry.TouchesEon(uint64(replayFlags.Eon))
+		if replayFlags.Verbose || stop || len(step.Divergences) > 0 {
+			printReplayStep(step)
+		}
+		if stop {
+			replayBreakpoint(step)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Replayed %d steps, %d diverged", numSteps, numDiverged)
+	return nil
+}
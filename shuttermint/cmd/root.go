@@ -67,7 +67,10 @@ func init() {
 	rootCmd.AddCommand(chainCmd)
 	rootCmd.AddCommand(config.ConfigCmd)
 	rootCmd.AddCommand(keyperCmd)
+	rootCmd.AddCommand(depositCmd)
+	rootCmd.AddCommand(feesCmd)
 	rootCmd.AddCommand(showCmd)
+	rootCmd.AddCommand(signerCmd)
 	rootCmd.AddCommand(txsearchCmd)
 	rootCmd.AddCommand(bootstrapCmd)
 	rootCmd.AddCommand(initCmd)
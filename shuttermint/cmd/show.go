@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"context"
+	"log"
 
 	"github.com/kr/pretty"
 	"github.com/spf13/cobra"
 	"github.com/tendermint/tendermint/rpc/client"
 	"github.com/tendermint/tendermint/rpc/client/http"
+	tmtypes "github.com/tendermint/tendermint/types"
 
 	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
 )
@@ -14,13 +16,17 @@ import (
 var showFlags struct {
 	ShuttermintURL string
 	Height         int64
+	Checkpoints    string
+	Genesis        string
 }
 
 var showCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show the internal state of a Shuttermint node",
 	Long: `This command queries transactions from a running shuttermint node and rebuilds the
-internal shutter state object according to the results. It then prints the result to stdout.`,
+internal shutter state object according to the results. It then prints the result to stdout.
+If a checkpoint directory is given, it resumes from the latest checkpoint stored there and
+saves a new checkpoint afterwards.`,
 	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		showMain()
@@ -42,30 +48,75 @@ func init() {
 		-1,
 		"target height",
 	)
+	showCmd.PersistentFlags().StringVarP(
+		&showFlags.Checkpoints,
+		"checkpoints",
+		"",
+		"",
+		"checkpoint directory",
+	)
+	showCmd.PersistentFlags().StringVarP(
+		&showFlags.Genesis,
+		"genesis",
+		"",
+		"",
+		"verify the blocks against the headers signed by the validators, starting with the ones of this genesis file",
+	)
 }
 
-func showShutter(shuttermintURL string, height int64) {
+func showShutter(shuttermintURL string, height int64, checkpoints string, genesisFile string) {
+	ctx := context.Background()
 	var cl client.Client
 	cl, err := http.New(shuttermintURL, "/websocket")
 	if err != nil {
 		panic(err)
 	}
 
+	var genesis *tmtypes.GenesisDoc
+	if genesisFile != "" {
+		genesis, err = tmtypes.GenesisDocFromFile(genesisFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	s := observe.NewShutter()
 	if height == -1 {
-		height, err = s.GetLastCommittedHeight(context.Background(), cl)
+		height, err = s.GetLastCommittedHeight(ctx, cl)
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	s, err = s.SyncToHeight(context.Background(), cl, height)
+	if checkpoints != "" {
+		cp, err := observe.LoadCheckpoint(ctx, checkpoints, cl, nil)
+		if err != nil {
+			panic(err)
+		}
+		if cp != nil && cp.Shutter.CurrentBlock <= height {
+			log.Printf("Resuming from checkpoint at height %d", cp.Shutter.CurrentBlock)
+			s = cp.Shutter
+		}
+	}
+
+	s, err = s.SyncToHeight(ctx, cl, height, genesis)
 	if err != nil {
 		panic(err)
 	}
 	pretty.Println("Synced:", s)
+
+	if checkpoints != "" && height > 0 {
+		cp, err := observe.NewCheckpoint(ctx, cl, observe.World{Shutter: s})
+		if err != nil {
+			panic(err)
+		}
+		err = observe.SaveCheckpoint(checkpoints, cp)
+		if err != nil {
+			panic(err)
+		}
+	}
 }
 
 func showMain() {
-	showShutter(showFlags.ShuttermintURL, showFlags.Height)
+	showShutter(showFlags.ShuttermintURL, showFlags.Height, showFlags.Checkpoints, showFlags.Genesis)
 }
@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper"
+	"github.com/shutter-network/shutter/shuttermint/keyper/signer"
+)
+
+var signerFlags struct {
+	Keystore             string
+	PassphraseFile       string
+	Listen               string
+	BatcherContract      string
+	ExecutorContract     string
+	KeyBroadcastContract string
+	KeyperSlasher        string
+	FeeBankContract      string
+	FeeWithdrawalAddress string
+}
+
+var signerCmd = &cobra.Command{
+	Use:   "signer",
+	Short: "Run an external signer for a keyper",
+	Long: `This command runs a signer process holding a keyper's signing key. The keyper can use it
+by setting SignerURL in its config to the socket path or URL the signer listens on, so that the
+signing key does not have to be loaded into the keyper process.
+
+The signer only signs transactions calling the keyper's methods of the given contracts and
+decryption signatures for the given batcher contract. Fees are only withdrawn from the fee bank
+if a fee withdrawal address is given, and only to that address.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return signerMain()
+	},
+}
+
+func init() {
+	signerCmd.Flags().StringVarP(
+		&signerFlags.Keystore,
+		"keystore",
+		"k",
+		"",
+		"path to the keystore file containing the signing key",
+	)
+	signerCmd.MarkFlagRequired("keystore")
+	signerCmd.Flags().StringVarP(
+		&signerFlags.PassphraseFile,
+		"passphrase-file",
+		"",
+		"",
+		"path to a file containing the keystore passphrase",
+	)
+	signerCmd.Flags().StringVarP(
+		&signerFlags.Listen,
+		"listen",
+		"l",
+		"signer.ipc",
+		"Unix socket path or http URL on a loopback address to listen on",
+	)
+	for _, f := range []struct {
+		value       *string
+		name, usage string
+		required    bool
+	}{
+		{&signerFlags.BatcherContract, "batcher-contract", "address of the batcher contract", true},
+		{&signerFlags.ExecutorContract, "executor-contract", "address of the executor contract", true},
+		{&signerFlags.KeyBroadcastContract, "keybroadcast-contract", "address of the key broadcast contract", true},
+		{&signerFlags.KeyperSlasher, "keyper-slasher", "address of the keyper slasher contract", true},
+		{&signerFlags.FeeBankContract, "feebank-contract", "address of the fee bank contract", false},
+		{&signerFlags.FeeWithdrawalAddress, "fee-withdrawal-address", "address fees may be withdrawn to", false},
+	} {
+		signerCmd.Flags().StringVar(f.value, f.name, "", f.usage)
+		if f.required {
+			signerCmd.MarkFlagRequired(f.name)
+		}
+	}
+}
+
+func parseAddressFlag(name, value string) (common.Address, error) {
+	if !common.IsHexAddress(value) {
+		return common.Address{}, errors.Errorf("--%s: invalid address %s", name, value)
+	}
+	return common.HexToAddress(value), nil
+}
+
+// allowMethods allows calls of the given methods of the contract with the given ABI.
+func allowMethods(policy *signer.Policy, address common.Address, abiJSON string, methods ...string) error {
+	contractABI, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return err
+	}
+	for _, name := range methods {
+		method, ok := contractABI.Methods[name]
+		if !ok {
+			return errors.Errorf("unknown method %s", name)
+		}
+		policy.Allow(address, method.ID, nil)
+	}
+	return nil
+}
+
+// signerPolicy creates the policy allowing the transactions a keyper sends.
+func signerPolicy() (*signer.Policy, error) {
+	batcher, err := parseAddressFlag("batcher-contract", signerFlags.BatcherContract)
+	if err != nil {
+		return nil, err
+	}
+	executor, err := parseAddressFlag("executor-contract", signerFlags.ExecutorContract)
+	if err != nil {
+		return nil, err
+	}
+	keyBroadcast, err := parseAddressFlag("keybroadcast-contract", signerFlags.KeyBroadcastContract)
+	if err != nil {
+		return nil, err
+	}
+	slasher, err := parseAddressFlag("keyper-slasher", signerFlags.KeyperSlasher)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := signer.NewPolicy(batcher)
+	err = allowMethods(
+		policy,
+		executor,
+		contract.ExecutorContractABI,
+		"executeCipherBatch",
+		"executePlainBatch",
+		"skipCipherExecution",
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowMethods(policy, keyBroadcast, contract.KeyBroadcastContractABI, "vote"); err != nil {
+		return nil, err
+	}
+	if err := allowMethods(policy, slasher, contract.KeyperSlasherABI, "accuse", "appeal", "slash"); err != nil {
+		return nil, err
+	}
+
+	if signerFlags.FeeBankContract == "" || signerFlags.FeeWithdrawalAddress == "" {
+		log.Printf("Warning: no fee bank contract or fee withdrawal address given, not signing fee withdrawals")
+		return policy, nil
+	}
+	feeBank, err := parseAddressFlag("feebank-contract", signerFlags.FeeBankContract)
+	if err != nil {
+		return nil, err
+	}
+	receiver, err := parseAddressFlag("fee-withdrawal-address", signerFlags.FeeWithdrawalAddress)
+	if err != nil {
+		return nil, err
+	}
+	feeBankABI, err := abi.JSON(strings.NewReader(contract.FeeBankContractABI))
+	if err != nil {
+		return nil, err
+	}
+	withdraw := feeBankABI.Methods["withdraw0"]
+	policy.Allow(feeBank, withdraw.ID, func(args []byte) error {
+		values, err := withdraw.Inputs.Unpack(args)
+		if err != nil {
+			return err
+		}
+		if to, ok := values[0].(common.Address); !ok || to != receiver {
+			return errors.Errorf("fees may only be withdrawn to %s", receiver.Hex())
+		}
+		return nil
+	})
+	return policy, nil
+}
+
+func signerMain() error {
+	keyjson, err := ioutil.ReadFile(signerFlags.Keystore)
+	if err != nil {
+		return errors.Wrap(err, "failed to read keystore file")
+	}
+	passphrase, err := keyper.ReadPassphrase(signerFlags.PassphraseFile, false)
+	if err != nil {
+		return err
+	}
+	key, err := keyper.DecryptECDSAKey(keyjson, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt signing key")
+	}
+	s := signer.NewLocalSigner(key)
+	policy, err := signerPolicy()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s. Stopping signer", sig)
+		cancel()
+	}()
+
+	log.Printf("Signing for %s, listening on %s", s.Address().Hex(), signerFlags.Listen)
+	return signer.Serve(ctx, s, policy, signerFlags.Listen)
+}
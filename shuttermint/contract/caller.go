@@ -2,21 +2,37 @@ package contract
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
 
 	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
+	"github.com/shutter-network/shutter/shuttermint/keyper/signer"
 )
 
+// EthClient is the part of the Ethereum client API used to interact with the main chain. It is
+// implemented by *ethclient.Client.
+type EthClient interface {
+	bind.ContractBackend
+	ChainID(ctx context.Context) (*big.Int, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
 // Caller interacts with the contracts on Ethereum.
 type Caller struct {
-	Ethclient  *ethclient.Client
-	signingKey *ecdsa.PrivateKey
+	Ethclient EthClient
+	signer    signer.Signer
 
 	ConfigContract       *ConfigContract
 	KeyBroadcastContract *KeyBroadcastContract
@@ -24,22 +40,24 @@ type Caller struct {
 	ExecutorContract     *ExecutorContract
 	DepositContract      *DepositContract
 	KeyperSlasher        *KeyperSlasher
+	FeeBankContract      *FeeBankContract
 }
 
 // NewCaller creates a new ContractCaller.
 func NewCaller(
-	ethcl *ethclient.Client,
-	signingKey *ecdsa.PrivateKey,
+	ethcl EthClient,
+	s signer.Signer,
 	configContract *ConfigContract,
 	keyBroadcastContract *KeyBroadcastContract,
 	batcherContract *BatcherContract,
 	executorContract *ExecutorContract,
 	depositContract *DepositContract,
 	keyperSlasher *KeyperSlasher,
+	feeBankContract *FeeBankContract,
 ) Caller {
 	return Caller{
-		Ethclient:  ethcl,
-		signingKey: signingKey,
+		Ethclient: ethcl,
+		signer:    s,
 
 		ConfigContract:       configContract,
 		KeyBroadcastContract: keyBroadcastContract,
@@ -47,37 +65,111 @@ func NewCaller(
 		ExecutorContract:     executorContract,
 		DepositContract:      depositContract,
 		KeyperSlasher:        keyperSlasher,
+		FeeBankContract:      feeBankContract,
 	}
 }
 
 // Address returns the address of the account that is used to send transactions.
 func (cc *Caller) Address() common.Address {
-	return crypto.PubkeyToAddress(cc.signingKey.PublicKey)
+	return cc.signer.Address()
 }
 
-// Auth returns a new transactor with initialized key, nonce, and gas price.
-func (cc *Caller) Auth() (*bind.TransactOpts, error) {
+// transactor returns a new transactor with initialized signer.
+func (cc *Caller) transactor() (*bind.TransactOpts, error) {
 	chainID, err := cc.Ethclient.ChainID(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(cc.signingKey, chainID)
+	from := cc.Address()
+	return &bind.TransactOpts{
+		From: from,
+		Signer: func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if address != from {
+				return nil, bind.ErrNotAuthorized
+			}
+			signedTx, err := cc.signer.SignTx(tx, chainID)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to sign transaction")
+			}
+			return signedTx, nil
+		},
+		Context: context.Background(),
+	}, nil
+}
+
+// Auth returns a new transactor with initialized signer, the given nonce, and fees suitable for a
+// transaction of the given urgency.
+func (cc *Caller) Auth(nonce uint64, urgency gaspricer.Urgency) (*bind.TransactOpts, error) {
+	auth, err := cc.transactor()
+	if err != nil {
+		return nil, err
+	}
+	auth.Nonce = new(big.Int).SetUint64(nonce)
+
+	fees, err := gaspricer.SuggestFees(context.Background(), cc.Ethclient, urgency)
 	if err != nil {
 		return nil, err
 	}
+	fees.Apply(auth)
+	return auth, nil
+}
 
-	nonce, err := cc.Ethclient.PendingNonceAt(context.Background(), cc.Address())
+// ReplacementAuth returns a new transactor for a transaction replacing the transaction with the
+// given nonce and fees. The fees are bumped so that nodes accept the replacement. It returns
+// gaspricer.ErrCannotBump if that's not possible without exceeding the maximum fee per gas.
+func (cc *Caller) ReplacementAuth(nonce uint64, oldFees gaspricer.Fees, urgency gaspricer.Urgency) (*bind.TransactOpts, error) {
+	auth, err := cc.transactor()
 	if err != nil {
 		return nil, err
 	}
-	auth.Nonce = big.NewInt(int64(nonce))
+	auth.Nonce = new(big.Int).SetUint64(nonce)
 
-	gasPrice, err := cc.Ethclient.SuggestGasPrice(context.Background())
+	suggested, err := gaspricer.SuggestFees(context.Background(), cc.Ethclient, urgency)
+	if err != nil {
+		return nil, err
+	}
+	fees, err := gaspricer.BumpFees(oldFees, suggested)
 	if err != nil {
 		return nil, err
 	}
-	gasPrice = gaspricer.Adjust(gasPrice)
-	auth.GasPrice = gasPrice
+	fees.Apply(auth)
 	return auth, nil
 }
+
+// SendCancelTX sends a transaction without any effect using the nonce and fees of the given
+// transactor. It is used to cancel a pending transaction or to fill a nonce gap.
+func (cc *Caller) SendCancelTX(auth *bind.TransactOpts) (*types.Transaction, error) {
+	chainID, err := cc.Ethclient.ChainID(auth.Context)
+	if err != nil {
+		return nil, err
+	}
+	var tx *types.Transaction
+	if auth.GasFeeCap != nil {
+		tx = types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     auth.Nonce.Uint64(),
+			GasTipCap: auth.GasTipCap,
+			GasFeeCap: auth.GasFeeCap,
+			Gas:       params.TxGas,
+			To:        &auth.From,
+			Value:     big.NewInt(0),
+		})
+	} else {
+		tx = types.NewTx(&types.LegacyTx{
+			Nonce:    auth.Nonce.Uint64(),
+			GasPrice: auth.GasPrice,
+			Gas:      params.TxGas,
+			To:       &auth.From,
+			Value:    big.NewInt(0),
+		})
+	}
+	signedTx, err := auth.Signer(auth.From, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.Ethclient.SendTransaction(auth.Context, signedTx); err != nil {
+		return nil, err
+	}
+	return signedTx, nil
+}
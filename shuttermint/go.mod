@@ -46,6 +46,7 @@ require (
 )
 
 require (
+	github.com/google/uuid v1.1.5
 	github.com/shutter-network/shutter/shlib v0.1.12
 	golang.org/x/sys v0.0.0-20210906170528-6f6e22806c34 // indirect
 )
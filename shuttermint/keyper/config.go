@@ -1,14 +1,17 @@
 package keyper
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"path/filepath"
 	"reflect"
 	"text/template"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -16,26 +19,63 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/signer"
 )
 
 // Config contains validated configuration parameters for the keyper client.
 type Config struct {
 	ShuttermintURL              string
+	FallbackShuttermintURLs     []string // used if the node at ShuttermintURL fails
+	VerifyShuttermint           bool     // verify shuttermint data against the signed block headers, see observe.SyncShutter
+	ShuttermintGenesis          string   // path to the shuttermint genesis file, required to verify shuttermint data
 	EthereumURL                 string
+	FallbackEthereumURLs        []string      // used in order if the node at EthereumURL fails
+	EthereumQuorum              int           // number of Ethereum nodes that must agree on block hashes
+	EthereumPollInterval        time.Duration // how often to poll for new blocks if not all URLs are websocket URLs
 	DBDir                       string
 	SigningKey                  *ecdsa.PrivateKey
 	ValidatorKey                ed25519.PrivateKey `mapstructure:"ValidatorSeed"`
 	EncryptionKey               *ecies.PrivateKey
+	PreviousEncryptionKeys      []*ecies.PrivateKey
+	PreviousEncryptionKeystores []string
+	SigningKeystore             string         // path to the signing key's keystore file
+	ValidatorKeystore           string         // path to the validator key's keystore file
+	EncryptionKeystore          string         // path to the encryption key's keystore file
+	PassphraseFile              string         // path to a file containing the keystore passphrase
+	SignerURL                   string         // URL or socket path of an external signer
 	ConfigContractAddress       common.Address `mapstructure:"ConfigContract"`
 	BatcherContractAddress      common.Address `mapstructure:"BatcherContract"`
 	KeyBroadcastContractAddress common.Address `mapstructure:"KeyBroadcastContract"`
 	ExecutorContractAddress     common.Address `mapstructure:"ExecutorContract"`
 	DepositContractAddress      common.Address `mapstructure:"DepositContract"`
 	KeyperSlasherAddress        common.Address `mapstructure:"KeyperSlasher"`
+	FeeBankContractAddress      common.Address `mapstructure:"FeeBankContract"` // optional, queried from the batcher contract if unset
 	MainChainFollowDistance     uint64         // in main chain blocks
 	ExecutionStaggering         uint64         // in main chain blocks
 	DKGPhaseLength              uint64         // in shuttermint blocks
-	GasPriceMultiplier          float64
+	EonRotationBatches          uint64         // rotate the eon key every that many batches, 0 disables rotation
+	GasPriceMultiplier          float64        // applied to the suggested gas price of legacy txs
+	GasPriceMode                string         // auto, legacy, or dynamic
+	MaxFeePerGas                float64        // in gwei, 0 means no limit
+	PriorityFee                 float64        // in gwei, 0 means use the suggested tip
+	ResendTXAfterBlocks         uint64         // in main chain blocks, 0 disables replacing stuck txs
+	RequireDeposit              bool           // don't take part in eons without a valid deposit
+	Journal                     bool           // record the decider's inputs for the replay command
+	NotifyWebhookURL            string         // URL notifications about critical events are posted to
+	NotifyCommand               string         // shell command run for notifications about critical events
+	MinBalance                  float64        // in ETH, notify if the balance falls below, 0 disables the check
+	FeeWithdrawalAddress        common.Address // address fees in the fee bank are withdrawn to automatically
+	FeeWithdrawalThreshold      float64        // in ETH, withdraw fees once the fee bank balance reaches it
+
+	// Signer signs main chain transactions and shuttermint messages. It is set up by
+	// SetupSigner and either uses SigningKey or the external signer at SignerURL.
+	Signer signer.Signer `mapstructure:"-"`
+
+	// Shadow makes the keyper record the actions it decides on instead of running them and
+	// compare them with what the keyper using the same key has done on chain. It is set by the
+	// --shadow command line flag.
+	Shadow bool `mapstructure:"-"`
 }
 
 const configTemplate = `# Shutter keyper configuration for {{ .Address }}
@@ -47,21 +87,73 @@ DepositContract		= "{{ .DepositContractAddress }}"
 ExecutorContract	= "{{ .ExecutorContractAddress }}"
 KeyBroadcastContract	= "{{ .KeyBroadcastContractAddress }}"
 KeyperSlasher		= "{{ .KeyperSlasherAddress }}"
+FeeBankContract		= "{{ .FeeBankContractAddress }}"
 
 EthereumURL		= "{{ .EthereumURL }}"
+{{- if .FallbackEthereumURLs }}
+FallbackEthereumURLs	= [{{ range $i, $url := .FallbackEthereumURLs }}{{ if $i }}, {{ end }}"{{ $url }}"{{ end }}]
+{{- end }}
+EthereumQuorum		= {{ .EthereumQuorum }}
+EthereumPollInterval	= "{{ .EthereumPollInterval }}"
 ShuttermintURL		= "{{ .ShuttermintURL }}"
+{{- if .FallbackShuttermintURLs }}
+FallbackShuttermintURLs	= [{{ range $i, $url := .FallbackShuttermintURLs }}{{ if $i }}, {{ end }}"{{ $url }}"{{ end }}]
+{{- end }}
+VerifyShuttermint	= {{ .VerifyShuttermint }}
+ShuttermintGenesis	= "{{ .ShuttermintGenesis }}"
 DBDir			= "{{ .DBDir }}"
 DKGPhaseLength		= {{ .DKGPhaseLength }}
+EonRotationBatches	= {{ .EonRotationBatches }}
 ExecutionStaggering	= {{ .ExecutionStaggering }}
 MainChainFollowDistance = {{ .MainChainFollowDistance }}
 GasPriceMultiplier      = {{ .GasPriceMultiplier }}
+GasPriceMode		= "{{ .GasPriceMode }}"
+MaxFeePerGas		= {{ .MaxFeePerGas }}
+PriorityFee		= {{ .PriorityFee }}
+ResendTXAfterBlocks	= {{ .ResendTXAfterBlocks }}
+RequireDeposit		= {{ .RequireDeposit }}
+Journal			= {{ .Journal }}
+
+# Notifications about critical events
+NotifyWebhookURL	= "{{ .NotifyWebhookURL }}"
+NotifyCommand		= "{{ .NotifyCommand }}"
+MinBalance		= {{ .MinBalance }}
+
+# Automatic withdrawal of fees from the fee bank, disabled if no address is given
+FeeWithdrawalAddress	= "{{ .FeeWithdrawalAddress }}"
+FeeWithdrawalThreshold	= {{ .FeeWithdrawalThreshold }}
+
+{{- if .SigningKeystore }}
+
+# Keystore files holding the secret keys, relative paths are relative to this file
+EncryptionKeystore	= "{{ .EncryptionKeystore }}"
+SigningKeystore		= "{{ .SigningKeystore }}"
+ValidatorKeystore	= "{{ .ValidatorKeystore }}"
+{{- if .PreviousEncryptionKeystores }}
+PreviousEncryptionKeystores = [{{ range $i, $path := .PreviousEncryptionKeystores }}{{ if $i }}, {{ end }}"{{ $path }}"{{ end }}]
+{{- end }}
+{{- else }}
 
 # Secret Keys
 EncryptionKey	= "{{ .EncryptionKey.ExportECDSA | FromECDSA | printf "%x" }}"
 SigningKey	= "{{ .SigningKey | FromECDSA | printf "%x" }}"
 ValidatorSeed	= "{{ .ValidatorKey.Seed | printf "%x" }}"
+{{- if .PreviousEncryptionKeys }}
+PreviousEncryptionKeys = [{{ range $i, $key := .PreviousEncryptionKeys }}{{ if $i }}, {{ end }}"{{ $key.ExportECDSA | FromECDSA | printf "%x" }}"{{ end }}]
+{{- end }}
+{{- end }}
 `
 
+// File names of the keystore files created by WriteKeystores.
+const (
+	signingKeystoreFilename    = "signing-key.json"
+	validatorKeystoreFilename  = "validator-key.json"
+	encryptionKeystoreFilename = "encryption-key.json"
+)
+
+// defaultEthereumPollInterval is used if EthereumPollInterval is not set.
+const defaultEthereumPollInterval = 4 * time.Second
+
 var tmpl *template.Template
 
 func init() {
@@ -178,8 +270,220 @@ func (config *Config) Unmarshal(v *viper.Viper) error {
 	)
 }
 
+func resolvePath(dir, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	return filepath.Abs(filepath.Join(dir, path))
+}
+
+// ResolvePaths makes the relative paths in the config absolute by interpreting them relative to
+// the given directory. This should be the directory of the config file.
+func (config *Config) ResolvePaths(dir string) error {
+	var err error
+	config.DBDir, err = resolvePath(dir, config.DBDir)
+	if err != nil {
+		return err
+	}
+	for _, p := range []*string{
+		&config.SigningKeystore,
+		&config.ValidatorKeystore,
+		&config.EncryptionKeystore,
+		&config.PassphraseFile,
+		&config.ShuttermintGenesis,
+	} {
+		if *p == "" {
+			continue
+		}
+		*p, err = resolvePath(dir, *p)
+		if err != nil {
+			return err
+		}
+	}
+	for i, p := range config.PreviousEncryptionKeystores {
+		config.PreviousEncryptionKeystores[i], err = resolvePath(dir, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (config *Config) usesKeystores() bool {
+	return config.SigningKeystore != "" || config.ValidatorKeystore != "" || config.EncryptionKeystore != "" ||
+		len(config.PreviousEncryptionKeystores) > 0
+}
+
+// UnlockKeys decrypts the secret keys stored in the keystore files referenced by the config.
+// getPassphrase is only called if at least one keystore file is used. A key must either be given
+// directly or via a keystore file, but not both.
+func (config *Config) UnlockKeys(getPassphrase func() (string, error)) error {
+	if config.SigningKeystore != "" && config.SigningKey != nil {
+		return errors.New("SigningKey and SigningKeystore must not be given both")
+	}
+	if config.ValidatorKeystore != "" && config.ValidatorKey != nil {
+		return errors.New("ValidatorSeed and ValidatorKeystore must not be given both")
+	}
+	if config.EncryptionKeystore != "" && config.EncryptionKey != nil {
+		return errors.New("EncryptionKey and EncryptionKeystore must not be given both")
+	}
+	if len(config.PreviousEncryptionKeystores) > 0 && len(config.PreviousEncryptionKeys) > 0 {
+		return errors.New("PreviousEncryptionKeys and PreviousEncryptionKeystores must not be given both")
+	}
+
+	if config.usesKeystores() {
+		passphrase, err := getPassphrase()
+		if err != nil {
+			return err
+		}
+		if err := config.decryptKeystores(passphrase); err != nil {
+			return err
+		}
+	}
+
+	if config.SignerURL != "" {
+		if config.SigningKey != nil {
+			return errors.New("SignerURL must not be given together with SigningKey or SigningKeystore")
+		}
+	} else if config.SigningKey == nil {
+		return errors.New("missing SigningKey, SigningKeystore, or SignerURL")
+	}
+	if config.ValidatorKey == nil {
+		return errors.New("missing ValidatorSeed or ValidatorKeystore")
+	}
+	if config.EncryptionKey == nil {
+		return errors.New("missing EncryptionKey or EncryptionKeystore")
+	}
+	return nil
+}
+
+func (config *Config) decryptKeystores(passphrase string) error {
+	if config.SigningKeystore != "" {
+		keyjson, err := readKeystoreFile(config.SigningKeystore)
+		if err != nil {
+			return err
+		}
+		config.SigningKey, err = DecryptECDSAKey(keyjson, passphrase)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt signing key from %s", config.SigningKeystore)
+		}
+	}
+	if config.ValidatorKeystore != "" {
+		keyjson, err := readKeystoreFile(config.ValidatorKeystore)
+		if err != nil {
+			return err
+		}
+		config.ValidatorKey, err = DecryptEd25519Key(keyjson, passphrase)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt validator key from %s", config.ValidatorKeystore)
+		}
+	}
+	if config.EncryptionKeystore != "" {
+		keyjson, err := readKeystoreFile(config.EncryptionKeystore)
+		if err != nil {
+			return err
+		}
+		encryptionKeyECDSA, err := DecryptECDSAKey(keyjson, passphrase)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt encryption key from %s", config.EncryptionKeystore)
+		}
+		config.EncryptionKey = ecies.ImportECDSA(encryptionKeyECDSA)
+	}
+	for _, path := range config.PreviousEncryptionKeystores {
+		keyjson, err := readKeystoreFile(path)
+		if err != nil {
+			return err
+		}
+		encryptionKeyECDSA, err := DecryptECDSAKey(keyjson, passphrase)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt previous encryption key from %s", path)
+		}
+		config.PreviousEncryptionKeys = append(config.PreviousEncryptionKeys, ecies.ImportECDSA(encryptionKeyECDSA))
+	}
+	return nil
+}
+
+// WriteKeystores encrypts the secret keys with the given passphrase and writes them as keystore
+// files to dir. The config will reference the keystore files by their names relative to dir, so
+// the config file should be stored in the same directory.
+func (config *Config) WriteKeystores(dir string, passphrase string, params ScryptParams) error {
+	signingJSON, err := EncryptECDSAKey(config.SigningKey, passphrase, params)
+	if err != nil {
+		return err
+	}
+	validatorJSON, err := EncryptEd25519Key(config.ValidatorKey, passphrase, params)
+	if err != nil {
+		return err
+	}
+	encryptionJSON, err := EncryptECDSAKey(config.EncryptionKey.ExportECDSA(), passphrase, params)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		name    string
+		keyjson []byte
+	}{
+		{signingKeystoreFilename, signingJSON},
+		{validatorKeystoreFilename, validatorJSON},
+		{encryptionKeystoreFilename, encryptionJSON},
+	} {
+		if err := writeKeystoreFile(filepath.Join(dir, f.name), f.keyjson); err != nil {
+			return err
+		}
+	}
+
+	config.SigningKeystore = signingKeystoreFilename
+	config.ValidatorKeystore = validatorKeystoreFilename
+	config.EncryptionKeystore = encryptionKeystoreFilename
+	return nil
+}
+
+// SetupSigner sets config.Signer. If SignerURL is given, it connects to the external signer,
+// otherwise it signs with SigningKey.
+func (config *Config) SetupSigner(ctx context.Context) error {
+	if config.SignerURL == "" {
+		config.Signer = signer.NewLocalSigner(config.SigningKey)
+		return nil
+	}
+	s, err := signer.DialRPCSigner(ctx, config.SignerURL)
+	if err != nil {
+		return err
+	}
+	config.Signer = s
+	return nil
+}
+
+// ShuttermintURLs returns the URLs of all shuttermint nodes to use, starting with the primary one.
+func (config *Config) ShuttermintURLs() []string {
+	return append([]string{config.ShuttermintURL}, config.FallbackShuttermintURLs...)
+}
+
+// EthereumURLs returns the URLs of all Ethereum nodes to use, starting with the primary one.
+func (config *Config) EthereumURLs() []string {
+	return append([]string{config.EthereumURL}, config.FallbackEthereumURLs...)
+}
+
+// MainChainPollInterval returns the interval in which to poll the main chain for new blocks. It
+// returns zero if all Ethereum URLs are websocket URLs, in which case we subscribe to new blocks
+// instead.
+func (config *Config) MainChainPollInterval() time.Duration {
+	for _, url := range config.EthereumURLs() {
+		if !IsWebsocketURL(url) {
+			if config.EthereumPollInterval == 0 {
+				return defaultEthereumPollInterval
+			}
+			return config.EthereumPollInterval
+		}
+	}
+	return 0
+}
+
 // Address returns the keyper's Ethereum address.
 func (config *Config) Address() common.Address {
+	if config.Signer != nil {
+		return config.Signer.Address()
+	}
 	return crypto.PubkeyToAddress(config.SigningKey.PublicKey)
 }
 
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
@@ -22,7 +21,9 @@ import (
 	"github.com/shutter-network/shutter/shuttermint/contract"
 	"github.com/shutter-network/shutter/shuttermint/keyper/epochkg"
 	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/notify"
 	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/keyper/signer"
 	"github.com/shutter-network/shutter/shuttermint/medley"
 	"github.com/shutter-network/shutter/shuttermint/shmsg"
 )
@@ -31,11 +32,16 @@ import (
 // steps.
 const maxParallelHalfSteps uint64 = 10
 
+// notifiedRetentionBlocks is the number of main chain blocks for which we remember the events
+// we've notified operators about.
+const notifiedRetentionBlocks uint64 = 100_000
+
 type decryptfn func(encrypted []byte) ([]byte, error)
 
 // Batch is used to store local state about a single Batch.
 type Batch struct {
 	BatchIndex               uint64
+	EncryptedBatchHash       common.Hash // hash of the main chain batch we've decrypted
 	DecryptionSignatureHash  []byte
 	DecryptedTransactions    [][]byte
 	DecryptedBatchHash       []byte
@@ -70,6 +76,11 @@ type DKG struct {
 	Pure                 *puredkg.PureDKG
 	OutgoingPolyEvalMsgs []puredkg.PolyEvalMsg
 	PhaseLength          PhaseLength
+	StartHeight          int64  // shuttermint block at which the eon has been started
+	Attempt              uint64 // number of previous eons for the same config whose DKG failed
+	Failed               bool
+	CorruptKeypers       []common.Address // keypers considered corrupt when finalizing
+	RetryVoteHeight      int64            // shuttermint block of our last vote to retry, zero if none
 }
 
 // EKG is used to store local state about the epoch key generation process.
@@ -83,6 +94,46 @@ func (dkg *DKG) ShortInfo() string {
 	return fmt.Sprintf("eon=%d, #keypers=%d, %s", dkg.Eon, len(dkg.Keypers), dkg.Pure.ShortInfo())
 }
 
+// isCorrupt checks if we are considered corrupt by the other keypers.
+func (dkg *DKG) isCorrupt() bool {
+	return dkg.isKeyperCorrupt(dkg.Pure.Keyper)
+}
+
+// isKeyperCorrupt checks if the given keyper is considered corrupt. It applies the rules puredkg
+// uses: a keyper is corrupt if their commitment didn't make it into the chain, if they didn't
+// apologize for an accusation against them, or if their apology doesn't match their commitment.
+func (dkg *DKG) isKeyperCorrupt(keyper puredkg.KeyperIndex) bool {
+	pure := dkg.Pure
+	commitment := pure.Commitments[keyper]
+	if commitment == nil {
+		return true
+	}
+	for key := range pure.Accusations {
+		if key.Accused != keyper {
+			continue
+		}
+		eval, ok := pure.Apologies[key]
+		if !ok {
+			return true
+		}
+		if !shcrypto.VerifyPolyEval(int(key.Accuser), eval, commitment, pure.Threshold) {
+			return true
+		}
+	}
+	return false
+}
+
+// corruptKeypers returns the addresses of the keypers considered corrupt.
+func (dkg *DKG) corruptKeypers() []common.Address {
+	var corrupt []common.Address
+	for i, keyper := range dkg.Keypers {
+		if dkg.isKeyperCorrupt(puredkg.KeyperIndex(i)) {
+			corrupt = append(corrupt, keyper)
+		}
+	}
+	return corrupt
+}
+
 func (dkg *DKG) IsFinalized() bool {
 	return dkg.Pure == nil || dkg.Pure.Phase == puredkg.Finalized
 }
@@ -247,14 +298,24 @@ func (dkg *DKG) syncApologies(syncHeight int64, eon observe.Eon) {
 type State struct {
 	CheckInMessageSent       bool
 	LastSentBatchConfigIndex uint64
-	LastEonStarted           uint64
+	LastEonStarted           uint64 // last eon whose DKG we've taken part in
+	LastEonSeen              uint64 // last eon we've decided whether to take part in
 	DKGs                     []DKG
 	EKGs                     []*EKG
 	PendingHalfStep          *uint64
-	PendingAppeals           map[uint64]struct{}
+	Slashings                map[uint64]*SlashingCase // by half step
 	NextEpochSecretShare     uint64
 	Batches                  map[uint64]*Batch
 	HalfStepsChecked         uint64
+	MainChainReorgs          uint64 // number of main chain reorgs we've handled
+	FeeWithdrawalSentBlock   uint64 // main chain block at which we've sent our last fee withdrawal
+	RotationVoteBatchIndex   uint64 // start batch index of our last vote to rotate the eon key
+	RotationVoteHeight       int64  // shuttermint block at which we've sent that vote
+	KeyRotationHeight        int64  // shuttermint block at which we've sent our last key rotation
+
+	// Notified holds the main chain block at which we've notified operators about an event by
+	// the event's kind and key.
+	Notified map[string]uint64
 
 	// We store the actions that should be executed together with a counter. When starting the
 	// program, we feed these actions into runenv, which can use the counter to identify the
@@ -262,14 +323,16 @@ type State struct {
 	ActionCounter uint64
 	Actions       []fx.IAction
 
-	SyncHeight int64
+	SyncHeight         int64
+	MainChainSyncBlock uint64 // the first main chain block we haven't processed yet
 }
 
 // NewState creates an empty State object.
 func NewState() *State {
 	return &State{
-		PendingAppeals: make(map[uint64]struct{}),
-		Batches:        make(map[uint64]*Batch),
+		Slashings: make(map[uint64]*SlashingCase),
+		Batches:   make(map[uint64]*Batch),
+		Notified:  make(map[string]uint64),
 	}
 }
 
@@ -283,14 +346,19 @@ func (st *State) GetShutterFilter(mainChain *observe.MainChain) observe.ShutterF
 
 // Decider decides on the next actions to take based on our internal State and the current Shutter
 // and MainChain state for a single step. For each step the keyper creates a new Decider. The
-// actions to run are stored inside the Actions field.
+// actions to run are stored inside the Actions field. Changes holds the parts of Shutter and
+// MainChain that have changed since the last step, only those are looked at.
 type Decider struct {
 	Config      Config
 	State       *State
 	Shutter     *observe.Shutter
 	MainChain   *observe.MainChain
+	Changes     observe.ChangeSet
 	Actions     []fx.IAction
 	PhaseLength PhaseLength
+
+	// Notifications are events operators should be notified about
+	Notifications []notify.Event
 }
 
 func NewDecider(kpr *Keyper) Decider {
@@ -300,11 +368,33 @@ func NewDecider(kpr *Keyper) Decider {
 		State:       kpr.State,
 		Shutter:     world.Shutter,
 		MainChain:   world.MainChain,
+		Changes:     kpr.State.GetChanges(world),
 		Actions:     []fx.IAction{},
 		PhaseLength: NewConstantPhaseLength(int64(kpr.Config.DKGPhaseLength)),
 	}
 }
 
+// GetChanges returns the changes of the given world we haven't processed yet.
+func (st *State) GetChanges(world observe.World) observe.ChangeSet {
+	changes := world.Shutter.ChangesSince(st.SyncHeight)
+	changes.Merge(world.MainChain.ChangesSince(st.MainChainSyncBlock))
+	if st.MainChainSyncBlock == 0 {
+		// we haven't processed anything yet or our state is from a version that didn't track
+		// changes
+		changes.Full = true
+	}
+	return changes
+}
+
+// changedKeys returns the changed keys of the given change set map in ascending order. If the
+// changes are unknown, the keys of all are returned instead.
+func (dcdr *Decider) changedKeys(changed map[uint64]struct{}, all func() []uint64) []uint64 {
+	if dcdr.Changes.Full {
+		return all()
+	}
+	return observe.SortedKeys(changed)
+}
+
 var errEKGNotFound = errors.New("EKG not found")
 
 func (st *State) FindEKGByEon(eon uint64) (*EKG, error) {
@@ -316,6 +406,30 @@ func (st *State) FindEKGByEon(eon uint64) (*EKG, error) {
 	return nil, pkgErrors.WithStack(errEKGNotFound)
 }
 
+// notify stores an event operators should be notified about. Each event is only emitted once per
+// kind and key. We keep track of them in the state, since the checks that lead to them are
+// repeated on every step and, after a restart, for all objects.
+func (dcdr *Decider) notify(kind notify.Kind, key string, format string, args ...interface{}) {
+	if dcdr.State.Notified == nil {
+		dcdr.State.Notified = make(map[string]uint64) // state from before notifications were stored
+	}
+	id := string(kind) + "/" + key
+	if _, ok := dcdr.State.Notified[id]; ok {
+		return
+	}
+	dcdr.State.Notified[id] = dcdr.MainChain.CurrentBlock
+	dcdr.Notifications = append(dcdr.Notifications, notify.NewEvent(kind, key, format, args...))
+}
+
+// pruneNotified forgets about the events we've notified about long ago.
+func (dcdr *Decider) pruneNotified() {
+	for id, block := range dcdr.State.Notified {
+		if block+notifiedRetentionBlocks < dcdr.MainChain.CurrentBlock {
+			delete(dcdr.State.Notified, id)
+		}
+	}
+}
+
 // addAction stores the given IAction to be run later.
 func (dcdr *Decider) addAction(a fx.IAction) {
 	if reflect.ValueOf(a).Kind() != reflect.Ptr {
@@ -349,6 +463,9 @@ func (dcdr *Decider) sendCheckIn() {
 }
 
 func (dcdr *Decider) maybeSendCheckIn() {
+	if !dcdr.Changes.Full && !dcdr.Changes.CheckIns && !dcdr.Changes.ShutterConfigs {
+		return
+	}
 	if dcdr.shouldSendCheckIn() {
 		dcdr.sendCheckIn()
 		dcdr.State.CheckInMessageSent = true
@@ -369,6 +486,9 @@ func (dcdr *Decider) sendBatchConfig(configIndex uint64, config contract.BatchCo
 }
 
 func (dcdr *Decider) maybeSendBatchConfig() {
+	if !dcdr.Changes.Full && !dcdr.Changes.ShutterConfigs && !dcdr.Changes.MainChainConfigs {
+		return
+	}
 	if len(dcdr.Shutter.BatchConfigs) == 0 {
 		log.Printf("Shutter is not bootstrapped")
 		return
@@ -385,11 +505,13 @@ func (dcdr *Decider) maybeSendBatchConfig() {
 	}
 }
 
-func (dcdr *Decider) startDKG(eon *observe.Eon) {
+// startDKG starts taking part in the DKG of the given eon. It returns false if we're not a keyper
+// in the eon.
+func (dcdr *Decider) startDKG(eon *observe.Eon) bool {
 	batchConfig := dcdr.Shutter.FindBatchConfigByBatchIndex(eon.StartEvent.BatchIndex)
 	keyperIndex, err := medley.FindAddressIndex(batchConfig.Keypers, dcdr.Config.Address())
 	if err != nil {
-		return
+		return false
 	}
 
 	pure := puredkg.NewPureDKG(eon.Eon, uint64(len(batchConfig.Keypers)), batchConfig.Threshold, uint64(keyperIndex))
@@ -399,21 +521,77 @@ func (dcdr *Decider) startDKG(eon *observe.Eon) {
 		Pure:            &pure,
 		Keypers:         batchConfig.Keypers,
 		PhaseLength:     dcdr.PhaseLength,
+		StartHeight:     eon.StartHeight,
+		Attempt:         eon.StartEvent.Attempt,
+	}
+	if dkg.Attempt > 0 {
+		log.Printf("Starting DKG for eon %d, attempt %d for batch config at batch %d", dkg.Eon, dkg.Attempt, dkg.StartBatchIndex)
 	}
 	dcdr.State.DKGs = append(dcdr.State.DKGs, dkg)
+	return true
 }
 
 func (dcdr *Decider) maybeStartDKG() {
-	for i := range dcdr.Shutter.Eons {
-		eon := &dcdr.Shutter.Eons[i]
-		if eon.Eon > dcdr.State.LastEonStarted {
+	allEons := func() []uint64 {
+		var eons []uint64
+		for _, eon := range dcdr.Shutter.Eons {
+			eons = append(eons, eon.Eon)
+		}
+		return eons
+	}
+	if dcdr.State.LastEonSeen < dcdr.State.LastEonStarted {
+		dcdr.State.LastEonSeen = dcdr.State.LastEonStarted // state from before LastEonSeen existed
+	}
+	for _, eonIndex := range dcdr.changedKeys(dcdr.Changes.Eons, allEons) {
+		eon, err := dcdr.Shutter.FindEon(eonIndex)
+		if err != nil {
+			continue // already garbage collected
+		}
+		if eon.Eon > dcdr.State.LastEonSeen {
 			// TODO we should check that we do not start eons that are in the past
-			dcdr.startDKG(eon)
-			dcdr.State.LastEonStarted = eon.Eon
+			if dcdr.checkDeposit(eon) && dcdr.startDKG(eon) {
+				dcdr.State.LastEonStarted = eon.Eon
+			}
+			dcdr.State.LastEonSeen = eon.Eon
 		}
 	}
 }
 
+// depositProblem returns a description of what's wrong with our deposit or the empty string if
+// it's fine. A requested withdrawal is not considered a problem, since the deposit can still be
+// slashed until it's withdrawn.
+func (dcdr *Decider) depositProblem() string {
+	deposit, ok := dcdr.MainChain.Deposits[dcdr.Config.Address()]
+	switch {
+	case !ok || deposit.Amount == nil || deposit.Amount.Sign() == 0:
+		return "no deposit"
+	case deposit.Slashed:
+		return "deposit has been slashed"
+	default:
+		return ""
+	}
+}
+
+// checkDeposit checks our deposit before we take part in the DKG of the given eon. It returns
+// false if we should refuse to take part, which we only do if the config requires a deposit.
+func (dcdr *Decider) checkDeposit(eon *observe.Eon) bool {
+	batchConfig := dcdr.Shutter.FindBatchConfigByBatchIndex(eon.StartEvent.BatchIndex)
+	if _, err := medley.FindAddressIndex(batchConfig.Keypers, dcdr.Config.Address()); err != nil {
+		return true // not a keyper in this eon
+	}
+	problem := dcdr.depositProblem()
+	if problem == "" {
+		return true
+	}
+	dcdr.notify(notify.KindDeposit, fmt.Sprint(eon.Eon), "keyper in eon %d, but %s", eon.Eon, problem)
+	if !dcdr.Config.RequireDeposit {
+		log.Printf("Warning: taking part in eon %d even though %s", eon.Eon, problem)
+		return true
+	}
+	log.Printf("Not taking part in eon %d: %s", eon.Eon, problem)
+	return false
+}
+
 // PhaseLength is used to store the accumulated lengths of the DKG phases.
 type PhaseLength struct {
 	Off         int64
@@ -473,7 +651,7 @@ func (dcdr *Decider) sendPolyEvals(dkg *DKG) {
 
 	for _, p := range dkg.OutgoingPolyEvalMsgs {
 		receiver := dkg.Keypers[p.Receiver]
-		encryptionKey, ok := dcdr.Shutter.KeyperEncryptionKeys[receiver]
+		encryptionKey, ok := dcdr.Shutter.EncryptionKeyAt(receiver, dkg.StartHeight)
 		if ok {
 			encrypted, err := encryptionKey.Encrypt(rand.Reader, p.Eval.Bytes())
 			if err != nil {
@@ -542,12 +720,27 @@ func (dcdr *Decider) startPhase3Apologizing(dkg *DKG, phaseAtNextBlockHeight pur
 
 func (dcdr *Decider) dkgFinalize(dkg *DKG) {
 	dkg.Pure.Finalize()
+	if dkg.isCorrupt() {
+		dcdr.notify(notify.KindCorrupt, fmt.Sprint(dkg.Eon), "we are considered corrupt in DKG for eon %d", dkg.Eon)
+	}
+	dkg.CorruptKeypers = dkg.corruptKeypers()
+	corrupt := "none"
+	if len(dkg.CorruptKeypers) > 0 {
+		corrupt = addressesString(dkg.CorruptKeypers)
+		log.Printf("Corrupt keypers in DKG for eon %d: %s", dkg.Eon, corrupt)
+	}
 	dkgresult, err := dkg.Pure.ComputeResult()
 	if err != nil {
+		dkg.Failed = true
 		log.Printf("Error: DKG process failed for %s: %+v", dkg.ShortInfo(), err)
-		dcdr.sendShuttermintMessage(
-			"requesting DKG restart",
-			shmsg.NewEonStartVote(dkg.StartBatchIndex),
+		dcdr.notify(
+			notify.KindDKGFailed,
+			fmt.Sprint(dkg.Eon),
+			"DKG for eon %d (attempt %d) failed: %s, corrupt keypers: %s",
+			dkg.Eon,
+			dkg.Attempt,
+			err,
+			corrupt,
 		)
 		return
 	}
@@ -571,8 +764,9 @@ func (dcdr *Decider) broadcastEonPublicKey(dkgResult *puredkg.Result, startBatch
 }
 
 func (dcdr *Decider) syncDKGWithEon(dkg *DKG, eon observe.Eon) {
+	encryptionKey := dcdr.encryptionKeyAt(eon.StartHeight)
 	decrypt := func(encrypted []byte) ([]byte, error) {
-		return dcdr.Config.EncryptionKey.Decrypt(encrypted, []byte(""), []byte(""))
+		return encryptionKey.Decrypt(encrypted, []byte(""), []byte(""))
 	}
 	syncHeight := dcdr.State.SyncHeight
 	// We look at the next block's phase, because that is the first block that might make it
@@ -669,10 +863,6 @@ func (dcdr *Decider) syncEKGWithEon(syncHeight int64, ekg *EKG, eon *observe.Eon
 	}
 }
 
-// Add a prefix to avoid accidentally signing data with special meaning in different context, in
-// particular Ethereum transactions (c.f. EIP191 https://eips.ethereum.org/EIPS/eip-191).
-var hashPrefix = []byte{0x19, 'd', 'e', 'c', 't', 'x'}
-
 func transactionsHash(txs [][]byte) []byte {
 	keccak := sha3.NewLegacyKeccak256()
 	hash := make([]byte, keccak.Size())
@@ -690,58 +880,48 @@ func transactionsHash(txs [][]byte) []byte {
 	return hash
 }
 
-// computeDecryptionSignatureHash computes a cryptographic hash over the encrypted transactions,
-// the decrypted transactions, the batcher contracts address and the batch index.
-// It's the same hash we compute in the KeyperSlasher.sol's verifyAuthorization.
-func (dcdr *Decider) computeDecryptionSignatureHash(batchIndex uint64, cipherBatchHash, batchHash []byte) []byte {
-	keccak := sha3.NewLegacyKeccak256()
-	if _, err := keccak.Write(hashPrefix); err != nil {
-		panic(err)
-	}
-
-	batchIndexBuffer := new(bytes.Buffer)
-	err := binary.Write(batchIndexBuffer, binary.BigEndian, batchIndex)
-	if err != nil {
-		panic(err)
-	}
-
-	if _, err := keccak.Write(dcdr.Config.BatcherContractAddress.Bytes()); err != nil {
-		panic(err)
+// decryption returns the decryption we vouch for by signing the batch.
+func (dcdr *Decider) decryption(batchIndex uint64, cipherBatchHash common.Hash, batchHash []byte) signer.Decryption {
+	return signer.Decryption{
+		BatcherContract: dcdr.Config.BatcherContractAddress,
+		BatchIndex:      batchIndex,
+		CipherBatchHash: cipherBatchHash,
+		BatchHash:       batchHash,
 	}
-	if _, err := batchIndexBuffer.WriteTo(keccak); err != nil {
-		panic(err)
-	}
-	if _, err := keccak.Write(cipherBatchHash); err != nil {
-		panic(err)
-	}
-	if _, err := keccak.Write(batchHash); err != nil {
-		panic(err)
-	}
-	return keccak.Sum(nil)
 }
 
-func (dcdr *Decider) decryptTransactions(key *shcrypto.EpochSecretKey, epoch uint64) {
+// decryptBatch decrypts the main chain batch of the given epoch.
+func (dcdr *Decider) decryptBatch(key *shcrypto.EpochSecretKey, epoch uint64) *Batch {
 	batchIndex := epoch
 	batch, ok := dcdr.MainChain.Batches[batchIndex]
 	if !ok {
 		// We may run into this case if our main chain node is lagging behind or if the
-		// batch is empty. XXX The former case is not being handled here.
+		// batch is empty. In the former case, handleBatchChanges decrypts the batch again
+		// once we see its transactions.
 		log.Printf("Batch missing for batch index=%d", batchIndex)
 		batch = &observe.Batch{BatchIndex: batchIndex}
 	}
 	txs := batch.DecryptTransactions(key)
 	decryptedBatchHash := transactionsHash(txs)
-	hash := dcdr.computeDecryptionSignatureHash(batchIndex, batch.EncryptedBatchHash.Bytes(), decryptedBatchHash)
+	hash := signer.DecryptionSignatureHash(dcdr.decryption(batchIndex, batch.EncryptedBatchHash, decryptedBatchHash))
 
-	stBatch := &Batch{
+	return &Batch{
 		BatchIndex:              batchIndex,
+		EncryptedBatchHash:      batch.EncryptedBatchHash,
 		DecryptionSignatureHash: hash,
 		DecryptedTransactions:   txs,
 		DecryptedBatchHash:      decryptedBatchHash,
 		VerifiedSignatures:      nil,
 		IsEmpty:                 batch.EncryptedBatchHash == common.Hash{},
 	}
-	dcdr.State.Batches[batchIndex] = stBatch
+}
+
+func (dcdr *Decider) decryptTransactions(key *shcrypto.EpochSecretKey, epoch uint64) {
+	stBatch := dcdr.decryptBatch(key, epoch)
+	dcdr.State.Batches[stBatch.BatchIndex] = stBatch
+	// Pick up the signatures that have arrived before we've got here. They've been part of the
+	// changes already, so handleDecryptionSignatures won't look at them again.
+	dcdr.syncBatch(stBatch)
 }
 
 func (dcdr *Decider) sendDecryptionSignature(epoch uint64) {
@@ -761,7 +941,16 @@ func (dcdr *Decider) sendDecryptionSignature(epoch uint64) {
 	}
 
 	if uint64(len(stBatch.VerifiedSignatures)) < config.Threshold && !stBatch.IsEmpty {
-		signature, err := crypto.Sign(stBatch.DecryptionSignatureHash, dcdr.Config.SigningKey)
+		decryption := dcdr.decryption(batchIndex, stBatch.EncryptedBatchHash, stBatch.DecryptedBatchHash)
+		if mainChainBatch, ok := dcdr.MainChain.Batches[batchIndex]; ok && stBatch.EncryptedBatchHash == (common.Hash{}) {
+			// batches stored before we've tracked the encrypted batch hash don't have it set
+			decryption.CipherBatchHash = mainChainBatch.EncryptedBatchHash
+		}
+		if !bytes.Equal(signer.DecryptionSignatureHash(decryption), stBatch.DecryptionSignatureHash) {
+			log.Printf("Error: cannot reconstruct the decryption signature hash of batch %d", batchIndex)
+			return
+		}
+		signature, err := dcdr.Config.Signer.SignDecryption(decryption)
 		if err != nil {
 			log.Panicf("Cannot sign the decryption signature: %s", err)
 		}
@@ -774,7 +963,18 @@ func (dcdr *Decider) sendDecryptionSignature(epoch uint64) {
 }
 
 func (dcdr *Decider) syncEKGs() {
-	for _, ekg := range dcdr.State.EKGs {
+	allEons := func() []uint64 {
+		var eons []uint64
+		for _, ekg := range dcdr.State.EKGs {
+			eons = append(eons, ekg.Eon)
+		}
+		return eons
+	}
+	for _, eonIndex := range dcdr.changedKeys(dcdr.Changes.Shares, allEons) {
+		ekg, err := dcdr.State.FindEKGByEon(eonIndex)
+		if err != nil {
+			continue // we're not part of this eon or the DKG has failed
+		}
 		eon, err := dcdr.Shutter.FindEon(ekg.Eon)
 		if err != nil {
 			panic(err)
@@ -875,9 +1075,66 @@ func (dcdr *Decider) syncBatch(batch *Batch) {
 	batch.DecryptionSignatureIndex = len(shBatch.DecryptionSignatures)
 }
 
+// handleBatchChanges decrypts the batches again whose transactions have been added on the main
+// chain after we've decrypted them, which happens if our main chain node has been lagging behind.
+// If the batch can still be executed, we send our decryption signature for the new result.
+func (dcdr *Decider) handleBatchChanges() {
+	allBatches := func() []uint64 {
+		var batchIndices []uint64
+		for batchIndex := range dcdr.State.Batches {
+			batchIndices = append(batchIndices, batchIndex)
+		}
+		return batchIndices
+	}
+	for _, batchIndex := range dcdr.changedKeys(dcdr.Changes.Batches, allBatches) {
+		stBatch, ok := dcdr.State.Batches[batchIndex]
+		if !ok {
+			continue // not decrypted yet
+		}
+		batch, ok := dcdr.MainChain.Batches[batchIndex]
+		if !ok || batch.EncryptedBatchHash == stBatch.EncryptedBatchHash {
+			continue
+		}
+
+		eon, err := dcdr.Shutter.FindEonByBatchIndex(batchIndex)
+		if err != nil {
+			continue
+		}
+		ekg, err := dcdr.State.FindEKGByEon(eon.Eon)
+		if err != nil {
+			continue
+		}
+		key, ok := ekg.EpochKG.SecretKeys[batchIndex]
+		if !ok {
+			continue
+		}
+		newBatch := dcdr.decryptBatch(key, batchIndex)
+		if bytes.Equal(newBatch.DecryptionSignatureHash, stBatch.DecryptionSignatureHash) {
+			// state from before the hash of the decrypted batch was stored
+			stBatch.EncryptedBatchHash = newBatch.EncryptedBatchHash
+			continue
+		}
+		log.Printf("Transactions of batch %d changed since we've decrypted it, decrypting again", batchIndex)
+		dcdr.State.Batches[batchIndex] = newBatch
+		dcdr.syncBatch(newBatch)
+		if !dcdr.executionTimeoutReachedOrInactive(batchIndex) {
+			dcdr.sendDecryptionSignature(batchIndex)
+		}
+	}
+}
+
 func (dcdr *Decider) handleDecryptionSignatures() {
-	for _, batch := range dcdr.State.Batches {
-		dcdr.syncBatch(batch)
+	allBatches := func() []uint64 {
+		var batchIndices []uint64
+		for batchIndex := range dcdr.State.Batches {
+			batchIndices = append(batchIndices, batchIndex)
+		}
+		return batchIndices
+	}
+	for _, batchIndex := range dcdr.changedKeys(dcdr.Changes.Signatures, allBatches) {
+		if batch, ok := dcdr.State.Batches[batchIndex]; ok {
+			dcdr.syncBatch(batch)
+		}
 	}
 }
 
@@ -985,6 +1242,15 @@ func (dcdr *Decider) maybeExecuteHalfStep(nextHalfStep uint64) fx.IAction {
 
 	// skip cipher half steps if execution timeout block + delay is passed
 	if isCipherBatch && dcdr.MainChain.CurrentBlock >= executionTimeoutBlock {
+		if config.IsKeyper(dcdr.Config.Address()) {
+			dcdr.notify(
+				notify.KindExecutionTimeout,
+				fmt.Sprint(batchIndex),
+				"execution timeout of batch %d reached at block %d",
+				batchIndex,
+				executionTimeoutBlock,
+			)
+		}
 		if dcdr.MainChain.CurrentBlock >= executionTimeoutBlock+delay {
 			return &fx.SkipCipherBatch{
 				BatchIndex: batchIndex,
@@ -1050,18 +1316,40 @@ func (dcdr *Decider) getSortedDecryptionSignaturesWithIndices(batch *Batch) ([][
 }
 
 // maybeAppeal checks if there are any accusations against anyone and if so sends an appeal if
-// possible.
+// possible. We only look at new accusations, at accusations of batches we've got new signatures
+// for, since we might not have been able to appeal without them, and at accusations for which
+// our last appeal hasn't made it into the chain in time.
 func (dcdr *Decider) maybeAppeal() {
-	dcdr.syncPendingAppeals()
-
-	for _, accusation := range dcdr.MainChain.Accusations {
-		batchIndex := accusation.HalfStep / 2
+	halfSteps := observe.NewChangeSet().Accusations
+	for halfStep := range dcdr.Changes.Accusations {
+		halfSteps[halfStep] = struct{}{}
+	}
+	for batchIndex := range dcdr.Changes.Signatures {
+		halfSteps[2*batchIndex] = struct{}{}
+	}
+	for _, halfStep := range dcdr.openSlashingCases() {
+		if dcdr.State.Slashings[halfStep].AppealSentBlock != 0 {
+			halfSteps[halfStep] = struct{}{}
+		}
+	}
+	allAccusations := func() []uint64 {
+		var accused []uint64
+		for halfStep := range dcdr.MainChain.Accusations {
+			accused = append(accused, halfStep)
+		}
+		return accused
+	}
 
-		if accusation.Appealed {
+	for _, halfStep := range dcdr.changedKeys(halfSteps, allAccusations) {
+		accusation, ok := dcdr.MainChain.Accusations[halfStep]
+		if !ok {
 			continue
 		}
-		if _, ok := dcdr.State.PendingAppeals[accusation.HalfStep]; ok {
-			continue // don't send appeal if we've already done so and the tx is still pending
+		batchIndex := accusation.HalfStep / 2
+
+		slashingCase := dcdr.slashingCase(accusation)
+		if !slashingCase.appealDue(dcdr.MainChain.CurrentBlock) {
+			continue // appealed, slashed, or our appeal may still be pending
 		}
 
 		receipt, ok := dcdr.MainChain.CipherExecutionReceipts[accusation.HalfStep]
@@ -1097,7 +1385,16 @@ func (dcdr *Decider) maybeAppeal() {
 		action := fx.Appeal{
 			Authorization: authorization,
 		}
-		dcdr.State.PendingAppeals[accusation.HalfStep] = struct{}{}
+		if slashingCase.AppealSentBlock != 0 {
+			slashingCase.record(
+				dcdr.MainChain.CurrentBlock,
+				"appeal not included since block %d, retrying",
+				slashingCase.AppealSentBlock,
+			)
+		}
+		slashingCase.AppealSentBlock = dcdr.MainChain.CurrentBlock
+		slashingCase.NumAppealsSent++
+		slashingCase.record(dcdr.MainChain.CurrentBlock, "sending appeal")
 		dcdr.addAction(&action)
 	}
 }
@@ -1147,18 +1444,52 @@ func (dcdr *Decider) maybeAccuse() {
 	dcdr.State.HalfStepsChecked = dcdr.MainChain.NumExecutionHalfSteps
 }
 
-// syncPendingAppeals removes any pending appeals that have been successfully handled by the main
-// chain.
-// XXX: It's possible that someone else appeals, in which case our tx would still be pending.
-// Also, we don't notice if our tx fails (but this shouldn't happen if we prepare it properly).
-func (dcdr *Decider) syncPendingAppeals() {
-	for halfStep := range dcdr.State.PendingAppeals {
-		for _, accusation := range dcdr.MainChain.Accusations {
-			if halfStep == accusation.HalfStep && accusation.Appealed {
-				delete(dcdr.State.PendingAppeals, halfStep)
-			}
+// notifyAccusations notifies operators about new accusations against us. Expired appeal
+// deadlines are notified about by updateSlashingCase.
+func (dcdr *Decider) notifyAccusations() {
+	address := dcdr.Config.Address()
+	allAccusations := func() []uint64 {
+		var halfSteps []uint64
+		for halfStep := range dcdr.MainChain.Accusations {
+			halfSteps = append(halfSteps, halfStep)
+		}
+		return halfSteps
+	}
+	for _, halfStep := range dcdr.changedKeys(dcdr.Changes.Accusations, allAccusations) {
+		accusation, ok := dcdr.MainChain.Accusations[halfStep]
+		if !ok || accusation.Executor != address || accusation.Appealed {
+			continue
 		}
+		dcdr.notify(
+			notify.KindAccused,
+			fmt.Sprint(halfStep),
+			"accused by %s for half step %d at block %d",
+			accusation.Accuser.Hex(),
+			halfStep,
+			accusation.BlockNumber,
+		)
+	}
+}
+
+// handleMainChainReorgs resets the parts of our state that depend on main chain data if the main
+// chain observer rolled back its state due to a reorg, so that they are re-evaluated against the
+// new main chain state.
+func (dcdr *Decider) handleMainChainReorgs() {
+	if dcdr.MainChain.NumReorgs == dcdr.State.MainChainReorgs {
+		return
 	}
+	log.Printf(
+		"Main chain reorg detected, re-evaluating state at main chain block %d",
+		dcdr.MainChain.CurrentBlock,
+	)
+	dcdr.Changes.Full = true
+	dcdr.State.PendingHalfStep = nil
+	dcdr.State.resetPendingSlashingTXs()
+	dcdr.State.FeeWithdrawalSentBlock = 0
+	if dcdr.State.HalfStepsChecked > dcdr.MainChain.NumExecutionHalfSteps {
+		dcdr.State.HalfStepsChecked = dcdr.MainChain.NumExecutionHalfSteps
+	}
+	dcdr.State.MainChainReorgs = dcdr.MainChain.NumReorgs
 }
 
 // executionDelay returns the number of main chain blocks to wait before sending an execution tx.
@@ -1200,14 +1531,24 @@ func (dcdr *Decider) Decide() {
 		log.Printf("Not registered as keyper in shuttermint, nothing to do")
 		return
 	}
+	dcdr.handleMainChainReorgs()
 	dcdr.maybeSendCheckIn()
 	dcdr.maybeSendBatchConfig()
 	dcdr.maybeStartDKG()
 	dcdr.handleDKGs()
+	dcdr.maybeRetryDKGs()
+	dcdr.maybeRotateEon()
+	dcdr.maybeRotateKeys()
 	dcdr.handleEpochKG()
+	dcdr.handleBatchChanges()
 	dcdr.handleDecryptionSignatures()
 	dcdr.maybeExecuteBatch()
+	dcdr.handleSlashings()
 	dcdr.maybeAppeal()
 	dcdr.maybeAccuse()
+	dcdr.notifyAccusations()
+	dcdr.maybeWithdrawFees()
+	dcdr.pruneNotified()
 	dcdr.State.SyncHeight = dcdr.Shutter.CurrentBlock + 1
+	dcdr.State.MainChainSyncBlock = dcdr.MainChain.CurrentBlock + 1
 }
@@ -0,0 +1,117 @@
+package keyper
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/notify"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+)
+
+func TestHandleMainChainReorgs(t *testing.T) {
+	pendingHalfStep := uint64(10)
+	state := NewState()
+	state.PendingHalfStep = &pendingHalfStep
+	state.Slashings[4] = &SlashingCase{HalfStep: 4, AppealSentBlock: 3}
+	state.HalfStepsChecked = 12
+
+	mainChain := observe.NewMainChain(0)
+	mainChain.NumExecutionHalfSteps = 12
+	dcdr := Decider{State: state, MainChain: mainChain}
+
+	// without a reorg, nothing changes
+	dcdr.handleMainChainReorgs()
+	assert.Equal(t, *state.PendingHalfStep, pendingHalfStep)
+	assert.Equal(t, state.Slashings[4].AppealSentBlock, uint64(3))
+
+	rolledBack := observe.NewMainChain(0)
+	rolledBack.NumExecutionHalfSteps = 8
+	rolledBack.NumReorgs = 1
+	dcdr.MainChain = rolledBack
+	dcdr.handleMainChainReorgs()
+	assert.Assert(t, state.PendingHalfStep == nil)
+	assert.Equal(t, state.Slashings[4].AppealSentBlock, uint64(0))
+	assert.Equal(t, state.HalfStepsChecked, uint64(8))
+	assert.Equal(t, state.MainChainReorgs, uint64(1))
+}
+
+func TestGetChanges(t *testing.T) {
+	state := NewState()
+	shutter := observe.NewShutter()
+	mainChain := observe.NewMainChain(0)
+	world := observe.World{Shutter: shutter, MainChain: mainChain}
+
+	// we don't know what we've processed before the first step
+	assert.Assert(t, state.GetChanges(world).Full)
+
+	state.MainChainSyncBlock = 1
+	assert.Assert(t, !state.GetChanges(world).Full)
+
+	mainChain.Changes = []observe.Change{{Height: 5, Kind: observe.AccusationChanged, Index: 8}}
+	assert.Equal(t, len(state.GetChanges(world).Accusations), 1)
+	state.MainChainSyncBlock = 6
+	assert.Equal(t, len(state.GetChanges(world).Accusations), 0)
+	assert.Assert(t, state.GetChanges(world).IsEmpty())
+
+	mainChain.Changes = append(mainChain.Changes, observe.Change{Height: 7, Kind: observe.BatchChanged, Index: 3})
+	changes := state.GetChanges(world)
+	assert.Assert(t, !changes.IsEmpty())
+	assert.DeepEqual(t, observe.SortedKeys(changes.Batches), []uint64{3})
+}
+
+func TestCheckDeposit(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	config := Config{SigningKey: key}
+	shutter := observe.NewShutter()
+	shutter.BatchConfigs = []shutterevents.BatchConfig{{Keypers: []common.Address{config.Address()}}}
+	mainChain := observe.NewMainChain(0)
+	eon := &observe.Eon{Eon: 1}
+	dcdr := Decider{Config: config, State: NewState(), Shutter: shutter, MainChain: mainChain}
+
+	// without a required deposit, we only warn
+	assert.Assert(t, dcdr.checkDeposit(eon))
+	assert.Equal(t, len(dcdr.Notifications), 1)
+
+	dcdr.Config.RequireDeposit = true
+	assert.Assert(t, !dcdr.checkDeposit(eon))
+
+	// refused eons don't count as started
+	shutter.Eons = []observe.Eon{*eon}
+	dcdr.Changes = observe.NewChangeSet()
+	dcdr.Changes.Full = true
+	dcdr.maybeStartDKG()
+	assert.Equal(t, len(dcdr.State.DKGs), 0)
+	assert.Equal(t, dcdr.State.LastEonStarted, uint64(0))
+	assert.Equal(t, dcdr.State.LastEonSeen, uint64(1))
+
+	mainChain.Deposits[config.Address()] = &observe.Deposit{Amount: big.NewInt(1)}
+	assert.Assert(t, dcdr.checkDeposit(eon))
+
+	mainChain.Deposits[config.Address()] = &observe.Deposit{Amount: big.NewInt(0), Slashed: true}
+	assert.Assert(t, !dcdr.checkDeposit(eon))
+}
+
+func TestNotifyOnce(t *testing.T) {
+	mainChain := observe.NewMainChain(0)
+	dcdr := Decider{State: NewState(), MainChain: mainChain}
+	dcdr.notify(notify.KindExecutionTimeout, "5", "timeout")
+	dcdr.notify(notify.KindExecutionTimeout, "5", "timeout")
+	dcdr.notify(notify.KindExecutionTimeout, "6", "timeout")
+	assert.Equal(t, len(dcdr.Notifications), 2)
+
+	// the events are remembered in the state, so they aren't emitted again after a restart
+	restarted := Decider{State: cloneState(dcdr.State), MainChain: mainChain}
+	restarted.notify(notify.KindExecutionTimeout, "5", "timeout")
+	assert.Equal(t, len(restarted.Notifications), 0)
+
+	mainChain.CurrentBlock = notifiedRetentionBlocks + 1
+	restarted.pruneNotified()
+	restarted.notify(notify.KindExecutionTimeout, "5", "timeout")
+	assert.Equal(t, len(restarted.Notifications), 1)
+}
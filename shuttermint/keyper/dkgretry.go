@@ -0,0 +1,52 @@
+package keyper
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/shutter-network/shutter/shuttermint/medley"
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+// retryHeight returns the first shuttermint block at which shuttermint accepts votes to start
+// another eon after this DKG failed.
+func (dkg *DKG) retryHeight() int64 {
+	return dkg.StartHeight + medley.EonRetryDelay(dkg.Attempt)
+}
+
+// superseded checks if an eon for a later batch than the given DKG's has been started, so that
+// retrying the DKG would start an eon in the past.
+func (dcdr *Decider) superseded(dkg *DKG) bool {
+	eons := dcdr.Shutter.Eons
+	return len(eons) > 0 && eons[len(eons)-1].StartEvent.BatchIndex > dkg.StartBatchIndex
+}
+
+// maybeRetryDKGs votes to start a new eon for the batch config of a failed DKG once the retry
+// delay shuttermint and the keypers agree on has passed. If no new eon is started, e.g. because
+// our vote didn't make it into the chain, we vote again after EonRetryBaseDelay blocks. Eons we
+// haven't taken part in don't stop us from voting, unless they're for a later batch.
+func (dcdr *Decider) maybeRetryDKGs() {
+	// We look at the next block's height, because that is the first block our vote can make it
+	// into
+	nextHeight := dcdr.Shutter.CurrentBlock + 1
+	for i := range dcdr.State.DKGs {
+		dkg := &dcdr.State.DKGs[i]
+		if !dkg.Failed || dcdr.State.LastEonStarted > dkg.Eon || dcdr.superseded(dkg) {
+			continue
+		}
+		if nextHeight < dkg.retryHeight() {
+			continue
+		}
+		if dkg.RetryVoteHeight != 0 && nextHeight < dkg.RetryVoteHeight+medley.EonRetryBaseDelay {
+			continue
+		}
+		if dkg.RetryVoteHeight != 0 {
+			log.Printf("No new eon started since our vote at block %d, voting again", dkg.RetryVoteHeight)
+		}
+		dkg.RetryVoteHeight = nextHeight
+		dcdr.sendShuttermintMessage(
+			fmt.Sprintf("eon start vote, retrying failed eon %d (attempt %d)", dkg.Eon, dkg.Attempt+1),
+			shmsg.NewEonStartVote(dkg.StartBatchIndex),
+		)
+	}
+}
@@ -0,0 +1,68 @@
+package keyper
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shlib/puredkg"
+	"github.com/shutter-network/shutter/shlib/shcrypto"
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+	"github.com/shutter-network/shutter/shuttermint/medley"
+)
+
+func TestCorruptKeypers(t *testing.T) {
+	keypers := []common.Address{{1}, {2}, {3}}
+	pure := puredkg.NewPureDKG(1, 3, 2, 0)
+	pure.Commitments[0] = &shcrypto.Gammas{}
+	pure.Commitments[2] = &shcrypto.Gammas{}
+	dkg := DKG{Eon: 1, Keypers: keypers, Pure: &pure}
+	assert.DeepEqual(t, dkg.corruptKeypers(), []common.Address{{2}})
+	assert.Assert(t, !dkg.isCorrupt())
+}
+
+func TestMaybeRetryDKGs(t *testing.T) {
+	state := NewState()
+	state.LastEonStarted = 3
+	state.DKGs = []DKG{{
+		Eon:             3,
+		StartBatchIndex: 100,
+		StartHeight:     10,
+		Attempt:         1,
+		Failed:          true,
+	}}
+	shutter := observe.NewShutter()
+
+	step := func(currentBlock int64) []fx.IAction {
+		shutter.CurrentBlock = currentBlock
+		dcdr := &Decider{State: state, Shutter: shutter}
+		dcdr.maybeRetryDKGs()
+		return dcdr.Actions
+	}
+
+	retryHeight := 10 + medley.EonRetryDelay(1)
+	assert.Equal(t, len(step(retryHeight-2)), 0)
+	actions := step(retryHeight - 1)
+	assert.Equal(t, len(actions), 1)
+	msg := actions[0].(*fx.SendShuttermintMessage).Msg
+	assert.Equal(t, msg.GetEonStartVote().GetStartBatchIndex(), uint64(100))
+
+	// vote again if no new eon has been started
+	assert.Equal(t, len(step(retryHeight)), 0)
+	assert.Equal(t, len(step(retryHeight-1+medley.EonRetryBaseDelay)), 1)
+
+	// keep voting if we haven't taken part in the new eon, e.g. because we've refused to, unless
+	// it's for a later batch
+	shutter.Eons = []observe.Eon{{Eon: 4, StartEvent: shutterevents.EonStarted{Eon: 4, BatchIndex: 100}}}
+	assert.Equal(t, len(step(retryHeight-1+2*medley.EonRetryBaseDelay)), 1)
+	shutter.Eons[0].StartEvent.BatchIndex = 200
+	assert.Equal(t, len(step(retryHeight-1+3*medley.EonRetryBaseDelay)), 0)
+
+	// stop voting once we've taken part in a new eon
+	shutter.Eons = nil
+	state.LastEonStarted = 4
+	assert.Equal(t, len(step(retryHeight+10*medley.EonRetryBaseDelay)), 0)
+}
@@ -0,0 +1,45 @@
+package keyper
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+)
+
+// feeWithdrawalRetryBlocks is the number of main chain blocks we wait for a fee withdrawal to
+// show up before sending it again.
+const feeWithdrawalRetryBlocks = 60
+
+// etherToWei converts an amount of ETH as used in the config to wei.
+func etherToWei(eth float64) *big.Int {
+	wei, _ := new(big.Float).Mul(big.NewFloat(eth), big.NewFloat(params.Ether)).Int(nil)
+	return wei
+}
+
+// maybeWithdrawFees withdraws our fees from the fee bank to the configured address once they
+// reach the configured threshold.
+func (dcdr *Decider) maybeWithdrawFees() {
+	receiver := dcdr.Config.FeeWithdrawalAddress
+	if receiver == (common.Address{}) {
+		return
+	}
+	account := dcdr.Config.Address()
+	balance := dcdr.MainChain.FeeBankBalances[account]
+	if balance == 0 || new(big.Int).SetUint64(balance).Cmp(etherToWei(dcdr.Config.FeeWithdrawalThreshold)) < 0 {
+		return
+	}
+	sentBlock := dcdr.State.FeeWithdrawalSentBlock
+	if sentBlock != 0 && dcdr.MainChain.CurrentBlock < sentBlock+feeWithdrawalRetryBlocks {
+		return // our last withdrawal may still be pending
+	}
+
+	dcdr.State.FeeWithdrawalSentBlock = dcdr.MainChain.CurrentBlock
+	dcdr.addAction(&fx.WithdrawFees{
+		Account:  account,
+		Receiver: receiver,
+		Amount:   balance,
+	})
+}
@@ -0,0 +1,58 @@
+package keyper
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+)
+
+func TestMaybeWithdrawFees(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	config := Config{
+		SigningKey:             key,
+		FeeWithdrawalAddress:   common.Address{1},
+		FeeWithdrawalThreshold: 1,
+	}
+	state := NewState()
+	mainChain := observe.NewMainChain(0)
+	mainChain.CurrentBlock = 100
+
+	step := func() []fx.IAction {
+		dcdr := &Decider{
+			Config:    config,
+			State:     state,
+			MainChain: mainChain,
+			Changes:   observe.NewChangeSet(),
+		}
+		dcdr.maybeWithdrawFees()
+		return dcdr.Actions
+	}
+
+	// below the threshold
+	mainChain.FeeBankBalances[config.Address()] = 999_999_999_999_999_999
+	assert.Equal(t, len(step()), 0)
+
+	mainChain.FeeBankBalances[config.Address()] = 1_000_000_000_000_000_000
+	assert.DeepEqual(t, step(), []fx.IAction{&fx.WithdrawFees{
+		Account:  config.Address(),
+		Receiver: common.Address{1},
+		Amount:   1_000_000_000_000_000_000,
+	}})
+
+	// wait for the withdrawal to be included before retrying
+	mainChain.CurrentBlock = 101
+	assert.Equal(t, len(step()), 0)
+	mainChain.CurrentBlock = 100 + feeWithdrawalRetryBlocks
+	assert.Equal(t, len(step()), 1)
+
+	// disabled without a withdrawal address
+	config.FeeWithdrawalAddress = common.Address{}
+	mainChain.CurrentBlock = 1000
+	assert.Equal(t, len(step()), 0)
+}
@@ -6,19 +6,24 @@ import (
 	"strings"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 
 	"github.com/shutter-network/shutter/shlib/shcrypto"
 	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
 	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
 	"github.com/shutter-network/shutter/shuttermint/shmsg"
 )
 
+// The gas limits of main chain transactions are estimated before sending them. The following
+// values cap the estimates.
 const (
 	eonKeyBroadcastGasLimit     = uint64(1_000_000)
 	executeCipherBatchBaseLimit = uint64(250_000)
-	executePlainBatchBaseLimit  = uint64(250_000) // XXX check if we can lower that value
+	executePlainBatchBaseLimit  = uint64(250_000)
 	skipCipherExecutionLimit    = uint64(200_000)
+	withdrawFeesGasLimit        = uint64(100_000)
 )
 
 // IAction describes an action to run as determined by the Decider's Decide method.
@@ -32,7 +37,36 @@ type IAction interface {
 // MainChainTX is an action that sends a transaction to the main chain.
 type MainChainTX interface {
 	IAction
+	// SendTX sends the transaction using the given transactor. The gas limit is set by the
+	// caller.
 	SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error)
+	// Urgency determines how much we are willing to pay to get the transaction included quickly.
+	Urgency() gaspricer.Urgency
+	// GasCap returns the maximum gas limit we use for the transaction. Zero means no limit.
+	GasCap() uint64
+	// HandleRevert classifies the error of a transaction that would revert. It returns an
+	// ActionDoneError if the revert reason shows that the action has already been performed, a
+	// NonRetriableError if retrying won't help, or the error itself otherwise.
+	HandleRevert(err error) error
+}
+
+// classifyRevert implements HandleRevert given the revert reasons indicating that the action is
+// done and those indicating that retrying won't help.
+func classifyRevert(err error, done []string, nonRetriable []string) error {
+	if errorMsgContains(err, done) {
+		return &ActionDoneError{Err: err}
+	}
+	if errorMsgContains(err, nonRetriable) {
+		return &NonRetriableError{Err: err}
+	}
+	return err
+}
+
+// executionDoneReasons are the revert reasons of the executor contract telling us that the half
+// step we want to execute or skip has already been passed.
+var executionDoneReasons = []string{
+	"ExecutorContract: unexpected batch index",
+	"ExecutorContract: unexpected half step",
 }
 
 var (
@@ -42,6 +76,8 @@ var (
 	_ MainChainTX = SkipCipherBatch{}
 	_ MainChainTX = Accuse{}
 	_ MainChainTX = Appeal{}
+	_ MainChainTX = Slash{}
+	_ MainChainTX = WithdrawFees{}
 	_ MainChainTX = EonKeyBroadcast{}
 )
 
@@ -53,6 +89,8 @@ func init() {
 		&SkipCipherBatch{},
 		&Accuse{},
 		&Appeal{},
+		&Slash{},
+		&WithdrawFees{},
 		&EonKeyBroadcast{},
 	} {
 		gob.Register(a)
@@ -82,12 +120,15 @@ type ExecuteCipherBatch struct {
 	TransactionGasLimit uint64
 }
 
-func (a ExecuteCipherBatch) gasLimit() uint64 {
+func (a ExecuteCipherBatch) GasCap() uint64 {
 	return executeCipherBatchBaseLimit + uint64(len(a.Transactions))*a.TransactionGasLimit
 }
 
+func (a ExecuteCipherBatch) HandleRevert(err error) error {
+	return classifyRevert(err, executionDoneReasons, nil)
+}
+
 func (a ExecuteCipherBatch) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
-	auth.GasLimit = a.gasLimit()
 	return caller.ExecutorContract.ExecuteCipherBatch(
 		auth, a.BatchIndex, a.CipherBatchHash, a.Transactions, a.KeyperIndex,
 	)
@@ -97,6 +138,10 @@ func (a ExecuteCipherBatch) String() string {
 	return fmt.Sprintf("=> executor contract: execute cipher batch %d with %d txs", a.BatchIndex, len(a.Transactions))
 }
 
+func (a ExecuteCipherBatch) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyHigh
+}
+
 func (a ExecuteCipherBatch) IsExpired(world observe.World) bool {
 	halfStep := 2 * a.BatchIndex
 	return world.MainChain.NumExecutionHalfSteps > halfStep
@@ -109,12 +154,15 @@ type ExecutePlainBatch struct {
 	TransactionGasLimit uint64
 }
 
-func (a ExecutePlainBatch) gasLimit() uint64 {
+func (a ExecutePlainBatch) GasCap() uint64 {
 	return executePlainBatchBaseLimit + uint64(len(a.Transactions))*a.TransactionGasLimit
 }
 
+func (a ExecutePlainBatch) HandleRevert(err error) error {
+	return classifyRevert(err, executionDoneReasons, nil)
+}
+
 func (a ExecutePlainBatch) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
-	auth.GasLimit = a.gasLimit()
 	return caller.ExecutorContract.ExecutePlainBatch(auth, a.BatchIndex, a.Transactions)
 }
 
@@ -122,6 +170,10 @@ func (a ExecutePlainBatch) String() string {
 	return fmt.Sprintf("=> executor contract: execute plain batch %d with %d txs", a.BatchIndex, len(a.Transactions))
 }
 
+func (a ExecutePlainBatch) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyHigh
+}
+
 func (a ExecutePlainBatch) IsExpired(world observe.World) bool {
 	halfStep := 2*a.BatchIndex + 1
 	return world.MainChain.NumExecutionHalfSteps > halfStep
@@ -132,8 +184,15 @@ type SkipCipherBatch struct {
 	BatchIndex uint64
 }
 
+func (a SkipCipherBatch) GasCap() uint64 {
+	return skipCipherExecutionLimit
+}
+
+func (a SkipCipherBatch) HandleRevert(err error) error {
+	return classifyRevert(err, executionDoneReasons, nil)
+}
+
 func (a SkipCipherBatch) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
-	auth.GasLimit = skipCipherExecutionLimit
 	return caller.ExecutorContract.SkipCipherExecution(auth, a.BatchIndex)
 }
 
@@ -141,6 +200,10 @@ func (a SkipCipherBatch) String() string {
 	return fmt.Sprintf("=> executor contract: skip cipher batch %d", a.BatchIndex)
 }
 
+func (a SkipCipherBatch) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyHigh
+}
+
 func (a SkipCipherBatch) IsExpired(world observe.World) bool {
 	halfStep := 2 * a.BatchIndex
 	return world.MainChain.NumExecutionHalfSteps > halfStep
@@ -165,25 +228,35 @@ func errorMsgContains(err error, msgs []string) bool {
 	return false
 }
 
+func (a Accuse) GasCap() uint64 {
+	return 0
+}
+
+// HandleRevert classifies the revert reason. If we try to accuse an empty batch, we may have run
+// into a fork.  In a perfect world we would detect that and not try to run any actions that rely
+// on obsolete information. Alas, we don't do that at the moment, but know for sure that we cannot
+// accuse an empty batch, so there's no need to retry this action.
+func (a Accuse) HandleRevert(err error) error {
+	return classifyRevert(
+		err,
+		[]string{"KeyperSlasher: already accused"},
+		[]string{"KeyperSlasher: cannot accuse empty batch"},
+	)
+}
+
 func (a Accuse) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
 	tx, err := caller.KeyperSlasher.Accuse(auth, a.HalfStep, a.KeyperIndex)
-	// If we try to accuse an empty batch, we may have run into a fork.  In a perfect world we
-	// would detect that and not try to run any actions that rely on obsolete information.
-	// Alas, we don't do that at the moment, but know for sure that we cannot accuse an empty
-	// batch, so there's no need to retry this action
-	if errorMsgContains(err, []string{
-		"cannot accuse empty batch",
-		"already accused",
-	}) {
-		err = &NonRetriableError{Err: err}
-	}
-	return tx, err
+	return tx, a.HandleRevert(err)
 }
 
 func (a Accuse) String() string {
 	return fmt.Sprintf("=> keyper slasher: accuse for half step %d", a.HalfStep)
 }
 
+func (a Accuse) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyLow
+}
+
 func (a Accuse) IsExpired(world observe.World) bool {
 	_, ok := world.MainChain.Accusations[a.HalfStep]
 	return ok
@@ -194,18 +267,33 @@ type Appeal struct {
 	Authorization contract.Authorization
 }
 
+func (a Appeal) GasCap() uint64 {
+	return 0
+}
+
+func (a Appeal) HandleRevert(err error) error {
+	return classifyRevert(
+		err,
+		[]string{"KeyperSlasher: already appealed"},
+		[]string{"KeyperSlasher: wrong signer"},
+	)
+}
+
 func (a Appeal) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
 	tx, err := caller.KeyperSlasher.Appeal(auth, a.Authorization)
-	if err != nil && strings.Contains(err.Error(), "wrong signer") {
-		err = &NonRetriableError{Err: err}
-	}
-	return tx, err
+	return tx, a.HandleRevert(err)
 }
 
 func (a Appeal) String() string {
 	return fmt.Sprintf("=> keyper slasher: appeal for half step %d", a.Authorization.HalfStep)
 }
 
+// Urgency of appeals is high, because they have to be included before the accusation leads to
+// slashing.
+func (a Appeal) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyHigh
+}
+
 func (a Appeal) IsExpired(world observe.World) bool {
 	acc, ok := world.MainChain.Accusations[a.Authorization.HalfStep]
 	if !ok {
@@ -214,6 +302,84 @@ func (a Appeal) IsExpired(world observe.World) bool {
 	return acc.Appealed
 }
 
+// Slash is an action slashing the executor of an accusation that hasn't been appealed within the
+// appeal period.
+type Slash struct {
+	HalfStep uint64
+}
+
+func (a Slash) GasCap() uint64 {
+	return 0
+}
+
+func (a Slash) HandleRevert(err error) error {
+	return classifyRevert(
+		err,
+		[]string{"KeyperSlasher: already slashed", "KeyperSlasher: successfully appealed"},
+		[]string{"KeyperSlasher: no accusation"},
+	)
+}
+
+func (a Slash) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
+	tx, err := caller.KeyperSlasher.Slash(auth, a.HalfStep)
+	return tx, a.HandleRevert(err)
+}
+
+func (a Slash) String() string {
+	return fmt.Sprintf("=> keyper slasher: slash for half step %d", a.HalfStep)
+}
+
+func (a Slash) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyLow
+}
+
+func (a Slash) IsExpired(world observe.World) bool {
+	acc, ok := world.MainChain.Accusations[a.HalfStep]
+	if !ok {
+		return true
+	}
+	return acc.Appealed || acc.Slashed
+}
+
+// WithdrawFees is an action withdrawing the fees of Account from the fee bank to Receiver.
+type WithdrawFees struct {
+	Account  common.Address
+	Receiver common.Address
+	Amount   uint64
+}
+
+func (a WithdrawFees) GasCap() uint64 {
+	return withdrawFeesGasLimit
+}
+
+func (a WithdrawFees) HandleRevert(err error) error {
+	return classifyRevert(
+		err,
+		[]string{"FeeBank: deposit is empty", "FeeBank: amount exceeds deposit"},
+		[]string{"FeeBank: receiver is zero address", "FeeBank: withdrawal call failed"},
+	)
+}
+
+func (a WithdrawFees) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
+	tx, err := caller.FeeBankContract.Withdraw0(auth, a.Receiver, a.Amount)
+	return tx, a.HandleRevert(err)
+}
+
+func (a WithdrawFees) String() string {
+	return fmt.Sprintf("=> fee bank: withdraw %d wei to %s", a.Amount, a.Receiver.Hex())
+}
+
+func (a WithdrawFees) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyLow
+}
+
+// IsExpired checks if the fees have been withdrawn already. We don't know the balance if we're
+// not the fee receiver of any config anymore, in which case we try anyway.
+func (a WithdrawFees) IsExpired(world observe.World) bool {
+	balance, ok := world.MainChain.FeeBankBalances[a.Account]
+	return ok && balance < a.Amount
+}
+
 // EonKeyBroadcast is an action sending a vote for an eon public key to the key broadcast contract.
 type EonKeyBroadcast struct {
 	KeyperIndex     uint64
@@ -221,8 +387,15 @@ type EonKeyBroadcast struct {
 	EonPublicKey    *shcrypto.EonPublicKey
 }
 
+func (a EonKeyBroadcast) GasCap() uint64 {
+	return eonKeyBroadcastGasLimit
+}
+
+func (a EonKeyBroadcast) HandleRevert(err error) error {
+	return classifyRevert(err, []string{"KeyBroadcastContract: keyper has already voted"}, nil)
+}
+
 func (a EonKeyBroadcast) SendTX(caller *contract.Caller, auth *bind.TransactOpts) (*types.Transaction, error) {
-	auth.GasLimit = eonKeyBroadcastGasLimit
 	return caller.KeyBroadcastContract.Vote(
 		auth,
 		a.KeyperIndex,
@@ -235,6 +408,10 @@ func (a EonKeyBroadcast) String() string {
 	return fmt.Sprintf("=> key broadcast contract: voting for eon key with start batch %d", a.StartBatchIndex)
 }
 
+func (a EonKeyBroadcast) Urgency() gaspricer.Urgency {
+	return gaspricer.UrgencyNormal
+}
+
 func (a EonKeyBroadcast) IsExpired(world observe.World) bool {
 	return false
 }
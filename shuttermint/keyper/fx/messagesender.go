@@ -2,16 +2,19 @@ package fx
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/base64"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/mempool"
 	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
 	tmtypes "github.com/tendermint/tendermint/types"
 
+	"github.com/shutter-network/shutter/shuttermint/keyper/signer"
 	"github.com/shutter-network/shutter/shuttermint/shmsg"
 )
 
@@ -70,11 +73,13 @@ type MessageSender interface {
 	SendMessage(context.Context, *shmsg.Message) error
 }
 
-// RPCMessageSender signs messages and sends them via RPC to shuttermint.
+// RPCMessageSender signs messages and sends them via RPC to shuttermint. Each message is
+// broadcast to all of the given shuttermint nodes, so that it gets included even if some of them
+// are down.
 type RPCMessageSender struct {
-	rpcclient  client.Client
+	rpcclients []client.Client
 	chainID    string
-	signingKey *ecdsa.PrivateKey
+	signer     signer.Signer
 }
 
 var _ MessageSender = &RPCMessageSender{}
@@ -92,12 +97,12 @@ func init() {
 	rand.Seed(time.Now().UnixNano()) // Seed the PRNG we use for random nonces
 }
 
-// NewRPCMessageSender creates a new RPCMessageSender.
-func NewRPCMessageSender(cl client.Client, signingKey *ecdsa.PrivateKey) RPCMessageSender {
+// NewRPCMessageSender creates a new RPCMessageSender sending messages to the given nodes.
+func NewRPCMessageSender(cls []client.Client, s signer.Signer) RPCMessageSender {
 	return RPCMessageSender{
-		rpcclient:  cl,
+		rpcclients: cls,
 		chainID:    "",
-		signingKey: signingKey,
+		signer:     s,
 	}
 }
 
@@ -108,21 +113,69 @@ func (ms *RPCMessageSender) SendMessage(ctx context.Context, msg *shmsg.Message)
 	}
 
 	msgWithNonce := ms.addNonceAndChainID(msg)
-	signedMessage, err := shmsg.SignMessage(msgWithNonce, ms.signingKey)
+	signedMessage, err := shmsg.SignMessageWith(msgWithNonce, ms.signer.SignMessage)
 	if err != nil {
 		return err
 	}
 	var tx tmtypes.Tx = tmtypes.Tx(base64.RawURLEncoding.EncodeToString(signedMessage))
-	res, err := ms.rpcclient.BroadcastTxCommit(ctx, tx)
-	if err != nil {
-		return err
+	return ms.broadcast(ctx, tx)
+}
+
+// isTxInCache checks if the error has been returned by a node that already knows the tx, e.g.
+// because another node gossiped it.
+func isTxInCache(err error) bool {
+	return strings.Contains(err.Error(), mempool.ErrTxInCache.Error())
+}
+
+// broadcast sends the tx to all nodes and waits until one of them reports that it has been
+// committed. Since all nodes receive the same tx, it is included at most once. Nodes that already
+// know the tx are ignored, as the node we've sent it to first will report the result.
+func (ms *RPCMessageSender) broadcast(ctx context.Context, tx tmtypes.Tx) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		res *ctypes.ResultBroadcastTxCommit
+		err error
 	}
-	if res.DeliverTx.Code != 0 {
-		return &RemoteError{
-			msg: res.DeliverTx.Log,
+	results := make(chan result, len(ms.rpcclients))
+	for _, cl := range ms.rpcclients {
+		go func(cl client.Client) {
+			res, err := cl.BroadcastTxCommit(ctx, tx)
+			results <- result{res: res, err: err}
+		}(cl)
+	}
+
+	var lastErr error
+	numInCache := 0
+	for range ms.rpcclients {
+		r := <-results
+		if r.err != nil {
+			if isTxInCache(r.err) {
+				numInCache++
+			} else {
+				lastErr = r.err
+			}
+			continue
 		}
+		if r.res.CheckTx.Code != 0 {
+			return &RemoteError{
+				msg: r.res.CheckTx.Log,
+			}
+		}
+		if r.res.DeliverTx.Code != 0 {
+			return &RemoteError{
+				msg: r.res.DeliverTx.Log,
+			}
+		}
+		return nil
 	}
-	return nil
+	if numInCache > 0 {
+		// The nodes we've sent the tx to first failed to report the result, but the tx has
+		// been accepted into the mempool
+		return nil
+	}
+	return lastErr
 }
 
 func (ms *RPCMessageSender) addNonceAndChainID(msg *shmsg.Message) *shmsg.MessageWithNonce {
@@ -138,7 +191,14 @@ func (ms *RPCMessageSender) maybeFetchChainID(ctx context.Context) error {
 		return nil
 	}
 
-	info, err := ms.rpcclient.BlockchainInfo(ctx, 0, 0)
+	var info *ctypes.ResultBlockchainInfo
+	var err error
+	for _, cl := range ms.rpcclients {
+		info, err = cl.BlockchainInfo(ctx, 0, 0)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return err
 	}
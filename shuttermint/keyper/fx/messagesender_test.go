@@ -0,0 +1,57 @@
+package fx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/mempool"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"gotest.tools/v3/assert"
+)
+
+// fakeNode implements BroadcastTxCommit only. Calling any other method panics.
+type fakeNode struct {
+	client.Client
+	err          error
+	deliverTxLog string // non-empty if the tx fails
+}
+
+func (n *fakeNode) BroadcastTxCommit(_ context.Context, _ tmtypes.Tx) (*ctypes.ResultBroadcastTxCommit, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	res := &ctypes.ResultBroadcastTxCommit{}
+	if n.deliverTxLog != "" {
+		res.DeliverTx = abcitypes.ResponseDeliverTx{Code: 1, Log: n.deliverTxLog}
+	}
+	return res, nil
+}
+
+func TestBroadcast(t *testing.T) {
+	ctx := context.Background()
+	down := &fakeNode{err: errors.New("connection refused")}
+	inCache := &fakeNode{err: errors.Errorf("error on broadcastTxCommit: %s", mempool.ErrTxInCache)}
+	ok := &fakeNode{}
+	failing := &fakeNode{deliverTxLog: "bad message"}
+
+	send := func(nodes ...*fakeNode) error {
+		var cls []client.Client
+		for _, n := range nodes {
+			cls = append(cls, n)
+		}
+		ms := NewRPCMessageSender(cls, nil)
+		return ms.broadcast(ctx, tmtypes.Tx("tx"))
+	}
+
+	assert.NilError(t, send(down, inCache, ok))
+	assert.NilError(t, send(down, inCache))
+	assert.Error(t, send(down, down), "connection refused")
+
+	err := send(inCache, failing)
+	assert.Assert(t, !IsRetriable(err))
+	assert.Error(t, err, "remote error: bad message")
+}
@@ -0,0 +1,195 @@
+package fx
+
+import (
+	"encoding/gob"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
+)
+
+// NonceManager hands out the nonces of the main chain transactions we send. Nonces are handed out
+// sequentially, so that transactions sent concurrently never use the same nonce. The next nonce
+// and the cancel transactions we've sent are stored on disk with gob, so that we don't reuse
+// nonces after a restart.
+//
+// A nonce that has been handed out, but is neither used by an in-flight transaction nor reserved
+// for one that is about to be sent, is a gap. Gaps block all later transactions and are filled
+// with cancel transactions.
+type NonceManager struct {
+	mux       sync.Mutex
+	Next      uint64                 // the next nonce to hand out
+	CancelTXs map[uint64]*InFlightTX // cancel transactions sent to fill gaps
+	reserved  map[uint64]struct{}    // handed out nonces, for which no tx has been recorded yet
+	path      string
+}
+
+// NewNonceManager creates a new NonceManager storing its state at the given path.
+func NewNonceManager(path string) *NonceManager {
+	return &NonceManager{
+		Next:      0,
+		CancelTXs: make(map[uint64]*InFlightTX),
+		reserved:  make(map[uint64]struct{}),
+		path:      path,
+	}
+}
+
+// Reserve hands out the next nonce. pendingNonce is the nonce of the next transaction according
+// to the Ethereum node. It is used if it's larger than our next nonce, e.g. because transactions
+// have been sent from our account by someone else.
+func (nm *NonceManager) Reserve(pendingNonce uint64) uint64 {
+	nm.mux.Lock()
+	defer nm.mux.Unlock()
+
+	if pendingNonce > nm.Next {
+		nm.Next = pendingNonce
+	}
+	nonce := nm.Next
+	nm.Next++
+	nm.reserved[nonce] = struct{}{}
+	nm.save()
+	return nonce
+}
+
+// Commit marks the given reserved nonce as used. It should be called after the transaction using
+// it has been recorded as in-flight.
+func (nm *NonceManager) Commit(nonce uint64) {
+	nm.mux.Lock()
+	defer nm.mux.Unlock()
+	delete(nm.reserved, nonce)
+}
+
+// Release gives back a reserved nonce that has not been used, because sending the transaction
+// failed. If it's the last nonce handed out, it will be handed out again, otherwise it becomes a
+// gap.
+func (nm *NonceManager) Release(nonce uint64) {
+	nm.mux.Lock()
+	defer nm.mux.Unlock()
+
+	delete(nm.reserved, nonce)
+	if nonce+1 == nm.Next {
+		nm.Next--
+		nm.save()
+	}
+}
+
+// Gaps returns the sorted nonces that need to be filled with cancel transactions. minedNonce is
+// the nonce of the next transaction to be mined, i.e. the number of transactions from our account
+// included in the chain. inUse contains the nonces of the in-flight transactions. Cancel
+// transactions that are no longer needed are forgotten.
+func (nm *NonceManager) Gaps(minedNonce uint64, inUse map[uint64]struct{}) []uint64 {
+	nm.mux.Lock()
+	defer nm.mux.Unlock()
+
+	changed := false
+	for nonce := range nm.CancelTXs {
+		if nonce < minedNonce {
+			delete(nm.CancelTXs, nonce)
+			changed = true
+		}
+	}
+	if changed {
+		nm.save()
+	}
+
+	var gaps []uint64
+	for nonce := minedNonce; nonce < nm.Next; nonce++ {
+		if _, ok := inUse[nonce]; ok {
+			continue
+		}
+		if _, ok := nm.reserved[nonce]; ok {
+			continue
+		}
+		if _, ok := nm.CancelTXs[nonce]; ok {
+			continue
+		}
+		gaps = append(gaps, nonce)
+	}
+	return gaps
+}
+
+// AddCancelTX records a cancel transaction sent at the given main chain block. If a cancel
+// transaction with the same nonce has been sent before, the new one replaces it.
+func (nm *NonceManager) AddCancelTX(tx *types.Transaction, block uint64) {
+	nm.mux.Lock()
+	defer nm.mux.Unlock()
+
+	cancel, ok := nm.CancelTXs[tx.Nonce()]
+	if !ok {
+		cancel = &InFlightTX{Nonce: tx.Nonce()}
+		nm.CancelTXs[tx.Nonce()] = cancel
+	}
+	cancel.Hashes = append(cancel.Hashes, tx.Hash())
+	cancel.Fees = gaspricer.FeesFromTX(tx)
+	cancel.SentBlock = block
+	nm.save()
+}
+
+// GetCancelTXs returns copies of the cancel transactions sorted by nonce.
+func (nm *NonceManager) GetCancelTXs() []InFlightTX {
+	nm.mux.Lock()
+	defer nm.mux.Unlock()
+
+	var res []InFlightTX
+	for _, cancel := range nm.CancelTXs {
+		c := *cancel
+		c.Hashes = append([]common.Hash{}, cancel.Hashes...)
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Nonce < res[j].Nonce })
+	return res
+}
+
+// save saves the nonce manager to disk. It panics if it cannot write the file to disk.
+func (nm *NonceManager) save() {
+	tmppath := nm.path + ".tmp"
+	file, err := os.Create(tmppath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	enc := gob.NewEncoder(file)
+	err = enc.Encode(nm)
+	if err != nil {
+		panic(err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		panic(err)
+	}
+	err = os.Rename(tmppath, nm.path)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Load loads the nonce manager from disk. Nonces reserved before a restart are not reserved
+// anymore, so they become gaps unless they are used by an in-flight transaction.
+func (nm *NonceManager) Load() error {
+	nm.mux.Lock()
+	defer nm.mux.Unlock()
+	gobfile, err := os.Open(nm.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer gobfile.Close()
+	dec := gob.NewDecoder(gobfile)
+	err = dec.Decode(nm)
+	if err != nil {
+		return err
+	}
+	if nm.CancelTXs == nil {
+		nm.CancelTXs = make(map[uint64]*InFlightTX)
+	}
+	log.Printf("Loaded nonce manager with next nonce %d from %s", nm.Next, nm.path)
+	return nil
+}
@@ -0,0 +1,57 @@
+package fx
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gotest.tools/v3/assert"
+)
+
+func TestNonceManagerReserve(t *testing.T) {
+	nm := NewNonceManager(filepath.Join(t.TempDir(), "nonces.gob"))
+
+	assert.Equal(t, nm.Reserve(5), uint64(5))
+	assert.Equal(t, nm.Reserve(5), uint64(6))
+	assert.Equal(t, nm.Reserve(0), uint64(7))
+
+	// releasing the last nonce hands it out again
+	nm.Release(7)
+	assert.Equal(t, nm.Reserve(0), uint64(7))
+
+	// someone else sent transactions from our account
+	assert.Equal(t, nm.Reserve(10), uint64(10))
+}
+
+func TestNonceManagerGaps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonces.gob")
+	nm := NewNonceManager(path)
+	for i := 0; i < 5; i++ {
+		nm.Reserve(0)
+	}
+	nm.Commit(0)
+	nm.Commit(1)
+	nm.Commit(3)
+	nm.Release(2) // not the last nonce, so this becomes a gap
+
+	inUse := map[uint64]struct{}{1: {}}
+	assert.DeepEqual(t, nm.Gaps(1, inUse), []uint64{2, 3})
+
+	to := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	cancel := types.NewTx(&types.LegacyTx{Nonce: 2, GasPrice: big.NewInt(1), To: &to})
+	nm.AddCancelTX(cancel, 100)
+	assert.DeepEqual(t, nm.Gaps(1, inUse), []uint64{3})
+
+	// reserved nonces are forgotten on restart
+	loaded := NewNonceManager(path)
+	assert.NilError(t, loaded.Load())
+	assert.Equal(t, loaded.Next, uint64(5))
+	assert.DeepEqual(t, loaded.Gaps(1, inUse), []uint64{3, 4})
+	assert.Equal(t, len(loaded.GetCancelTXs()), 1)
+
+	// cancel transactions are forgotten once their nonce has been mined
+	assert.DeepEqual(t, loaded.Gaps(4, inUse), []uint64{4})
+	assert.Equal(t, len(loaded.GetCancelTXs()), 0)
+}
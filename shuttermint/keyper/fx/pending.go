@@ -9,29 +9,47 @@ import (
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
 )
 
 // ActionID identifies an action.
 type ActionID uint64
 
+// InFlightTX holds information about the transactions we've sent for a main chain action. All of
+// them use the same nonce, since later ones replace earlier ones, so at most one of them can get
+// mined.
+type InFlightTX struct {
+	Nonce        uint64
+	Hashes       []common.Hash  // hashes of all transactions sent, the last one is the most recent
+	Fees         gaspricer.Fees // fees of the most recent transaction
+	SentBlock    uint64         // main chain block number at which the most recent one was sent
+	NonceUnknown bool           // migrated from a state that didn't record nonces, see Load
+}
+
 // PendingActions contains information about the actions, which are currently running or are
 // scheduled to be run. This struct is stored on disk with gob. We enumerate the actions the
 // decider gives us.
 type PendingActions struct {
-	mux               sync.Mutex
-	ActionMap         map[ActionID]IAction
+	mux         sync.Mutex
+	ActionMap   map[ActionID]IAction
+	InFlightTXs map[ActionID]*InFlightTX
+	CurrentID   ActionID
+	path        string
+
+	// MainChainTXHashes has been replaced by InFlightTXs. It's only read to migrate the state
+	// written by older versions.
 	MainChainTXHashes map[ActionID]common.Hash
-	CurrentID         ActionID
-	path              string
 }
 
 // NewPendingActions creates a empty PendingActions struct.
 func NewPendingActions(path string) *PendingActions {
 	return &PendingActions{
-		ActionMap:         make(map[ActionID]IAction),
-		MainChainTXHashes: make(map[ActionID]common.Hash),
-		CurrentID:         0,
-		path:              path,
+		ActionMap:   make(map[ActionID]IAction),
+		InFlightTXs: make(map[ActionID]*InFlightTX),
+		CurrentID:   0,
+		path:        path,
 	}
 }
 
@@ -79,19 +97,78 @@ func (pending *PendingActions) AddActions(id ActionID, actions []IAction) (Actio
 	return startID, pending.CurrentID
 }
 
-// SetMainChainTXHash sets the transaction hash for the given main chain action.
-func (pending *PendingActions) SetMainChainTXHash(id ActionID, hash common.Hash) {
+// AddMainChainTX records a transaction sent for the given main chain action at the given main
+// chain block. If a transaction has been sent before, the new one must replace it, i.e. use the
+// same nonce.
+func (pending *PendingActions) AddMainChainTX(id ActionID, tx *types.Transaction, block uint64) {
+	pending.mux.Lock()
+	defer pending.mux.Unlock()
+
+	inFlight, ok := pending.InFlightTXs[id]
+	if !ok {
+		inFlight = &InFlightTX{Nonce: tx.Nonce()}
+		pending.InFlightTXs[id] = inFlight
+	} else if inFlight.Nonce != tx.Nonce() {
+		panic("internal error: replacement transaction with different nonce")
+	}
+	inFlight.Hashes = append(inFlight.Hashes, tx.Hash())
+	inFlight.Fees = gaspricer.FeesFromTX(tx)
+	inFlight.SentBlock = block
+	pending.save()
+}
+
+// GetInFlightTX returns a copy of the information about the transactions sent for the given
+// main chain action. The second return value is false if we haven't sent any transaction yet.
+func (pending *PendingActions) GetInFlightTX(id ActionID) (InFlightTX, bool) {
+	pending.mux.Lock()
+	defer pending.mux.Unlock()
+
+	inFlight, ok := pending.InFlightTXs[id]
+	if !ok {
+		return InFlightTX{}, false
+	}
+	res := *inFlight
+	res.Hashes = append([]common.Hash{}, inFlight.Hashes...)
+	return res, true
+}
+
+// NonceGaps returns the nonce gaps to fill with cancel transactions, see NonceManager.Gaps. The
+// nonces of the in-flight transactions are collected and the gaps computed while holding our
+// lock. A transaction is recorded with AddMainChainTX before its nonce is committed, so it is
+// either in-flight or its nonce is still reserved and cannot be mistaken for a gap.
+func (pending *PendingActions) NonceGaps(nonces *NonceManager, minedNonce uint64) []uint64 {
 	pending.mux.Lock()
 	defer pending.mux.Unlock()
-	pending.MainChainTXHashes[id] = hash
+
+	inUse := make(map[uint64]struct{})
+	for _, inFlight := range pending.InFlightTXs {
+		inUse[inFlight.Nonce] = struct{}{}
+	}
+	return nonces.Gaps(minedNonce, inUse)
+}
+
+// SetInFlightNonce sets the nonce of the transactions sent for the given main chain action.
+func (pending *PendingActions) SetInFlightNonce(id ActionID, nonce uint64) {
+	pending.mux.Lock()
+	defer pending.mux.Unlock()
+
+	inFlight, ok := pending.InFlightTXs[id]
+	if !ok {
+		return
+	}
+	inFlight.Nonce = nonce
+	inFlight.NonceUnknown = false
 	pending.save()
 }
 
-// GetMainChainTXHash returns the transaction hash for the given main chain action.
-func (pending *PendingActions) GetMainChainTXHash(id ActionID) common.Hash {
+// ForgetInFlightTX forgets the transactions sent for the given main chain action, so that the
+// action is run again.
+func (pending *PendingActions) ForgetInFlightTX(id ActionID) {
 	pending.mux.Lock()
 	defer pending.mux.Unlock()
-	return pending.MainChainTXHashes[id]
+
+	delete(pending.InFlightTXs, id)
+	pending.save()
 }
 
 // RemoveAction removes the action with the given id.
@@ -100,7 +177,7 @@ func (pending *PendingActions) RemoveAction(id ActionID) {
 	defer pending.mux.Unlock()
 
 	delete(pending.ActionMap, id)
-	delete(pending.MainChainTXHashes, id)
+	delete(pending.InFlightTXs, id)
 	pending.save()
 }
 
@@ -152,6 +229,26 @@ func (pending *PendingActions) Load() error {
 	if err != nil {
 		return err
 	}
+	pending.migrateMainChainTXHashes()
 	log.Printf("Loaded %d pending actions from %s", len(pending.ActionMap), pending.path)
 	return nil
 }
+
+// migrateMainChainTXHashes converts the transaction hashes stored by older versions to in-flight
+// transactions. Their nonces are unknown and have to be looked up on the main chain.
+func (pending *PendingActions) migrateMainChainTXHashes() {
+	if pending.InFlightTXs == nil {
+		pending.InFlightTXs = make(map[ActionID]*InFlightTX)
+	}
+	if len(pending.MainChainTXHashes) == 0 {
+		return
+	}
+	for id, hash := range pending.MainChainTXHashes {
+		if _, ok := pending.InFlightTXs[id]; !ok {
+			pending.InFlightTXs[id] = &InFlightTX{Hashes: []common.Hash{hash}, NonceUnknown: true}
+		}
+	}
+	log.Printf("Migrated %d in-flight transactions from %s", len(pending.MainChainTXHashes), pending.path)
+	pending.MainChainTXHashes = nil
+	pending.save()
+}
@@ -2,11 +2,17 @@ package fx
 
 import (
 	"encoding/gob"
+	"math/big"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"gotest.tools/v3/assert"
 
+	"github.com/shutter-network/shutter/shlib/shtest"
+
 	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
 )
 
@@ -39,3 +45,86 @@ func TestAddActions(t *testing.T) {
 	pending.AddActions(ActionID(3), myactions[3:5])
 	assert.Equal(t, 8, len(pending.SortedIDs()))
 }
+
+func TestInFlightTXs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "actions.gob")
+	pending := NewPendingActions(path)
+	pending.AddActions(ActionID(0), myactions[0:2])
+
+	_, ok := pending.GetInFlightTX(ActionID(1))
+	assert.Assert(t, !ok)
+
+	to := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	tx1 := types.NewTx(&types.DynamicFeeTx{Nonce: 5, GasFeeCap: big.NewInt(100), GasTipCap: big.NewInt(2), To: &to})
+	tx2 := types.NewTx(&types.DynamicFeeTx{Nonce: 5, GasFeeCap: big.NewInt(120), GasTipCap: big.NewInt(3), To: &to})
+	pending.AddMainChainTX(ActionID(1), tx1, 10)
+	pending.AddMainChainTX(ActionID(1), tx2, 15)
+
+	loaded := NewPendingActions(path)
+	assert.NilError(t, loaded.Load())
+	inFlight, ok := loaded.GetInFlightTX(ActionID(1))
+	assert.Assert(t, ok)
+	assert.Equal(t, inFlight.Nonce, uint64(5))
+	assert.DeepEqual(t, inFlight.Hashes, []common.Hash{tx1.Hash(), tx2.Hash()})
+	assert.DeepEqual(t, inFlight.Fees.GasFeeCap, big.NewInt(120), shtest.BigIntComparer)
+	assert.Equal(t, inFlight.SentBlock, uint64(15))
+
+	loaded.RemoveAction(ActionID(1))
+	_, ok = loaded.GetInFlightTX(ActionID(1))
+	assert.Assert(t, !ok)
+}
+
+func TestNonceGaps(t *testing.T) {
+	dir := t.TempDir()
+	pending := NewPendingActions(filepath.Join(dir, "actions.gob"))
+	nonces := NewNonceManager(filepath.Join(dir, "nonces.gob"))
+	pending.AddActions(ActionID(0), myactions[0:2])
+
+	to := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	for i := 0; i < 2; i++ {
+		nonce := nonces.Reserve(0)
+		tx := types.NewTx(&types.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(1), To: &to})
+		pending.AddMainChainTX(ActionID(i), tx, 10)
+		nonces.Commit(nonce)
+	}
+	assert.Equal(t, len(pending.NonceGaps(nonces, 0)), 0)
+
+	// the action has been removed without its transaction being mined
+	pending.RemoveAction(ActionID(1))
+	assert.DeepEqual(t, pending.NonceGaps(nonces, 0), []uint64{1})
+}
+
+func TestMigrateMainChainTXHashes(t *testing.T) {
+	// the state as written by versions that only stored one hash per action
+	type oldPendingActions struct {
+		ActionMap         map[ActionID]IAction
+		MainChainTXHashes map[ActionID]common.Hash
+		CurrentID         ActionID
+	}
+	path := filepath.Join(t.TempDir(), "actions.gob")
+	hash := common.HexToHash("0xaa")
+	old := oldPendingActions{
+		ActionMap:         map[ActionID]IAction{1: myactions[1]},
+		MainChainTXHashes: map[ActionID]common.Hash{1: hash},
+		CurrentID:         2,
+	}
+	file, err := os.Create(path)
+	assert.NilError(t, err)
+	assert.NilError(t, gob.NewEncoder(file).Encode(old))
+	assert.NilError(t, file.Close())
+
+	pending := NewPendingActions(path)
+	assert.NilError(t, pending.Load())
+	inFlight, ok := pending.GetInFlightTX(ActionID(1))
+	assert.Assert(t, ok)
+	assert.Assert(t, inFlight.NonceUnknown)
+	assert.DeepEqual(t, inFlight.Hashes, []common.Hash{hash})
+
+	pending.SetInFlightNonce(ActionID(1), 7)
+	loaded := NewPendingActions(path)
+	assert.NilError(t, loaded.Load())
+	inFlight, _ = loaded.GetInFlightTX(ActionID(1))
+	assert.Equal(t, inFlight.Nonce, uint64(7))
+	assert.Assert(t, !inFlight.NonceUnknown)
+	assert.Equal(t, len(loaded.MainChainTXHashes), 0)
+}
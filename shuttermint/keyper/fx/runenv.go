@@ -5,18 +5,23 @@ import (
 	"log"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper/gaspricer"
 	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
 	"github.com/shutter-network/shutter/shuttermint/medley"
 )
 
 const (
 	numMainChainWorkers = 20
+	receiptPollInterval = 2 * time.Second
+	nonceCheckInterval  = 30 * time.Second
 )
 
 type ActionWithID struct {
@@ -27,23 +32,37 @@ type ActionWithID struct {
 type RunEnv struct {
 	PendingActions       *PendingActions
 	PendingActionsPath   string
+	Nonces               *NonceManager
 	MessageSender        MessageSender
 	ContractCaller       *contract.Caller
 	shuttermintMessages  chan ActionID
 	mainChainTXs         chan ActionID
 	inFlightMainChainTXs chan ActionID
 	currentWorld         func() observe.World
+	loaded               chan struct{} // closed after Load has been called
+
+	// ResendAfterBlocks is the number of main chain blocks after which a transaction that has not
+	// been mined is replaced by one paying higher fees. Zero disables replacing transactions.
+	ResendAfterBlocks uint64
 }
 
-func NewRunEnv(messageSender MessageSender, contractCaller *contract.Caller, currentWorld func() observe.World, path string) *RunEnv {
+func NewRunEnv(
+	messageSender MessageSender,
+	contractCaller *contract.Caller,
+	currentWorld func() observe.World,
+	path string,
+	noncesPath string,
+) *RunEnv {
 	return &RunEnv{
 		PendingActions:       NewPendingActions(path),
+		Nonces:               NewNonceManager(noncesPath),
 		MessageSender:        messageSender,
 		ContractCaller:       contractCaller,
 		shuttermintMessages:  make(chan ActionID),
 		mainChainTXs:         make(chan ActionID, numMainChainWorkers),
 		inFlightMainChainTXs: make(chan ActionID),
 		currentWorld:         currentWorld,
+		loaded:               make(chan struct{}),
 	}
 }
 
@@ -65,37 +84,154 @@ func (runenv *RunEnv) sendMainChainTX(ctx context.Context, id ActionID, act Main
 	var tx *types.Transaction
 	var auth *bind.TransactOpts
 
-	auth, err = runenv.ContractCaller.Auth()
+	pendingNonce, err := runenv.ContractCaller.Ethclient.PendingNonceAt(ctx, runenv.ContractCaller.Address())
+	if err != nil {
+		return err
+	}
+	nonce := runenv.Nonces.Reserve(pendingNonce)
+
+	auth, err = runenv.ContractCaller.Auth(nonce, act.Urgency())
+	if err != nil {
+		runenv.Nonces.Release(nonce)
+		return err
+	}
 	auth.Context = ctx
 
+	auth.GasLimit, err = estimateGas(ctx, runenv.ContractCaller, act, auth)
 	if err != nil {
+		runenv.Nonces.Release(nonce)
 		return err
 	}
 	tx, err = act.SendTX(runenv.ContractCaller, auth)
 	if err != nil {
+		runenv.Nonces.Release(nonce)
 		return err
 	}
-	runenv.PendingActions.SetMainChainTXHash(id, tx.Hash())
+	runenv.PendingActions.AddMainChainTX(id, tx, runenv.CurrentWorld().MainChain.CurrentBlock)
+	runenv.Nonces.Commit(nonce)
 	runenv.inFlightMainChainTXs <- id
 	return nil
 }
 
-var zerohash = common.Hash{}
+// findReceipt looks for a receipt of any of the given transactions. It returns a nil receipt if
+// none of them has been mined yet.
+func (runenv *RunEnv) findReceipt(ctx context.Context, hashes []common.Hash) (*types.Receipt, common.Hash, error) {
+	for _, hash := range hashes {
+		receipt, err := runenv.ContractCaller.Ethclient.TransactionReceipt(ctx, hash)
+		if err == ethereum.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, common.Hash{}, err
+		}
+		return receipt, hash, nil
+	}
+	return nil, common.Hash{}, nil
+}
 
-func (runenv *RunEnv) waitMined(ctx context.Context, id ActionID) {
-	act := runenv.PendingActions.GetAction(id)
-	hash := runenv.PendingActions.GetMainChainTXHash(id)
-	if hash == zerohash {
-		log.Fatalf("internal error: cannot wait for the zero hash, id=%d", id)
+// maybeReplaceTX replaces the transactions sent for the given action with a new one paying
+// higher fees if they haven't been mined for ResendAfterBlocks blocks.
+func (runenv *RunEnv) maybeReplaceTX(ctx context.Context, id ActionID, act MainChainTX, inFlight InFlightTX) {
+	if runenv.ResendAfterBlocks == 0 {
+		return
+	}
+	world := runenv.CurrentWorld()
+	if world.MainChain.CurrentBlock < inFlight.SentBlock+runenv.ResendAfterBlocks {
+		return
+	}
+	if act.IsExpired(world) {
+		// No need to spend more on an action that has become obsolete
+		return
 	}
-	receipt, err := medley.WaitMined(ctx, runenv.ContractCaller.Ethclient, hash)
-	if err == context.Canceled {
+
+	auth, err := runenv.ContractCaller.ReplacementAuth(inFlight.Nonce, inFlight.Fees, act.Urgency())
+	if err != nil {
+		log.Printf("Cannot replace TX: id=%d, %s, nonce=%d: %s", id, act, inFlight.Nonce, err)
 		return
 	}
+	auth.Context = ctx
+	auth.GasLimit, err = estimateGas(ctx, runenv.ContractCaller, act, auth)
 	if err != nil {
-		log.Printf("Error waiting for transaction id=%d, %s: %v", id, hash.Hex(), err)
+		// The action may have become obsolete in the meantime. Waiting for the earlier
+		// transaction is fine in this case, too.
+		log.Printf("Not replacing TX: id=%d, %s, nonce=%d: %s", id, act, inFlight.Nonce, err)
 		return
 	}
+	tx, err := act.SendTX(runenv.ContractCaller, auth)
+	if err != nil {
+		// This may happen if one of the earlier transactions got mined in the meantime, in which
+		// case we will find its receipt.
+		log.Printf("Failed to send replacement TX: id=%d, %s, nonce=%d: %s", id, act, inFlight.Nonce, err)
+		return
+	}
+	runenv.PendingActions.AddMainChainTX(id, tx, world.MainChain.CurrentBlock)
+	log.Printf(
+		"Replaced TX not mined after %d blocks: id=%d, %s, nonce=%d, hash=%s",
+		world.MainChain.CurrentBlock-inFlight.SentBlock, id, act, inFlight.Nonce, tx.Hash().Hex(),
+	)
+}
+
+// waitMined waits until one of the transactions sent for the given action has been mined or its
+// nonce has been used by another transaction. While waiting, it replaces transactions that seem to
+// be stuck. It returns false if the context has been canceled before.
+func (runenv *RunEnv) waitMined(ctx context.Context, id ActionID) bool {
+	act := runenv.PendingActions.GetAction(id).(MainChainTX)
+	lastReplacementCheck := uint64(0)
+	for {
+		inFlight, ok := runenv.PendingActions.GetInFlightTX(id)
+		if !ok {
+			log.Fatalf("internal error: cannot wait for action without transaction, id=%d", id)
+		}
+		receipt, hash, err := runenv.findReceipt(ctx, inFlight.Hashes)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			// Keep the action, so that its nonce isn't mistaken for a gap, and try again
+			log.Printf("Error waiting for transaction id=%d, %s: %v", id, inFlight.Hashes[len(inFlight.Hashes)-1].Hex(), err)
+			medley.Sleep(ctx, receiptPollInterval)
+			continue
+		}
+		if receipt != nil {
+			runenv.logReceipt(ctx, id, act, hash, receipt)
+			return true
+		}
+
+		currentBlock := runenv.CurrentWorld().MainChain.CurrentBlock
+		if currentBlock > lastReplacementCheck {
+			lastReplacementCheck = currentBlock
+			if runenv.isNonceUsed(ctx, inFlight.Nonce) {
+				// Check again, since one of our transactions may have been mined after we've
+				// looked for the receipts
+				receipt, hash, err = runenv.findReceipt(ctx, inFlight.Hashes)
+				if err == nil && receipt != nil {
+					runenv.logReceipt(ctx, id, act, hash, receipt)
+				} else if err == nil {
+					log.Printf("TX superseded: id=%d, %s, nonce=%d has been used by another transaction", id, act, inFlight.Nonce)
+				} else {
+					log.Printf("Error waiting for transaction id=%d: %v", id, err)
+					medley.Sleep(ctx, receiptPollInterval)
+					continue
+				}
+				return true
+			}
+			runenv.maybeReplaceTX(ctx, id, act, inFlight)
+		}
+		medley.Sleep(ctx, receiptPollInterval)
+	}
+}
+
+// isNonceUsed checks if a transaction with the given nonce has been mined.
+func (runenv *RunEnv) isNonceUsed(ctx context.Context, nonce uint64) bool {
+	minedNonce, err := runenv.ContractCaller.Ethclient.NonceAt(ctx, runenv.ContractCaller.Address(), nil)
+	if err != nil {
+		log.Printf("Failed to fetch nonce: %s", err)
+		return false
+	}
+	return nonce < minedNonce
+}
+
+func (runenv *RunEnv) logReceipt(ctx context.Context, id ActionID, act MainChainTX, hash common.Hash, receipt *types.Receipt) {
 	if receipt.Status != types.ReceiptStatusSuccessful {
 		world := runenv.CurrentWorld() // XXX we should make sure our world includes the receipt's blocknumber
 		expired := act.IsExpired(world)
@@ -138,8 +274,7 @@ func (runenv *RunEnv) scheduleAction(ctx context.Context, id ActionID) error {
 	case *SendShuttermintMessage:
 		ch = runenv.shuttermintMessages
 	case MainChainTX:
-		txhash := runenv.PendingActions.GetMainChainTXHash(id)
-		if txhash != zerohash {
+		if _, ok := runenv.PendingActions.GetInFlightTX(id); ok {
 			ch = runenv.inFlightMainChainTXs
 		} else {
 			ch = runenv.mainChainTXs
@@ -161,6 +296,15 @@ func (runenv *RunEnv) Load(ctx context.Context) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	err = runenv.Nonces.Load()
+	if err != nil {
+		return false, err
+	}
+	err = runenv.resolveMigratedNonces(ctx)
+	if err != nil {
+		return false, err
+	}
+	close(runenv.loaded)
 
 	sortedIDs := runenv.PendingActions.SortedIDs()
 	for _, id := range sortedIDs {
@@ -172,6 +316,30 @@ func (runenv *RunEnv) Load(ctx context.Context) (bool, error) {
 	return len(sortedIDs) > 0, nil
 }
 
+// resolveMigratedNonces looks up the nonces of the in-flight transactions migrated from a state
+// that didn't record them. Actions whose transaction is unknown to the main chain node are run
+// again.
+func (runenv *RunEnv) resolveMigratedNonces(ctx context.Context) error {
+	for _, id := range runenv.PendingActions.SortedIDs() {
+		inFlight, ok := runenv.PendingActions.GetInFlightTX(id)
+		if !ok || !inFlight.NonceUnknown {
+			continue
+		}
+		hash := inFlight.Hashes[len(inFlight.Hashes)-1]
+		tx, _, err := runenv.ContractCaller.Ethclient.TransactionByHash(ctx, hash)
+		if err == ethereum.NotFound {
+			log.Printf("Migrated TX %s not found, running action id=%d again", hash.Hex(), id)
+			runenv.PendingActions.ForgetInFlightTX(id)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to look up nonce of migrated TX %s", hash.Hex())
+		}
+		runenv.PendingActions.SetInFlightNonce(id, tx.Nonce())
+	}
+	return nil
+}
+
 func (runenv *RunEnv) handleAction(ctx context.Context, id ActionID, action IAction) (bool, error) {
 	switch a := action.(type) {
 	case *SendShuttermintMessage:
@@ -213,6 +381,11 @@ func (runenv *RunEnv) handleActions(ctx context.Context, actions chan ActionID)
 				if err == nil {
 					break
 				}
+				if _, ok := err.(*ActionDoneError); ok {
+					remove = true
+					log.Printf("Action already done: id=%d, %s; %s", id, a, err)
+					break
+				}
 				if !IsRetriable(err) {
 					remove = true
 					log.Printf("Non-retriable error id=%d, %s; err=%s", id, a, err)
@@ -237,8 +410,9 @@ func (runenv *RunEnv) handleInFlightTXs(ctx context.Context) {
 	for {
 		select {
 		case id := <-runenv.inFlightMainChainTXs:
-			runenv.waitMined(ctx, id)
-			runenv.PendingActions.RemoveAction(id)
+			if runenv.waitMined(ctx, id) {
+				runenv.PendingActions.RemoveAction(id)
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -266,4 +440,64 @@ func (runenv *RunEnv) StartBackgroundTasks(ctx context.Context, g *errgroup.Grou
 			return nil
 		})
 	}
+
+	g.Go(func() error {
+		runenv.checkNonces(ctx)
+		return nil
+	})
+}
+
+// checkNonces periodically fills nonce gaps with cancel transactions and replaces cancel
+// transactions that are stuck.
+func (runenv *RunEnv) checkNonces(ctx context.Context) {
+	select {
+	case <-runenv.loaded:
+	case <-ctx.Done():
+		return
+	}
+	for {
+		medley.Sleep(ctx, nonceCheckInterval)
+		if ctx.Err() != nil {
+			return
+		}
+		minedNonce, err := runenv.ContractCaller.Ethclient.NonceAt(ctx, runenv.ContractCaller.Address(), nil)
+		if err != nil {
+			log.Printf("Failed to fetch nonce: %s", err)
+			continue
+		}
+		currentBlock := runenv.CurrentWorld().MainChain.CurrentBlock
+		for _, nonce := range runenv.PendingActions.NonceGaps(runenv.Nonces, minedNonce) {
+			runenv.sendCancelTX(ctx, nonce, nil, currentBlock)
+		}
+		for _, cancel := range runenv.Nonces.GetCancelTXs() {
+			if runenv.ResendAfterBlocks != 0 && currentBlock >= cancel.SentBlock+runenv.ResendAfterBlocks {
+				cancel := cancel
+				runenv.sendCancelTX(ctx, cancel.Nonce, &cancel, currentBlock)
+			}
+		}
+	}
+}
+
+// sendCancelTX sends a cancel transaction with the given nonce. If previous is not nil, the new
+// transaction replaces it.
+func (runenv *RunEnv) sendCancelTX(ctx context.Context, nonce uint64, previous *InFlightTX, currentBlock uint64) {
+	var auth *bind.TransactOpts
+	var err error
+	if previous == nil {
+		auth, err = runenv.ContractCaller.Auth(nonce, gaspricer.UrgencyHigh)
+	} else {
+		auth, err = runenv.ContractCaller.ReplacementAuth(nonce, previous.Fees, gaspricer.UrgencyHigh)
+	}
+	if err != nil {
+		log.Printf("Cannot cancel nonce %d: %s", nonce, err)
+		return
+	}
+	auth.Context = ctx
+	tx, err := runenv.ContractCaller.SendCancelTX(auth)
+	if err != nil {
+		log.Printf("Failed to send cancel TX for nonce %d: %s", nonce, err)
+		return
+	}
+	runenv.Nonces.AddCancelTX(tx, currentBlock)
+	log.Printf("Sent cancel TX to fill nonce gap: nonce=%d, hash=%s", nonce, tx.Hash().Hex())
 }
@@ -0,0 +1,108 @@
+package fx
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+)
+
+// gasLimitMarginPercent is the safety margin we add to the estimated gas of a transaction.
+const gasLimitMarginPercent = 25
+
+// ActionDoneError is returned if simulating the transaction of an action shows that the action
+// has already been performed, by us or by someone else.
+type ActionDoneError struct {
+	Err error
+}
+
+func (*ActionDoneError) IsRetriable() bool {
+	return false
+}
+
+func (e *ActionDoneError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ActionDoneError) Unwrap() error {
+	return e.Err
+}
+
+var _ IRetriable = &ActionDoneError{}
+
+// gasLimit returns the gas limit to use for a transaction with the given gas estimate. If the
+// estimate exceeds the cap, the transaction would run out of gas, so we don't send it at all.
+func gasLimit(estimate uint64, gasCap uint64) (uint64, error) {
+	limit := estimate + estimate*gasLimitMarginPercent/100
+	if gasCap == 0 {
+		return limit, nil
+	}
+	if estimate > gasCap {
+		return 0, &NonRetriableError{Err: errors.Errorf("estimated gas %d exceeds cap of %d", estimate, gasCap)}
+	}
+	if limit > gasCap {
+		limit = gasCap
+	}
+	return limit, nil
+}
+
+// usesGasCap checks if the transaction of the given action must be sent with its gas cap. The
+// executor contract runs each transaction of a batch with the config's transaction gas limit and
+// doesn't revert if one of them runs out of gas. A gas estimate would therefore only tell us how
+// much gas is needed for the batch not to revert, not for the transactions to succeed.
+func usesGasCap(act MainChainTX) bool {
+	switch act.(type) {
+	case *ExecuteCipherBatch, *ExecutePlainBatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// estimateGas simulates the transaction of the given action with eth_call and returns the gas
+// limit it should be sent with. If the transaction would revert, the error is classified by the
+// action's HandleRevert method. Batch executions are only simulated to detect reverts and always
+// use their gas cap, see usesGasCap. The given transactor is not modified.
+func estimateGas(ctx context.Context, caller *contract.Caller, act MainChainTX, auth *bind.TransactOpts) (uint64, error) {
+	// Build the transaction without signing or sending it in order to get the call data
+	simAuth := *auth
+	simAuth.NoSend = true
+	simAuth.GasLimit = act.GasCap()
+	if simAuth.GasLimit == 0 {
+		simAuth.GasLimit = 1 // prevent bind from estimating the gas itself
+	}
+	simAuth.Signer = func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		return tx, nil
+	}
+	simAuth.Context = ctx
+	tx, err := act.SendTX(caller, &simAuth)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := ethereum.CallMsg{
+		From:  auth.From,
+		To:    tx.To(),
+		Gas:   act.GasCap(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+	_, err = caller.Ethclient.CallContract(ctx, msg, nil)
+	if err != nil {
+		return 0, act.HandleRevert(errors.Wrap(err, "simulation failed"))
+	}
+	if usesGasCap(act) {
+		return act.GasCap(), nil
+	}
+	msg.Gas = 0
+	estimate, err := caller.Ethclient.EstimateGas(ctx, msg)
+	if err != nil {
+		return 0, act.HandleRevert(errors.Wrap(err, "gas estimation failed"))
+	}
+	return gasLimit(estimate, act.GasCap())
+}
@@ -0,0 +1,56 @@
+package fx
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+)
+
+func TestGasLimit(t *testing.T) {
+	for _, c := range []struct {
+		estimate, gasCap, limit uint64
+	}{
+		{100_000, 0, 125_000},
+		{100_000, 200_000, 125_000},
+		{100_000, 110_000, 110_000},
+		{200_000, 200_000, 200_000},
+	} {
+		limit, err := gasLimit(c.estimate, c.gasCap)
+		assert.NilError(t, err)
+		assert.Equal(t, limit, c.limit)
+	}
+
+	// we don't send transactions that would run out of gas
+	_, err := gasLimit(300_000, 200_000)
+	assert.Assert(t, err != nil)
+	assert.Assert(t, !IsRetriable(err))
+
+	assert.Assert(t, usesGasCap(&ExecuteCipherBatch{}))
+	assert.Assert(t, usesGasCap(&ExecutePlainBatch{}))
+	assert.Assert(t, !usesGasCap(&SkipCipherBatch{}))
+}
+
+func TestHandleRevert(t *testing.T) {
+	revert := func(reason string) error {
+		return errors.Wrap(errors.Errorf("execution reverted: %s", reason), "simulation failed")
+	}
+
+	err := ExecuteCipherBatch{}.HandleRevert(revert("ExecutorContract: unexpected half step"))
+	_, ok := err.(*ActionDoneError)
+	assert.Assert(t, ok)
+	assert.Assert(t, !IsRetriable(err))
+
+	err = ExecutePlainBatch{}.HandleRevert(revert("ExecutorContract: batch is not closed yet"))
+	assert.Assert(t, IsRetriable(err))
+
+	err = Accuse{}.HandleRevert(revert("KeyperSlasher: cannot accuse empty batch"))
+	_, ok = err.(*NonRetriableError)
+	assert.Assert(t, ok)
+
+	err = EonKeyBroadcast{}.HandleRevert(revert("KeyBroadcastContract: keyper has already voted"))
+	_, ok = err.(*ActionDoneError)
+	assert.Assert(t, ok)
+
+	assert.NilError(t, Appeal{}.HandleRevert(nil))
+}
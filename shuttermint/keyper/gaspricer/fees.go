@@ -0,0 +1,264 @@
+package gaspricer
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+)
+
+// Mode determines which kind of transactions we send.
+type Mode string
+
+const (
+	// ModeAuto sends dynamic fee transactions if the chain supports them and legacy
+	// transactions otherwise.
+	ModeAuto Mode = "auto"
+	// ModeLegacy always sends legacy transactions.
+	ModeLegacy Mode = "legacy"
+	// ModeDynamic always sends dynamic fee (EIP-1559) transactions.
+	ModeDynamic Mode = "dynamic"
+)
+
+// Urgency tells how important it is that a transaction gets included quickly. In dynamic fee
+// mode, more urgent transactions pay a higher tip and leave more room for base fee increases.
+type Urgency int
+
+const (
+	UrgencyLow Urgency = iota
+	UrgencyNormal
+	UrgencyHigh
+)
+
+// replacementBumpFactor is the factor by which we increase the fees of a transaction we replace.
+// Ethereum nodes only accept replacements that pay at least 10% more.
+const replacementBumpFactor = 1.125
+
+// ErrCannotBump is returned by BumpFees if the fees cannot be increased enough to replace a
+// transaction without exceeding the maximum fee per gas.
+var ErrCannotBump = errors.New("cannot bump fees without exceeding the max fee per gas")
+
+type urgencyParams struct {
+	baseFeeMultiplier float64 // headroom for base fee increases in the fee cap
+	tipMultiplier     float64 // applied to the configured or suggested tip
+}
+
+var urgencies = map[Urgency]urgencyParams{
+	UrgencyLow:    {baseFeeMultiplier: 1.5, tipMultiplier: 1.0},
+	UrgencyNormal: {baseFeeMultiplier: 2.0, tipMultiplier: 1.5},
+	UrgencyHigh:   {baseFeeMultiplier: 3.0, tipMultiplier: 3.0},
+}
+
+func (u Urgency) String() string {
+	switch u {
+	case UrgencyLow:
+		return "low"
+	case UrgencyNormal:
+		return "normal"
+	case UrgencyHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+func (u Urgency) params() urgencyParams {
+	p, ok := urgencies[u]
+	if !ok {
+		return urgencies[UrgencyNormal]
+	}
+	return p
+}
+
+var (
+	mode         = ModeAuto
+	maxFeePerGas *big.Int // nil means no cap
+	priorityFee  *big.Int // nil means use the tip suggested by the node
+)
+
+// SetMode sets the transaction mode. The empty string selects ModeAuto. This is a global
+// setting.
+func SetMode(m string) error {
+	switch Mode(m) {
+	case "", ModeAuto:
+		mode = ModeAuto
+	case ModeLegacy, ModeDynamic:
+		mode = Mode(m)
+	default:
+		return errors.Errorf("invalid gas price mode %q (must be auto, legacy, or dynamic)", m)
+	}
+	return nil
+}
+
+// SetMaxFeePerGas sets the maximum fee per gas in gwei we are willing to pay. It caps the fee cap
+// of dynamic fee transactions and the gas price of legacy transactions. Zero means no cap. This
+// is a global setting.
+func SetMaxFeePerGas(gwei float64) error {
+	if gwei < 0.0 {
+		return errors.New("max fee per gas must be non-negative")
+	}
+	maxFeePerGas = gweiToWei(gwei)
+	return nil
+}
+
+// SetPriorityFee sets the tip per gas in gwei for dynamic fee transactions before the urgency
+// multiplier is applied. Zero means that the tip suggested by the Ethereum node is used. This is
+// a global setting.
+func SetPriorityFee(gwei float64) error {
+	if gwei < 0.0 {
+		return errors.New("priority fee must be non-negative")
+	}
+	priorityFee = gweiToWei(gwei)
+	return nil
+}
+
+func gweiToWei(gwei float64) *big.Int {
+	if gwei == 0.0 {
+		return nil
+	}
+	f := new(big.Float).Mul(big.NewFloat(gwei), big.NewFloat(params.GWei))
+	wei, _ := f.Int(nil)
+	return wei
+}
+
+func mul(x *big.Int, f float64) *big.Int {
+	p := new(big.Float).SetInt(x)
+	p.Mul(p, big.NewFloat(f))
+	r, _ := p.Int(nil)
+	return r
+}
+
+func capAt(x, max *big.Int) *big.Int {
+	if max != nil && x.Cmp(max) > 0 {
+		return new(big.Int).Set(max)
+	}
+	return x
+}
+
+// FeeSuggester is the part of the Ethereum client API needed to determine fees.
+type FeeSuggester interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+}
+
+// Fees holds the fee parameters of a transaction. Either GasPrice is set for a legacy
+// transaction or GasFeeCap and GasTipCap for a dynamic fee transaction.
+type Fees struct {
+	GasPrice  *big.Int
+	GasFeeCap *big.Int
+	GasTipCap *big.Int
+}
+
+// IsDynamic checks if the fees are meant for a dynamic fee transaction.
+func (f Fees) IsDynamic() bool {
+	return f.GasFeeCap != nil
+}
+
+// Apply sets the fees in the given transactor.
+func (f Fees) Apply(auth *bind.TransactOpts) {
+	auth.GasPrice = f.GasPrice
+	auth.GasFeeCap = f.GasFeeCap
+	auth.GasTipCap = f.GasTipCap
+}
+
+// SuggestFees determines the fees of a transaction with the given urgency according to the
+// global settings.
+func SuggestFees(ctx context.Context, client FeeSuggester, urgency Urgency) (Fees, error) {
+	if mode == ModeLegacy {
+		return suggestLegacyFees(ctx, client)
+	}
+
+	header, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return Fees{}, err
+	}
+	if header.BaseFee == nil {
+		if mode == ModeDynamic {
+			return Fees{}, errors.New("chain does not support dynamic fee transactions")
+		}
+		return suggestLegacyFees(ctx, client)
+	}
+
+	tip := priorityFee
+	if tip == nil {
+		tip, err = client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return Fees{}, err
+		}
+	}
+	return dynamicFees(header.BaseFee, tip, urgency), nil
+}
+
+func suggestLegacyFees(ctx context.Context, client FeeSuggester) (Fees, error) {
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		return Fees{}, err
+	}
+	return Fees{GasPrice: capAt(Adjust(gasPrice), maxFeePerGas)}, nil
+}
+
+func dynamicFees(baseFee *big.Int, tip *big.Int, urgency Urgency) Fees {
+	p := urgency.params()
+	tipCap := mul(tip, p.tipMultiplier)
+	feeCap := new(big.Int).Add(mul(baseFee, p.baseFeeMultiplier), tipCap)
+	feeCap = capAt(feeCap, maxFeePerGas)
+	tipCap = capAt(tipCap, feeCap)
+	return Fees{GasFeeCap: feeCap, GasTipCap: tipCap}
+}
+
+// FeesFromTX returns the fees of the given transaction.
+func FeesFromTX(tx *types.Transaction) Fees {
+	if tx.Type() == types.LegacyTxType || tx.Type() == types.AccessListTxType {
+		return Fees{GasPrice: tx.GasPrice()}
+	}
+	return Fees{GasFeeCap: tx.GasFeeCap(), GasTipCap: tx.GasTipCap()}
+}
+
+// EffectiveGasPrice returns the price per gas the given transaction pays when included in a block
+// with the given base fee. The base fee is nil for blocks before the London fork.
+func EffectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return tx.GasPrice()
+	}
+	return new(big.Int).Add(baseFee, tx.EffectiveGasTipValue(baseFee))
+}
+
+func bump(x *big.Int) *big.Int {
+	return new(big.Int).Add(mul(x, replacementBumpFactor), big.NewInt(1))
+}
+
+func maxBig(x, y *big.Int) *big.Int {
+	if x.Cmp(y) >= 0 {
+		return x
+	}
+	return y
+}
+
+// BumpFees returns the fees of a transaction replacing a transaction paying the old fees. The new
+// fees are the suggested fees, but at least the old fees increased by the replacement bump factor.
+func BumpFees(old, suggested Fees) (Fees, error) {
+	oldFeeCap, oldTipCap := old.GasFeeCap, old.GasTipCap
+	if !old.IsDynamic() {
+		oldFeeCap, oldTipCap = old.GasPrice, old.GasPrice
+	}
+	minFeeCap, minTipCap := bump(oldFeeCap), bump(oldTipCap)
+
+	if !suggested.IsDynamic() {
+		gasPrice := capAt(maxBig(suggested.GasPrice, minFeeCap), maxFeePerGas)
+		if gasPrice.Cmp(minFeeCap) < 0 {
+			return Fees{}, ErrCannotBump
+		}
+		return Fees{GasPrice: gasPrice}, nil
+	}
+
+	feeCap := capAt(maxBig(suggested.GasFeeCap, minFeeCap), maxFeePerGas)
+	tipCap := capAt(maxBig(suggested.GasTipCap, minTipCap), feeCap)
+	if feeCap.Cmp(minFeeCap) < 0 || tipCap.Cmp(minTipCap) < 0 {
+		return Fees{}, ErrCannotBump
+	}
+	return Fees{GasFeeCap: feeCap, GasTipCap: tipCap}, nil
+}
@@ -0,0 +1,123 @@
+package gaspricer
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shlib/shtest"
+)
+
+type fakeClient struct {
+	baseFee  *big.Int
+	gasPrice *big.Int
+	tip      *big.Int
+}
+
+func (c fakeClient) HeaderByNumber(_ context.Context, _ *big.Int) (*types.Header, error) {
+	return &types.Header{BaseFee: c.baseFee}, nil
+}
+
+func (c fakeClient) SuggestGasPrice(_ context.Context) (*big.Int, error) {
+	return c.gasPrice, nil
+}
+
+func (c fakeClient) SuggestGasTipCap(_ context.Context) (*big.Int, error) {
+	return c.tip, nil
+}
+
+func resetGlobals(t *testing.T) {
+	t.Helper()
+	oldMultiplier, oldMode, oldMaxFee, oldTip := gasPriceMultiplier, mode, maxFeePerGas, priorityFee
+	t.Cleanup(func() {
+		gasPriceMultiplier, mode, maxFeePerGas, priorityFee = oldMultiplier, oldMode, oldMaxFee, oldTip
+	})
+	_ = SetMultiplier(1.0)
+	_ = SetMode("")
+	_ = SetMaxFeePerGas(0)
+	_ = SetPriorityFee(0)
+}
+
+func TestSuggestFeesDynamic(t *testing.T) {
+	resetGlobals(t)
+	ctx := context.Background()
+	client := fakeClient{baseFee: big.NewInt(100), gasPrice: big.NewInt(1000), tip: big.NewInt(10)}
+
+	fees, err := SuggestFees(ctx, client, UrgencyLow)
+	assert.NilError(t, err)
+	assert.Assert(t, fees.IsDynamic())
+	assert.Assert(t, fees.GasPrice == nil)
+	assert.DeepEqual(t, fees.GasTipCap, big.NewInt(10), shtest.BigIntComparer)
+	assert.DeepEqual(t, fees.GasFeeCap, big.NewInt(160), shtest.BigIntComparer)
+
+	fees, err = SuggestFees(ctx, client, UrgencyHigh)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fees.GasTipCap, big.NewInt(30), shtest.BigIntComparer)
+	assert.DeepEqual(t, fees.GasFeeCap, big.NewInt(330), shtest.BigIntComparer)
+
+	assert.NilError(t, SetPriorityFee(1))
+	fees, err = SuggestFees(ctx, client, UrgencyLow)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fees.GasTipCap, big.NewInt(1e9), shtest.BigIntComparer)
+
+	assert.NilError(t, SetMaxFeePerGas(0.5))
+	fees, err = SuggestFees(ctx, client, UrgencyLow)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fees.GasFeeCap, big.NewInt(5e8), shtest.BigIntComparer)
+	assert.DeepEqual(t, fees.GasTipCap, big.NewInt(5e8), shtest.BigIntComparer)
+}
+
+func TestSuggestFeesLegacy(t *testing.T) {
+	resetGlobals(t)
+	ctx := context.Background()
+	_ = SetMultiplier(2.0)
+
+	preLondon := fakeClient{gasPrice: big.NewInt(1000), tip: big.NewInt(10)}
+	fees, err := SuggestFees(ctx, preLondon, UrgencyNormal)
+	assert.NilError(t, err)
+	assert.Assert(t, !fees.IsDynamic())
+	assert.DeepEqual(t, fees.GasPrice, big.NewInt(2000), shtest.BigIntComparer)
+
+	assert.NilError(t, SetMode("dynamic"))
+	_, err = SuggestFees(ctx, preLondon, UrgencyNormal)
+	assert.Assert(t, err != nil)
+
+	assert.NilError(t, SetMode("legacy"))
+	london := fakeClient{baseFee: big.NewInt(100), gasPrice: big.NewInt(1000), tip: big.NewInt(10)}
+	fees, err = SuggestFees(ctx, london, UrgencyNormal)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fees.GasPrice, big.NewInt(2000), shtest.BigIntComparer)
+
+	assert.Error(t, SetMode("fast"), `invalid gas price mode "fast" (must be auto, legacy, or dynamic)`)
+}
+
+func TestBumpFees(t *testing.T) {
+	resetGlobals(t)
+
+	old := Fees{GasFeeCap: big.NewInt(1000), GasTipCap: big.NewInt(100)}
+	fees, err := BumpFees(old, Fees{GasFeeCap: big.NewInt(900), GasTipCap: big.NewInt(500)})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fees.GasFeeCap, big.NewInt(1126), shtest.BigIntComparer)
+	assert.DeepEqual(t, fees.GasTipCap, big.NewInt(500), shtest.BigIntComparer)
+
+	fees, err = BumpFees(Fees{GasPrice: big.NewInt(1000)}, Fees{GasPrice: big.NewInt(2000)})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fees.GasPrice, big.NewInt(2000), shtest.BigIntComparer)
+
+	assert.NilError(t, SetMaxFeePerGas(1e-6)) // 1000 wei
+	_, err = BumpFees(old, Fees{GasFeeCap: big.NewInt(900), GasTipCap: big.NewInt(50)})
+	assert.Equal(t, err, ErrCannotBump)
+}
+
+func TestEffectiveGasPrice(t *testing.T) {
+	legacy := types.NewTx(&types.LegacyTx{GasPrice: big.NewInt(1000)})
+	assert.DeepEqual(t, EffectiveGasPrice(legacy, nil), big.NewInt(1000), shtest.BigIntComparer)
+	assert.DeepEqual(t, EffectiveGasPrice(legacy, big.NewInt(800)), big.NewInt(1000), shtest.BigIntComparer)
+
+	dynamic := types.NewTx(&types.DynamicFeeTx{GasFeeCap: big.NewInt(1000), GasTipCap: big.NewInt(100)})
+	assert.DeepEqual(t, EffectiveGasPrice(dynamic, big.NewInt(500)), big.NewInt(600), shtest.BigIntComparer)
+	assert.DeepEqual(t, EffectiveGasPrice(dynamic, big.NewInt(950)), big.NewInt(1000), shtest.BigIntComparer)
+}
@@ -1,5 +1,6 @@
 // Package gaspricer is used to multiply the gas price by a configurable factor. This is needed
-// because the give price returned from SuggestGasPrice is too low (at least on goerli)
+// because the give price returned from SuggestGasPrice is too low (at least on goerli). It also
+// determines the fees of dynamic fee (EIP-1559) transactions, see SuggestFees.
 package gaspricer
 
 import (
@@ -0,0 +1,432 @@
+package keyper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/medley"
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+const (
+	// journalSegmentSize is the size in bytes after which a new journal segment is started.
+	journalSegmentSize = 64 << 20
+	// maxJournalSegments is the number of journal segments we keep on disk. Older ones are
+	// removed when a new segment is started.
+	maxJournalSegments = 16
+)
+
+// JournalEntry records a single run of the decider. If the decider neither emitted actions nor
+// changed the state, only the time and the heights are recorded in order to keep the journal
+// small. Otherwise, the entry contains everything needed to replay the decision.
+type JournalEntry struct {
+	Time           time.Time
+	ShutterBlock   int64
+	MainChainBlock uint64
+
+	Shutter     *observe.Shutter
+	MainChain   *observe.MainChain
+	StateBefore *State
+	StateAfter  *State
+	Actions     []fx.IAction
+}
+
+// HasSnapshot returns true if the entry contains the decider's inputs and can be replayed.
+func (e *JournalEntry) HasSnapshot() bool {
+	return e.Shutter != nil && e.MainChain != nil && e.StateBefore != nil
+}
+
+// Journal is an append-only log of the decisions the keyper made. Each record is a gzip
+// compressed, gob encoded JournalEntry prefixed with its length as 4 byte big endian integer.
+// Records are self-contained, so the journal can be read even if the last record has only been
+// written partially.
+type Journal struct {
+	dir  string
+	file *os.File
+	size int64
+}
+
+// OpenJournal opens the journal stored in the given directory. Entries are appended to a new
+// segment.
+func OpenJournal(dir string) (*Journal, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir}
+	err = j.startSegment()
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// journalSegmentPaths returns the paths of the journal segments in the given directory, the
+// oldest one first.
+func journalSegmentPaths(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "journal-*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (j *Journal) startSegment() error {
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+		j.file = nil
+	}
+	path := filepath.Join(j.dir, fmt.Sprintf("journal-%020d.dat", time.Now().UnixNano()))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	j.size = 0
+
+	paths, err := journalSegmentPaths(j.dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(paths)-maxJournalSegments; i++ {
+		if err := os.Remove(paths[i]); err != nil {
+			log.Printf("Error: failed to remove old journal segment: %s", err)
+		}
+	}
+	return nil
+}
+
+// encodeJournalRecord encodes the entry as journal record including the length prefix.
+func encodeJournalRecord(entry *JournalEntry) ([]byte, error) {
+	buff := bytes.Buffer{}
+	buff.Write(make([]byte, 4)) // reserve space for the length prefix
+	zw := gzip.NewWriter(&buff)
+	err := gob.NewEncoder(zw).Encode(entry)
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	record := buff.Bytes()
+	binary.BigEndian.PutUint32(record[:4], uint32(len(record)-4))
+	return record, nil
+}
+
+// Append writes the given entry to the journal.
+func (j *Journal) Append(entry *JournalEntry) error {
+	if j.size >= journalSegmentSize {
+		if err := j.startSegment(); err != nil {
+			return err
+		}
+	}
+	record, err := encodeJournalRecord(entry)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(record)
+	if err != nil {
+		return err
+	}
+	j.size += int64(len(record))
+	return nil
+}
+
+// Close closes the journal. It may be called on a nil journal.
+func (j *Journal) Close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// readJournalSegment calls handle for each entry stored in the given segment. A truncated record
+// at the end of the segment is ignored.
+func readJournalSegment(path string, handle func(entry *JournalEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	for {
+		var length uint32
+		err := binary.Read(r, binary.BigEndian, &length)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("Ignoring truncated record at the end of journal segment %s", path)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		record := make([]byte, length)
+		_, err = io.ReadFull(r, record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			log.Printf("Ignoring truncated record at the end of journal segment %s", path)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, err := decodeJournalEntry(record)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode journal record in %s", path)
+		}
+		err = handle(entry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decodeJournalEntry(record []byte) (*JournalEntry, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(record))
+	if err != nil {
+		return nil, err
+	}
+	entry := JournalEntry{}
+	err = gob.NewDecoder(zr).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ReadJournal calls handle for each entry of the journal stored in the given directory, the
+// oldest one first.
+func ReadJournal(dir string, handle func(entry *JournalEntry) error) error {
+	paths, err := journalSegmentPaths(dir)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return errors.Errorf("no journal found in %s", dir)
+	}
+	for _, path := range paths {
+		err := readJournalSegment(path, handle)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneState returns a deep copy of the given state.
+func cloneState(st *State) *State {
+	clone := State{}
+	medley.CloneWithGob(st, &clone)
+	return &clone
+}
+
+// stateChanged checks if the decider changed the given state in a way other than advancing the
+// sync heights. Both states must have been cloned with cloneState, so that empty and nil values
+// compare equal.
+func stateChanged(before, after *State) bool {
+	b := *before
+	b.SyncHeight = after.SyncHeight
+	b.MainChainSyncBlock = after.MainChainSyncBlock
+	return !reflect.DeepEqual(&b, after)
+}
+
+// NewJournalEntry creates a journal entry for the decider run in the given world.
+func NewJournalEntry(world observe.World, before, after *State, actions []fx.IAction) *JournalEntry {
+	entry := JournalEntry{
+		Time:           time.Now(),
+		ShutterBlock:   world.Shutter.CurrentBlock,
+		MainChainBlock: world.MainChain.CurrentBlock,
+	}
+	if len(actions) > 0 || stateChanged(before, after) {
+		entry.Shutter = world.Shutter
+		entry.MainChain = world.MainChain
+		entry.StateBefore = before
+		entry.StateAfter = after
+		entry.Actions = actions
+	}
+	return &entry
+}
+
+// TouchesBatch checks if the entry's actions or state changes concern the given batch.
+func (e *JournalEntry) TouchesBatch(batchIndex uint64) bool {
+	if !e.HasSnapshot() {
+		return false
+	}
+	for _, action := range e.Actions {
+		switch a := action.(type) {
+		case *fx.ExecuteCipherBatch:
+			if a.BatchIndex == batchIndex {
+				return true
+			}
+		case *fx.ExecutePlainBatch:
+			if a.BatchIndex == batchIndex {
+				return true
+			}
+		case *fx.SkipCipherBatch:
+			if a.BatchIndex == batchIndex {
+				return true
+			}
+		case *fx.Accuse:
+			if a.HalfStep/2 == batchIndex {
+				return true
+			}
+		case *fx.Appeal:
+			if a.Authorization.HalfStep/2 == batchIndex {
+				return true
+			}
+		case *fx.SendShuttermintMessage:
+			switch p := a.Msg.Payload.(type) {
+			case *shmsg.Message_DecryptionSignature:
+				if p.DecryptionSignature.BatchIndex == batchIndex {
+					return true
+				}
+			case *shmsg.Message_EpochSecretKeyShare:
+				if p.EpochSecretKeyShare.Epoch == batchIndex {
+					return true
+				}
+			}
+		}
+	}
+	return !reflect.DeepEqual(e.StateBefore.Batches[batchIndex], e.StateAfter.Batches[batchIndex])
+}
+
+// TouchesEon checks if the entry's actions or state changes concern the DKG or the epoch key
+// generation of the given eon.
+func (e *JournalEntry) TouchesEon(eon uint64) bool {
+	if !e.HasSnapshot() {
+		return false
+	}
+	for _, action := range e.Actions {
+		a, ok := action.(*fx.SendShuttermintMessage)
+		if !ok {
+			continue
+		}
+		var msgEon uint64
+		switch p := a.Msg.Payload.(type) {
+		case *shmsg.Message_PolyCommitment:
+			msgEon = p.PolyCommitment.Eon
+		case *shmsg.Message_PolyEval:
+			msgEon = p.PolyEval.Eon
+		case *shmsg.Message_Accusation:
+			msgEon = p.Accusation.Eon
+		case *shmsg.Message_Apology:
+			msgEon = p.Apology.Eon
+		case *shmsg.Message_EpochSecretKeyShare:
+			msgEon = p.EpochSecretKeyShare.Eon
+		default:
+			continue
+		}
+		if msgEon == eon {
+			return true
+		}
+	}
+	return !reflect.DeepEqual(findDKG(e.StateBefore, eon), findDKG(e.StateAfter, eon)) ||
+		!reflect.DeepEqual(findEKG(e.StateBefore, eon), findEKG(e.StateAfter, eon))
+}
+
+func findDKG(st *State, eon uint64) *DKG {
+	for i := range st.DKGs {
+		if st.DKGs[i].Eon == eon {
+			return &st.DKGs[i]
+		}
+	}
+	return nil
+}
+
+func findEKG(st *State, eon uint64) *EKG {
+	for _, ekg := range st.EKGs {
+		if ekg.Eon == eon {
+			return ekg
+		}
+	}
+	return nil
+}
+
+// ReplayStep is the result of replaying a single journal entry.
+type ReplayStep struct {
+	Entry       *JournalEntry
+	State       *State // the state after replaying the entry
+	Actions     []fx.IAction
+	Divergences []string
+}
+
+// ReplayEntry runs the decider on the inputs recorded in the given entry and compares the result
+// with the recorded one. Note that DKG messages contain random values, so steps in which we take
+// part in a DKG are expected to diverge.
+func ReplayEntry(config Config, entry *JournalEntry) *ReplayStep {
+	world := observe.World{Shutter: entry.Shutter, MainChain: entry.MainChain}
+	state := cloneState(entry.StateBefore)
+	dcdr := Decider{
+		Config:      config,
+		State:       state,
+		Shutter:     world.Shutter,
+		MainChain:   world.MainChain,
+		Changes:     state.GetChanges(world),
+		Actions:     []fx.IAction{},
+		PhaseLength: NewConstantPhaseLength(int64(config.DKGPhaseLength)),
+	}
+	dcdr.Decide()
+
+	step := ReplayStep{
+		Entry:   entry,
+		State:   state,
+		Actions: dcdr.Actions,
+	}
+	numActions := len(dcdr.Actions)
+	if len(entry.Actions) > numActions {
+		numActions = len(entry.Actions)
+	}
+	for i := 0; i < numActions; i++ {
+		var recorded, replayed string
+		if i < len(entry.Actions) {
+			recorded = fmt.Sprint(entry.Actions[i])
+		}
+		if i < len(dcdr.Actions) {
+			replayed = fmt.Sprint(dcdr.Actions[i])
+		}
+		if recorded != replayed {
+			step.Divergences = append(
+				step.Divergences,
+				fmt.Sprintf("action %d: recorded %q, replayed %q", i, recorded, replayed),
+			)
+		}
+	}
+	if stateChanged(cloneState(state), entry.StateAfter) {
+		step.Divergences = append(step.Divergences, "resulting state differs")
+	}
+	return &step
+}
+
+// Replay replays all entries of the journal in the given directory that contain a snapshot and
+// calls handle with the result.
+func Replay(config Config, dir string, handle func(step *ReplayStep) error) error {
+	return ReadJournal(dir, func(entry *JournalEntry) error {
+		if !entry.HasSnapshot() {
+			return nil
+		}
+		return handle(ReplayEntry(config, entry))
+	})
+}
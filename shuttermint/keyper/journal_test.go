@@ -0,0 +1,123 @@
+package keyper
+
+import (
+	"crypto/ed25519"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	world := observe.World{Shutter: observe.NewShutter(), MainChain: observe.NewMainChain(0)}
+	world.Shutter.CurrentBlock = 5
+
+	before := cloneState(NewState())
+	after := cloneState(before)
+	after.SyncHeight = 6
+	idle := NewJournalEntry(world, before, after, nil)
+	assert.Assert(t, !idle.HasSnapshot())
+
+	after = cloneState(after)
+	after.Batches[3] = &Batch{BatchIndex: 3}
+	actions := []fx.IAction{&fx.ExecutePlainBatch{BatchIndex: 3}}
+	busy := NewJournalEntry(world, before, after, actions)
+	assert.Assert(t, busy.HasSnapshot())
+	assert.Assert(t, busy.TouchesBatch(3))
+	assert.Assert(t, !busy.TouchesBatch(4))
+	assert.Assert(t, !busy.TouchesEon(3))
+
+	j, err := OpenJournal(dir)
+	assert.NilError(t, err)
+	assert.NilError(t, j.Append(idle))
+	assert.NilError(t, j.Append(busy))
+	assert.NilError(t, j.Close())
+
+	// a partially written record at the end is ignored
+	paths, err := journalSegmentPaths(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(paths), 1)
+	file, err := os.OpenFile(paths[0], os.O_WRONLY|os.O_APPEND, 0)
+	assert.NilError(t, err)
+	_, err = file.Write([]byte{0, 0, 1, 0, 42})
+	assert.NilError(t, err)
+	assert.NilError(t, file.Close())
+
+	var entries []*JournalEntry
+	err = ReadJournal(dir, func(entry *JournalEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Assert(t, !entries[0].HasSnapshot())
+	assert.Equal(t, entries[0].ShutterBlock, int64(5))
+	assert.Assert(t, entries[1].HasSnapshot())
+	assert.Assert(t, entries[1].TouchesBatch(3))
+	assert.Equal(t, len(entries[1].Actions), 1)
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	signingKey, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	encryptionKey, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	config := Config{
+		SigningKey:    signingKey,
+		EncryptionKey: ecies.ImportECDSA(encryptionKey),
+		ValidatorKey:  ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)),
+	}
+	shutter := observe.NewShutter()
+	shutter.CurrentBlock = 5
+	shutter.BatchConfigs = []shutterevents.BatchConfig{{Keypers: []common.Address{config.Address()}}}
+	mainChain := observe.NewMainChain(0)
+	mainChain.BatchConfigs = []contract.BatchConfig{{}}
+	world := observe.World{Shutter: shutter, MainChain: mainChain}
+
+	// record a step in which we check in
+	before := cloneState(NewState())
+	state := cloneState(before)
+	dcdr := Decider{
+		Config:    config,
+		State:     state,
+		Shutter:   shutter,
+		MainChain: mainChain,
+		Changes:   state.GetChanges(world),
+		Actions:   []fx.IAction{},
+	}
+	dcdr.Decide()
+	assert.Equal(t, len(dcdr.Actions), 1)
+	entry := NewJournalEntry(world, before, cloneState(state), dcdr.Actions)
+	assert.Assert(t, entry.HasSnapshot())
+
+	j, err := OpenJournal(dir)
+	assert.NilError(t, err)
+	assert.NilError(t, j.Append(NewJournalEntry(world, before, before, nil)))
+	assert.NilError(t, j.Append(entry))
+	// a step that has been recorded with a different result
+	entry.Actions = nil
+	assert.NilError(t, j.Append(entry))
+	assert.NilError(t, j.Close())
+
+	var steps []*ReplayStep
+	err = Replay(config, dir, func(step *ReplayStep) error {
+		steps = append(steps, step)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(steps), 2)
+	assert.Equal(t, len(steps[0].Divergences), 0)
+	assert.Equal(t, len(steps[0].Actions), 1)
+	assert.Assert(t, steps[0].State.CheckInMessageSent)
+	assert.Equal(t, len(steps[1].Divergences), 1)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -14,20 +15,33 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/kr/pretty"
 	"github.com/pkg/errors"
 	"github.com/tendermint/tendermint/rpc/client"
 	"github.com/tendermint/tendermint/rpc/client/http"
+	tmtypes "github.com/tendermint/tendermint/types"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/shutter-network/shutter/shuttermint/contract"
 	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/multiclient"
+	"github.com/shutter-network/shutter/shuttermint/keyper/notify"
 	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/medley"
 )
 
+// checkpointInterval is the time between two checkpoints of the observed world.
+const checkpointInterval = 10 * time.Minute
+
+// balanceCheckInterval is the time between two checks of the balance of our account.
+const balanceCheckInterval = 5 * time.Minute
+
 // IsWebsocketURL returns true iff the given URL is a websocket URL, i.e. if it starts with ws://
-// or wss://. This is needed for the watchMainChainHeadBlock method.
+// or wss://. We can only subscribe to new main chain blocks via websocket connections and have to
+// poll otherwise.
 func IsWebsocketURL(url string) bool {
 	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
 }
@@ -41,10 +55,15 @@ type Keyper struct {
 	State  *State // keyper's internal state
 
 	ContractCaller contract.Caller
-	shmcl          client.Client
+	ethcl          *multiclient.Client
+	shmcls         []client.Client
+	shmGenesis     *tmtypes.GenesisDoc // the shuttermint data is verified against, nil if not verified
 	MessageSender  fx.MessageSender
 	lastlogTime    time.Time
 	runenv         *fx.RunEnv
+	shadow         *shadowRecorder // replaces runenv in shadow mode
+	journal        *Journal
+	notifier       *notify.Notifier
 
 	mainChainCh     chan *observe.MainChain    // observed main chain updates
 	shutterCh       chan *observe.Shutter      // observed shutter updates
@@ -66,11 +85,10 @@ func NewKeyper(kc Config) Keyper {
 	}
 }
 
-func NewContractCallerFromConfig(config Config) (contract.Caller, error) {
-	ethcl, err := ethclient.Dial(config.EthereumURL)
-	if err != nil {
-		return contract.Caller{}, err
-	}
+// NewContractCallerFromConfig creates a contract caller using the given Ethereum client and the
+// contract addresses from the config. If the config doesn't specify the fee bank contract, its
+// address is queried from the batcher contract.
+func NewContractCallerFromConfig(config Config, ethcl contract.EthClient) (contract.Caller, error) {
 	configContract, err := contract.NewConfigContract(config.ConfigContractAddress, ethcl)
 	if err != nil {
 		return contract.Caller{}, err
@@ -101,39 +119,101 @@ func NewContractCallerFromConfig(config Config) (contract.Caller, error) {
 		return contract.Caller{}, err
 	}
 
+	feeBankContractAddress := config.FeeBankContractAddress
+	if feeBankContractAddress == (common.Address{}) {
+		feeBankContractAddress, err = batcherContract.FeeBankContract(&bind.CallOpts{})
+		if err != nil {
+			return contract.Caller{}, errors.Wrap(err, "failed to query fee bank address from batcher contract")
+		}
+	}
+	feeBankContract, err := contract.NewFeeBankContract(feeBankContractAddress, ethcl)
+	if err != nil {
+		return contract.Caller{}, err
+	}
+
 	return contract.NewCaller(
 		ethcl,
-		config.SigningKey,
+		config.Signer,
 		configContract,
 		keyBroadcastContract,
 		batcherContract,
 		executorContract,
 		depositContract,
 		keyperSlasher,
+		feeBankContract,
 	), nil
 }
 
 func (kpr *Keyper) init() error {
-	if kpr.shmcl != nil {
+	if kpr.shmcls != nil {
 		panic("internal error: already initialized")
 	}
 	var err error
-	kpr.shmcl, err = http.New(kpr.Config.ShuttermintURL, "/websocket")
-	if err != nil {
-		return errors.Wrapf(err, "create shuttermint client at %s", kpr.Config.ShuttermintURL)
+	numStarted := 0
+	for _, url := range kpr.Config.ShuttermintURLs() {
+		shmcl, err := http.New(url, "/websocket")
+		if err != nil {
+			return errors.Wrapf(err, "create shuttermint client at %s", url)
+		}
+		// Don't let a single node that is down prevent us from starting. We keep its client, so
+		// that the sync can start it once the node is back.
+		if err := shmcl.Start(); err != nil {
+			log.Printf("Error: cannot start shuttermint client at %s, will retry later: %v", url, err)
+		} else {
+			numStarted++
+		}
+		kpr.shmcls = append(kpr.shmcls, shmcl)
 	}
-	err = kpr.shmcl.Start()
-	if err != nil {
-		return errors.Wrapf(err, "start shuttermint client")
+	if numStarted == 0 {
+		return errors.Errorf("cannot start a shuttermint client at any of %v", kpr.Config.ShuttermintURLs())
+	}
+	if kpr.Config.VerifyShuttermint {
+		kpr.shmGenesis, err = tmtypes.GenesisDocFromFile(kpr.Config.ShuttermintGenesis)
+		if err != nil {
+			return errors.Wrap(err, "failed to read shuttermint genesis file")
+		}
 	}
-	ms := fx.NewRPCMessageSender(kpr.shmcl, kpr.Config.SigningKey)
+	ms := fx.NewRPCMessageSender(kpr.shmcls, kpr.Config.Signer)
 	kpr.MessageSender = &ms
 
-	kpr.ContractCaller, err = NewContractCallerFromConfig(kpr.Config)
+	kpr.ethcl, err = multiclient.Dial(context.Background(), kpr.Config.EthereumURLs(), kpr.Config.EthereumQuorum)
+	if err != nil {
+		return err
+	}
+	kpr.ContractCaller, err = NewContractCallerFromConfig(kpr.Config, kpr.ethcl)
 	if err != nil {
 		return err
 	}
-	kpr.runenv = fx.NewRunEnv(kpr.MessageSender, &kpr.ContractCaller, kpr.CurrentWorld, kpr.pathActionsGob())
+	if kpr.Config.Shadow {
+		kpr.shadow = newShadowRecorder(kpr.Config.Address())
+	} else {
+		kpr.runenv = fx.NewRunEnv(
+			kpr.MessageSender,
+			&kpr.ContractCaller,
+			kpr.CurrentWorld,
+			kpr.pathActionsGob(),
+			kpr.pathNoncesGob(),
+		)
+		kpr.runenv.ResendAfterBlocks = kpr.Config.ResendTXAfterBlocks
+	}
+	// In shadow mode, notifications are only logged, since the keyper running in production
+	// will send them already.
+	var sinks []notify.Sink
+	if kpr.Config.NotifyWebhookURL != "" && !kpr.Config.Shadow {
+		sinks = append(sinks, notify.NewWebhookSink(kpr.Config.NotifyWebhookURL))
+	}
+	if kpr.Config.NotifyCommand != "" && !kpr.Config.Shadow {
+		sinks = append(sinks, notify.NewExecSink(kpr.Config.NotifyCommand))
+	}
+	kpr.notifier = notify.NewNotifier(sinks...)
+	// The journal stores a copy of the state for every step in which the decider does
+	// something, which is expensive, so it's only written if enabled.
+	if kpr.Config.Journal {
+		kpr.journal, err = OpenJournal(kpr.pathJournal())
+		if err != nil {
+			return err
+		}
+	}
 	kpr.mainChainCh = make(chan *observe.MainChain)
 	kpr.shutterCh = make(chan *observe.Shutter)
 	kpr.signalCh = make(chan os.Signal, 1)
@@ -169,12 +249,18 @@ func (kpr *Keyper) ShortInfo() string {
 			notAKeyper = fmt.Sprintf("Not configured as keyper in config %d, ", configIndex)
 		}
 	}
+	var runInfo string
+	if kpr.shadow != nil {
+		runInfo = kpr.shadow.ShortInfo()
+	} else {
+		runInfo = kpr.runenv.ShortInfo()
+	}
 	return fmt.Sprintf(
 		"%sshutter block %d, main chain %d, %s, last eon started %d, num half steps: %d%s",
 		notAKeyper,
 		world.Shutter.CurrentBlock,
 		world.MainChain.CurrentBlock,
-		kpr.runenv.ShortInfo(),
+		runInfo,
 		kpr.State.LastEonStarted,
 		world.MainChain.NumExecutionHalfSteps,
 		kpr.dkginfo(),
@@ -238,13 +324,73 @@ func (kpr *Keyper) syncLoop(ctx context.Context) error {
 
 func (kpr *Keyper) startSyncTasks(ctx context.Context, g *errgroup.Group) {
 	g.Go(func() error {
-		return observe.SyncMain(ctx, &kpr.ContractCaller, kpr.CurrentWorld().MainChain, kpr.mainChainCh)
+		return kpr.ethcl.RunHealthChecks(ctx)
+	})
+	g.Go(func() error {
+		return kpr.writeCheckpoints(ctx)
+	})
+	g.Go(func() error {
+		return kpr.notifier.Run(ctx)
+	})
+	if kpr.Config.MinBalance > 0 && kpr.shadow == nil {
+		g.Go(func() error {
+			return kpr.watchBalance(ctx)
+		})
+	}
+	// We only need our fee bank balance if we withdraw fees automatically
+	var feeAccount common.Address
+	if kpr.Config.FeeWithdrawalAddress != (common.Address{}) {
+		feeAccount = kpr.Config.Address()
+	}
+	g.Go(func() error {
+		return observe.SyncMain(
+			ctx,
+			&kpr.ContractCaller,
+			kpr.CurrentWorld().MainChain,
+			kpr.mainChainCh,
+			kpr.Config.MainChainPollInterval(),
+			feeAccount,
+		)
 	})
 	g.Go(func() error {
-		return observe.SyncShutter(ctx, kpr.shmcl, kpr.CurrentWorld().Shutter, kpr.shutterCh, kpr.shutterFilterCh)
+		return observe.SyncShutter(
+			ctx,
+			kpr.shmcls,
+			kpr.CurrentWorld().Shutter,
+			kpr.shutterCh,
+			kpr.shutterFilterCh,
+			kpr.shmGenesis,
+		)
 	})
 }
 
+// watchBalance periodically checks that the balance of our account is above the configured
+// threshold and notifies operators otherwise.
+func (kpr *Keyper) watchBalance(ctx context.Context) error {
+	address := kpr.Config.Address()
+	minBalance := etherToWei(kpr.Config.MinBalance)
+	for {
+		balance, err := kpr.ethcl.BalanceAt(ctx, address, nil)
+		if err != nil {
+			log.Printf("Error: failed to fetch balance of %s: %s", address.Hex(), err)
+		} else if balance.Cmp(minBalance) < 0 {
+			eth := new(big.Float).Quo(new(big.Float).SetInt(balance), big.NewFloat(params.Ether))
+			kpr.notifier.Notify(notify.NewEvent(
+				notify.KindLowBalance,
+				address.Hex(),
+				"balance of %s is %s ETH, below the threshold of %g ETH",
+				address.Hex(),
+				eth.Text('f', 6),
+				kpr.Config.MinBalance,
+			))
+		}
+		medley.Sleep(ctx, balanceCheckInterval)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
 func (kpr *Keyper) loadRunenv(ctx context.Context) error {
 	havePendingActions, err := kpr.runenv.Load(ctx)
 	if err != nil {
@@ -261,9 +407,11 @@ func (kpr *Keyper) loadRunenv(ctx context.Context) error {
 func (kpr *Keyper) run(ctx context.Context, g *errgroup.Group) error {
 	kpr.startSyncTasks(ctx, g)
 	kpr.syncOnce(ctx)
-	kpr.runenv.StartBackgroundTasks(ctx, g)
-	if err := kpr.loadRunenv(ctx); err != nil {
-		return err
+	if kpr.shadow == nil {
+		kpr.runenv.StartBackgroundTasks(ctx, g)
+		if err := kpr.loadRunenv(ctx); err != nil {
+			return err
+		}
 	}
 
 	if len(kpr.State.Actions) > 0 {
@@ -279,6 +427,10 @@ func (kpr *Keyper) Run(ctx context.Context) error {
 	if err := kpr.init(); err != nil {
 		return err
 	}
+	defer kpr.journal.Close()
+	if err := kpr.loadCheckpoint(ctx); err != nil {
+		return err
+	}
 	g, groupCtx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
@@ -306,6 +458,87 @@ func (kpr *Keyper) pathActionsGob() string {
 	return filepath.Join(kpr.Config.DBDir, "actions.gob")
 }
 
+func (kpr *Keyper) pathNoncesGob() string {
+	return filepath.Join(kpr.Config.DBDir, "nonces.gob")
+}
+
+func (kpr *Keyper) pathJournal() string {
+	return filepath.Join(kpr.Config.DBDir, "journal")
+}
+
+func (kpr *Keyper) pathCheckpoints() string {
+	return filepath.Join(kpr.Config.DBDir, "checkpoints")
+}
+
+// loadCheckpoint resumes syncing from the latest valid checkpoint if we haven't synced anything
+// yet, e.g. because the state file has been removed.
+func (kpr *Keyper) loadCheckpoint(ctx context.Context) error {
+	world := kpr.CurrentWorld()
+	if world.Shutter.CurrentBlock != -1 {
+		return nil
+	}
+	var cp *observe.Checkpoint
+	var err error
+	for _, shmcl := range kpr.shmcls {
+		cp, err = observe.LoadCheckpoint(ctx, kpr.pathCheckpoints(), shmcl, &kpr.ContractCaller)
+		if err == nil {
+			break
+		}
+		log.Printf("Failed to load checkpoint: %s", err)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to load checkpoint")
+	}
+	if cp == nil {
+		return nil
+	}
+
+	log.Printf("Resuming from checkpoint at shuttermint height %d", cp.Shutter.CurrentBlock)
+	world.Shutter = cp.Shutter
+	if cp.MainChain != nil {
+		world.MainChain = cp.MainChain
+	}
+	kpr.world.Store(world)
+	return nil
+}
+
+// writeCheckpoints periodically saves a checkpoint of the observed world.
+func (kpr *Keyper) writeCheckpoints(ctx context.Context) error {
+	lastHeight := kpr.CurrentWorld().Shutter.CurrentBlock
+	writer := observe.NewCheckpointWriter(kpr.pathCheckpoints())
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(checkpointInterval):
+		}
+
+		world := kpr.CurrentWorld()
+		if world.Shutter.CurrentBlock <= lastHeight {
+			continue
+		}
+		var cp *observe.Checkpoint
+		var err error
+		for _, shmcl := range kpr.shmcls {
+			cp, err = observe.NewCheckpoint(ctx, shmcl, world)
+			if err == nil {
+				break
+			}
+		}
+		if err == nil {
+			err = writer.Save(cp)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Failed to write checkpoint: %s", err)
+			continue
+		}
+		lastHeight = world.Shutter.CurrentBlock
+	}
+}
+
 func (kpr *Keyper) LoadState() error {
 	gobpath := kpr.pathStateGob()
 
@@ -374,28 +607,57 @@ func (kpr *Keyper) runActions(ctx context.Context) error {
 		kpr.lastlogTime = now
 	}
 
-	err := kpr.runenv.RunActions(ctx, kpr.State.ActionCounter, kpr.State.Actions)
-	if err != nil {
-		return err
+	if kpr.shadow != nil {
+		world := kpr.CurrentWorld()
+		kpr.shadow.Check(world, kpr.State)
+		kpr.shadow.Record(world, kpr.State.Actions)
+	} else {
+		err := kpr.runenv.RunActions(ctx, kpr.State.ActionCounter, kpr.State.Actions)
+		if err != nil {
+			return err
+		}
 	}
 	kpr.State.ActionCounter += uint64(len(kpr.State.Actions))
 	kpr.State.Actions = nil
 	return nil
 }
 
-func (kpr *Keyper) decide() []fx.IAction {
+// decide runs the decider for a single step and returns the actions to run. It also returns if the
+// state needs to be saved, which is the case if the observed world has changed or there are actions
+// or notifications, which must not be repeated after a restart.
+func (kpr *Keyper) decide() ([]fx.IAction, bool) {
 	decider := NewDecider(kpr)
+	var before *State
+	if kpr.journal != nil {
+		before = cloneState(kpr.State)
+	}
 	decider.Decide()
-	return decider.Actions
+	if kpr.journal != nil {
+		world := observe.World{Shutter: decider.Shutter, MainChain: decider.MainChain}
+		entry := NewJournalEntry(world, before, cloneState(kpr.State), decider.Actions)
+		if err := kpr.journal.Append(entry); err != nil {
+			log.Printf("Error: failed to write journal entry: %s", err)
+		}
+	}
+	if kpr.notifier != nil {
+		for _, ev := range decider.Notifications {
+			kpr.notifier.Notify(ev)
+		}
+	}
+	save := !decider.Changes.IsEmpty() || len(decider.Actions) > 0 || len(decider.Notifications) > 0
+	return decider.Actions, save
 }
 
 func (kpr *Keyper) runOneStep(ctx context.Context) error {
 	if len(kpr.State.Actions) > 0 {
 		panic("internal errror: kpr.State.Actions is not empty")
 	}
-	kpr.State.Actions = kpr.decide()
-	if err := kpr.saveState(); err != nil {
-		panic(err)
+	actions, save := kpr.decide()
+	kpr.State.Actions = actions
+	if save {
+		if err := kpr.saveState(); err != nil {
+			panic(err)
+		}
 	}
 	return kpr.runActions(ctx)
 }
@@ -0,0 +1,85 @@
+package keyper
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+const (
+	// keyRotationDelay is the number of shuttermint blocks between sending a key rotation and
+	// the activation of the new keys. It gives the keyper's operator time to switch the
+	// shuttermint node to a new validator key.
+	keyRotationDelay = 100
+
+	// keyRotationRetryInterval is the number of shuttermint blocks after which we send a key
+	// rotation again if it hasn't shown up in the chain.
+	keyRotationRetryInterval = 50
+)
+
+// encryptionKeyAt returns our private encryption key that belongs to the public key shuttermint
+// knows for us at the given height. Other keypers encrypt the poly evals of an eon to the key
+// active at the eon's start, so this is the key we need to decrypt them. If we don't have that
+// key anymore, we fall back to our current one.
+func (dcdr *Decider) encryptionKeyAt(height int64) *ecies.PrivateKey {
+	publicKey, ok := dcdr.Shutter.EncryptionKeyAt(dcdr.Config.Address(), height)
+	if !ok {
+		return dcdr.Config.EncryptionKey
+	}
+	keys := append([]*ecies.PrivateKey{dcdr.Config.EncryptionKey}, dcdr.Config.PreviousEncryptionKeys...)
+	for _, key := range keys {
+		if key.PublicKey.X.Cmp(publicKey.X) == 0 && key.PublicKey.Y.Cmp(publicKey.Y) == 0 {
+			return key
+		}
+	}
+	log.Printf("Warning: missing the encryption key active at shuttermint block %d, using the current one", height)
+	return dcdr.Config.EncryptionKey
+}
+
+// maybeRotateKeys schedules our configured encryption and validator keys in shuttermint if they
+// differ from the ones we've checked in with or rotated to last. The new keys become active
+// keyRotationDelay blocks later.
+func (dcdr *Decider) maybeRotateKeys() {
+	address := dcdr.Config.Address()
+	if !dcdr.Shutter.IsCheckedIn(address) {
+		return
+	}
+
+	var encryptionKey *ecies.PublicKey
+	latestEncryptionKey, _ := dcdr.Shutter.LatestEncryptionKey(address)
+	configEncryptionKey := &dcdr.Config.EncryptionKey.PublicKey
+	if configEncryptionKey.X.Cmp(latestEncryptionKey.X) != 0 || configEncryptionKey.Y.Cmp(latestEncryptionKey.Y) != 0 {
+		encryptionKey = configEncryptionKey
+	}
+	var validatorKey []byte
+	latestValidatorKey, ok := dcdr.Shutter.LatestValidatorKey(address)
+	configValidatorKey := []byte(dcdr.Config.ValidatorKey.Public().(ed25519.PublicKey))
+	if ok && !bytes.Equal(configValidatorKey, latestValidatorKey) {
+		validatorKey = configValidatorKey
+	}
+	if encryptionKey == nil && validatorKey == nil {
+		return
+	}
+
+	nextHeight := dcdr.Shutter.CurrentBlock + 1
+	if dcdr.State.KeyRotationHeight != 0 && nextHeight < dcdr.State.KeyRotationHeight+keyRotationRetryInterval {
+		return
+	}
+	if dcdr.State.KeyRotationHeight != 0 {
+		log.Printf("Key rotation sent at block %d hasn't shown up, sending it again", dcdr.State.KeyRotationHeight)
+	}
+	dcdr.State.KeyRotationHeight = nextHeight
+	activationHeight := nextHeight + keyRotationDelay
+	dcdr.sendShuttermintMessage(
+		fmt.Sprintf(
+			"key rotation, activation=%d, encryption key=%t, validator key=%t",
+			activationHeight, encryptionKey != nil, validatorKey != nil,
+		),
+		shmsg.NewKeyRotation(uint64(activationHeight), validatorKey, encryptionKey),
+	)
+}
@@ -0,0 +1,61 @@
+package keyper
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+)
+
+func TestMaybeRotateKeys(t *testing.T) {
+	config := Config{}
+	assert.NilError(t, config.GenerateNewKeys())
+	oldKey := config.EncryptionKey
+	state := NewState()
+	shutter := observe.NewShutter()
+
+	step := func(shutterBlock int64) []fx.IAction {
+		shutter.CurrentBlock = shutterBlock
+		dcdr := &Decider{Config: config, State: state, Shutter: shutter}
+		dcdr.maybeRotateKeys()
+		return dcdr.Actions
+	}
+
+	// nothing to rotate before we've checked in
+	assert.Equal(t, len(step(10)), 0)
+	shutter.KeyperEncryptionKeys[config.Address()] = (*observe.EncryptionPublicKey)(&oldKey.PublicKey)
+	shutter.KeyperValidatorKeys[config.Address()] = []byte(config.ValidatorKey.Public().(ed25519.PublicKey))
+	assert.Equal(t, len(step(10)), 0)
+
+	newKey, err := randomEncryptionKey()
+	assert.NilError(t, err)
+	config.EncryptionKey = newKey
+	config.PreviousEncryptionKeys = []*ecies.PrivateKey{oldKey}
+	actions := step(10)
+	assert.Equal(t, len(actions), 1)
+	msg := actions[0].(*fx.SendShuttermintMessage).Msg.GetKeyRotation()
+	assert.Equal(t, msg.GetActivationHeight(), uint64(11+keyRotationDelay))
+	assert.Equal(t, len(msg.GetValidatorPublicKey()), 0)
+	assert.DeepEqual(t, msg.GetEncryptionPublicKey(), crypto.CompressPubkey(newKey.PublicKey.ExportECDSA()))
+
+	// send again if the rotation doesn't show up
+	assert.Equal(t, len(step(11)), 0)
+	assert.Equal(t, len(step(10+keyRotationRetryInterval)), 1)
+
+	shutter.KeyRotations[config.Address()] = []observe.KeyRotation{{
+		Height:              12,
+		ActivationHeight:    100,
+		EncryptionPublicKey: (*observe.EncryptionPublicKey)(&newKey.PublicKey),
+	}}
+	assert.Equal(t, len(step(100+keyRotationRetryInterval)), 0)
+
+	// eons are decrypted with the key active at their start
+	dcdr := &Decider{Config: config, State: state, Shutter: shutter}
+	assert.Assert(t, dcdr.encryptionKeyAt(99) == oldKey)
+	assert.Assert(t, dcdr.encryptionKeyAt(100) == newKey)
+}
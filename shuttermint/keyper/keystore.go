@@ -0,0 +1,168 @@
+package keyper
+
+// This file implements passphrase protected keystore files for the keyper's secret keys. The
+// ECDSA keys (signing and encryption key) are stored in the geth keystore format. The ed25519
+// validator key is stored in a similar JSON format using the same key derivation and encryption.
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/console/prompt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// PassphraseEnvVar is the environment variable the keystore passphrase is read from, if no
+// passphrase file is given.
+const PassphraseEnvVar = "KEYPER_PASSPHRASE"
+
+const (
+	ed25519KeystoreVersion = 1
+	ed25519KeystoreType    = "ed25519"
+)
+
+// ScryptParams determines the cost of the key derivation used to encrypt keystore files.
+type ScryptParams struct {
+	N, P int
+}
+
+var (
+	// StandardScryptParams should be used for keystore files in production.
+	StandardScryptParams = ScryptParams{N: keystore.StandardScryptN, P: keystore.StandardScryptP}
+	// LightScryptParams are much cheaper to compute and are intended for test setups.
+	LightScryptParams = ScryptParams{N: keystore.LightScryptN, P: keystore.LightScryptP}
+)
+
+// ed25519KeyJSON is the on-disk format of an encrypted ed25519 key.
+type ed25519KeyJSON struct {
+	Type      string              `json:"type"`
+	PublicKey string              `json:"publickey"`
+	Crypto    keystore.CryptoJSON `json:"crypto"`
+	ID        string              `json:"id"`
+	Version   int                 `json:"version"`
+}
+
+// ReadPassphrase returns the passphrase used to lock and unlock keystore files. It is read from
+// the file at path if path is non-empty, from the KEYPER_PASSPHRASE environment variable if that
+// one is set, and otherwise interactively from the terminal. If confirm is true, the user has to
+// enter the passphrase twice when prompted.
+func ReadPassphrase(path string, confirm bool) (string, error) {
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read passphrase file")
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	if passphrase, ok := os.LookupEnv(PassphraseEnvVar); ok {
+		return passphrase, nil
+	}
+
+	passphrase, err := prompt.Stdin.PromptPassword("Keystore passphrase: ")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read passphrase")
+	}
+	if confirm {
+		repeated, err := prompt.Stdin.PromptPassword("Repeat passphrase: ")
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read passphrase")
+		}
+		if passphrase != repeated {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// EncryptECDSAKey encrypts the given key with the passphrase in the geth keystore format.
+func EncryptECDSAKey(key *ecdsa.PrivateKey, passphrase string, params ScryptParams) ([]byte, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	k := &keystore.Key{
+		Id:         id,
+		Address:    crypto.PubkeyToAddress(key.PublicKey),
+		PrivateKey: key,
+	}
+	return keystore.EncryptKey(k, passphrase, params.N, params.P)
+}
+
+// DecryptECDSAKey decrypts a key stored in the geth keystore format.
+func DecryptECDSAKey(keyjson []byte, passphrase string) (*ecdsa.PrivateKey, error) {
+	k, err := keystore.DecryptKey(keyjson, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return k.PrivateKey, nil
+}
+
+// EncryptEd25519Key encrypts the seed of the given key with the passphrase.
+func EncryptEd25519Key(key ed25519.PrivateKey, passphrase string, params ScryptParams) ([]byte, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	cryptoJSON, err := keystore.EncryptDataV3(key.Seed(), []byte(passphrase), params.N, params.P)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ed25519KeyJSON{
+		Type:      ed25519KeystoreType,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Crypto:    cryptoJSON,
+		ID:        id.String(),
+		Version:   ed25519KeystoreVersion,
+	})
+}
+
+// DecryptEd25519Key decrypts a key encrypted with EncryptEd25519Key.
+func DecryptEd25519Key(keyjson []byte, passphrase string) (ed25519.PrivateKey, error) {
+	k := ed25519KeyJSON{}
+	if err := json.Unmarshal(keyjson, &k); err != nil {
+		return nil, err
+	}
+	if k.Type != ed25519KeystoreType || k.Version != ed25519KeystoreVersion {
+		return nil, errors.Errorf("unsupported keystore type %q, version %d", k.Type, k.Version)
+	}
+	seed, err := keystore.DecryptDataV3(k.Crypto, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.Errorf("invalid seed length %d (must be %d)", len(seed), ed25519.SeedSize)
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	if hex.EncodeToString(key.Public().(ed25519.PublicKey)) != k.PublicKey {
+		return nil, errors.New("public key mismatch")
+	}
+	return key, nil
+}
+
+func readKeystoreFile(path string) ([]byte, error) {
+	keyjson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read keystore file %s", path)
+	}
+	return keyjson, nil
+}
+
+func writeKeystoreFile(path string, keyjson []byte) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create keystore file %s", path)
+	}
+	defer file.Close()
+	if _, err := file.Write(keyjson); err != nil {
+		return errors.Wrapf(err, "failed to write keystore file %s", path)
+	}
+	return file.Sync()
+}
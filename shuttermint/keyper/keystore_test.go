@@ -0,0 +1,112 @@
+package keyper
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/spf13/viper"
+	"gotest.tools/v3/assert"
+)
+
+func TestECDSAKeystoreRoundtrip(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+
+	keyjson, err := EncryptECDSAKey(key, "secret", LightScryptParams)
+	assert.NilError(t, err)
+
+	decrypted, err := DecryptECDSAKey(keyjson, "secret")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, crypto.FromECDSA(key), crypto.FromECDSA(decrypted))
+
+	_, err = DecryptECDSAKey(keyjson, "wrong")
+	assert.Assert(t, err != nil)
+}
+
+func TestEd25519KeystoreRoundtrip(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+
+	keyjson, err := EncryptEd25519Key(key, "secret", LightScryptParams)
+	assert.NilError(t, err)
+
+	decrypted, err := DecryptEd25519Key(keyjson, "secret")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, key, decrypted)
+
+	_, err = DecryptEd25519Key(keyjson, "wrong")
+	assert.Assert(t, err != nil)
+}
+
+func TestConfigKeystores(t *testing.T) {
+	dir := t.TempDir()
+
+	config := Config{DBDir: "db"}
+	assert.NilError(t, config.GenerateNewKeys())
+	assert.NilError(t, config.WriteKeystores(dir, "secret", LightScryptParams))
+
+	buf := new(bytes.Buffer)
+	assert.NilError(t, config.WriteTOML(buf))
+	assert.Assert(t, !bytes.Contains(buf.Bytes(), []byte("SigningKey\t")))
+
+	v := viper.New()
+	v.SetConfigType("toml")
+	assert.NilError(t, v.ReadConfig(buf))
+
+	loaded := Config{}
+	assert.NilError(t, loaded.Unmarshal(v))
+	assert.NilError(t, loaded.ResolvePaths(dir))
+	assert.Equal(t, loaded.DBDir, filepath.Join(dir, "db"))
+	assert.Equal(t, loaded.SigningKeystore, filepath.Join(dir, signingKeystoreFilename))
+
+	err := loaded.UnlockKeys(func() (string, error) { return "wrong", nil })
+	assert.Assert(t, err != nil)
+
+	assert.NilError(t, loaded.UnlockKeys(func() (string, error) { return "secret", nil }))
+	assert.DeepEqual(t, crypto.FromECDSA(config.SigningKey), crypto.FromECDSA(loaded.SigningKey))
+	assert.DeepEqual(t, config.ValidatorKey, loaded.ValidatorKey)
+	assert.DeepEqual(t, config.EncryptionKey.D.Bytes(), loaded.EncryptionKey.D.Bytes())
+
+	info, err := os.Stat(loaded.SigningKeystore)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+}
+
+func TestUnlockKeysRejectsDuplicateKeys(t *testing.T) {
+	config := Config{}
+	assert.NilError(t, config.GenerateNewKeys())
+	config.SigningKeystore = "signing-key.json"
+
+	err := config.UnlockKeys(func() (string, error) {
+		t.Fatal("passphrase must not be requested")
+		return "", nil
+	})
+	assert.Assert(t, err != nil)
+}
+
+func TestConfigPreviousEncryptionKeys(t *testing.T) {
+	config := Config{}
+	assert.NilError(t, config.GenerateNewKeys())
+	for i := 0; i < 2; i++ {
+		key, err := randomEncryptionKey()
+		assert.NilError(t, err)
+		config.PreviousEncryptionKeys = append(config.PreviousEncryptionKeys, key)
+	}
+
+	buf := new(bytes.Buffer)
+	assert.NilError(t, config.WriteTOML(buf))
+	v := viper.New()
+	v.SetConfigType("toml")
+	assert.NilError(t, v.ReadConfig(buf))
+
+	loaded := Config{}
+	assert.NilError(t, loaded.Unmarshal(v))
+	assert.Equal(t, len(loaded.PreviousEncryptionKeys), 2)
+	for i, key := range config.PreviousEncryptionKeys {
+		assert.DeepEqual(t, key.D.Bytes(), loaded.PreviousEncryptionKeys[i].D.Bytes())
+	}
+}
@@ -0,0 +1,640 @@
+// Package multiclient implements an Ethereum client that distributes its calls over multiple
+// Ethereum nodes. Calls are sent to the first healthy node and fail over to the next one if the
+// node cannot be reached. Optionally, headers of specific blocks and the data we act on, i.e. the
+// results of contract calls, logs, and receipts, are cross-checked with the other nodes, so that a
+// single faulty node cannot make us act on a wrong view of the chain.
+package multiclient
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+)
+
+const (
+	healthCheckInterval = 15 * time.Second
+	healthCheckTimeout  = 10 * time.Second
+	maxHeadLag          = 5 // in main chain blocks
+)
+
+// ErrNoQuorum is returned if not enough nodes agree on the result of a call.
+var ErrNoQuorum = errors.New("not enough Ethereum nodes agree on the result")
+
+// DialFunc connects to the Ethereum node at the given URL.
+type DialFunc func(ctx context.Context, url string) (contract.EthClient, error)
+
+func dialEthclient(ctx context.Context, url string) (contract.EthClient, error) {
+	return ethclient.DialContext(ctx, url)
+}
+
+type endpoint struct {
+	url     string
+	client  contract.EthClient // nil if we couldn't connect yet
+	healthy bool
+}
+
+// Client implements contract.EthClient on top of multiple Ethereum nodes.
+type Client struct {
+	mux       sync.Mutex
+	endpoints []*endpoint
+	quorum    int
+	dial      DialFunc
+}
+
+var _ contract.EthClient = &Client{}
+
+// Dial connects to the Ethereum nodes at the given URLs. The first URL is the primary node, the
+// others are used in order if it fails. If quorum is larger than one, headers of specific blocks,
+// results of contract calls, logs, and receipts are only accepted if at least that many nodes agree
+// on them. Nodes that cannot be reached are retried later, but at least one node has to be
+// reachable.
+func Dial(ctx context.Context, urls []string, quorum int) (*Client, error) {
+	return New(ctx, urls, quorum, dialEthclient)
+}
+
+// New creates a client for the given URLs using the given function to connect to them. See Dial.
+func New(ctx context.Context, urls []string, quorum int, dial DialFunc) (*Client, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no Ethereum URL given")
+	}
+	if quorum > len(urls) {
+		return nil, errors.Errorf("quorum %d exceeds the number of Ethereum nodes %d", quorum, len(urls))
+	}
+
+	c := &Client{quorum: quorum, dial: dial}
+	numConnected := 0
+	for _, url := range urls {
+		ep := &endpoint{url: url}
+		cl, err := dial(ctx, url)
+		if err != nil {
+			log.Printf("Failed to connect to Ethereum node at %s: %v", url, err)
+		} else {
+			ep.client = cl
+			ep.healthy = true
+			numConnected++
+		}
+		c.endpoints = append(c.endpoints, ep)
+	}
+	if numConnected == 0 {
+		return nil, errors.Errorf("failed to connect to any Ethereum node")
+	}
+	return c, nil
+}
+
+// isNodeError checks if the error returned by a call indicates a problem with the node or the
+// connection to it. Other errors, e.g. reverted calls, missing data, or rejected transactions,
+// would be returned by any other node as well, so we don't fail over on them.
+func isNodeError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+// candidates returns the endpoints to try in order: first the healthy ones, then the others as a
+// last resort.
+func (c *Client) candidates() []*endpoint {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var healthy, unhealthy []*endpoint
+	for _, ep := range c.endpoints {
+		if ep.healthy {
+			healthy = append(healthy, ep)
+		} else {
+			unhealthy = append(unhealthy, ep)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (c *Client) markFailed(ep *endpoint, err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if ep.healthy {
+		log.Printf("Ethereum node at %s failed: %v", ep.url, err)
+		ep.healthy = false
+	}
+}
+
+func (c *Client) markHealthy(ep *endpoint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !ep.healthy {
+		log.Printf("Ethereum node at %s is healthy again", ep.url)
+		ep.healthy = true
+	}
+}
+
+// getClient returns the client of the given endpoint, connecting to the node if necessary.
+func (c *Client) getClient(ctx context.Context, ep *endpoint) (contract.EthClient, error) {
+	c.mux.Lock()
+	cl := ep.client
+	c.mux.Unlock()
+	if cl != nil {
+		return cl, nil
+	}
+
+	cl, err := c.dial(ctx, ep.url)
+	if err != nil {
+		return nil, err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if ep.client == nil {
+		ep.client = cl
+	}
+	return ep.client, nil
+}
+
+// do calls f with the client of the first working node.
+func (c *Client) do(ctx context.Context, f func(ep *endpoint, cl contract.EthClient) error) error {
+	var err error
+	for _, ep := range c.candidates() {
+		var cl contract.EthClient
+		cl, err = c.getClient(ctx, ep)
+		if err == nil {
+			err = f(ep, cl)
+		}
+		if !isNodeError(ctx, err) {
+			if err == nil {
+				c.markHealthy(ep)
+			}
+			return err
+		}
+		c.markFailed(ep, err)
+	}
+	return err
+}
+
+// RunHealthChecks periodically checks the health of all nodes until the context is canceled.
+// Nodes that don't respond or lag behind the others are only used if all others fail.
+func (c *Client) RunHealthChecks(ctx context.Context) error {
+	for {
+		c.checkHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(healthCheckInterval):
+		}
+	}
+}
+
+func (c *Client) checkHealth(ctx context.Context) {
+	c.mux.Lock()
+	endpoints := append([]*endpoint{}, c.endpoints...)
+	c.mux.Unlock()
+
+	blockNumbers := make([]uint64, len(endpoints))
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep *endpoint) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			cl, err := c.getClient(checkCtx, ep)
+			if err == nil {
+				blockNumbers[i], err = cl.BlockNumber(checkCtx)
+			}
+			errs[i] = err
+		}(i, ep)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	var head uint64
+	for i := range endpoints {
+		if errs[i] == nil && blockNumbers[i] > head {
+			head = blockNumbers[i]
+		}
+	}
+	for i, ep := range endpoints {
+		switch {
+		case errs[i] != nil:
+			c.markFailed(ep, errs[i])
+		case blockNumbers[i]+maxHeadLag < head:
+			c.markFailed(ep, errors.Errorf("lagging behind at block %d, head is %d", blockNumbers[i], head))
+		default:
+			c.markHealthy(ep)
+		}
+	}
+}
+
+// nodeResult is the result of a call to a single node.
+type nodeResult struct {
+	value interface{}
+	key   string // results with the same key are equal
+	err   error
+}
+
+// agree calls f with the clients of all nodes in parallel and returns the result at least quorum
+// nodes agree on. f returns the result and a key identifying it. Errors returned by the nodes
+// themselves, e.g. for reverted calls, are results as well, so we return them if enough nodes agree
+// on them, unwrapped.
+func (c *Client) agree(
+	ctx context.Context,
+	f func(cl contract.EthClient) (interface{}, string, error),
+) (interface{}, error) {
+	endpoints := c.candidates()
+	results := make([]*nodeResult, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep *endpoint) {
+			defer wg.Done()
+			var value interface{}
+			var key string
+			cl, err := c.getClient(ctx, ep)
+			if err == nil {
+				value, key, err = f(cl)
+			}
+			if isNodeError(ctx, err) {
+				c.markFailed(ep, err)
+				return
+			}
+			if err != nil {
+				key = "error: " + err.Error()
+			}
+			results[i] = &nodeResult{value: value, key: key, err: err}
+		}(i, ep)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	votes := make(map[string]int)
+	for _, res := range results {
+		if res == nil {
+			continue
+		}
+		votes[res.key]++
+		if votes[res.key] >= c.quorum {
+			return res.value, res.err
+		}
+	}
+	return nil, errors.Wrapf(ErrNoQuorum, "%d nodes required, %d distinct results", c.quorum, len(votes))
+}
+
+// jsonKey returns the JSON encoding of v for use as result key.
+func jsonKey(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// quorumBlockNumber returns the number of the latest block at least quorum nodes have reached.
+// Calls for the latest block are pinned to it, so that enough nodes can answer them.
+func (c *Client) quorumBlockNumber(ctx context.Context) (*big.Int, error) {
+	endpoints := c.candidates()
+	blockNumbers := make([]uint64, len(endpoints))
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep *endpoint) {
+			defer wg.Done()
+			cl, err := c.getClient(ctx, ep)
+			if err == nil {
+				blockNumbers[i], err = cl.BlockNumber(ctx)
+			}
+			if isNodeError(ctx, err) {
+				c.markFailed(ep, err)
+			}
+			errs[i] = err
+		}(i, ep)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var reached []uint64
+	for i := range endpoints {
+		if errs[i] == nil {
+			reached = append(reached, blockNumbers[i])
+		}
+	}
+	if len(reached) < c.quorum {
+		return nil, errors.Wrapf(ErrNoQuorum, "only %d nodes know their latest block", len(reached))
+	}
+	sort.Slice(reached, func(i, j int) bool { return reached[i] > reached[j] })
+	return new(big.Int).SetUint64(reached[c.quorum-1]), nil
+}
+
+// checkQuorum fetches the header of the given block from all nodes and returns it if at least
+// quorum nodes agree on its hash.
+func (c *Client) checkQuorum(ctx context.Context, number *big.Int) (*types.Header, error) {
+	res, err := c.agree(ctx, func(cl contract.EthClient) (interface{}, string, error) {
+		header, err := cl.HeaderByNumber(ctx, number)
+		if err != nil {
+			return nil, "", err
+		}
+		return header, header.Hash().Hex(), nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrNoQuorum) {
+			return nil, errors.Wrapf(err, "header of block %d", number)
+		}
+		return nil, err
+	}
+	return res.(*types.Header), nil
+}
+
+// HeaderByNumber returns the header of the given block. If number is not nil and a quorum is
+// configured, the header is only returned if enough nodes agree on it.
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number != nil && c.quorum > 1 {
+		return c.checkQuorum(ctx, number)
+	}
+	var res *types.Header
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.HeaderByNumber(ctx, number)
+		return
+	})
+	return res, err
+}
+
+// SubscribeNewHead subscribes to new head blocks of the first working node. If the subscription
+// fails, the node is marked as failed, so that resubscribing fails over to the next one.
+func (c *Client) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	var sub ethereum.Subscription
+	var subEndpoint *endpoint
+	err := c.do(ctx, func(ep *endpoint, cl contract.EthClient) (err error) {
+		sub, err = cl.SubscribeNewHead(ctx, ch)
+		subEndpoint = ep
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		select {
+		case err := <-sub.Err():
+			if err != nil {
+				c.markFailed(subEndpoint, err)
+			}
+			return err
+		case <-quit:
+			sub.Unsubscribe()
+			return nil
+		}
+	}), nil
+}
+
+// ChainID implements contract.EthClient.
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	var res *big.Int
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.ChainID(ctx)
+		return
+	})
+	return res, err
+}
+
+// BlockNumber implements contract.EthClient.
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	var res uint64
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.BlockNumber(ctx)
+		return
+	})
+	return res, err
+}
+
+// SyncProgress implements contract.EthClient.
+func (c *Client) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	var res *ethereum.SyncProgress
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.SyncProgress(ctx)
+		return
+	})
+	return res, err
+}
+
+// NonceAt implements contract.EthClient.
+func (c *Client) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	var res uint64
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.NonceAt(ctx, account, blockNumber)
+		return
+	})
+	return res, err
+}
+
+// BalanceAt implements contract.EthClient.
+func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	var res *big.Int
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.BalanceAt(ctx, account, blockNumber)
+		return
+	})
+	return res, err
+}
+
+// TransactionByHash implements contract.EthClient.
+func (c *Client) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	var res *types.Transaction
+	var isPending bool
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, isPending, err = cl.TransactionByHash(ctx, hash)
+		return
+	})
+	return res, isPending, err
+}
+
+// TransactionReceipt implements contract.EthClient. If a quorum is configured, the receipt is only
+// returned if enough nodes agree on it. Otherwise, the transaction is treated as not mined yet,
+// since the nodes may not all have seen the latest block.
+func (c *Client) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if c.quorum > 1 {
+		res, err := c.agree(ctx, func(cl contract.EthClient) (interface{}, string, error) {
+			receipt, err := cl.TransactionReceipt(ctx, txHash)
+			if err != nil {
+				return nil, "", err
+			}
+			return receipt, jsonKey(receipt), nil
+		})
+		if errors.Is(err, ErrNoQuorum) {
+			return nil, ethereum.NotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res.(*types.Receipt), nil
+	}
+	var res *types.Receipt
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.TransactionReceipt(ctx, txHash)
+		return
+	})
+	return res, err
+}
+
+// CodeAt implements bind.ContractCaller.
+func (c *Client) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	var res []byte
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.CodeAt(ctx, account, blockNumber)
+		return
+	})
+	return res, err
+}
+
+// CallContract implements bind.ContractCaller. If a quorum is configured, the result is only
+// returned if enough nodes agree on it. Calls for the latest block are pinned to the latest block
+// enough nodes have reached.
+func (c *Client) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if c.quorum > 1 {
+		if blockNumber == nil {
+			var err error
+			blockNumber, err = c.quorumBlockNumber(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		res, err := c.agree(ctx, func(cl contract.EthClient) (interface{}, string, error) {
+			data, err := cl.CallContract(ctx, call, blockNumber)
+			return data, string(data), err
+		})
+		if errors.Is(err, ErrNoQuorum) {
+			return nil, errors.Wrapf(err, "call to %s at block %d", call.To, blockNumber)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res.([]byte), nil
+	}
+	var res []byte
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.CallContract(ctx, call, blockNumber)
+		return
+	})
+	return res, err
+}
+
+// PendingCodeAt implements bind.ContractTransactor.
+func (c *Client) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	var res []byte
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.PendingCodeAt(ctx, account)
+		return
+	})
+	return res, err
+}
+
+// PendingNonceAt implements bind.ContractTransactor.
+func (c *Client) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	var res uint64
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.PendingNonceAt(ctx, account)
+		return
+	})
+	return res, err
+}
+
+// SuggestGasPrice implements bind.ContractTransactor.
+func (c *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	var res *big.Int
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.SuggestGasPrice(ctx)
+		return
+	})
+	return res, err
+}
+
+// SuggestGasTipCap implements bind.ContractTransactor.
+func (c *Client) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	var res *big.Int
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.SuggestGasTipCap(ctx)
+		return
+	})
+	return res, err
+}
+
+// EstimateGas implements bind.ContractTransactor.
+func (c *Client) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	var res uint64
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.EstimateGas(ctx, call)
+		return
+	})
+	return res, err
+}
+
+// SendTransaction implements bind.ContractTransactor.
+func (c *Client) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return c.do(ctx, func(_ *endpoint, cl contract.EthClient) error {
+		return cl.SendTransaction(ctx, tx)
+	})
+}
+
+// FilterLogs implements bind.ContractFilterer. If a quorum is configured, the logs are only
+// returned if enough nodes agree on them. Queries up to the latest block are pinned to the latest
+// block enough nodes have reached.
+func (c *Client) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	if c.quorum > 1 {
+		if query.BlockHash == nil && query.ToBlock == nil {
+			toBlock, err := c.quorumBlockNumber(ctx)
+			if err != nil {
+				return nil, err
+			}
+			query.ToBlock = toBlock
+		}
+		res, err := c.agree(ctx, func(cl contract.EthClient) (interface{}, string, error) {
+			logs, err := cl.FilterLogs(ctx, query)
+			if err != nil {
+				return nil, "", err
+			}
+			return logs, jsonKey(logs), nil
+		})
+		if errors.Is(err, ErrNoQuorum) {
+			return nil, errors.Wrap(err, "filter logs")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res.([]types.Log), nil
+	}
+	var res []types.Log
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.FilterLogs(ctx, query)
+		return
+	})
+	return res, err
+}
+
+// SubscribeFilterLogs implements bind.ContractFilterer.
+func (c *Client) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	var res ethereum.Subscription
+	err := c.do(ctx, func(_ *endpoint, cl contract.EthClient) (err error) {
+		res, err = cl.SubscribeFilterLogs(ctx, query, ch)
+		return
+	})
+	return res, err
+}
@@ -0,0 +1,200 @@
+package multiclient
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+)
+
+var errConnection = errors.New("connection refused")
+
+type rpcError struct{}
+
+func (rpcError) Error() string  { return "execution reverted" }
+func (rpcError) ErrorCode() int { return 3 }
+
+// fakeNode implements the few methods used in the tests. Calling any other method panics.
+type fakeNode struct {
+	contract.EthClient
+	chainID     int64
+	err         error
+	blockNumber uint64
+	extra       []byte // makes the header hash differ between nodes
+	calls       int
+	callBlock   *big.Int // block number of the last contract call
+}
+
+func (n *fakeNode) ChainID(_ context.Context) (*big.Int, error) {
+	n.calls++
+	if n.err != nil {
+		return nil, n.err
+	}
+	return big.NewInt(n.chainID), nil
+}
+
+func (n *fakeNode) BlockNumber(_ context.Context) (uint64, error) {
+	return n.blockNumber, n.err
+}
+
+func (n *fakeNode) HeaderByNumber(_ context.Context, number *big.Int) (*types.Header, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	return &types.Header{Number: number, Extra: n.extra}, nil
+}
+
+func (n *fakeNode) CallContract(_ context.Context, _ ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	n.callBlock = blockNumber
+	if n.err != nil {
+		return nil, n.err
+	}
+	return append([]byte{byte(blockNumber.Uint64())}, n.extra...), nil
+}
+
+func (n *fakeNode) FilterLogs(_ context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	return []types.Log{{BlockNumber: query.ToBlock.Uint64(), Data: n.extra}}, nil
+}
+
+func (n *fakeNode) TransactionReceipt(_ context.Context, _ common.Hash) (*types.Receipt, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	if n.blockNumber == 0 {
+		return nil, ethereum.NotFound
+	}
+	return &types.Receipt{BlockNumber: new(big.Int).SetUint64(n.blockNumber), Logs: []*types.Log{}}, nil
+}
+
+func newTestClient(t *testing.T, quorum int, nodes ...*fakeNode) *Client {
+	t.Helper()
+	var urls []string
+	byURL := make(map[string]*fakeNode)
+	for i, n := range nodes {
+		url := string(rune('a' + i))
+		urls = append(urls, url)
+		byURL[url] = n
+	}
+	c, err := New(context.Background(), urls, quorum, func(_ context.Context, url string) (contract.EthClient, error) {
+		return byURL[url], nil
+	})
+	assert.NilError(t, err)
+	return c
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	primary := &fakeNode{chainID: 1, err: errConnection}
+	secondary := &fakeNode{chainID: 2}
+	c := newTestClient(t, 0, primary, secondary)
+
+	chainID, err := c.ChainID(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, chainID.Int64(), int64(2))
+
+	// the failed node is not tried again as long as another one works
+	chainID, err = c.ChainID(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, chainID.Int64(), int64(2))
+	assert.Equal(t, primary.calls, 1)
+
+	// errors returned by the node itself don't trigger a failover
+	secondary.err = rpcError{}
+	_, err = c.ChainID(ctx)
+	assert.Equal(t, err, secondary.err)
+	assert.Equal(t, primary.calls, 1)
+
+	// the primary node is used again once it's healthy
+	primary.err = nil
+	primary.blockNumber = 10
+	secondary.err = nil
+	secondary.blockNumber = 10
+	c.checkHealth(ctx)
+	chainID, err = c.ChainID(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, chainID.Int64(), int64(1))
+}
+
+func TestHealthCheckLag(t *testing.T) {
+	ctx := context.Background()
+	primary := &fakeNode{chainID: 1, blockNumber: 100}
+	secondary := &fakeNode{chainID: 2, blockNumber: 120}
+	c := newTestClient(t, 0, primary, secondary)
+
+	c.checkHealth(ctx)
+	chainID, err := c.ChainID(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, chainID.Int64(), int64(2))
+}
+
+func TestQuorum(t *testing.T) {
+	ctx := context.Background()
+	a := &fakeNode{}
+	b := &fakeNode{extra: []byte("fork")}
+	c := &fakeNode{}
+	client := newTestClient(t, 2, a, b, c)
+
+	header, err := client.HeaderByNumber(ctx, big.NewInt(5))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, header.Extra, a.extra)
+
+	c.err = errConnection
+	_, err = client.HeaderByNumber(ctx, big.NewInt(5))
+	assert.Assert(t, errors.Is(err, ErrNoQuorum))
+
+	// the latest header is not cross-checked
+	_, err = client.HeaderByNumber(ctx, nil)
+	assert.NilError(t, err)
+
+	_, err = New(ctx, []string{"a"}, 2, nil)
+	assert.Assert(t, err != nil)
+}
+
+func TestQuorumCalls(t *testing.T) {
+	ctx := context.Background()
+	a := &fakeNode{blockNumber: 10}
+	b := &fakeNode{blockNumber: 12, extra: []byte("fake")}
+	c := &fakeNode{blockNumber: 8}
+	client := newTestClient(t, 2, a, b, c)
+
+	// calls for the latest block are pinned to a block enough nodes have reached
+	data, err := client.CallContract(ctx, ethereum.CallMsg{}, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, data, []byte{10})
+	assert.Equal(t, b.callBlock.Uint64(), uint64(10))
+
+	// a single node cannot make up the data
+	data, err = client.CallContract(ctx, ethereum.CallMsg{}, big.NewInt(5))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, data, []byte{5})
+	logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{ToBlock: big.NewInt(5)})
+	assert.NilError(t, err)
+	assert.Equal(t, len(logs), 1)
+	assert.Equal(t, len(logs[0].Data), 0)
+
+	// errors returned by enough nodes are passed on as they are
+	a.err = rpcError{}
+	c.err = rpcError{}
+	_, err = client.CallContract(ctx, ethereum.CallMsg{}, big.NewInt(5))
+	assert.Equal(t, err, rpcError{})
+
+	// receipts the nodes don't agree on are treated as not found yet
+	a.err = nil
+	c.err = nil
+	_, err = client.TransactionReceipt(ctx, common.Hash{})
+	assert.Equal(t, err, ethereum.NotFound)
+	c.blockNumber = 10
+	receipt, err := client.TransactionReceipt(ctx, common.Hash{})
+	assert.NilError(t, err)
+	assert.Equal(t, receipt.BlockNumber.Uint64(), uint64(10))
+}
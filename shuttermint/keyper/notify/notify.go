@@ -0,0 +1,176 @@
+// Package notify delivers notifications about critical keyper events to operators. Events are
+// deduplicated and rate limited per kind before being passed to the configured sinks.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Kind is the type of an event.
+type Kind string
+
+const (
+	// KindAccused means our keyper has been accused in the keyper slasher.
+	KindAccused Kind = "accused"
+	// KindAppealDeadline means an accusation against us has reached its appeal deadline without
+	// having been appealed.
+	KindAppealDeadline Kind = "appeal-deadline"
+	// KindDKGFailed means a DKG we take part in failed to finalize.
+	KindDKGFailed Kind = "dkg-failed"
+	// KindCorrupt means we are considered corrupt in a DKG.
+	KindCorrupt Kind = "corrupt"
+	// KindExecutionTimeout means the execution timeout of a batch has been reached.
+	KindExecutionTimeout Kind = "execution-timeout"
+	// KindDeposit means our deposit is missing or has been slashed while we're a keyper in a new
+	// eon.
+	KindDeposit Kind = "deposit"
+	// KindLowBalance means the balance of the account sending our transactions is low.
+	KindLowBalance Kind = "low-balance"
+)
+
+const (
+	// DefaultDedupInterval is the default time during which events with the same kind and key
+	// are only delivered once.
+	DefaultDedupInterval = 6 * time.Hour
+	// DefaultRateLimit is the default maximum number of events of the same kind delivered per
+	// RateInterval.
+	DefaultRateLimit = 20
+	// DefaultRateInterval is the default interval the rate limit applies to.
+	DefaultRateInterval = time.Hour
+
+	queueSize   = 64
+	sinkTimeout = 30 * time.Second
+)
+
+// Event is a notification about something an operator should look at.
+type Event struct {
+	Kind    Kind      `json:"kind"`
+	Key     string    `json:"key"` // identifies the event among those of the same kind
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// NewEvent creates a new event.
+func NewEvent(kind Kind, key string, format string, args ...interface{}) Event {
+	return Event{
+		Kind:    kind,
+		Key:     key,
+		Message: fmt.Sprintf(format, args...),
+		Time:    time.Now(),
+	}
+}
+
+func (ev Event) String() string {
+	return fmt.Sprintf("%s (%s): %s", ev.Kind, ev.Key, ev.Message)
+}
+
+// Sink delivers events to operators.
+type Sink interface {
+	Notify(ctx context.Context, ev Event) error
+}
+
+// Notifier deduplicates and rate limits events and passes the remaining ones to its sinks. Events
+// are delivered in the background by Run. The rate limit applies to each kind separately, so that
+// a flood of events of one kind doesn't keep us from delivering events of other kinds.
+type Notifier struct {
+	Sinks         []Sink
+	DedupInterval time.Duration
+	RateLimit     int
+	RateInterval  time.Duration
+
+	mux        sync.Mutex
+	sent       map[string]time.Time // time at which events have been sent by kind and key
+	recent     map[Kind][]time.Time // times of the events sent during the last rate interval
+	numDropped int                  // number of events dropped due to the rate limit
+	queue      chan Event
+}
+
+// NewNotifier creates a notifier passing events to the given sinks.
+func NewNotifier(sinks ...Sink) *Notifier {
+	return &Notifier{
+		Sinks:         sinks,
+		DedupInterval: DefaultDedupInterval,
+		RateLimit:     DefaultRateLimit,
+		RateInterval:  DefaultRateInterval,
+		sent:          make(map[string]time.Time),
+		recent:        make(map[Kind][]time.Time),
+		queue:         make(chan Event, queueSize),
+	}
+}
+
+// accept checks if the event should be delivered and records it if so.
+func (n *Notifier) accept(ev Event) bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	for key, t := range n.sent {
+		if ev.Time.Sub(t) >= n.DedupInterval {
+			delete(n.sent, key)
+		}
+	}
+	key := string(ev.Kind) + "/" + ev.Key
+	if _, ok := n.sent[key]; ok {
+		return false
+	}
+
+	recent := n.recent[ev.Kind]
+	i := 0
+	for i < len(recent) && ev.Time.Sub(recent[i]) >= n.RateInterval {
+		i++
+	}
+	recent = recent[i:]
+	n.recent[ev.Kind] = recent
+	if len(recent) >= n.RateLimit {
+		n.numDropped++
+		log.Printf("Notification rate limit for %s exceeded, dropping %s", ev.Kind, ev)
+		return false
+	}
+
+	n.sent[key] = ev.Time
+	n.recent[ev.Kind] = append(recent, ev.Time)
+	return true
+}
+
+// Notify schedules the event for delivery unless it is a duplicate or the rate limit has been
+// exceeded. It does not block.
+func (n *Notifier) Notify(ev Event) {
+	if !n.accept(ev) {
+		return
+	}
+	log.Printf("Notification: %s", ev)
+	select {
+	case n.queue <- ev:
+	default:
+		log.Printf("Notification queue full, dropping %s", ev)
+	}
+}
+
+// NumDropped returns the number of events dropped due to the rate limit.
+func (n *Notifier) NumDropped() int {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.numDropped
+}
+
+// Run delivers the scheduled events to the sinks until the context is canceled.
+func (n *Notifier) Run(ctx context.Context) error {
+	for {
+		select {
+		case ev := <-n.queue:
+			for _, sink := range n.Sinks {
+				sinkCtx, cancel := context.WithTimeout(ctx, sinkTimeout)
+				err := sink.Notify(sinkCtx, ev)
+				cancel()
+				if err != nil {
+					log.Printf("Error: failed to deliver notification %s: %s", ev, err)
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNotifierDedupAndRateLimit(t *testing.T) {
+	n := NewNotifier()
+	n.RateLimit = 2
+	start := time.Now()
+	event := func(key string, offset time.Duration) Event {
+		ev := NewEvent(KindAccused, key, "accused")
+		ev.Time = start.Add(offset)
+		return ev
+	}
+
+	assert.Assert(t, n.accept(event("1", 0)))
+	assert.Assert(t, !n.accept(event("1", time.Minute)))
+	assert.Assert(t, n.accept(event("2", time.Minute)))
+	assert.Assert(t, !n.accept(event("3", 2*time.Minute)))
+	assert.Equal(t, n.NumDropped(), 1)
+
+	// after the rate interval, events are accepted again, but duplicates only after the dedup
+	// interval
+	assert.Assert(t, n.accept(event("3", n.RateInterval+time.Minute)))
+	assert.Assert(t, !n.accept(event("1", n.RateInterval+2*time.Minute)))
+	assert.Assert(t, n.accept(event("1", n.DedupInterval)))
+
+	// events of other kinds are not held back by the rate limit
+	ev := NewEvent(KindDKGFailed, "1", "failed")
+	ev.Time = start.Add(n.DedupInterval)
+	assert.Assert(t, n.accept(event("4", n.DedupInterval)))
+	assert.Assert(t, !n.accept(event("5", n.DedupInterval)))
+	assert.Assert(t, n.accept(ev))
+}
+
+func TestSinks(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := Event{}
+		err := json.NewDecoder(r.Body).Decode(&ev)
+		assert.NilError(t, err)
+		received <- ev
+	}))
+	defer server.Close()
+
+	ev := NewEvent(KindLowBalance, "balance", "balance is %d", 5)
+	err := NewWebhookSink(server.URL).Notify(context.Background(), ev)
+	assert.NilError(t, err)
+	assert.Equal(t, (<-received).Message, "balance is 5")
+
+	path := filepath.Join(t.TempDir(), "event")
+	err = NewExecSink("echo $SHUTTER_EVENT_KIND > "+path).Notify(context.Background(), ev)
+	assert.NilError(t, err)
+	out, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(out), "low-balance\n")
+
+	err = NewExecSink("exit 1").Notify(context.Background(), ev)
+	assert.Assert(t, err != nil)
+}
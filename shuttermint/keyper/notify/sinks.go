@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// WebhookSink posts events as JSON to a URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookSink creates a sink posting events to the given URL.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: http.DefaultClient}
+}
+
+func (s *WebhookSink) Notify(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook %s returned status %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// ExecSink runs a shell command for each event. The event is passed as JSON on stdin and in the
+// environment variables SHUTTER_EVENT_KIND, SHUTTER_EVENT_KEY and SHUTTER_EVENT_MESSAGE.
+type ExecSink struct {
+	Command string
+}
+
+// NewExecSink creates a sink running the given shell command.
+func NewExecSink(command string) *ExecSink {
+	return &ExecSink{Command: command}
+}
+
+func (s *ExecSink) Notify(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(
+		os.Environ(),
+		"SHUTTER_EVENT_KIND="+string(ev.Kind),
+		"SHUTTER_EVENT_KEY="+ev.Key,
+		"SHUTTER_EVENT_MESSAGE="+ev.Message,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "notification command failed: %s", out)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package observe
+
+import (
+	"sort"
+)
+
+// ChangeKind is the type of a change to the observed world.
+type ChangeKind int
+
+const (
+	// EonChanged means an eon has been started or received new DKG messages. Index is the eon.
+	EonChanged ChangeKind = iota
+	// SharesChanged means an eon received new epoch secret key shares. Index is the eon.
+	SharesChanged
+	// SignaturesChanged means a batch received new decryption signatures. Index is the batch
+	// index.
+	SignaturesChanged
+	// ShutterConfigChanged means a batch config has been added in shuttermint. Index is the
+	// config index.
+	ShutterConfigChanged
+	// CheckInChanged means a keyper has checked in. Index is unused.
+	CheckInChanged
+	// MainChainConfigChanged means a batch config has been added to the config contract. Index is
+	// the config index.
+	MainChainConfigChanged
+	// AccusationChanged means an executor has been accused, has appealed, or has been slashed.
+	// Index is the half step.
+	AccusationChanged
+	// BatchChanged means a transaction has been added to a batch on the main chain. Index is the
+	// batch index.
+	BatchChanged
+)
+
+// Change describes a change of a single item of the observed world.
+type Change struct {
+	Height int64 // shuttermint height or main chain block number the change happened at
+	Kind   ChangeKind
+	Index  uint64
+}
+
+// ChangeSet is the set of items of the observed world that have changed since some point. The
+// decider uses it to only look at the parts of its state affected by the changes.
+type ChangeSet struct {
+	Full bool // if set, the changes are unknown and everything must be considered changed
+
+	Eons        map[uint64]struct{}
+	Shares      map[uint64]struct{}
+	Signatures  map[uint64]struct{}
+	Accusations map[uint64]struct{}
+	Batches     map[uint64]struct{}
+
+	ShutterConfigs   bool
+	MainChainConfigs bool
+	CheckIns         bool
+}
+
+// NewChangeSet creates an empty change set.
+func NewChangeSet() ChangeSet {
+	return ChangeSet{
+		Eons:        make(map[uint64]struct{}),
+		Shares:      make(map[uint64]struct{}),
+		Signatures:  make(map[uint64]struct{}),
+		Accusations: make(map[uint64]struct{}),
+		Batches:     make(map[uint64]struct{}),
+	}
+}
+
+// IsEmpty checks if nothing has changed.
+func (cs ChangeSet) IsEmpty() bool {
+	return !cs.Full &&
+		len(cs.Eons) == 0 &&
+		len(cs.Shares) == 0 &&
+		len(cs.Signatures) == 0 &&
+		len(cs.Accusations) == 0 &&
+		len(cs.Batches) == 0 &&
+		!cs.ShutterConfigs &&
+		!cs.MainChainConfigs &&
+		!cs.CheckIns
+}
+
+// Add adds a single change to the change set.
+func (cs *ChangeSet) Add(change Change) {
+	switch change.Kind {
+	case EonChanged:
+		cs.Eons[change.Index] = struct{}{}
+	case SharesChanged:
+		cs.Shares[change.Index] = struct{}{}
+	case SignaturesChanged:
+		cs.Signatures[change.Index] = struct{}{}
+	case AccusationChanged:
+		cs.Accusations[change.Index] = struct{}{}
+	case BatchChanged:
+		cs.Batches[change.Index] = struct{}{}
+	case ShutterConfigChanged:
+		cs.ShutterConfigs = true
+	case MainChainConfigChanged:
+		cs.MainChainConfigs = true
+	case CheckInChanged:
+		cs.CheckIns = true
+	}
+}
+
+// Merge adds all changes of other to the change set.
+func (cs *ChangeSet) Merge(other ChangeSet) {
+	cs.Full = cs.Full || other.Full
+	for k := range other.Eons {
+		cs.Eons[k] = struct{}{}
+	}
+	for k := range other.Shares {
+		cs.Shares[k] = struct{}{}
+	}
+	for k := range other.Signatures {
+		cs.Signatures[k] = struct{}{}
+	}
+	for k := range other.Accusations {
+		cs.Accusations[k] = struct{}{}
+	}
+	for k := range other.Batches {
+		cs.Batches[k] = struct{}{}
+	}
+	cs.ShutterConfigs = cs.ShutterConfigs || other.ShutterConfigs
+	cs.MainChainConfigs = cs.MainChainConfigs || other.MainChainConfigs
+	cs.CheckIns = cs.CheckIns || other.CheckIns
+}
+
+// SortedKeys returns the keys of one of the change set's maps in ascending order.
+func SortedKeys(m map[uint64]struct{}) []uint64 {
+	keys := make([]uint64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// changesSince returns the change set of all changes at or after the given height. The changes
+// must be sorted by height. Changes before start are unknown.
+func changesSince(changes []Change, start int64, height int64) ChangeSet {
+	cs := NewChangeSet()
+	if height < start {
+		cs.Full = true
+		return cs
+	}
+	idx := sort.Search(len(changes), func(i int) bool {
+		return changes[i].Height >= height
+	})
+	for _, change := range changes[idx:] {
+		cs.Add(change)
+	}
+	return cs
+}
+
+// pruneChanges removes the changes before the given height.
+func pruneChanges(changes []Change, height int64) []Change {
+	idx := sort.Search(len(changes), func(i int) bool {
+		return changes[i].Height >= height
+	})
+	return append([]Change(nil), changes[idx:]...)
+}
@@ -0,0 +1,38 @@
+package observe
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+)
+
+func TestShutterChanges(t *testing.T) {
+	sh := NewShutter()
+	sh.applyEvent(&shutterevents.EonStarted{Height: 1, Eon: 1})
+	sh.applyEvent(&shutterevents.DecryptionSignature{Height: 2, BatchIndex: 5})
+	sh.applyEvent(&shutterevents.EpochSecretKeyShare{Height: 3, Eon: 1})
+	sh.applyEvent(&shutterevents.BatchConfig{Height: 3, ConfigIndex: 2})
+
+	changes := sh.ChangesSince(0)
+	assert.Assert(t, !changes.Full)
+	assert.DeepEqual(t, SortedKeys(changes.Eons), []uint64{1})
+	assert.DeepEqual(t, SortedKeys(changes.Signatures), []uint64{5})
+	assert.DeepEqual(t, SortedKeys(changes.Shares), []uint64{1})
+	assert.Assert(t, changes.ShutterConfigs)
+
+	changes = sh.ChangesSince(3)
+	assert.Equal(t, len(changes.Eons), 0)
+	assert.Equal(t, len(changes.Signatures), 0)
+	assert.DeepEqual(t, SortedKeys(changes.Shares), []uint64{1})
+
+	// changes for an unknown eon are not recorded
+	sh.applyEvent(&shutterevents.PolyCommitment{Height: 4, Eon: 7})
+	assert.Equal(t, len(sh.ChangesSince(4).Eons), 0)
+
+	filtered := sh.ApplyFilter(ShutterFilter{SyncHeight: 3})
+	assert.Assert(t, filtered.ChangesSince(2).Full)
+	assert.Assert(t, !filtered.ChangesSince(3).Full)
+	assert.Equal(t, len(sh.ChangesSince(0).Signatures), 1)
+}
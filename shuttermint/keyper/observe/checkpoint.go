@@ -0,0 +1,411 @@
+package observe
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/tendermint/tendermint/rpc/client"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+)
+
+const (
+	// maxCheckpoints is the number of checkpoints we keep on disk, not counting the full
+	// checkpoints they're based on. Older ones are removed when a new checkpoint is saved.
+	maxCheckpoints = 3
+
+	// maxDeltas is the number of incremental checkpoints written after a full one, before the
+	// next full one is written.
+	maxDeltas = 10
+)
+
+// Checkpoint is a snapshot of the observed world syncing can resume from instead of starting at
+// height 0. It stores the hashes of the blocks the snapshot has been taken at, so that it can be
+// verified that it has been taken from the chains we're connected to.
+type Checkpoint struct {
+	Shutter          *Shutter
+	ShutterBlockHash []byte // hash of the shuttermint block at Shutter.CurrentBlock
+	MainChain        *MainChain
+}
+
+// checkpointFile is what we store on disk. A full checkpoint stores the whole world. An
+// incremental one only stores the batches and eons that have been added or changed since the full
+// checkpoint at BaseHeight and the indices of the batches that have been removed.
+type checkpointFile struct {
+	Checkpoint
+	BaseHeight              int64 // 0 for a full checkpoint
+	RemovedShutterBatches   []uint64
+	RemovedMainChainBatches []uint64
+}
+
+// NewCheckpoint creates a checkpoint of the given world. It fetches the hash of the shuttermint
+// block the shutter object has been synced to.
+func NewCheckpoint(ctx context.Context, shmcl client.Client, world World) (*Checkpoint, error) {
+	height := world.Shutter.CurrentBlock
+	if height < 1 {
+		return nil, pkgErrors.Errorf("shutter state has not been synced yet")
+	}
+	commit, err := shmcl.Commit(ctx, &height)
+	if err != nil {
+		return nil, pkgErrors.Wrapf(err, "failed to fetch shuttermint commit at height %d", height)
+	}
+	return &Checkpoint{
+		Shutter:          world.Shutter,
+		ShutterBlockHash: commit.Header.Hash(),
+		MainChain:        world.MainChain,
+	}, nil
+}
+
+func checkpointPath(dir string, height int64, baseHeight int64) string {
+	if baseHeight == 0 {
+		return filepath.Join(dir, fmt.Sprintf("checkpoint-%012d.gob", height))
+	}
+	return filepath.Join(dir, fmt.Sprintf("checkpoint-%012d-base-%012d.gob", height, baseHeight))
+}
+
+// checkpointBaseHeight returns the height of the full checkpoint the checkpoint at the given path
+// is based on, or 0 if it's a full checkpoint itself.
+func checkpointBaseHeight(path string) int64 {
+	name := strings.TrimSuffix(filepath.Base(path), ".gob")
+	i := strings.Index(name, "-base-")
+	if i == -1 {
+		return 0
+	}
+	baseHeight, err := strconv.ParseInt(name[i+len("-base-"):], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return baseHeight
+}
+
+// checkpointPaths returns the paths of the checkpoints stored in the given directory, the latest
+// one first.
+func checkpointPaths(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "checkpoint-*.gob"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths, nil
+}
+
+// sameSlice checks if the two slices are the same, i.e. if they share their elements. Since the
+// events are shared between the clones of a shutter object until they change, this tells if the
+// events have changed.
+func sameSlice(a, b interface{}) bool {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	return va.Len() == vb.Len() && (va.Len() == 0 || va.Pointer() == vb.Pointer())
+}
+
+func sameEon(a, b *Eon) bool {
+	return a.Eon == b.Eon &&
+		sameSlice(a.Commitments, b.Commitments) &&
+		sameSlice(a.PolyEvals, b.PolyEvals) &&
+		sameSlice(a.Accusations, b.Accusations) &&
+		sameSlice(a.Apologies, b.Apologies) &&
+		sameSlice(a.EpochSecretKeyShares, b.EpochSecretKeyShares)
+}
+
+// newCheckpointDelta creates the incremental checkpoint of cp based on the full checkpoint base.
+// Objects taken from the same sync loop share the batches and eons that haven't changed, so we
+// only compare pointers.
+func newCheckpointDelta(cp *Checkpoint, base *Checkpoint) *checkpointFile {
+	delta := &checkpointFile{
+		Checkpoint: *cp,
+		BaseHeight: base.Shutter.CurrentBlock,
+	}
+
+	shutter := cp.Shutter.ShallowClone()
+	shutter.Batches = make(map[uint64]*BatchData)
+	for idx, batch := range cp.Shutter.Batches {
+		if base.Shutter.Batches[idx] != batch {
+			shutter.Batches[idx] = batch
+		}
+	}
+	for idx := range base.Shutter.Batches {
+		if _, ok := cp.Shutter.Batches[idx]; !ok {
+			delta.RemovedShutterBatches = append(delta.RemovedShutterBatches, idx)
+		}
+	}
+	shutter.Eons = nil
+	for i := range cp.Shutter.Eons {
+		baseIdx := base.Shutter.searchEon(cp.Shutter.Eons[i].Eon)
+		if baseIdx == len(base.Shutter.Eons) || !sameEon(&base.Shutter.Eons[baseIdx], &cp.Shutter.Eons[i]) {
+			shutter.Eons = append(shutter.Eons, cp.Shutter.Eons[i])
+		}
+	}
+	delta.Shutter = shutter
+
+	if cp.MainChain != nil && base.MainChain != nil {
+		mainChain := *cp.MainChain
+		mainChain.Batches = make(map[uint64]*Batch)
+		for idx, batch := range cp.MainChain.Batches {
+			if base.MainChain.Batches[idx] != batch {
+				mainChain.Batches[idx] = batch
+			}
+		}
+		for idx := range base.MainChain.Batches {
+			if _, ok := cp.MainChain.Batches[idx]; !ok {
+				delta.RemovedMainChainBatches = append(delta.RemovedMainChainBatches, idx)
+			}
+		}
+		delta.MainChain = &mainChain
+	}
+	return delta
+}
+
+// apply returns the checkpoint the incremental checkpoint delta describes.
+func (delta *checkpointFile) apply(base *Checkpoint) (*Checkpoint, error) {
+	if base.Shutter.CurrentBlock != delta.BaseHeight {
+		return nil, pkgErrors.Errorf("checkpoint is based on height %d, not %d", delta.BaseHeight, base.Shutter.CurrentBlock)
+	}
+	cp := delta.Checkpoint
+
+	shutter := cp.Shutter.ShallowClone()
+	shutter.Batches = make(map[uint64]*BatchData, len(base.Shutter.Batches)+len(cp.Shutter.Batches))
+	for idx, batch := range base.Shutter.Batches {
+		shutter.Batches[idx] = batch
+	}
+	for idx, batch := range cp.Shutter.Batches {
+		shutter.Batches[idx] = batch
+	}
+	for _, idx := range delta.RemovedShutterBatches {
+		delete(shutter.Batches, idx)
+	}
+	shutter.Eons = append([]Eon(nil), base.Shutter.Eons...)
+	for _, eon := range cp.Shutter.Eons {
+		i := shutter.searchEon(eon.Eon)
+		if i < len(shutter.Eons) && shutter.Eons[i].Eon == eon.Eon {
+			shutter.Eons[i] = eon
+		} else {
+			shutter.Eons = append(shutter.Eons, eon) // eons are only ever added at the end
+		}
+	}
+	cp.Shutter = shutter
+
+	if cp.MainChain != nil {
+		if base.MainChain == nil {
+			return nil, pkgErrors.Errorf("checkpoint based on height %d has no main chain state", delta.BaseHeight)
+		}
+		mainChain := *cp.MainChain
+		mainChain.Batches = make(map[uint64]*Batch, len(base.MainChain.Batches)+len(cp.MainChain.Batches))
+		for idx, batch := range base.MainChain.Batches {
+			mainChain.Batches[idx] = batch
+		}
+		for idx, batch := range cp.MainChain.Batches {
+			mainChain.Batches[idx] = batch
+		}
+		for _, idx := range delta.RemovedMainChainBatches {
+			delete(mainChain.Batches, idx)
+		}
+		cp.MainChain = &mainChain
+	}
+	return &cp, nil
+}
+
+// CheckpointWriter writes checkpoints to a directory. Most of them are incremental, i.e. they
+// only contain what has changed since the last full checkpoint. The checkpoints must be taken from
+// the same sync loop for this to work efficiently.
+type CheckpointWriter struct {
+	dir       string
+	base      *Checkpoint // the last full checkpoint written
+	numDeltas int         // number of incremental checkpoints written since
+}
+
+// NewCheckpointWriter creates a checkpoint writer for the given directory.
+func NewCheckpointWriter(dir string) *CheckpointWriter {
+	return &CheckpointWriter{dir: dir}
+}
+
+// Save writes the checkpoint and removes all but the latest maxCheckpoints checkpoints and the
+// full checkpoints they're based on.
+func (w *CheckpointWriter) Save(cp *Checkpoint) error {
+	err := os.MkdirAll(w.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	full := w.base == nil || w.numDeltas >= maxDeltas || cp.Shutter.CurrentBlock <= w.base.Shutter.CurrentBlock ||
+		(cp.MainChain == nil) != (w.base.MainChain == nil)
+	var f *checkpointFile
+	if full {
+		f = &checkpointFile{Checkpoint: *cp}
+	} else {
+		f = newCheckpointDelta(cp, w.base)
+	}
+	err = writeCheckpointFile(checkpointPath(w.dir, cp.Shutter.CurrentBlock, f.BaseHeight), f)
+	if err != nil {
+		return err
+	}
+	if full {
+		w.base = cp
+		w.numDeltas = 0
+	} else {
+		w.numDeltas++
+	}
+
+	paths, err := checkpointPaths(w.dir)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for i := 0; i < maxCheckpoints && i < len(paths); i++ {
+		keep[paths[i]] = true
+		if baseHeight := checkpointBaseHeight(paths[i]); baseHeight != 0 {
+			keep[checkpointPath(w.dir, baseHeight, 0)] = true
+		}
+	}
+	for _, path := range paths {
+		if keep[path] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error: failed to remove old checkpoint: %s", err)
+		}
+	}
+	return nil
+}
+
+// SaveCheckpoint writes the checkpoint as a full checkpoint to the given directory and removes all
+// but the latest maxCheckpoints checkpoints.
+func SaveCheckpoint(dir string, cp *Checkpoint) error {
+	return NewCheckpointWriter(dir).Save(cp)
+}
+
+// writeCheckpointFile writes the checkpoint file, prefixed with the hash of its content, so that
+// it can be verified when it's loaded.
+func writeCheckpointFile(path string, f *checkpointFile) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(f)
+	if err != nil {
+		return err
+	}
+	stateHash := sha256.Sum256(buf.Bytes())
+
+	tmppath := path + ".tmp"
+	file, err := os.Create(tmppath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(stateHash[:]); err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmppath, path)
+}
+
+func readCheckpointFile(path string) (*checkpointFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < sha256.Size {
+		return nil, pkgErrors.Errorf("checkpoint %s is truncated", path)
+	}
+	stateHash := sha256.Sum256(data[sha256.Size:])
+	if !bytes.Equal(stateHash[:], data[:sha256.Size]) {
+		return nil, pkgErrors.Errorf("checkpoint %s does not match its hash", path)
+	}
+	f := checkpointFile{}
+	err = gob.NewDecoder(bytes.NewReader(data[sha256.Size:])).Decode(&f)
+	if err != nil {
+		return nil, pkgErrors.Wrapf(err, "failed to decode checkpoint %s", path)
+	}
+	if f.Shutter == nil {
+		return nil, pkgErrors.Errorf("checkpoint %s has no shutter state", path)
+	}
+	return &f, nil
+}
+
+// loadCheckpoint loads the checkpoint at the given path. If it's an incremental one, the full
+// checkpoint it's based on is loaded as well.
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	f, err := readCheckpointFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if f.BaseHeight == 0 {
+		return &f.Checkpoint, nil
+	}
+	base, err := readCheckpointFile(checkpointPath(filepath.Dir(path), f.BaseHeight, 0))
+	if err != nil {
+		return nil, pkgErrors.Wrapf(err, "failed to load the checkpoint %s is based on", path)
+	}
+	return f.apply(&base.Checkpoint)
+}
+
+// verifyShutter checks that the checkpoint's shutter state has been taken from the chain the
+// given shuttermint node serves.
+func (cp *Checkpoint) verifyShutter(ctx context.Context, shmcl client.Client) error {
+	height := cp.Shutter.CurrentBlock
+	commit, err := shmcl.Commit(ctx, &height)
+	if err != nil {
+		return pkgErrors.Wrapf(err, "failed to fetch shuttermint commit at height %d", height)
+	}
+	if !bytes.Equal(commit.Header.Hash(), cp.ShutterBlockHash) {
+		return pkgErrors.Errorf("shuttermint block hash at height %d does not match", height)
+	}
+	return nil
+}
+
+// LoadCheckpoint returns the latest checkpoint in the given directory whose shutter state has been
+// taken from the chain the given shuttermint node serves. Its MainChain field is set to nil if cc
+// is nil or if the main chain block it has been taken at has been reorged out. If there's no such
+// checkpoint, nil is returned.
+func LoadCheckpoint(ctx context.Context, dir string, shmcl client.Client, cc *contract.Caller) (*Checkpoint, error) {
+	paths, err := checkpointPaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		cp, err := loadCheckpoint(path)
+		if err == nil {
+			err = cp.verifyShutter(ctx, shmcl)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Ignoring checkpoint: %s", err)
+			continue
+		}
+
+		if cp.MainChain != nil && cc != nil {
+			canonical, err := cp.MainChain.isCanonical(ctx, cc)
+			if err != nil {
+				return nil, err
+			}
+			if !canonical {
+				log.Printf("Ignoring main chain state of checkpoint %s, it has been reorged out", path)
+				cp.MainChain = nil
+			}
+		} else {
+			cp.MainChain = nil
+		}
+		return cp, nil
+	}
+	if len(paths) > 0 {
+		log.Printf("None of the %d checkpoints in %s can be used", len(paths), dir)
+	}
+	return nil, nil
+}
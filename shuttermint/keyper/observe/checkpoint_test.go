@@ -0,0 +1,153 @@
+package observe
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+)
+
+func TestSaveCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	for height := int64(1); height <= maxCheckpoints+2; height++ {
+		sh := NewShutter()
+		sh.CurrentBlock = height
+		err := SaveCheckpoint(dir, &Checkpoint{Shutter: sh, ShutterBlockHash: []byte{byte(height)}})
+		assert.NilError(t, err)
+	}
+
+	paths, err := checkpointPaths(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(paths), maxCheckpoints)
+	assert.Equal(t, paths[0], checkpointPath(dir, maxCheckpoints+2, 0))
+
+	cp, err := loadCheckpoint(paths[0])
+	assert.NilError(t, err)
+	assert.Equal(t, cp.Shutter.CurrentBlock, int64(maxCheckpoints+2))
+	assert.DeepEqual(t, cp.ShutterBlockHash, []byte{maxCheckpoints + 2})
+	assert.Assert(t, cp.MainChain == nil)
+}
+
+// checkpointShutter returns a shutter object synced to the given height with a batch for each
+// height and an eon for every second one, like a sync loop would produce it from the given
+// previous one.
+func checkpointShutter(t *testing.T, prev *Shutter, height int64) *Shutter {
+	t.Helper()
+	sh := prev.Clone()
+	sh.CurrentBlock = height
+	assert.NilError(t, sh.applyDecryptionSignature(shutterevents.DecryptionSignature{BatchIndex: uint64(height)}))
+	if height%2 == 0 {
+		assert.NilError(t, sh.applyEonStarted(shutterevents.EonStarted{Height: height, Eon: uint64(height)}))
+	}
+	return sh
+}
+
+func TestIncrementalCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	writer := NewCheckpointWriter(dir)
+	sh := NewShutter()
+	var mainChain *MainChain
+	for height := int64(1); height <= maxDeltas+4; height++ {
+		sh = checkpointShutter(t, sh, height)
+		mainChain = NewMainChain(0)
+		mainChain.CurrentBlock = uint64(height)
+		mainChain.Batches[uint64(height)] = &Batch{BatchIndex: uint64(height)}
+		err := writer.Save(&Checkpoint{Shutter: sh, ShutterBlockHash: []byte{byte(height)}, MainChain: mainChain})
+		assert.NilError(t, err)
+	}
+
+	// the latest checkpoints are the second full one and incremental ones based on it, the first
+	// full one is gone
+	paths, err := checkpointPaths(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(paths), maxCheckpoints)
+	assert.Equal(t, paths[0], checkpointPath(dir, maxDeltas+4, maxDeltas+2))
+	assert.Equal(t, paths[len(paths)-1], checkpointPath(dir, maxDeltas+2, 0))
+
+	// only the changes since the full checkpoint are stored
+	f, err := readCheckpointFile(paths[0])
+	assert.NilError(t, err)
+	assert.Equal(t, len(f.Shutter.Batches), 2)
+	assert.Equal(t, len(f.Shutter.Eons), 1)
+
+	cp, err := loadCheckpoint(paths[0])
+	assert.NilError(t, err)
+	assert.Equal(t, cp.Shutter.CurrentBlock, int64(maxDeltas+4))
+	assert.Equal(t, len(cp.Shutter.Batches), maxDeltas+4)
+	assert.Equal(t, len(cp.Shutter.Eons), (maxDeltas+4)/2)
+	for i, eon := range cp.Shutter.Eons {
+		assert.Equal(t, eon.Eon, uint64(2*(i+1)))
+	}
+	assert.Equal(t, len(cp.MainChain.Batches), 1)
+	assert.Equal(t, cp.MainChain.CurrentBlock, uint64(maxDeltas+4))
+
+	// a full checkpoint is written after maxDeltas incremental ones
+	for height := int64(maxDeltas + 5); height <= 2*maxDeltas+2; height++ {
+		sh = checkpointShutter(t, sh, height)
+		assert.NilError(t, writer.Save(&Checkpoint{Shutter: sh, MainChain: mainChain}))
+	}
+	paths, err = checkpointPaths(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, paths[0], checkpointPath(dir, 2*maxDeltas+2, maxDeltas+2))
+	sh = checkpointShutter(t, sh, 2*maxDeltas+3)
+	assert.NilError(t, writer.Save(&Checkpoint{Shutter: sh, MainChain: mainChain}))
+	paths, err = checkpointPaths(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, paths[0], checkpointPath(dir, 2*maxDeltas+3, 0))
+}
+
+func TestCheckpointStateHash(t *testing.T) {
+	dir := t.TempDir()
+	sh := checkpointShutter(t, NewShutter(), 1)
+	assert.NilError(t, SaveCheckpoint(dir, &Checkpoint{Shutter: sh}))
+	path := checkpointPath(dir, 1, 0)
+
+	data, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	data[len(data)-1] ^= 1
+	assert.NilError(t, ioutil.WriteFile(path, data, 0o644))
+	_, err = loadCheckpoint(path)
+	assert.ErrorContains(t, err, "hash")
+
+	assert.NilError(t, ioutil.WriteFile(path, data[:10], 0o644))
+	_, err = loadCheckpoint(path)
+	assert.ErrorContains(t, err, "truncated")
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	chain := newTestChain(t, 5, nil)
+
+	cp, err := LoadCheckpoint(ctx, dir, chain, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, cp == nil)
+
+	writer := NewCheckpointWriter(dir)
+	sh := NewShutter()
+	for height := int64(1); height <= 4; height++ {
+		sh = checkpointShutter(t, sh, height)
+		hash := chain.headers[height].Hash()
+		if height == 4 {
+			hash = []byte("taken from another chain")
+		}
+		assert.NilError(t, writer.Save(&Checkpoint{Shutter: sh, ShutterBlockHash: hash}))
+	}
+
+	// the latest checkpoint doesn't match the chain and the one before has lost its base
+	assert.NilError(t, os.Remove(filepath.Join(dir, "checkpoint-000000000001.gob")))
+	cp, err = LoadCheckpoint(ctx, dir, chain, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, cp == nil)
+
+	assert.NilError(t, SaveCheckpoint(dir, &Checkpoint{Shutter: sh, ShutterBlockHash: chain.headers[4].Hash()}))
+	cp, err = LoadCheckpoint(ctx, dir, chain, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, cp.Shutter.CurrentBlock, int64(4))
+	assert.Equal(t, len(cp.Shutter.Batches), 4)
+}
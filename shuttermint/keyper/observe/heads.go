@@ -0,0 +1,133 @@
+package observe
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+)
+
+// maxPollBackoff is the factor by which the poll interval grows at most while polling fails.
+const maxPollBackoff = 16
+
+// watchHeads sends new main chain head blocks to the heads channel until the context is canceled.
+// If pollInterval is zero, it subscribes to new heads, which requires a websocket connection.
+// Otherwise, it polls the latest head block in the given interval.
+func watchHeads(
+	ctx context.Context,
+	caller *contract.Caller,
+	pollInterval time.Duration,
+	heads chan<- *types.Header,
+) error {
+	if pollInterval == 0 {
+		return subscribeHeads(ctx, caller, heads)
+	}
+	return pollHeads(ctx, caller, pollInterval, heads)
+}
+
+// subscribeHeads subscribes to new head blocks and forwards them to the heads channel. If the
+// subscription fails or no block is received for a long time, it resubscribes.
+func subscribeHeads(ctx context.Context, caller *contract.Caller, heads chan<- *types.Header) error {
+	headers := make(chan *types.Header)
+	sub, err := caller.Ethclient.SubscribeNewHead(ctx, headers)
+	if err != nil {
+		return err
+	}
+
+	reconnect := func() {
+		sub.Unsubscribe()
+		for {
+			log.Println("Attempting reconnection to main chain")
+			sub, err = caller.Ethclient.SubscribeNewHead(ctx, headers)
+			if err != nil {
+				select {
+				case <-time.After(mainChainReconnectInterval):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			} else {
+				log.Println("Main chain connection regained")
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			sub.Unsubscribe()
+			return ctx.Err()
+		case header := <-headers:
+			select {
+			case heads <- header:
+			case <-ctx.Done():
+				sub.Unsubscribe()
+				return ctx.Err()
+			}
+		case err := <-sub.Err():
+			log.Println("Main chain connection lost:", err)
+			reconnect()
+		case <-time.After(mainChainTimeout):
+			log.Println("No main chain blocks received in a long time")
+			reconnect()
+		}
+	}
+}
+
+// nextPollDelay returns the time to wait before polling again. While polling fails, the delay is
+// doubled up to maxPollBackoff times the poll interval, so that we don't hammer a node that is
+// down or rate limits us. After a successful poll, we go back to the poll interval.
+func nextPollDelay(delay time.Duration, pollInterval time.Duration, failed bool) time.Duration {
+	if !failed {
+		return pollInterval
+	}
+	delay *= 2
+	if delay > maxPollBackoff*pollInterval {
+		delay = maxPollBackoff * pollInterval
+	}
+	return delay
+}
+
+// pollHeads polls the latest head block and sends it to the heads channel whenever it changed.
+func pollHeads(
+	ctx context.Context,
+	caller *contract.Caller,
+	pollInterval time.Duration,
+	heads chan<- *types.Header,
+) error {
+	var lastHash common.Hash
+	lastChange := time.Now()
+	delay := pollInterval
+	for {
+		header, err := caller.Ethclient.HeaderByNumber(ctx, nil)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Failed to poll main chain head block: %s", err)
+		} else if header.Hash() != lastHash {
+			lastHash = header.Hash()
+			lastChange = time.Now()
+			select {
+			case heads <- header:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if time.Since(lastChange) > mainChainTimeout {
+			log.Println("No main chain blocks received in a long time")
+			lastChange = time.Now()
+		}
+		delay = nextPollDelay(delay, pollInterval, err != nil)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
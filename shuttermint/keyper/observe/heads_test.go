@@ -0,0 +1,74 @@
+package observe
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+)
+
+// fakeHeadClient returns the headers in the given order, one per call, and repeats the last one.
+type fakeHeadClient struct {
+	contract.EthClient
+	mux     sync.Mutex
+	results []*types.Header // nil means the call fails
+}
+
+func (c *fakeHeadClient) HeaderByNumber(_ context.Context, _ *big.Int) (*types.Header, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	header := c.results[0]
+	if len(c.results) > 1 {
+		c.results = c.results[1:]
+	}
+	if header == nil {
+		return nil, errors.New("too many requests")
+	}
+	return header, nil
+}
+
+func TestPollHeads(t *testing.T) {
+	h1 := &types.Header{Number: big.NewInt(1)}
+	h2 := &types.Header{Number: big.NewInt(2)}
+	client := &fakeHeadClient{results: []*types.Header{h1, nil, h1, h1, nil, h2}}
+	caller := &contract.Caller{Ethclient: client}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	heads := make(chan *types.Header)
+	done := make(chan error, 1)
+	go func() {
+		done <- pollHeads(ctx, caller, time.Millisecond, heads)
+	}()
+
+	assert.Equal(t, <-heads, h1)
+	assert.Equal(t, <-heads, h2)
+	select {
+	case h := <-heads:
+		t.Fatalf("unexpected head %v", h.Number)
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	assert.Equal(t, <-done, context.Canceled)
+}
+
+func TestNextPollDelay(t *testing.T) {
+	interval := time.Second
+	delay := interval
+	for i := 0; i < 3; i++ {
+		delay = nextPollDelay(delay, interval, true)
+	}
+	assert.Equal(t, delay, 8*time.Second)
+	for i := 0; i < 10; i++ {
+		delay = nextPollDelay(delay, interval, true)
+	}
+	assert.Equal(t, delay, maxPollBackoff*interval)
+	assert.Equal(t, nextPollDelay(delay, interval, false), interval)
+}
@@ -2,6 +2,7 @@ package observe
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"math/big"
 	"time"
@@ -14,7 +15,6 @@ import (
 
 	"github.com/shutter-network/shutter/shlib/shcrypto"
 	"github.com/shutter-network/shutter/shuttermint/contract"
-	"github.com/shutter-network/shutter/shuttermint/medley"
 )
 
 const (
@@ -22,6 +22,13 @@ const (
 	// node is lost if no new block is received.
 	mainChainTimeout           = 30 * time.Second
 	mainChainReconnectInterval = 5 * time.Second // time between two reconnection attempts
+
+	// maxReorgDepth is the number of synced main chain states we keep in order to roll back to
+	// them in case of a reorg. Deeper reorgs require a full resync.
+	maxReorgDepth = 64
+
+	// maxChangeAge is the number of main chain blocks we keep the changes for.
+	maxChangeAge = 1024
 )
 
 // MainChain let's a keyper fetch all necessary information from an ethereum node to do it's
@@ -30,6 +37,8 @@ const (
 type MainChain struct {
 	FollowDistance          uint64
 	CurrentBlock            uint64
+	CurrentBlockHash        common.Hash // zero if unknown
+	NumReorgs               uint64      // number of reorgs we've rolled back
 	NodeSyncProgress        *ethereum.SyncProgress
 	BatchConfigs            []contract.BatchConfig
 	Batches                 map[uint64]*Batch
@@ -37,6 +46,10 @@ type MainChain struct {
 	CipherExecutionReceipts map[uint64]*contract.CipherExecutionReceipt
 	Deposits                map[common.Address]*Deposit
 	Accusations             map[uint64]*Accusation
+	AppealBlocks            uint64                    // number of blocks accused executors have to appeal
+	FeeBankBalances         map[common.Address]uint64 // fee bank balance of the account given to SyncToHead
+	Changes                 []Change                  // sorted by block number
+	ChangesStart            int64                     // changes before this block number have been pruned
 }
 
 // Batch stores the encrypted and plain transactions submitted to the batching contract for a
@@ -63,10 +76,22 @@ type Accusation struct {
 	Executor    common.Address
 	Accuser     common.Address
 	Appealed    bool
+	Slashed     bool
 	HalfStep    uint64
 	BlockNumber uint64
 }
 
+// ReorgError is returned by SyncToHead if the block we've synced to last is not part of the
+// canonical chain anymore.
+type ReorgError struct {
+	BlockNumber uint64
+	BlockHash   common.Hash
+}
+
+func (e *ReorgError) Error() string {
+	return fmt.Sprintf("main chain reorg detected: block %d with hash %s is not canonical anymore", e.BlockNumber, e.BlockHash.Hex())
+}
+
 // NewMainChain creates an empty MainChain struct.
 func NewMainChain(followDistance uint64) *MainChain {
 	return &MainChain{
@@ -76,13 +101,34 @@ func NewMainChain(followDistance uint64) *MainChain {
 		CipherExecutionReceipts: make(map[uint64]*contract.CipherExecutionReceipt),
 		Deposits:                make(map[common.Address]*Deposit),
 		Accusations:             make(map[uint64]*Accusation),
+		FeeBankBalances:         make(map[common.Address]uint64),
 	}
 }
 
+// Clone returns a copy of the main chain object that can be synced without affecting the
+// original. Batches, deposits and accusations are shared with the original and copied when they
+// change. Fee bank balances are replaced as a whole when syncing.
 func (mainchain *MainChain) Clone() *MainChain {
-	clone := new(MainChain)
-	medley.CloneWithGob(mainchain, clone)
-	return clone
+	clone := *mainchain
+	clone.Changes = mainchain.Changes[:len(mainchain.Changes):len(mainchain.Changes)]
+	clone.BatchConfigs = append([]contract.BatchConfig(nil), mainchain.BatchConfigs...)
+	clone.Batches = make(map[uint64]*Batch, len(mainchain.Batches))
+	for k, v := range mainchain.Batches {
+		clone.Batches[k] = v
+	}
+	clone.CipherExecutionReceipts = make(map[uint64]*contract.CipherExecutionReceipt, len(mainchain.CipherExecutionReceipts))
+	for k, v := range mainchain.CipherExecutionReceipts {
+		clone.CipherExecutionReceipts[k] = v
+	}
+	clone.Deposits = make(map[common.Address]*Deposit, len(mainchain.Deposits))
+	for k, v := range mainchain.Deposits {
+		clone.Deposits[k] = v
+	}
+	clone.Accusations = make(map[uint64]*Accusation, len(mainchain.Accusations))
+	for k, v := range mainchain.Accusations {
+		clone.Accusations[k] = v
+	}
+	return &clone
 }
 
 // IsActiveKeyper checks if the given address is registered as a keyper in one of the active batch
@@ -159,6 +205,7 @@ func (mainchain *MainChain) syncConfigs(cc *contract.Caller, opts *bind.CallOpts
 			return errors.Wrap(err, "failed to get config by index from contract")
 		}
 		mainchain.BatchConfigs = append(mainchain.BatchConfigs, config)
+		mainchain.addChange(opts.BlockNumber.Uint64(), MainChainConfigChanged, configIndex)
 	}
 	return nil
 }
@@ -179,6 +226,7 @@ func (mainchain *MainChain) syncBatches(cc *contract.Caller, filter *bind.Filter
 
 	for _, event := range events {
 		mainchain.addTransaction(event)
+		mainchain.addChange(*filter.End, BatchChanged, event.BatchIndex)
 	}
 
 	return nil
@@ -186,12 +234,15 @@ func (mainchain *MainChain) syncBatches(cc *contract.Caller, filter *bind.Filter
 
 // AddTransaction adds a transaction to a batch according to a main chain TransactionAdded event.
 func (mainchain *MainChain) addTransaction(event *contract.BatcherContractTransactionAdded) {
-	batch, ok := mainchain.Batches[event.BatchIndex]
-	if !ok {
-		batch = &Batch{BatchIndex: event.BatchIndex}
-		// for the rest of the fields, the zero values are fine
-		mainchain.Batches[event.BatchIndex] = batch
+	batch := &Batch{BatchIndex: event.BatchIndex}
+	// for the rest of the fields, the zero values are fine
+	if existing, ok := mainchain.Batches[event.BatchIndex]; ok {
+		// the batch may be shared with other main chain objects, so we copy it
+		*batch = *existing
+		batch.EncryptedTransactions = existing.EncryptedTransactions[:len(existing.EncryptedTransactions):len(existing.EncryptedTransactions)]
+		batch.PlainTransactions = existing.PlainTransactions[:len(existing.PlainTransactions):len(existing.PlainTransactions)]
 	}
+	mainchain.Batches[event.BatchIndex] = batch
 
 	switch event.TransactionType {
 	case contract.TransactionTypeCipher:
@@ -229,6 +280,35 @@ func (mainchain *MainChain) syncExecutionState(cc *contract.Caller, opts *bind.C
 	return nil
 }
 
+// syncAppealBlocks fetches the appeal period from the keyper slasher. It cannot change, so we
+// only fetch it once.
+func (mainchain *MainChain) syncAppealBlocks(cc *contract.Caller, opts *bind.CallOpts) error {
+	if mainchain.AppealBlocks != 0 {
+		return nil
+	}
+	appealBlocks, err := cc.KeyperSlasher.AppealBlocks(opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to get appeal blocks from keyper slasher")
+	}
+	mainchain.AppealBlocks = appealBlocks.Uint64()
+	return nil
+}
+
+// syncFeeBankBalance fetches the fee bank balance of the given account, unless it's the zero
+// address. The balance is only needed to withdraw fees, so failures are logged and the previous
+// balance is kept instead of failing the whole sync.
+func (mainchain *MainChain) syncFeeBankBalance(cc *contract.Caller, opts *bind.CallOpts, account common.Address) {
+	if account == (common.Address{}) {
+		return
+	}
+	balance, err := cc.FeeBankContract.Deposits(opts, account)
+	if err != nil {
+		log.Printf("Error: failed to get fee bank balance of %s: %s", account.Hex(), err)
+		return
+	}
+	mainchain.FeeBankBalances = map[common.Address]uint64{account: balance}
+}
+
 func (mainchain *MainChain) syncDeposits(cc *contract.Caller, filter *bind.FilterOpts) error {
 	eventIt, err := cc.DepositContract.FilterDepositChanged(filter, []common.Address{})
 	if err != nil {
@@ -244,12 +324,12 @@ func (mainchain *MainChain) syncDeposits(cc *contract.Caller, filter *bind.Filte
 	}
 
 	for _, ev := range events {
-		deposit := mainchain.GetDeposit(ev.Account)
+		deposit := *mainchain.GetDeposit(ev.Account) // copy, the deposit may be shared
 		deposit.Amount = ev.Amount
 		deposit.WithdrawalDelayBlocks = ev.WithdrawalDelayBlocks
 		deposit.WithdrawalRequestedBlock = ev.WithdrawalRequestedBlock
 		deposit.Slashed = ev.Slashed
-		mainchain.Deposits[ev.Account] = deposit
+		mainchain.Deposits[ev.Account] = &deposit
 	}
 
 	return nil
@@ -264,8 +344,10 @@ func (mainchain *MainChain) syncSlashings(cc *contract.Caller, filter *bind.Filt
 	if err != nil {
 		return errors.Wrap(err, "failed to filter appealed events")
 	}
-	// We don't filter for slashed events. Each slashing also results in a DepositChanged event in
-	// the deposit contract which we already sync.
+	slashedIt, err := cc.KeyperSlasher.FilterSlashed(filter, []uint64{}, []common.Address{})
+	if err != nil {
+		return errors.Wrap(err, "failed to filter slashed events")
+	}
 
 	accusedEvents := []*contract.KeyperSlasherAccused{}
 	for accusedIt.Next() {
@@ -283,6 +365,14 @@ func (mainchain *MainChain) syncSlashings(cc *contract.Caller, filter *bind.Filt
 		return errors.Wrap(appealedIt.Error(), "failed to iterate appealed events")
 	}
 
+	slashedEvents := []*contract.KeyperSlasherSlashed{}
+	for slashedIt.Next() {
+		slashedEvents = append(slashedEvents, slashedIt.Event)
+	}
+	if slashedIt.Error() != nil {
+		return errors.Wrap(slashedIt.Error(), "failed to iterate slashed events")
+	}
+
 	for _, ev := range accusedEvents {
 		accusation := Accusation{
 			Executor:    ev.Executor,
@@ -292,18 +382,52 @@ func (mainchain *MainChain) syncSlashings(cc *contract.Caller, filter *bind.Filt
 			BlockNumber: ev.Raw.BlockNumber,
 		}
 		mainchain.Accusations[accusation.HalfStep] = &accusation
+		mainchain.addChange(*filter.End, AccusationChanged, accusation.HalfStep)
 	}
 	for _, ev := range appealedEvents {
 		accusation, ok := mainchain.Accusations[ev.HalfStep]
 		if !ok {
-			return errors.Errorf("got appeal without prior accusation: %+v", accusation)
+			return errors.Errorf("got appeal without prior accusation: %+v", ev)
+		}
+		appealed := *accusation // copy, the accusation may be shared
+		appealed.Appealed = true
+		mainchain.Accusations[ev.HalfStep] = &appealed
+		mainchain.addChange(*filter.End, AccusationChanged, ev.HalfStep)
+	}
+	for _, ev := range slashedEvents {
+		accusation, ok := mainchain.Accusations[ev.HalfStep]
+		if !ok {
+			return errors.Errorf("got slashing without prior accusation: %+v", ev)
 		}
-		accusation.Appealed = true
+		slashed := *accusation // copy, the accusation may be shared
+		slashed.Slashed = true
+		mainchain.Accusations[ev.HalfStep] = &slashed
+		mainchain.addChange(*filter.End, AccusationChanged, ev.HalfStep)
 	}
 
 	return nil
 }
 
+func (mainchain *MainChain) addChange(blockNumber uint64, kind ChangeKind, index uint64) {
+	mainchain.Changes = append(mainchain.Changes, Change{Height: int64(blockNumber), Kind: kind, Index: index})
+}
+
+// pruneChanges removes the changes older than maxChangeAge blocks.
+func (mainchain *MainChain) pruneChanges() {
+	start := int64(mainchain.CurrentBlock) - maxChangeAge
+	if start <= mainchain.ChangesStart {
+		return
+	}
+	mainchain.Changes = pruneChanges(mainchain.Changes, start)
+	mainchain.ChangesStart = start
+}
+
+// ChangesSince returns the changes at or after the given block number. If they are unknown
+// because they've been pruned, the change set is marked as full.
+func (mainchain *MainChain) ChangesSince(blockNumber uint64) ChangeSet {
+	return changesSince(mainchain.Changes, mainchain.ChangesStart, int64(blockNumber))
+}
+
 // GetDeposit returns the deposit of the given account or an empty one if it doesn't exist.
 func (mainchain *MainChain) GetDeposit(account common.Address) *Deposit {
 	deposit, ok := mainchain.Deposits[account]
@@ -316,12 +440,34 @@ func (mainchain *MainChain) GetDeposit(account common.Address) *Deposit {
 	return deposit
 }
 
+// isCanonical checks if the block we've synced to last is still part of the canonical chain.
+func (mainchain *MainChain) isCanonical(ctx context.Context, cc *contract.Caller) (bool, error) {
+	if mainchain.CurrentBlockHash == (common.Hash{}) {
+		return true, nil // either nothing synced yet or state from before we stored hashes
+	}
+	header, err := cc.Ethclient.HeaderByNumber(ctx, new(big.Int).SetUint64(mainchain.CurrentBlock))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get header of main chain block %d", mainchain.CurrentBlock)
+	}
+	return header.Hash() == mainchain.CurrentBlockHash, nil
+}
+
 // SyncToHead fetches the latest state from the ethereum node. It returns a new object with the
-// latest state.
+// latest state. If the state we've synced so far has been reorged out, a ReorgError is returned.
+// The fee bank balance is only fetched for feeAccount and only if it's not the zero address.
 func (mainchain *MainChain) SyncToHead(
 	ctx context.Context,
 	cc *contract.Caller,
+	feeAccount common.Address,
 ) (*MainChain, error) {
+	canonical, err := mainchain.isCanonical(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+	if !canonical {
+		return nil, &ReorgError{BlockNumber: mainchain.CurrentBlock, BlockHash: mainchain.CurrentBlockHash}
+	}
+
 	syncProgress, err := cc.Ethclient.SyncProgress(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get main chain sync progress")
@@ -344,6 +490,11 @@ func (mainchain *MainChain) SyncToHead(
 		syncUntilBlockNumber = 0
 	}
 
+	syncUntilHeader, err := cc.Ethclient.HeaderByNumber(ctx, new(big.Int).SetUint64(syncUntilBlockNumber))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get header of main chain block %d", syncUntilBlockNumber)
+	}
+
 	mainchain = mainchain.Clone()
 
 	opts := &bind.CallOpts{
@@ -380,8 +531,26 @@ func (mainchain *MainChain) SyncToHead(
 		return nil, err
 	}
 
+	err = mainchain.syncAppealBlocks(cc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	mainchain.syncFeeBankBalance(cc, opts, feeAccount)
+
+	// Make sure the chain didn't change while we were fetching the data
 	mainchain.CurrentBlock = syncUntilBlockNumber
+	mainchain.CurrentBlockHash = syncUntilHeader.Hash()
+	canonical, err = mainchain.isCanonical(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+	if !canonical {
+		return nil, errors.Errorf("main chain reorg at block %d while syncing", syncUntilBlockNumber)
+	}
+
 	mainchain.NodeSyncProgress = syncProgress
+	mainchain.pruneChanges()
 	return mainchain, nil
 }
 
@@ -412,65 +581,108 @@ func (batch *Batch) DecryptTransactions(key *shcrypto.EpochSecretKey) [][]byte {
 	return shcrypto.Shuffle(res, key)
 }
 
-// SyncMain subscribes to new blocks on the main chain and syncs the main chain object with the
-// head block in a loop. It writes newly synced main chain objects to the mainChains channel, as
-// well as errors to the syncErrors channel.
+// rollback returns the latest of the given snapshots that is still part of the canonical chain
+// together with the snapshots up to and including it. If none is, it returns an empty MainChain
+// and no snapshots, so that we resync from scratch. The returned MainChain counts the reorg.
+func rollback(
+	ctx context.Context,
+	cc *contract.Caller,
+	current *MainChain,
+	snapshots []*MainChain,
+) (*MainChain, []*MainChain, error) {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		canonical, err := snapshots[i].isCanonical(ctx, cc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if canonical {
+			rolledBack := snapshots[i].Clone()
+			rolledBack.NumReorgs = current.NumReorgs + 1
+			log.Printf("Rolled back main chain state from block %d to block %d", current.CurrentBlock, rolledBack.CurrentBlock)
+			return rolledBack, snapshots[:i+1], nil
+		}
+	}
+
+	log.Printf("Main chain reorg is deeper than the states we keep, resyncing from scratch")
+	rolledBack := NewMainChain(current.FollowDistance)
+	rolledBack.NumReorgs = current.NumReorgs + 1
+	return rolledBack, nil, nil
+}
+
+// SyncMain watches the main chain for new blocks and syncs the main chain object with the head
+// block in a loop. New blocks are detected by subscribing to them or, if pollInterval is not zero,
+// by polling the head block in that interval. It writes newly synced main chain objects to the
+// mainChains channel. See SyncToHead for feeAccount.
 func SyncMain(
 	ctx context.Context,
 	caller *contract.Caller,
 	mainChain *MainChain,
 	mainChains chan<- *MainChain,
+	pollInterval time.Duration,
+	feeAccount common.Address,
 ) error {
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	defer cancelWatch()
 	headers := make(chan *types.Header)
-	sub, err := caller.Ethclient.SubscribeNewHead(ctx, headers)
-	if err != nil {
-		return err
-	}
-
-	reconnect := func() {
-		sub.Unsubscribe()
-		for {
-			log.Println("Attempting reconnection to main chain")
-			sub, err = caller.Ethclient.SubscribeNewHead(ctx, headers)
-			if err != nil {
-				select {
-				case <-time.After(mainChainReconnectInterval):
-					continue
-				case <-ctx.Done():
-					return
-				}
-			} else {
-				log.Println("Main chain connection regained")
-				return
-			}
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watchHeads(watchCtx, caller, pollInterval, headers)
+	}()
+
+	// snapshots holds recent main chain states we can roll back to in case of a reorg
+	snapshots := []*MainChain{mainChain}
+	publish := func(newMainChain *MainChain) error {
+		select {
+		case mainChains <- newMainChain:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+		mainChain = newMainChain
+		return nil
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			sub.Unsubscribe()
 			return ctx.Err()
+		case err := <-watchErr:
+			return err
 		case <-headers:
-			newMainChain, err := mainChain.SyncToHead(ctx, caller)
+			newMainChain, err := mainChain.SyncToHead(ctx, caller, feeAccount)
+			var reorgErr *ReorgError
+			if errors.As(err, &reorgErr) {
+				log.Printf("%s", err)
+				var rolledBack *MainChain
+				rolledBack, snapshots, err = rollback(ctx, caller, mainChain, snapshots)
+				if err == nil && len(snapshots) > 0 {
+					// Publish the rolled back state right away, so that the decider learns
+					// about the reorg
+					if err := publish(rolledBack); err != nil {
+						return err
+					}
+					newMainChain, err = mainChain.SyncToHead(ctx, caller, feeAccount)
+				} else if err == nil {
+					// Keep the last good state published until the resync from scratch has
+					// completed, the decider must never see an empty main chain. If the
+					// resync fails, the next head detects the reorg again and we start over.
+					newMainChain, err = rolledBack.SyncToHead(ctx, caller, feeAccount)
+				}
+			}
 			if err != nil {
 				if err != context.Canceled {
 					log.Printf("Error in MainChain.SyncToHead: %+v", err)
 				}
 			} else {
-				select {
-				case mainChains <- newMainChain:
-				case <-ctx.Done():
-					return ctx.Err()
+				if err := publish(newMainChain); err != nil {
+					return err
+				}
+				if len(snapshots) == 0 || snapshots[len(snapshots)-1] != newMainChain {
+					snapshots = append(snapshots, newMainChain)
+				}
+				for len(snapshots) > 0 && snapshots[0].CurrentBlock+maxReorgDepth < newMainChain.CurrentBlock {
+					snapshots = snapshots[1:]
 				}
-				mainChain = newMainChain
 			}
-		case err := <-sub.Err():
-			log.Println("Main chain connection lost:", err)
-			reconnect()
-		case <-time.After(mainChainTimeout):
-			log.Println("No main chain blocks received in a long time")
-			reconnect()
 		}
 	}
 }
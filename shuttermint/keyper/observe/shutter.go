@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"reflect"
 	"sort"
 	"time"
@@ -19,14 +20,18 @@ import (
 	"github.com/tendermint/tendermint/crypto/ed25519"
 	"github.com/tendermint/tendermint/rpc/client"
 	rpctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
 
 	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
-	"github.com/shutter-network/shutter/shuttermint/medley"
 )
 
 const (
 	shuttermintTimeout       = 10 * time.Second
 	shutterReconnectInterval = 5 * time.Second
+
+	// shutterMaxReconnectInterval is the longest we wait before trying a failed shuttermint node
+	// again.
+	shutterMaxReconnectInterval = 2 * time.Minute
 )
 
 var errEonNotFound = errors.New("eon not found")
@@ -75,12 +80,19 @@ func (filter ShutterFilter) NeedsUpdate(newFilter ShutterFilter) bool {
 type Shutter struct {
 	CurrentBlock         int64
 	LastCommittedHeight  int64
+	VerifiedBlock        int64           // last block verified against the signed headers, 0 if none
+	TrustedHeader        *tmtypes.Header // last header verified against the signatures, nil if none
+	TrustedValidators    []Validator     // validators of the block after TrustedHeader
 	NodeStatus           *rpctypes.ResultStatus
 	KeyperEncryptionKeys map[common.Address]*EncryptionPublicKey
+	KeyperValidatorKeys  map[common.Address][]byte
+	KeyRotations         map[common.Address][]KeyRotation // sorted by activation height
 	BatchConfigs         []shutterevents.BatchConfig
 	Batches              map[uint64]*BatchData
 	Eons                 []Eon
 	Filter               ShutterFilter
+	Changes              []Change // sorted by height
+	ChangesStart         int64    // changes before this height have been pruned
 }
 
 // NewShutter creates an empty Shutter struct.
@@ -88,10 +100,20 @@ func NewShutter() *Shutter {
 	return &Shutter{
 		CurrentBlock:         -1,
 		KeyperEncryptionKeys: make(map[common.Address]*EncryptionPublicKey),
+		KeyperValidatorKeys:  make(map[common.Address][]byte),
+		KeyRotations:         make(map[common.Address][]KeyRotation),
 		Batches:              make(map[uint64]*BatchData),
 	}
 }
 
+// KeyRotation is a key rotation of a keyper. Keys that are not rotated are nil.
+type KeyRotation struct {
+	Height              int64
+	ActivationHeight    int64
+	EncryptionPublicKey *EncryptionPublicKey
+	ValidatorPublicKey  []byte
+}
+
 type Eon struct {
 	Eon                  uint64
 	StartHeight          int64
@@ -192,6 +214,15 @@ func (shutter *Shutter) filterSyncHeight() {
 	shutter.Eons = newEons
 }
 
+// filterChanges removes the changes at heights below the Filter's SyncHeight.
+func (shutter *Shutter) filterChanges() {
+	if shutter.Filter.SyncHeight <= shutter.ChangesStart {
+		return
+	}
+	shutter.Changes = pruneChanges(shutter.Changes, shutter.Filter.SyncHeight)
+	shutter.ChangesStart = shutter.Filter.SyncHeight
+}
+
 func (shutter *Shutter) filterBatchIndex() {
 	batchIndex := shutter.Filter.BatchIndex
 	newBatches := make(map[uint64]*BatchData)
@@ -208,11 +239,12 @@ func (shutter *Shutter) ApplyFilter(newFilter ShutterFilter) *Shutter {
 	if !shutter.Filter.NeedsUpdate(newFilter) {
 		return shutter
 	}
-	clone := *shutter
+	clone := shutter.ShallowClone()
 	clone.Filter = newFilter
 	clone.filterSyncHeight()
 	clone.filterBatchIndex()
-	return &clone
+	clone.filterChanges()
+	return clone
 }
 
 func (shutter *Shutter) applyTxEvents(height int64, events []abcitypes.Event) {
@@ -226,12 +258,26 @@ func (shutter *Shutter) applyTxEvents(height int64, events []abcitypes.Event) {
 	}
 }
 
+// addChange records that the object of the given kind and index has changed at the given height.
+func (shutter *Shutter) addChange(height int64, kind ChangeKind, index uint64) {
+	shutter.Changes = append(shutter.Changes, Change{Height: height, Kind: kind, Index: index})
+}
+
+// ChangesSince returns the changes at or after the given height. If they are unknown because
+// they've been pruned, the change set is marked as full.
+func (shutter *Shutter) ChangesSince(height int64) ChangeSet {
+	return changesSince(shutter.Changes, shutter.ChangesStart, height)
+}
+
+// getBatchData returns a copy of the batch data for the given batch index that can be modified.
+// The batch data may be shared with other shutter objects, so we never modify it in place.
 func (shutter *Shutter) getBatchData(batchIndex uint64) *BatchData {
-	b, ok := shutter.Batches[batchIndex]
-	if !ok {
-		b = &BatchData{BatchIndex: batchIndex}
-		shutter.Batches[batchIndex] = b
+	b := &BatchData{BatchIndex: batchIndex}
+	if existing, ok := shutter.Batches[batchIndex]; ok {
+		sigs := existing.DecryptionSignatures
+		b.DecryptionSignatures = sigs[:len(sigs):len(sigs)] // force append to copy
 	}
+	shutter.Batches[batchIndex] = b
 	return b
 }
 
@@ -261,19 +307,73 @@ func (shutter *Shutter) FindEon(eon uint64) (*Eon, error) {
 	return &shutter.Eons[idx], nil
 }
 
+// clip limits the capacity of the eon's event slices to their length. Appending to them copies
+// them, so that the events can be shared with other eon objects.
+func (eon *Eon) clip() {
+	eon.Commitments = eon.Commitments[:len(eon.Commitments):len(eon.Commitments)]
+	eon.PolyEvals = eon.PolyEvals[:len(eon.PolyEvals):len(eon.PolyEvals)]
+	eon.Accusations = eon.Accusations[:len(eon.Accusations):len(eon.Accusations)]
+	eon.Apologies = eon.Apologies[:len(eon.Apologies):len(eon.Apologies)]
+	eon.EpochSecretKeyShares = eon.EpochSecretKeyShares[:len(eon.EpochSecretKeyShares):len(eon.EpochSecretKeyShares)]
+}
+
 func (shutter *Shutter) applyCheckIn(e shutterevents.CheckIn) error { //nolint:unparam
 	shutter.KeyperEncryptionKeys[e.Sender] = (*EncryptionPublicKey)(e.EncryptionPublicKey)
+	if e.ValidatorPublicKey != nil {
+		if shutter.KeyperValidatorKeys == nil {
+			shutter.KeyperValidatorKeys = make(map[common.Address][]byte)
+		}
+		shutter.KeyperValidatorKeys[e.Sender] = e.ValidatorPublicKey
+	}
+	shutter.addChange(e.Height, CheckInChanged, 0)
+	return nil
+}
+
+// applyKeyRotation schedules the keys of a key rotation. Like the app, a rotation replaces the
+// keys of the same kind of a rotation that hasn't been activated yet.
+func (shutter *Shutter) applyKeyRotation(e shutterevents.KeyRotation) error { //nolint:unparam
+	var rotations []KeyRotation
+	for _, r := range shutter.KeyRotations[e.Sender] {
+		if r.ActivationHeight > e.Height {
+			if e.EncryptionPublicKey != nil {
+				r.EncryptionPublicKey = nil
+			}
+			if e.ValidatorPublicKey != nil {
+				r.ValidatorPublicKey = nil
+			}
+			if r.EncryptionPublicKey == nil && r.ValidatorPublicKey == nil {
+				continue
+			}
+		}
+		rotations = append(rotations, r)
+	}
+	rotations = append(rotations, KeyRotation{
+		Height:              e.Height,
+		ActivationHeight:    e.ActivationHeight,
+		EncryptionPublicKey: (*EncryptionPublicKey)(e.EncryptionPublicKey),
+		ValidatorPublicKey:  e.ValidatorPublicKey,
+	})
+	sort.SliceStable(rotations, func(i, j int) bool {
+		return rotations[i].ActivationHeight < rotations[j].ActivationHeight
+	})
+	if shutter.KeyRotations == nil {
+		shutter.KeyRotations = make(map[common.Address][]KeyRotation)
+	}
+	shutter.KeyRotations[e.Sender] = rotations
+	shutter.addChange(e.Height, CheckInChanged, 0)
 	return nil
 }
 
 func (shutter *Shutter) applyBatchConfig(e shutterevents.BatchConfig) error { //nolint:unparam
 	shutter.BatchConfigs = append(shutter.BatchConfigs, e)
+	shutter.addChange(e.Height, ShutterConfigChanged, e.ConfigIndex)
 	return nil
 }
 
 func (shutter *Shutter) applyDecryptionSignature(e shutterevents.DecryptionSignature) error { //nolint:unparam
 	b := shutter.getBatchData(e.BatchIndex)
 	b.DecryptionSignatures = append(b.DecryptionSignatures, e)
+	shutter.addChange(e.Height, SignaturesChanged, e.BatchIndex)
 	return nil
 }
 
@@ -283,6 +383,7 @@ func (shutter *Shutter) applyEonStarted(e shutterevents.EonStarted) error {
 		return pkgErrors.Errorf("eons should increase")
 	}
 	shutter.Eons = append(shutter.Eons, Eon{Eon: e.Eon, StartEvent: e, StartHeight: e.Height})
+	shutter.addChange(e.Height, EonChanged, e.Eon)
 	return nil
 }
 
@@ -292,6 +393,7 @@ func (shutter *Shutter) applyPolyCommitment(e shutterevents.PolyCommitment) erro
 		return err
 	}
 	eon.Commitments = append(eon.Commitments, e)
+	shutter.addChange(e.Height, EonChanged, e.Eon)
 	return nil
 }
 
@@ -301,6 +403,7 @@ func (shutter *Shutter) applyPolyEval(e shutterevents.PolyEval) error {
 		return err
 	}
 	eon.PolyEvals = append(eon.PolyEvals, e)
+	shutter.addChange(e.Height, EonChanged, e.Eon)
 	return nil
 }
 
@@ -310,6 +413,7 @@ func (shutter *Shutter) applyAccusation(e shutterevents.Accusation) error {
 		return err
 	}
 	eon.Accusations = append(eon.Accusations, e)
+	shutter.addChange(e.Height, EonChanged, e.Eon)
 	return nil
 }
 
@@ -319,6 +423,7 @@ func (shutter *Shutter) applyApology(e shutterevents.Apology) error {
 		return err
 	}
 	eon.Apologies = append(eon.Apologies, e)
+	shutter.addChange(e.Height, EonChanged, e.Eon)
 	return nil
 }
 
@@ -328,6 +433,7 @@ func (shutter *Shutter) applyEpochSecretKeyShare(e shutterevents.EpochSecretKeyS
 		return err
 	}
 	eon.EpochSecretKeyShares = append(eon.EpochSecretKeyShares, e)
+	shutter.addChange(e.Height, SharesChanged, e.Eon)
 	return nil
 }
 
@@ -336,6 +442,8 @@ func (shutter *Shutter) applyEvent(ev shutterevents.IEvent) {
 	switch e := ev.(type) {
 	case *shutterevents.CheckIn:
 		err = shutter.applyCheckIn(*e)
+	case *shutterevents.KeyRotation:
+		err = shutter.applyKeyRotation(*e)
 	case *shutterevents.BatchConfig:
 		err = shutter.applyBatchConfig(*e)
 	case *shutterevents.DecryptionSignature:
@@ -360,7 +468,15 @@ func (shutter *Shutter) applyEvent(ev shutterevents.IEvent) {
 	}
 }
 
-func (shutter *Shutter) fetchAndApplyEvents(ctx context.Context, shmcl client.Client, targetHeight int64) (*Shutter, error) {
+// fetchAndApplyEvents fetches the txs up to the given height and applies their events. If a
+// genesis document is given, the blocks are verified against the signed block headers before their
+// txs are applied.
+func (shutter *Shutter) fetchAndApplyEvents(
+	ctx context.Context,
+	shmcl client.Client,
+	targetHeight int64,
+	genesis *tmtypes.GenesisDoc,
+) (*Shutter, error) {
 	if targetHeight < shutter.CurrentBlock {
 		panic("internal error: fetchAndApplyEvents bad arguments")
 	}
@@ -382,11 +498,11 @@ func (shutter *Shutter) fetchAndApplyEvents(ctx context.Context, shmcl client.Cl
 		}
 
 		// tendermint silently caps the perPage value at 100, make sure to stay below, otherwise
-		// our exit condition is wrong and the log.Fatalf will trigger a panic below; see
+		// our exit condition is wrong and we'll fail with an error below; see
 		// https://github.com/shutter-network/shutter/issues/50
 		perPage := 100
 		page := 1
-		total := 0
+		var txs []abcitypes.TxResult
 		for {
 			res, err := shmcl.TxSearch(ctx, query, false, &page, &perPage, "")
 			if err != nil {
@@ -395,35 +511,65 @@ func (shutter *Shutter) fetchAndApplyEvents(ctx context.Context, shmcl client.Cl
 			// Create a shallow or deep clone
 			if !cloned {
 				if res.TotalCount == 0 && height == targetHeight {
-					return shutter.ShallowClone(), nil
+					shutter = shutter.ShallowClone()
+				} else {
+					shutter = shutter.Clone()
 				}
-				shutter = shutter.Clone()
 				cloned = true
 			}
 
-			total += len(res.Txs)
 			for _, tx := range res.Txs {
-				events := tx.TxResult.GetEvents()
-				shutter.applyTxEvents(tx.Height, events)
+				txs = append(txs, abcitypes.TxResult{
+					Height: tx.Height,
+					Index:  tx.Index,
+					Tx:     tx.Tx,
+					Result: tx.TxResult,
+				})
 			}
 			if page*perPage >= res.TotalCount {
-				if total != res.TotalCount {
-					log.Fatalf("internal error. got %d transactions, expected %d transactions from shuttermint for height %d..%d",
-						total,
+				if len(txs) != res.TotalCount {
+					return nil, pkgErrors.Errorf(
+						"got %d transactions, expected %d transactions from shuttermint for height %d..%d",
+						len(txs),
 						res.TotalCount,
-						shutter.CurrentBlock+1,
-						targetHeight)
+						currentBlock+1,
+						height,
+					)
 				}
 				break
 			}
 			page++
 		}
+
+		sort.Slice(txs, func(i, j int) bool {
+			if txs[i].Height != txs[j].Height {
+				return txs[i].Height < txs[j].Height
+			}
+			return txs[i].Index < txs[j].Index
+		})
+		for len(txs) > 0 {
+			n := 1
+			for n < len(txs) && txs[n].Height == txs[0].Height {
+				n++
+			}
+			if err := shutter.verifyAndApplyBlock(ctx, shmcl, genesis, txs[0].Height, txs[:n]); err != nil {
+				return nil, err
+			}
+			txs = txs[n:]
+		}
+
 		if height == targetHeight {
 			break
 		}
 		currentBlock = height
 	}
 
+	if genesis != nil {
+		// Make sure we haven't missed any txs after the last one
+		if err := shutter.verifyBlocks(ctx, shmcl, genesis, targetHeight, nil); err != nil {
+			return nil, err
+		}
+	}
 	return shutter, nil
 }
 
@@ -433,6 +579,60 @@ func (shutter *Shutter) IsCheckedIn(addr common.Address) bool {
 	return ok
 }
 
+// EncryptionKeyAt returns the encryption public key the given keyper uses at the given height,
+// taking key rotations into account.
+func (shutter *Shutter) EncryptionKeyAt(addr common.Address, height int64) (*EncryptionPublicKey, bool) {
+	key, ok := shutter.KeyperEncryptionKeys[addr]
+	if !ok {
+		return nil, false
+	}
+	for _, r := range shutter.KeyRotations[addr] {
+		if r.ActivationHeight > height {
+			break
+		}
+		if r.EncryptionPublicKey != nil {
+			key = r.EncryptionPublicKey
+		}
+	}
+	return key, true
+}
+
+// validatorUpdateDelay is the number of blocks after which tendermint applies the validator updates
+// returned at the end of a block.
+const validatorUpdateDelay = 2
+
+// ValidatorKeyAt returns the validator public key the given keyper uses at the given height,
+// taking key rotations into account. Shuttermint activates a rotated validator key at the end of
+// the block at its activation height and tendermint only applies the update validatorUpdateDelay
+// blocks later, so that's when the new key starts signing.
+func (shutter *Shutter) ValidatorKeyAt(addr common.Address, height int64) ([]byte, bool) {
+	key, ok := shutter.KeyperValidatorKeys[addr]
+	if !ok {
+		return nil, false
+	}
+	for _, r := range shutter.KeyRotations[addr] {
+		if r.ActivationHeight+validatorUpdateDelay > height {
+			break
+		}
+		if r.ValidatorPublicKey != nil {
+			key = r.ValidatorPublicKey
+		}
+	}
+	return key, true
+}
+
+// LatestEncryptionKey returns the encryption public key the given keyper will use once all of
+// their scheduled key rotations are active.
+func (shutter *Shutter) LatestEncryptionKey(addr common.Address) (*EncryptionPublicKey, bool) {
+	return shutter.EncryptionKeyAt(addr, math.MaxInt64)
+}
+
+// LatestValidatorKey returns the validator public key the given keyper will use once all of
+// their scheduled key rotations are active.
+func (shutter *Shutter) LatestValidatorKey(addr common.Address) ([]byte, bool) {
+	return shutter.ValidatorKeyAt(addr, math.MaxInt64)
+}
+
 // IsKeyper checks if the given address is a keyper in any of the given configs.
 func (shutter *Shutter) IsKeyper(addr common.Address) bool {
 	for _, cfg := range shutter.BatchConfigs {
@@ -461,14 +661,40 @@ func (shutter *Shutter) FindBatchConfigByBatchIndex(batchIndex uint64) shutterev
 	return shutterevents.BatchConfig{}
 }
 
+// ShallowClone returns a copy of the shutter object that shares all data with the original. Only
+// the top level fields of the copy may be modified.
 func (shutter *Shutter) ShallowClone() *Shutter {
 	s := *shutter
 	return &s
 }
 
+// Clone returns a copy of the shutter object that events can be applied to without affecting the
+// original. The events of eons and batches are shared with the original until they are modified,
+// so that cloning doesn't get more expensive the more events we've collected.
 func (shutter *Shutter) Clone() *Shutter {
-	clone := new(Shutter)
-	medley.CloneWithGob(shutter, clone)
+	clone := shutter.ShallowClone()
+	clone.KeyperEncryptionKeys = make(map[common.Address]*EncryptionPublicKey, len(shutter.KeyperEncryptionKeys))
+	for k, v := range shutter.KeyperEncryptionKeys {
+		clone.KeyperEncryptionKeys[k] = v
+	}
+	clone.KeyperValidatorKeys = make(map[common.Address][]byte, len(shutter.KeyperValidatorKeys))
+	for k, v := range shutter.KeyperValidatorKeys {
+		clone.KeyperValidatorKeys[k] = v
+	}
+	clone.KeyRotations = make(map[common.Address][]KeyRotation, len(shutter.KeyRotations))
+	for k, v := range shutter.KeyRotations {
+		clone.KeyRotations[k] = v
+	}
+	clone.BatchConfigs = append([]shutterevents.BatchConfig(nil), shutter.BatchConfigs...)
+	clone.Batches = make(map[uint64]*BatchData, len(shutter.Batches))
+	for k, v := range shutter.Batches {
+		clone.Batches[k] = v
+	}
+	clone.Changes = shutter.Changes[:len(shutter.Changes):len(shutter.Changes)]
+	clone.Eons = append([]Eon(nil), shutter.Eons...)
+	for i := range clone.Eons {
+		clone.Eons[i].clip()
+	}
 	return clone
 }
 
@@ -485,20 +711,41 @@ func (shutter *Shutter) GetLastCommittedHeight(ctx context.Context, shmcl client
 
 // SyncToHead syncs the state with the remote state. It fetches events from new blocks since the
 // last sync and updates the state by calling applyEvent for each event. This method does not
-// mutate the object in place, it rather returns a new object.
-func (shutter *Shutter) SyncToHead(ctx context.Context, shmcl client.Client) (*Shutter, error) {
+// mutate the object in place, it rather returns a new object. If the genesis document of
+// shuttermint is given, the blocks are verified against the headers signed by the validators,
+// starting with the genesis validators.
+func (shutter *Shutter) SyncToHead(ctx context.Context, shmcl client.Client, genesis *tmtypes.GenesisDoc) (*Shutter, error) {
+	return shutter.syncToHead(ctx, shmcl, genesis)
+}
+
+// SyncToHeight syncs the state with the remote state until the given height.
+func (shutter *Shutter) SyncToHeight(
+	ctx context.Context,
+	shmcl client.Client,
+	height int64,
+	genesis *tmtypes.GenesisDoc,
+) (*Shutter, error) {
+	return shutter.syncToHeight(ctx, shmcl, height, genesis)
+}
+
+func (shutter *Shutter) syncToHead(ctx context.Context, shmcl client.Client, genesis *tmtypes.GenesisDoc) (*Shutter, error) {
 	height, err := shutter.GetLastCommittedHeight(ctx, shmcl)
 	if err != nil {
 		return nil, err
 	}
-	if height == shutter.CurrentBlock {
+	if height <= shutter.CurrentBlock {
+		// Nothing new or the node is behind the one we've synced with before
 		return shutter, nil
 	}
-	return shutter.SyncToHeight(ctx, shmcl, height)
+	return shutter.syncToHeight(ctx, shmcl, height, genesis)
 }
 
-// SyncToHeight syncs the state with the remote state until the given height.
-func (shutter *Shutter) SyncToHeight(ctx context.Context, shmcl client.Client, height int64) (*Shutter, error) {
+func (shutter *Shutter) syncToHeight(
+	ctx context.Context,
+	shmcl client.Client,
+	height int64,
+	genesis *tmtypes.GenesisDoc,
+) (*Shutter, error) {
 	nodeStatus, err := shmcl.Status(ctx)
 	if err != nil {
 		return nil, pkgErrors.Wrap(err, "failed to get shuttermint status")
@@ -509,7 +756,7 @@ func (shutter *Shutter) SyncToHeight(ctx context.Context, shmcl client.Client, h
 		return nil, err
 	}
 
-	clone, err := shutter.fetchAndApplyEvents(ctx, shmcl, height)
+	clone, err := shutter.fetchAndApplyEvents(ctx, shmcl, height, genesis)
 	if err != nil {
 		return nil, err
 	}
@@ -525,65 +772,3 @@ func (shutter *Shutter) SyncToHeight(ctx context.Context, shmcl client.Client, h
 func (shutter *Shutter) IsSynced() bool {
 	return shutter.NodeStatus == nil || !shutter.NodeStatus.SyncInfo.CatchingUp
 }
-
-// SyncShutter subscribes to new blocks and syncs the shutter object with the head block in a
-// loop. It writes newly synced shutter objects to the shutters channel, as well as errors to the
-// syncErrors channel.
-func SyncShutter(ctx context.Context, shmcl client.Client, shutter *Shutter, shutters chan<- *Shutter, filter <-chan ShutterFilter) error {
-	name := "keyper"
-	query := "tm.event = 'NewBlock'"
-	events, err := shmcl.Subscribe(ctx, name, query)
-	if err != nil {
-		return err
-	}
-
-	reconnect := func() {
-		for {
-			log.Println("Attempting reconnection to Shuttermint")
-
-			ctx2, cancel2 := context.WithTimeout(ctx, shutterReconnectInterval)
-			events, err = shmcl.Subscribe(ctx2, name, query)
-			cancel2()
-
-			if err != nil {
-				// try again, unless context is canceled
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					continue
-				}
-			} else {
-				log.Println("Shuttermint connection regained")
-				return
-			}
-		}
-	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case f := <-filter:
-			shutter = shutter.ApplyFilter(f)
-		case <-events:
-			newShutter, err := shutter.SyncToHead(ctx, shmcl)
-			if err != nil {
-				if err != context.Canceled {
-					log.Printf("Error in Shutter.SyncToHead: %+v", err)
-				}
-			} else {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case shutters <- newShutter:
-				}
-
-				shutter = newShutter
-			}
-		case <-time.After(shuttermintTimeout):
-			log.Println("No Shuttermint blocks received in a long time")
-			reconnect()
-		}
-	}
-}
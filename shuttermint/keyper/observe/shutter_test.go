@@ -56,3 +56,84 @@ func TestFindBatchConfigByBatchIndex(t *testing.T) {
 	assert.Equal(t, int64(2), sh.FindBatchConfigByBatchIndex(10).Height)
 	assert.Equal(t, int64(2), sh.FindBatchConfigByBatchIndex(11).Height)
 }
+
+func TestCloneCopyOnWrite(t *testing.T) {
+	sh := NewShutter()
+	sh.applyEvent(&shutterevents.EonStarted{Eon: 1})
+	sh.applyEvent(&shutterevents.Accusation{Eon: 1, Height: 1})
+	sh.applyEvent(&shutterevents.DecryptionSignature{BatchIndex: 5})
+
+	clone1 := sh.Clone()
+	clone1.applyEvent(&shutterevents.Accusation{Eon: 1, Height: 2})
+	clone1.applyEvent(&shutterevents.DecryptionSignature{BatchIndex: 5})
+	clone1.applyEvent(&shutterevents.EonStarted{Eon: 2})
+
+	clone2 := sh.Clone()
+	clone2.applyEvent(&shutterevents.Accusation{Eon: 1, Height: 3})
+
+	assert.Equal(t, len(sh.Eons), 1)
+	assert.Equal(t, len(sh.Eons[0].Accusations), 1)
+	assert.Equal(t, len(sh.Batches[5].DecryptionSignatures), 1)
+
+	assert.Equal(t, len(clone1.Eons), 2)
+	assert.Equal(t, len(clone1.Eons[0].Accusations), 2)
+	assert.Equal(t, clone1.Eons[0].Accusations[1].Height, int64(2))
+	assert.Equal(t, len(clone1.Batches[5].DecryptionSignatures), 2)
+
+	assert.Equal(t, len(clone2.Eons[0].Accusations), 2)
+	assert.Equal(t, clone2.Eons[0].Accusations[1].Height, int64(3))
+	assert.Equal(t, len(clone2.Batches[5].DecryptionSignatures), 1)
+}
+
+func TestKeyRotation(t *testing.T) {
+	sh := NewShutter()
+	keyper := common.Address{1}
+	key0, key1, key2 := encryptionPublicKey(t), encryptionPublicKey(t), encryptionPublicKey(t)
+	assert.NilError(t, sh.applyCheckIn(shutterevents.CheckIn{
+		Height:              1,
+		Sender:              keyper,
+		EncryptionPublicKey: (*ecies.PublicKey)(key0),
+		ValidatorPublicKey:  []byte("v0"),
+	}))
+	assert.NilError(t, sh.applyKeyRotation(shutterevents.KeyRotation{
+		Height:              2,
+		Sender:              keyper,
+		ActivationHeight:    10,
+		EncryptionPublicKey: (*ecies.PublicKey)(key1),
+		ValidatorPublicKey:  []byte("v1"),
+	}))
+
+	key, ok := sh.EncryptionKeyAt(keyper, 9)
+	assert.Assert(t, ok)
+	assert.Assert(t, key == key0)
+	key, _ = sh.EncryptionKeyAt(keyper, 10)
+	assert.Assert(t, key == key1)
+	// tendermint applies validator updates two blocks after shuttermint activates them
+	validatorKey, _ := sh.ValidatorKeyAt(keyper, 11)
+	assert.DeepEqual(t, validatorKey, []byte("v0"))
+	validatorKey, _ = sh.ValidatorKeyAt(keyper, 12)
+	assert.DeepEqual(t, validatorKey, []byte("v1"))
+	_, ok = sh.EncryptionKeyAt(common.Address{2}, 10)
+	assert.Assert(t, !ok)
+
+	// a second rotation replaces the pending encryption key, but keeps the validator key
+	clone := sh.Clone()
+	assert.NilError(t, clone.applyKeyRotation(shutterevents.KeyRotation{
+		Height:              3,
+		Sender:              keyper,
+		ActivationHeight:    20,
+		EncryptionPublicKey: (*ecies.PublicKey)(key2),
+	}))
+	key, _ = clone.EncryptionKeyAt(keyper, 10)
+	assert.Assert(t, key == key0)
+	key, _ = clone.LatestEncryptionKey(keyper)
+	assert.Assert(t, key == key2)
+	validatorKey, _ = clone.ValidatorKeyAt(keyper, 12)
+	assert.DeepEqual(t, validatorKey, []byte("v1"))
+
+	// the original is not affected
+	key, _ = sh.LatestEncryptionKey(keyper)
+	assert.Assert(t, key == key1)
+
+	shtest.EnsureGobable(t, clone, new(Shutter), shtest.BigIntComparer, encryptionPublicKeyComparer)
+}
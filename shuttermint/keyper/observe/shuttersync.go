@@ -0,0 +1,401 @@
+package observe
+
+import (
+	"context"
+	"log"
+	"time"
+
+	pkgErrors "github.com/pkg/errors"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/rpc/client"
+	rpctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+const (
+	shutterSubscriber = "keyper"
+	txQuery           = "tm.event = 'Tx'"
+	headerQuery       = "tm.event = 'NewBlockHeader'"
+
+	// eventBufferSize is the number of events buffered per subscription. If the buffer is full,
+	// events are dropped and the affected blocks are refilled via TxSearch.
+	eventBufferSize = 1000
+
+	// maxEventDelay is the number of blocks a tx event may arrive later than the header of the
+	// next block. Header and tx events are received on different channels, so they may be
+	// reordered slightly. If the events of a block are still incomplete after that, they are
+	// considered lost.
+	maxEventDelay = 2
+)
+
+// nodeHealth tracks which shuttermint nodes have failed recently, so that we only try them again
+// after a backoff that doubles with every consecutive failure. Nodes that have sent us data that
+// doesn't match the signed headers are not tried again at all.
+type nodeHealth struct {
+	failures  []int
+	retryAt   []time.Time
+	untrusted []bool
+}
+
+func newNodeHealth(numNodes int) *nodeHealth {
+	return &nodeHealth{
+		failures:  make([]int, numNodes),
+		retryAt:   make([]time.Time, numNodes),
+		untrusted: make([]bool, numNodes),
+	}
+}
+
+func (h *nodeHealth) failed(node int, now time.Time) {
+	h.failures[node]++
+	backoff := shutterReconnectInterval
+	for i := 1; i < h.failures[node] && backoff < shutterMaxReconnectInterval; i++ {
+		backoff *= 2
+	}
+	if backoff > shutterMaxReconnectInterval {
+		backoff = shutterMaxReconnectInterval
+	}
+	h.retryAt[node] = now.Add(backoff)
+}
+
+func (h *nodeHealth) succeeded(node int) {
+	h.failures[node] = 0
+	h.retryAt[node] = time.Time{}
+}
+
+func (h *nodeHealth) distrust(node int) {
+	h.untrusted[node] = true
+}
+
+// next returns the node to try after the given one and the time at which to try it. This is the
+// node whose backoff ends first, taking the nodes in turns if there are several. It returns false
+// if we don't trust any of the nodes anymore.
+func (h *nodeHealth) next(node int) (int, time.Time, bool) {
+	numNodes := len(h.failures)
+	best := -1
+	for i := 1; i <= numNodes; i++ {
+		n := (node + i) % numNodes
+		if h.untrusted[n] {
+			continue
+		}
+		if best == -1 || h.retryAt[n].Before(h.retryAt[best]) {
+			best = n
+		}
+	}
+	if best == -1 {
+		return 0, time.Time{}, false
+	}
+	return best, h.retryAt[best], true
+}
+
+// pendingBlock collects the events of a shuttermint block received via subscription.
+type pendingBlock struct {
+	numTxs    int64 // -1 as long as we haven't received the header
+	txResults map[uint32]abcitypes.TxResult
+}
+
+func (block *pendingBlock) isComplete() bool {
+	return block.numTxs >= 0 && int64(len(block.txResults)) == block.numTxs
+}
+
+// blockAssembler puts together shuttermint blocks from the header and tx events we receive via
+// subscription.
+type blockAssembler struct {
+	blocks       map[int64]*pendingBlock
+	latestHeader int64 // height of the latest header received
+}
+
+func newBlockAssembler() *blockAssembler {
+	return &blockAssembler{
+		blocks:       make(map[int64]*pendingBlock),
+		latestHeader: -1,
+	}
+}
+
+func (ba *blockAssembler) getBlock(height int64) *pendingBlock {
+	block, ok := ba.blocks[height]
+	if !ok {
+		block = &pendingBlock{
+			numTxs:    -1,
+			txResults: make(map[uint32]abcitypes.TxResult),
+		}
+		ba.blocks[height] = block
+	}
+	return block
+}
+
+func (ba *blockAssembler) addHeader(height int64, numTxs int64) {
+	ba.getBlock(height).numTxs = numTxs
+	if height > ba.latestHeader {
+		ba.latestHeader = height
+	}
+}
+
+func (ba *blockAssembler) addTx(txResult abcitypes.TxResult) {
+	ba.getBlock(txResult.Height).txResults[txResult.Index] = txResult
+}
+
+// prune forgets about all blocks up to and including the given height.
+func (ba *blockAssembler) prune(height int64) {
+	for h := range ba.blocks {
+		if h <= height {
+			delete(ba.blocks, h)
+		}
+	}
+}
+
+// txs returns the txs of the block sorted by index.
+func (block *pendingBlock) txs() []abcitypes.TxResult {
+	var txs []abcitypes.TxResult
+	for i := int64(0); i < block.numTxs; i++ {
+		txs = append(txs, block.txResults[uint32(i)])
+	}
+	return txs
+}
+
+// applyBlock returns a new shutter object with the events of the given complete block applied.
+// If a genesis document is given, the block is verified against the signed headers first, even if
+// it is empty.
+func (shutter *Shutter) applyBlock(
+	ctx context.Context,
+	shmcl client.Client,
+	genesis *tmtypes.GenesisDoc,
+	height int64,
+	block *pendingBlock,
+) (*Shutter, error) {
+	var clone *Shutter
+	if block.numTxs == 0 {
+		clone = shutter.ShallowClone()
+	} else {
+		clone = shutter.Clone()
+	}
+	if err := clone.verifyAndApplyBlock(ctx, shmcl, genesis, height, block.txs()); err != nil {
+		return nil, err
+	}
+	clone.CurrentBlock = height
+	clone.LastCommittedHeight = height
+	return clone, nil
+}
+
+// SyncShutter syncs the shutter object with the shuttermint chain in a loop. After catching up
+// via TxSearch, it subscribes to the events of new blocks and applies them in order. Events that
+// got lost are refilled via TxSearch. It writes newly synced shutter objects to the shutters
+// channel. If multiple shuttermint nodes are given, it switches to the next one if the current
+// one fails. Nodes that fail, including the ones we couldn't connect to at startup, are tried
+// again after a backoff. The synced state does not depend on the node, so syncing continues where
+// it left off.
+//
+// If the genesis document of shuttermint is given, every block is verified against the block
+// headers signed by the validators, starting with the genesis validators, so that a shuttermint
+// node cannot make us apply events that have not been committed or hide txs from us. A node that
+// sends us data that doesn't match the signed headers is not used anymore. If that leaves no node
+// to sync with, SyncShutter fails with an error.
+func SyncShutter(
+	ctx context.Context,
+	shmcls []client.Client,
+	shutter *Shutter,
+	shutters chan<- *Shutter,
+	filter <-chan ShutterFilter,
+	genesis *tmtypes.GenesisDoc,
+) error {
+	current := 0
+	health := newNodeHealth(len(shmcls))
+	var txs, headers <-chan rpctypes.ResultEvent
+	var ba *blockAssembler
+
+	publish := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case shutters <- shutter:
+			return nil
+		}
+	}
+
+	// connect subscribes to the events of the current node and catches up with it. We subscribe
+	// first, so that we don't miss events of blocks created while catching up.
+	connect := func() error {
+		shmcl := shmcls[current]
+		if !shmcl.IsRunning() {
+			// the node was down when we tried to start its client
+			if err := shmcl.Start(); err != nil {
+				return err
+			}
+		}
+		ctx2, cancel2 := context.WithTimeout(ctx, shutterReconnectInterval)
+		defer cancel2()
+		_ = shmcl.UnsubscribeAll(ctx2, shutterSubscriber)
+
+		var err error
+		txs, err = shmcl.Subscribe(ctx2, shutterSubscriber, txQuery, eventBufferSize)
+		if err != nil {
+			return err
+		}
+		headers, err = shmcl.Subscribe(ctx2, shutterSubscriber, headerQuery, eventBufferSize)
+		if err != nil {
+			return err
+		}
+		ba = newBlockAssembler()
+
+		newShutter, err := shutter.syncToHead(ctx, shmcl, genesis)
+		if err != nil {
+			return err
+		}
+		shutter = newShutter
+		return publish()
+	}
+
+	// fail records that the current node has failed with the given error, which may be nil.
+	fail := func(err error) {
+		if IsVerificationError(err) {
+			log.Printf(
+				"Error: Shuttermint node #%d sent data that doesn't match the signed headers, not using it anymore: %s",
+				current,
+				err,
+			)
+			health.distrust(current)
+			return
+		}
+		health.failed(current, time.Now())
+	}
+
+	// reconnect records the failure of the current node, switches to the next node whose backoff
+	// has ended and connects to it.
+	reconnect := func(cause error) error {
+		fail(cause)
+		for {
+			next, retryAt, ok := health.next(current)
+			if !ok {
+				return pkgErrors.New("none of the Shuttermint nodes sends data that matches the signed headers")
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(retryAt)):
+			}
+			if next != current {
+				current = next
+				log.Printf("Switching to Shuttermint node #%d", current)
+			}
+			log.Println("Attempting reconnection to Shuttermint")
+			err := connect()
+			if err == nil {
+				health.succeeded(current)
+				log.Println("Shuttermint connection regained")
+				return nil
+			}
+			log.Printf("Failed to connect to Shuttermint node #%d: %s", current, err)
+			fail(err)
+		}
+	}
+
+	// advance applies the complete blocks in order and refills the blocks whose events got lost.
+	advance := func() error {
+		changed := false
+		for {
+			for {
+				height := shutter.CurrentBlock + 1
+				block, ok := ba.blocks[height]
+				if !ok || !block.isComplete() {
+					break
+				}
+				if genesis != nil && block.numTxs > 0 && ba.latestHeader <= height {
+					break // the tx results are only signed in the header of the next block
+				}
+				newShutter, err := shutter.applyBlock(ctx, shmcls[current], genesis, height, block)
+				if err != nil {
+					return err
+				}
+				shutter = newShutter
+				changed = true
+			}
+			ba.prune(shutter.CurrentBlock)
+
+			refillHeight := ba.latestHeader - maxEventDelay
+			if refillHeight <= shutter.CurrentBlock {
+				break
+			}
+			log.Printf("Missed events of Shuttermint blocks %d..%d, refilling", shutter.CurrentBlock+1, refillHeight)
+			newShutter, err := shutter.syncToHeight(ctx, shmcls[current], refillHeight, genesis)
+			if err != nil {
+				return err
+			}
+			shutter = newShutter
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		if !shutter.IsSynced() {
+			// we've caught up with a node that was catching up itself, check if it's done
+			nodeStatus, err := shmcls[current].Status(ctx)
+			if err != nil {
+				return err
+			}
+			shutter = shutter.ShallowClone()
+			shutter.NodeStatus = nodeStatus
+		}
+		return publish()
+	}
+
+	err := connect()
+	for err != nil && current+1 < len(shmcls) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Failed to connect to Shuttermint node #%d: %s", current, err)
+		fail(err)
+		current++
+		err = connect()
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case f := <-filter:
+			shutter = shutter.ApplyFilter(f)
+		case ev, ok := <-headers:
+			if !ok {
+				log.Println("Shuttermint subscription closed")
+				if err := reconnect(nil); err != nil {
+					return err
+				}
+				continue
+			}
+			if data, ok := ev.Data.(tmtypes.EventDataNewBlockHeader); ok {
+				ba.addHeader(data.Header.Height, data.NumTxs)
+				err = advance()
+			}
+		case ev, ok := <-txs:
+			if !ok {
+				log.Println("Shuttermint subscription closed")
+				if err := reconnect(nil); err != nil {
+					return err
+				}
+				continue
+			}
+			if data, ok := ev.Data.(tmtypes.EventDataTx); ok {
+				ba.addTx(data.TxResult)
+				err = advance()
+			}
+		case <-time.After(shuttermintTimeout):
+			log.Println("No Shuttermint blocks received in a long time")
+			if err := reconnect(nil); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Error syncing with Shuttermint: %+v", err)
+			if err = reconnect(err); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package observe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+)
+
+func batchConfigTx(height int64, index uint32, configIndex uint64) abcitypes.TxResult {
+	ev := shutterevents.BatchConfig{ConfigIndex: configIndex, Threshold: 1}.MakeABCIEvent()
+	return abcitypes.TxResult{
+		Height: height,
+		Index:  index,
+		Result: abcitypes.ResponseDeliverTx{Events: []abcitypes.Event{ev}},
+	}
+}
+
+func TestBlockAssembler(t *testing.T) {
+	ba := newBlockAssembler()
+
+	// tx events may arrive before the header
+	ba.addTx(batchConfigTx(5, 1, 2))
+	assert.Assert(t, !ba.getBlock(5).isComplete())
+	ba.addHeader(5, 2)
+	assert.Assert(t, !ba.getBlock(5).isComplete())
+	ba.addTx(batchConfigTx(5, 0, 1))
+	assert.Assert(t, ba.getBlock(5).isComplete())
+
+	ba.addHeader(6, 0)
+	assert.Assert(t, ba.getBlock(6).isComplete())
+	assert.Equal(t, ba.latestHeader, int64(6))
+
+	ba.prune(5)
+	_, ok := ba.blocks[5]
+	assert.Assert(t, !ok)
+	_, ok = ba.blocks[6]
+	assert.Assert(t, ok)
+}
+
+func TestNodeHealth(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := newNodeHealth(3)
+
+	// healthy nodes are taken in turns
+	next, retryAt, ok := h.next(0)
+	assert.Assert(t, ok)
+	assert.Equal(t, next, 1)
+	assert.Assert(t, retryAt.IsZero())
+
+	// failed nodes are skipped until their backoff has ended, which doubles with every failure
+	h.failed(1, now)
+	next, _, _ = h.next(0)
+	assert.Equal(t, next, 2)
+	h.failed(2, now)
+	h.failed(2, now)
+	next, retryAt, _ = h.next(0)
+	assert.Equal(t, next, 0)
+	assert.Assert(t, retryAt.IsZero())
+	h.failed(0, now)
+	next, retryAt, _ = h.next(0)
+	assert.Equal(t, next, 1)
+	assert.Equal(t, retryAt, now.Add(shutterReconnectInterval))
+	assert.Equal(t, h.retryAt[2], now.Add(2*shutterReconnectInterval))
+
+	for i := 0; i < 20; i++ {
+		h.failed(2, now)
+	}
+	assert.Equal(t, h.retryAt[2], now.Add(shutterMaxReconnectInterval))
+	h.succeeded(2)
+	next, _, _ = h.next(1)
+	assert.Equal(t, next, 2)
+
+	// untrusted nodes are never taken again
+	h.distrust(2)
+	next, _, _ = h.next(1)
+	assert.Equal(t, next, 0)
+	h.distrust(0)
+	h.distrust(1)
+	_, _, ok = h.next(1)
+	assert.Assert(t, !ok)
+}
+
+func TestApplyBlock(t *testing.T) {
+	ctx := context.Background()
+	ba := newBlockAssembler()
+	ba.addHeader(5, 2)
+	ba.addTx(batchConfigTx(5, 1, 2))
+	ba.addTx(batchConfigTx(5, 0, 1))
+	ba.addHeader(6, 0)
+
+	sh := NewShutter()
+	sh.CurrentBlock = 4
+	sh5, err := sh.applyBlock(ctx, nil, nil, 5, ba.getBlock(5))
+	assert.NilError(t, err)
+	assert.Equal(t, sh5.CurrentBlock, int64(5))
+	assert.Equal(t, len(sh5.BatchConfigs), 2)
+	// txs are applied in order
+	assert.Equal(t, sh5.BatchConfigs[0].ConfigIndex, uint64(1))
+	assert.Equal(t, sh5.BatchConfigs[1].ConfigIndex, uint64(2))
+	// the original object is not modified
+	assert.Equal(t, len(sh.BatchConfigs), 0)
+
+	sh6, err := sh5.applyBlock(ctx, nil, nil, 6, ba.getBlock(6))
+	assert.NilError(t, err)
+	assert.Equal(t, sh6.CurrentBlock, int64(6))
+	assert.Equal(t, len(sh6.BatchConfigs), 2)
+}
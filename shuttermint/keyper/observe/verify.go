@@ -0,0 +1,336 @@
+package observe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+
+	pkgErrors "github.com/pkg/errors"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/rpc/client"
+	tmtypes "github.com/tendermint/tendermint/types"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+)
+
+const (
+	// maxBlockMetas is the number of block headers tendermint returns at most per BlockchainInfo
+	// call.
+	maxBlockMetas = 20
+
+	// maxValidatorsPerPage is the number of validators tendermint returns at most per Validators
+	// call.
+	maxValidatorsPerPage = 100
+
+	// maxUnsignedHeaders is the number of headers we link by their hashes at most before we
+	// check the signatures, so that we don't have to keep too many of them in memory.
+	maxUnsignedHeaders = 500
+)
+
+// Validator is a shuttermint validator.
+type Validator struct {
+	PubKey      []byte // ed25519 public key
+	VotingPower int64
+}
+
+// VerificationError is returned if a shuttermint node sends us data that doesn't match the block
+// headers signed by the validators. Unlike network errors, asking the same node again won't help.
+type VerificationError struct {
+	msg string
+}
+
+func (err *VerificationError) Error() string {
+	return err.msg
+}
+
+func verificationErrorf(format string, args ...interface{}) error {
+	return &VerificationError{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsVerificationError checks if the given error or one it wraps is a VerificationError.
+func IsVerificationError(err error) bool {
+	var verr *VerificationError
+	return pkgErrors.As(err, &verr)
+}
+
+// genesisValidators returns the validators of the given shuttermint genesis document.
+func genesisValidators(genesis *tmtypes.GenesisDoc) ([]Validator, error) {
+	var validators []Validator
+	for _, v := range genesis.Validators {
+		key, ok := v.PubKey.(ed25519.PubKey)
+		if !ok {
+			return nil, pkgErrors.Errorf("genesis validator %s has no ed25519 key", v.Address)
+		}
+		validators = append(validators, Validator{PubKey: key, VotingPower: v.Power})
+	}
+	return validators, nil
+}
+
+// newValidatorSet creates the tendermint validator set with the given validators.
+func newValidatorSet(validators []Validator) (*tmtypes.ValidatorSet, error) {
+	if len(validators) == 0 {
+		return nil, pkgErrors.New("no validators")
+	}
+	var vals []*tmtypes.Validator
+	for _, v := range validators {
+		if len(v.PubKey) != ed25519.PubKeySize {
+			return nil, pkgErrors.Errorf("invalid validator key %X", v.PubKey)
+		}
+		vals = append(vals, tmtypes.NewValidator(ed25519.PubKey(v.PubKey), v.VotingPower))
+	}
+	// Unlike NewValidatorSet, this doesn't panic on duplicate validators
+	valSet := &tmtypes.ValidatorSet{}
+	if err := valSet.UpdateWithChangeSet(vals); err != nil {
+		return nil, err
+	}
+	return valSet, nil
+}
+
+// fetchValidators fetches the validators of the shuttermint block at the given height and checks
+// that their hash is the given one.
+func fetchValidators(
+	ctx context.Context,
+	shmcl client.Client,
+	height int64,
+	hash []byte,
+) ([]Validator, *tmtypes.ValidatorSet, error) {
+	var validators []Validator
+	perPage := maxValidatorsPerPage
+	for page := 1; ; page++ {
+		res, err := shmcl.Validators(ctx, &height, &page, &perPage)
+		if err != nil {
+			return nil, nil, pkgErrors.Wrapf(err, "failed to fetch shuttermint validators at height %d", height)
+		}
+		for _, v := range res.Validators {
+			key, ok := v.PubKey.(ed25519.PubKey)
+			if !ok {
+				return nil, nil, verificationErrorf("shuttermint validator %s at height %d has no ed25519 key", v.Address, height)
+			}
+			validators = append(validators, Validator{PubKey: key, VotingPower: v.VotingPower})
+		}
+		if len(validators) >= res.Total || len(res.Validators) == 0 {
+			break
+		}
+	}
+	valSet, err := newValidatorSet(validators)
+	if err != nil {
+		return nil, nil, verificationErrorf("invalid shuttermint validators at height %d: %s", height, err)
+	}
+	if !bytes.Equal(valSet.Hash(), hash) {
+		return nil, nil, verificationErrorf("shuttermint validators at height %d do not match the signed header", height)
+	}
+	return validators, valSet, nil
+}
+
+// verifyCommit checks that the given header is signed by more than two thirds of the voting power
+// of the given validators.
+func verifyCommit(
+	ctx context.Context,
+	shmcl client.Client,
+	chainID string,
+	validators *tmtypes.ValidatorSet,
+	header *tmtypes.Header,
+) error {
+	height := header.Height
+	res, err := shmcl.Commit(ctx, &height)
+	if err != nil {
+		return pkgErrors.Wrapf(err, "failed to fetch shuttermint commit at height %d", height)
+	}
+	commit := res.Commit
+	if commit == nil || !bytes.Equal(commit.BlockID.Hash, header.Hash()) {
+		return verificationErrorf("shuttermint commit at height %d is not for the header we've got", height)
+	}
+	if err := validators.VerifyCommitLight(chainID, commit.BlockID, height, commit); err != nil {
+		return verificationErrorf("shuttermint header at height %d is not signed by its validators: %s", height, err)
+	}
+	return nil
+}
+
+// verifyHeaders extends the chain of trusted shuttermint headers up to the given height, starting
+// with the validators of the genesis document. Each header must be linked to the one before by its
+// hash and must be signed by the validators the one before has committed to. As all headers
+// signed by the same validators are linked by their hashes, we only check the signatures of the
+// last one. The newly trusted headers are passed to check in order, if it is not nil.
+func (shutter *Shutter) verifyHeaders(
+	ctx context.Context,
+	shmcl client.Client,
+	genesis *tmtypes.GenesisDoc,
+	height int64,
+	check func(*tmtypes.Header) error,
+) error {
+	nextHeight := genesis.InitialHeight
+	var lastHash []byte
+	validators := shutter.TrustedValidators
+	if shutter.TrustedHeader == nil {
+		var err error
+		validators, err = genesisValidators(genesis)
+		if err != nil {
+			return err
+		}
+	} else {
+		nextHeight = shutter.TrustedHeader.Height + 1
+		lastHash = shutter.TrustedHeader.Hash()
+	}
+	valSet, err := newValidatorSet(validators)
+	if err != nil {
+		return pkgErrors.Wrap(err, "invalid trusted shuttermint validators")
+	}
+
+	var unsigned []*tmtypes.Header // linked to the trusted header, but not signed yet
+	for nextHeight <= height {
+		maxHeight := nextHeight + maxBlockMetas - 1
+		if maxHeight > height {
+			maxHeight = height
+		}
+		info, err := shmcl.BlockchainInfo(ctx, nextHeight, maxHeight)
+		if err != nil {
+			return pkgErrors.Wrapf(err, "failed to fetch shuttermint headers %d..%d", nextHeight, maxHeight)
+		}
+		headers := make(map[int64]*tmtypes.Header)
+		for _, meta := range info.BlockMetas {
+			headers[meta.Header.Height] = &meta.Header
+		}
+
+		for ; nextHeight <= maxHeight; nextHeight++ {
+			header, ok := headers[nextHeight]
+			if !ok {
+				return pkgErrors.Errorf("shuttermint header at height %d is missing", nextHeight)
+			}
+			if err := header.ValidateBasic(); err != nil {
+				return verificationErrorf("invalid shuttermint header at height %d: %s", nextHeight, err)
+			}
+			if header.ChainID != genesis.ChainID {
+				return verificationErrorf("shuttermint header at height %d is for chain %s", nextHeight, header.ChainID)
+			}
+			if !bytes.Equal(header.LastBlockID.Hash, lastHash) {
+				return verificationErrorf("shuttermint header at height %d is not linked to the one before", nextHeight)
+			}
+			if !bytes.Equal(header.ValidatorsHash, valSet.Hash()) {
+				return verificationErrorf("shuttermint header at height %d has unexpected validators", nextHeight)
+			}
+			unsigned = append(unsigned, header)
+			lastHash = header.Hash()
+
+			validatorsChange := !bytes.Equal(header.NextValidatorsHash, header.ValidatorsHash)
+			if !validatorsChange && nextHeight < height && len(unsigned) < maxUnsignedHeaders {
+				continue
+			}
+			if err := verifyCommit(ctx, shmcl, genesis.ChainID, valSet, header); err != nil {
+				return err
+			}
+			if check != nil {
+				for _, h := range unsigned {
+					if err := check(h); err != nil {
+						return err
+					}
+				}
+			}
+			if validatorsChange {
+				validators, valSet, err = fetchValidators(ctx, shmcl, nextHeight+1, header.NextValidatorsHash)
+				if err != nil {
+					return err
+				}
+			}
+			shutter.TrustedHeader = header
+			shutter.TrustedValidators = validators
+			unsigned = nil
+		}
+	}
+	return nil
+}
+
+// verifyBlocks verifies the shuttermint blocks after the last verified one up to the given height
+// against the signed headers: The block at that height must have exactly the given txs with the
+// given results, the blocks before must not have any txs. The txs must be sorted by their index in
+// the block. As the results are committed to in the header of the next block, we need that one as
+// well if there are any txs.
+//
+// Blocks created before the upgrade of shuttermint don't have the hash of their events in their tx
+// results, so only their txs can be verified, not their events.
+func (shutter *Shutter) verifyBlocks(
+	ctx context.Context,
+	shmcl client.Client,
+	genesis *tmtypes.GenesisDoc,
+	height int64,
+	txs []abcitypes.TxResult,
+) error {
+	if shutter.VerifiedBlock < shutter.CurrentBlock {
+		log.Printf(
+			"Warning: shuttermint blocks up to height %d have been synced without verification, only verifying their headers",
+			shutter.CurrentBlock,
+		)
+		if err := shutter.verifyHeaders(ctx, shmcl, genesis, shutter.CurrentBlock, nil); err != nil {
+			return err
+		}
+		shutter.VerifiedBlock = shutter.CurrentBlock
+	}
+	if height <= shutter.VerifiedBlock {
+		return nil
+	}
+
+	var blockTxs tmtypes.Txs
+	var results []*abcitypes.ResponseDeliverTx
+	for i := range txs {
+		if txs[i].Height != height || txs[i].Index != uint32(i) {
+			return pkgErrors.Errorf("unexpected tx %d at height %d", txs[i].Index, txs[i].Height)
+		}
+		upgraded := len(txs[i].Result.Data) > 0
+		if upgraded && !bytes.Equal(shutterevents.EventsHash(txs[i].Result.Events), txs[i].Result.Data) {
+			return verificationErrorf("events of tx %d at height %d do not match their hash", i, height)
+		}
+		blockTxs = append(blockTxs, txs[i].Tx)
+		results = append(results, &txs[i].Result)
+	}
+	dataHash := blockTxs.Hash()
+	emptyDataHash := tmtypes.Txs{}.Hash()
+	checkBlock := func(header *tmtypes.Header) error {
+		switch {
+		case header.Height < height && !bytes.Equal(header.DataHash, emptyDataHash):
+			return verificationErrorf("shuttermint block at height %d has txs we haven't got", header.Height)
+		case header.Height == height && !bytes.Equal(header.DataHash, dataHash):
+			return verificationErrorf("txs at height %d do not match the signed header", height)
+		case header.Height == height+1 && !bytes.Equal(header.LastResultsHash, tmtypes.NewResults(results).Hash()):
+			return verificationErrorf("tx results at height %d do not match the signed header", height)
+		}
+		return nil
+	}
+
+	// The last trusted header may belong to a block we haven't verified yet
+	if shutter.TrustedHeader != nil && shutter.TrustedHeader.Height > shutter.VerifiedBlock {
+		if err := checkBlock(shutter.TrustedHeader); err != nil {
+			return err
+		}
+	}
+	trustedHeight := height
+	if len(txs) > 0 {
+		trustedHeight++
+	}
+	if err := shutter.verifyHeaders(ctx, shmcl, genesis, trustedHeight, checkBlock); err != nil {
+		return err
+	}
+	shutter.VerifiedBlock = height
+	return nil
+}
+
+// verifyAndApplyBlock verifies the given txs of the block at the given height if a genesis
+// document to verify against is given, and applies their events. The verification covers the
+// blocks without txs since the last verified one as well.
+func (shutter *Shutter) verifyAndApplyBlock(
+	ctx context.Context,
+	shmcl client.Client,
+	genesis *tmtypes.GenesisDoc,
+	height int64,
+	txs []abcitypes.TxResult,
+) error {
+	if genesis != nil {
+		if err := shutter.verifyBlocks(ctx, shmcl, genesis, height, txs); err != nil {
+			return err
+		}
+	}
+	for _, tx := range txs {
+		shutter.applyTxEvents(height, tx.Result.Events)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package observe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tmversion "github.com/tendermint/tendermint/proto/tendermint/version"
+	"github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"github.com/tendermint/tendermint/version"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+)
+
+// testChain is a shuttermint client serving the headers, commits and validators of a chain.
+type testChain struct {
+	client.Client
+	genesis    *tmtypes.GenesisDoc
+	headers    map[int64]*tmtypes.Header
+	commits    map[int64]*tmtypes.Commit
+	validators map[int64]*tmtypes.ValidatorSet
+}
+
+func (c *testChain) Commit(_ context.Context, height *int64) (*ctypes.ResultCommit, error) {
+	return &ctypes.ResultCommit{
+		SignedHeader: tmtypes.SignedHeader{Header: c.headers[*height], Commit: c.commits[*height]},
+	}, nil
+}
+
+func (c *testChain) BlockchainInfo(_ context.Context, minHeight, maxHeight int64) (*ctypes.ResultBlockchainInfo, error) {
+	res := &ctypes.ResultBlockchainInfo{LastHeight: int64(len(c.headers))}
+	for h := maxHeight; h >= minHeight; h-- {
+		res.BlockMetas = append(res.BlockMetas, &tmtypes.BlockMeta{Header: *c.headers[h]})
+	}
+	return res, nil
+}
+
+func (c *testChain) Validators(_ context.Context, height *int64, _, _ *int) (*ctypes.ResultValidators, error) {
+	validators := c.validators[*height].Validators
+	return &ctypes.ResultValidators{
+		BlockHeight: *height,
+		Validators:  validators,
+		Count:       len(validators),
+		Total:       len(validators),
+	}, nil
+}
+
+func newTestValidators(n int) (*tmtypes.ValidatorSet, []tmtypes.PrivValidator) {
+	var validators []*tmtypes.Validator
+	pvs := make(map[string]tmtypes.PrivValidator)
+	for i := 0; i < n; i++ {
+		pv := tmtypes.NewMockPV()
+		pubKey, _ := pv.GetPubKey()
+		validators = append(validators, tmtypes.NewValidator(pubKey, 10))
+		pvs[string(pubKey.Address())] = pv
+	}
+	valSet := tmtypes.NewValidatorSet(validators)
+	// the votes must be in the order of the validator set
+	var sorted []tmtypes.PrivValidator
+	for _, v := range valSet.Validators {
+		sorted = append(sorted, pvs[string(v.Address)])
+	}
+	return valSet, sorted
+}
+
+func newTestGenesis(valSet *tmtypes.ValidatorSet) *tmtypes.GenesisDoc {
+	genesis := &tmtypes.GenesisDoc{ChainID: "shutter-test", InitialHeight: 1}
+	for _, v := range valSet.Validators {
+		genesis.Validators = append(genesis.Validators, tmtypes.GenesisValidator{
+			Address: v.Address,
+			PubKey:  v.PubKey,
+			Power:   v.VotingPower,
+		})
+	}
+	return genesis
+}
+
+// newTestChain creates a chain of numBlocks blocks with the given txs. The validators are
+// replaced by new ones starting at each of the given heights.
+func newTestChain(
+	t *testing.T,
+	numBlocks int64,
+	txs map[int64][]abcitypes.TxResult,
+	validatorChanges ...int64,
+) *testChain {
+	t.Helper()
+	valSet, pvs := newTestValidators(3)
+	chain := &testChain{
+		genesis:    newTestGenesis(valSet),
+		headers:    make(map[int64]*tmtypes.Header),
+		commits:    make(map[int64]*tmtypes.Commit),
+		validators: make(map[int64]*tmtypes.ValidatorSet),
+	}
+	signers := make(map[int64][]tmtypes.PrivValidator)
+	for h := int64(1); h <= numBlocks+1; h++ {
+		for _, c := range validatorChanges {
+			if c == h {
+				valSet, pvs = newTestValidators(3)
+			}
+		}
+		chain.validators[h] = valSet
+		signers[h] = pvs
+	}
+
+	var lastBlockID tmtypes.BlockID
+	var lastResults []*abcitypes.ResponseDeliverTx
+	for h := int64(1); h <= numBlocks; h++ {
+		var blockTxs tmtypes.Txs
+		var results []*abcitypes.ResponseDeliverTx
+		for i := range txs[h] {
+			blockTxs = append(blockTxs, txs[h][i].Tx)
+			results = append(results, &txs[h][i].Result)
+		}
+		valSet := chain.validators[h]
+		header := &tmtypes.Header{
+			Version:            tmversion.Consensus{Block: version.BlockProtocol},
+			ChainID:            chain.genesis.ChainID,
+			Height:             h,
+			Time:               time.Unix(h, 0),
+			LastBlockID:        lastBlockID,
+			DataHash:           blockTxs.Hash(),
+			LastResultsHash:    tmtypes.NewResults(lastResults).Hash(),
+			ValidatorsHash:     valSet.Hash(),
+			NextValidatorsHash: chain.validators[h+1].Hash(),
+			ProposerAddress:    valSet.Validators[0].Address,
+		}
+		blockID := tmtypes.BlockID{
+			Hash:          header.Hash(),
+			PartSetHeader: tmtypes.PartSetHeader{Total: 1, Hash: header.Hash()},
+		}
+		voteSet := tmtypes.NewVoteSet(header.ChainID, h, 0, tmproto.PrecommitType, valSet)
+		commit, err := tmtypes.MakeCommit(blockID, h, 0, voteSet, signers[h], header.Time)
+		assert.NilError(t, err)
+		chain.headers[h] = header
+		chain.commits[h] = commit
+		lastBlockID = blockID
+		lastResults = results
+	}
+	return chain
+}
+
+// testTx creates a tx with a batch config event, hashed like by an upgraded shuttermint.
+func testTx(height int64, index uint32, configIndex uint64) abcitypes.TxResult {
+	tx := batchConfigTx(height, index, configIndex)
+	tx.Tx = []byte{byte(height), byte(index)}
+	tx.Result.Data = shutterevents.EventsHash(tx.Result.Events)
+	return tx
+}
+
+func TestVerifyBlocks(t *testing.T) {
+	ctx := context.Background()
+	txs := map[int64][]abcitypes.TxResult{
+		3: {testTx(3, 0, 1), testTx(3, 1, 2)},
+		6: {testTx(6, 0, 3)},
+	}
+	chain := newTestChain(t, 8, txs)
+
+	// we start with the genesis validators, so the first blocks can be verified as well
+	sh := NewShutter()
+	assert.NilError(t, sh.verifyAndApplyBlock(ctx, chain, chain.genesis, 3, txs[3]))
+	assert.Equal(t, sh.VerifiedBlock, int64(3))
+	assert.Equal(t, sh.TrustedHeader.Height, int64(4))
+	assert.NilError(t, sh.verifyAndApplyBlock(ctx, chain, chain.genesis, 6, txs[6]))
+	assert.NilError(t, sh.verifyAndApplyBlock(ctx, chain, chain.genesis, 8, nil))
+	assert.Equal(t, sh.VerifiedBlock, int64(8))
+	assert.Equal(t, len(sh.BatchConfigs), 3)
+
+	// txs must not be hidden in the blocks before
+	sh = NewShutter()
+	err := sh.verifyAndApplyBlock(ctx, chain, chain.genesis, 6, txs[6])
+	assert.Assert(t, IsVerificationError(err))
+	assert.Equal(t, len(sh.BatchConfigs), 0)
+	sh = NewShutter()
+	err = sh.verifyBlocks(ctx, chain, chain.genesis, 3, txs[3][:1])
+	assert.Assert(t, IsVerificationError(err))
+
+	// nor in empty blocks
+	sh = NewShutter()
+	assert.NilError(t, sh.verifyBlocks(ctx, chain, chain.genesis, 2, nil))
+	err = sh.verifyBlocks(ctx, chain, chain.genesis, 4, nil)
+	assert.Assert(t, IsVerificationError(err))
+
+	// the events must be the ones the tx results commit to
+	sh = NewShutter()
+	assert.NilError(t, sh.verifyBlocks(ctx, chain, chain.genesis, 3, txs[3]))
+	forged := testTx(6, 0, 3)
+	forged.Result.Events = batchConfigTx(6, 0, 4).Result.Events
+	err = sh.verifyBlocks(ctx, chain, chain.genesis, 6, []abcitypes.TxResult{forged})
+	assert.Assert(t, IsVerificationError(err))
+}
+
+func TestVerifyBlocksValidatorChange(t *testing.T) {
+	ctx := context.Background()
+	txs := map[int64][]abcitypes.TxResult{6: {testTx(6, 0, 1)}}
+	chain := newTestChain(t, 8, txs, 5)
+
+	sh := NewShutter()
+	assert.NilError(t, sh.verifyBlocks(ctx, chain, chain.genesis, 6, txs[6]))
+	valSet, err := newValidatorSet(sh.TrustedValidators)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, valSet.Hash(), chain.validators[7].Hash())
+
+	// the node must not be able to make up the new validators
+	otherValSet, _ := newTestValidators(3)
+	chain.validators[5] = otherValSet
+	sh = NewShutter()
+	err = sh.verifyBlocks(ctx, chain, chain.genesis, 6, txs[6])
+	assert.Assert(t, IsVerificationError(err))
+}
+
+func TestVerifyBlocksWrongGenesis(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, 4, nil)
+	otherValSet, _ := newTestValidators(3)
+
+	sh := NewShutter()
+	err := sh.verifyBlocks(ctx, chain, newTestGenesis(otherValSet), 3, nil)
+	assert.Assert(t, IsVerificationError(err))
+}
+
+func TestVerifyBlocksTooFewSignatures(t *testing.T) {
+	ctx := context.Background()
+	txs := map[int64][]abcitypes.TxResult{3: {testTx(3, 0, 1)}}
+	chain := newTestChain(t, 4, txs)
+
+	// two of three validators are exactly two thirds, which is not enough
+	chain.commits[4].Signatures[2] = tmtypes.NewCommitSigAbsent()
+	sh := NewShutter()
+	err := sh.verifyBlocks(ctx, chain, chain.genesis, 3, txs[3])
+	assert.Assert(t, IsVerificationError(err))
+}
+
+func TestVerifyBlocksUnverifiedState(t *testing.T) {
+	ctx := context.Background()
+	txs := map[int64][]abcitypes.TxResult{
+		3: {testTx(3, 0, 1)},
+		6: {testTx(6, 0, 2)},
+	}
+	chain := newTestChain(t, 7, txs)
+
+	// blocks synced without verification are taken as they are, only the headers are verified
+	sh := NewShutter()
+	sh.CurrentBlock = 4
+	assert.NilError(t, sh.verifyBlocks(ctx, chain, chain.genesis, 6, txs[6]))
+	assert.Equal(t, sh.VerifiedBlock, int64(6))
+}
@@ -0,0 +1,73 @@
+package keyper
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/shutter-network/shutter/shuttermint/medley"
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+// nextRotationBatchIndex returns the batch index at which the eon started at eonStartBatchIndex
+// should be replaced by a new one if its key is rotated every period batches. The key is used
+// for the full period: the result is the end of the period. We only vote for the new eon early,
+// once half of the period has passed, so that its DKG has time to finish before the key is
+// needed. The result is false if it's too early to vote. If the end of the period has passed
+// without a rotation, the eon is replaced at the end of the next period and we vote right away.
+func nextRotationBatchIndex(eonStartBatchIndex, currentBatchIndex, period uint64) (uint64, bool) {
+	if period == 0 || currentBatchIndex < eonStartBatchIndex {
+		return 0, false
+	}
+	passed := currentBatchIndex - eonStartBatchIndex
+	if passed < period/2 {
+		return 0, false
+	}
+	return eonStartBatchIndex + (passed/period+1)*period, true
+}
+
+// maybeRotateEon votes to start a new eon for the current keyper set every EonRotationBatches
+// batches. The new eon starts at a future batch, so that its key is generated ahead of time and
+// the current key stays in use until then. Since the votes only count if enough keypers vote for
+// the same batch, the batch is derived from the start of the latest eon only. If no new eon is
+// started, e.g. because our vote didn't make it into the chain, we vote again after
+// EonRetryBaseDelay blocks.
+func (dcdr *Decider) maybeRotateEon() {
+	if dcdr.Config.EonRotationBatches == 0 || len(dcdr.Shutter.Eons) == 0 || len(dcdr.MainChain.BatchConfigs) == 0 {
+		return
+	}
+	latest := &dcdr.Shutter.Eons[len(dcdr.Shutter.Eons)-1]
+	if _, err := dcdr.State.FindEKGByEon(latest.Eon); err != nil {
+		return // the DKG is still running, has failed, or we're not part of it
+	}
+
+	currentConfig := dcdr.MainChain.CurrentConfig()
+	if !currentConfig.IsActive() {
+		return
+	}
+	currentBatchIndex := currentConfig.BatchIndex(dcdr.MainChain.CurrentBlock)
+	startBatchIndex, ok := nextRotationBatchIndex(
+		latest.StartEvent.BatchIndex, currentBatchIndex, dcdr.Config.EonRotationBatches,
+	)
+	if !ok {
+		return
+	}
+	config, ok := dcdr.MainChain.ConfigForBatchIndex(startBatchIndex)
+	if !ok || !config.IsKeyper(dcdr.Config.Address()) {
+		return
+	}
+
+	nextHeight := dcdr.Shutter.CurrentBlock + 1
+	if dcdr.State.RotationVoteBatchIndex == startBatchIndex &&
+		nextHeight < dcdr.State.RotationVoteHeight+medley.EonRetryBaseDelay {
+		return
+	}
+	if dcdr.State.RotationVoteBatchIndex == startBatchIndex {
+		log.Printf("No new eon started since our rotation vote at block %d, voting again", dcdr.State.RotationVoteHeight)
+	}
+	dcdr.State.RotationVoteBatchIndex = startBatchIndex
+	dcdr.State.RotationVoteHeight = nextHeight
+	dcdr.sendShuttermintMessage(
+		fmt.Sprintf("eon start vote, rotating key of eon %d at batch %d", latest.Eon, startBatchIndex),
+		shmsg.NewEonStartVote(startBatchIndex),
+	)
+}
@@ -0,0 +1,77 @@
+package keyper
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+	"github.com/shutter-network/shutter/shuttermint/medley"
+)
+
+func TestNextRotationBatchIndex(t *testing.T) {
+	_, ok := nextRotationBatchIndex(100, 149, 100)
+	assert.Assert(t, !ok)
+	b, ok := nextRotationBatchIndex(100, 150, 100)
+	assert.Assert(t, ok)
+	assert.Equal(t, b, uint64(200))
+	b, ok = nextRotationBatchIndex(100, 199, 100)
+	assert.Assert(t, ok)
+	assert.Equal(t, b, uint64(200))
+	// too late for the first rotation, rotate at the end of the next period
+	b, ok = nextRotationBatchIndex(100, 210, 100)
+	assert.Assert(t, ok)
+	assert.Equal(t, b, uint64(300))
+	b, ok = nextRotationBatchIndex(100, 250, 100)
+	assert.Assert(t, ok)
+	assert.Equal(t, b, uint64(300))
+	_, ok = nextRotationBatchIndex(100, 150, 0)
+	assert.Assert(t, !ok)
+}
+
+func TestMaybeRotateEon(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	config := Config{SigningKey: key, EonRotationBatches: 100}
+	state := NewState()
+	shutter := observe.NewShutter()
+	shutter.Eons = []observe.Eon{{Eon: 1, StartEvent: shutterevents.EonStarted{Eon: 1, BatchIndex: 0}}}
+	mainChain := observe.NewMainChain(0)
+	mainChain.BatchConfigs = []contract.BatchConfig{{
+		BatchSpan: 10,
+		Keypers:   []common.Address{config.Address()},
+		Threshold: 1,
+	}}
+
+	step := func(mainChainBlock uint64, shutterBlock int64) []fx.IAction {
+		mainChain.CurrentBlock = mainChainBlock
+		shutter.CurrentBlock = shutterBlock
+		dcdr := &Decider{Config: config, State: state, Shutter: shutter, MainChain: mainChain}
+		dcdr.maybeRotateEon()
+		return dcdr.Actions
+	}
+
+	// no rotation unless we have the key of the current eon
+	assert.Equal(t, len(step(500, 10)), 0)
+	state.EKGs = []*EKG{{Eon: 1}}
+	assert.Equal(t, len(step(490, 10)), 0)
+
+	actions := step(500, 10)
+	assert.Equal(t, len(actions), 1)
+	msg := actions[0].(*fx.SendShuttermintMessage).Msg
+	assert.Equal(t, msg.GetEonStartVote().GetStartBatchIndex(), uint64(100))
+
+	// vote again if no new eon has been started
+	assert.Equal(t, len(step(510, 11)), 0)
+	assert.Equal(t, len(step(510, 10+medley.EonRetryBaseDelay)), 1)
+
+	// the new eon starts in the future, so there's nothing to rotate
+	shutter.Eons = append(shutter.Eons, observe.Eon{Eon: 2, StartEvent: shutterevents.EonStarted{Eon: 2, BatchIndex: 100}})
+	state.EKGs = append(state.EKGs, &EKG{Eon: 2})
+	assert.Equal(t, len(step(520, 1000)), 0)
+}
@@ -0,0 +1,471 @@
+package keyper
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+const (
+	// shadowShuttermintTimeout is the number of shuttermint blocks after which we report a
+	// recorded message that hasn't shown up on chain, or a message of ours on chain that we
+	// haven't recorded.
+	shadowShuttermintTimeout = 20
+	// shadowMainChainTimeout is the number of main chain blocks after which we report a recorded
+	// transaction whose effect hasn't shown up on chain.
+	shadowMainChainTimeout = 100
+)
+
+// shadowEntry is a single effect of an action we expect to show up on chain. Key identifies the
+// effect, value holds the arguments that must match.
+type shadowEntry struct {
+	key   string
+	value string
+}
+
+type recordedMessage struct {
+	entry  shadowEntry
+	height int64 // shuttermint height at which we've recorded the message
+}
+
+type observedMessage struct {
+	value  string
+	height int64
+}
+
+type recordedTX struct {
+	action fx.MainChainTX
+	block  uint64 // main chain block at which we've recorded the transaction
+}
+
+// shadowRecorder is used in shadow mode instead of fx.RunEnv. Rather than running the actions
+// the decider comes up with, it records them and compares them with what the keyper running in
+// production with the same key actually did on chain. Divergences are logged.
+//
+// Only actions decided after the first check are compared, since the actions the decider comes up
+// with while catching up have usually been performed by the production keyper long ago.
+//
+// Unless the shadow keyper starts from a copy of the production keyper's state, it deals its own
+// polynomials in the DKGs. Its eon secret key shares then differ from the production keyper's
+// ones, so for these eons we only check that epoch secret key shares have been sent, not what
+// they contain.
+type shadowRecorder struct {
+	address common.Address
+	started bool
+
+	ownDealings map[uint64]bool // eons for which we've dealt a different polynomial on our own
+
+	messages     map[string]recordedMessage // recorded messages not observed yet
+	observed     map[string]observedMessage // messages of ours observed on chain not recorded yet
+	txs          []recordedTX
+	checkedIn    bool
+	syncedHeight int64  // shuttermint height up to which we've looked for our messages
+	syncedBlock  uint64 // main chain block up to which we've looked for our accusations
+
+	NumRecorded    uint64
+	NumDivergences uint64
+}
+
+func newShadowRecorder(address common.Address) *shadowRecorder {
+	return &shadowRecorder{
+		address:     address,
+		ownDealings: make(map[uint64]bool),
+		messages:    make(map[string]recordedMessage),
+		observed:    make(map[string]observedMessage),
+	}
+}
+
+func (r *shadowRecorder) ShortInfo() string {
+	return fmt.Sprintf("shadow: %d recorded, %d divergences", r.NumRecorded, r.NumDivergences)
+}
+
+func (r *shadowRecorder) diverged(format string, args ...interface{}) {
+	r.NumDivergences++
+	log.Printf("Shadow divergence: "+format, args...)
+}
+
+func addressesString(addresses []common.Address) string {
+	var s []string
+	for _, a := range addresses {
+		s = append(s, a.Hex())
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func bytesToAddresses(bs [][]byte) []common.Address {
+	var addresses []common.Address
+	for _, b := range bs {
+		addresses = append(addresses, common.BytesToAddress(b))
+	}
+	return addresses
+}
+
+// messageEntries returns the entries we expect to show up on chain for the given message. Poly
+// commitments and poly evals are random, so we only check that they have been sent, not what
+// they contain. Messages whose effect we cannot observe return no entries.
+func messageEntries(msg *shmsg.Message) []shadowEntry {
+	switch p := msg.Payload.(type) {
+	case *shmsg.Message_CheckIn:
+		return []shadowEntry{{"check-in", hex.EncodeToString(p.CheckIn.EncryptionPublicKey)}}
+	case *shmsg.Message_BatchConfig:
+		bc := p.BatchConfig
+		return []shadowEntry{{
+			fmt.Sprintf("batch config %d", bc.ConfigIndex),
+			fmt.Sprintf("start=%d threshold=%d keypers=%s", bc.StartBatchIndex, bc.Threshold, addressesString(bytesToAddresses(bc.Keypers))),
+		}}
+	case *shmsg.Message_PolyCommitment:
+		return []shadowEntry{{fmt.Sprintf("poly commitment, eon %d", p.PolyCommitment.Eon), ""}}
+	case *shmsg.Message_PolyEval:
+		var entries []shadowEntry
+		for _, receiver := range bytesToAddresses(p.PolyEval.Receivers) {
+			entries = append(entries, shadowEntry{fmt.Sprintf("poly eval, eon %d, receiver %s", p.PolyEval.Eon, receiver.Hex()), ""})
+		}
+		return entries
+	case *shmsg.Message_Accusation:
+		return []shadowEntry{{
+			fmt.Sprintf("accusation, eon %d", p.Accusation.Eon),
+			addressesString(bytesToAddresses(p.Accusation.Accused)),
+		}}
+	case *shmsg.Message_Apology:
+		return []shadowEntry{{
+			fmt.Sprintf("apology, eon %d", p.Apology.Eon),
+			addressesString(bytesToAddresses(p.Apology.Accusers)),
+		}}
+	case *shmsg.Message_EpochSecretKeyShare:
+		return []shadowEntry{{
+			fmt.Sprintf("epoch secret key share, eon %d, epoch %d", p.EpochSecretKeyShare.Eon, p.EpochSecretKeyShare.Epoch),
+			hex.EncodeToString(p.EpochSecretKeyShare.Share),
+		}}
+	case *shmsg.Message_DecryptionSignature:
+		return []shadowEntry{{
+			fmt.Sprintf("decryption signature, batch %d", p.DecryptionSignature.BatchIndex),
+			hex.EncodeToString(p.DecryptionSignature.Signature),
+		}}
+	default:
+		return nil
+	}
+}
+
+// checkDealings looks for eons in which our polynomial differs from the one the production keyper
+// has committed to on chain.
+func (r *shadowRecorder) checkDealings(dkgs []DKG) {
+	for _, dkg := range dkgs {
+		if r.ownDealings[dkg.Eon] || dkg.Pure == nil || dkg.Pure.Polynomial == nil {
+			continue
+		}
+		if int(dkg.Pure.Keyper) >= len(dkg.Pure.Commitments) {
+			continue
+		}
+		committed := dkg.Pure.Commitments[dkg.Pure.Keyper]
+		if committed == nil {
+			continue // we don't know yet what has been sent on chain
+		}
+		if !dkg.Pure.Polynomial.Gammas().Equal(*committed) {
+			log.Printf("Shadow: dealt our own polynomial in eon %d, not comparing epoch secret key shares", dkg.Eon)
+			r.ownDealings[dkg.Eon] = true
+		}
+	}
+}
+
+// Record records the given actions decided on in the given world.
+func (r *shadowRecorder) Record(world observe.World, actions []fx.IAction) {
+	if !r.started {
+		log.Printf("Shadow: ignoring %d actions decided while catching up", len(actions))
+		return
+	}
+	for _, action := range actions {
+		r.NumRecorded++
+		log.Printf("Shadow: recorded %s", action)
+		switch a := action.(type) {
+		case *fx.SendShuttermintMessage:
+			entries := messageEntries(a.Msg)
+			if entries == nil {
+				log.Printf("Shadow: cannot check %s on chain", a)
+			}
+			if share := a.Msg.GetEpochSecretKeyShare(); share != nil && r.ownDealings[share.Eon] {
+				entries[0].value = ""
+			}
+			for _, entry := range entries {
+				r.recordMessage(entry, world.Shutter.CurrentBlock)
+			}
+		case *fx.EonKeyBroadcast:
+			log.Printf("Shadow: cannot check %s on chain", a)
+		case fx.MainChainTX:
+			r.txs = append(r.txs, recordedTX{action: a, block: world.MainChain.CurrentBlock})
+		}
+	}
+}
+
+func (r *shadowRecorder) recordMessage(entry shadowEntry, height int64) {
+	if obs, ok := r.observed[entry.key]; ok {
+		delete(r.observed, entry.key)
+		if obs.value != entry.value {
+			r.diverged("%s: sent %s on chain, but would send %s", entry.key, obs.value, entry.value)
+		}
+		return
+	}
+	r.messages[entry.key] = recordedMessage{entry: entry, height: height}
+}
+
+func (r *shadowRecorder) observeMessage(key string, value string, height int64) {
+	if rec, ok := r.messages[key]; ok {
+		delete(r.messages, key)
+		if rec.entry.value != value {
+			r.diverged("%s: sent %s on chain, but would send %s", key, value, rec.entry.value)
+		}
+		return
+	}
+	if _, ok := r.observed[key]; !ok {
+		r.observed[key] = observedMessage{value: value, height: height}
+	}
+}
+
+// observeMessages collects the messages we've sent according to the shuttermint state since the
+// last call.
+func (r *shadowRecorder) observeMessages(shutter *observe.Shutter) {
+	if shutter.CurrentBlock < r.syncedHeight {
+		return
+	}
+	since := r.syncedHeight
+	r.syncedHeight = shutter.CurrentBlock + 1
+
+	changes := shutter.ChangesSince(since)
+	if changes.Full {
+		log.Printf("Shadow: cannot check messages sent before shuttermint height %d", shutter.ChangesStart)
+		return
+	}
+	if changes.CheckIns && !r.checkedIn && shutter.IsCheckedIn(r.address) {
+		r.checkedIn = true
+		key := (*ecies.PublicKey)(shutter.KeyperEncryptionKeys[r.address]).ExportECDSA()
+		r.observeMessage("check-in", hex.EncodeToString(ethcrypto.CompressPubkey(key)), shutter.CurrentBlock)
+	}
+	if changes.ShutterConfigs {
+		for _, bc := range shutter.BatchConfigs {
+			if bc.Height >= since {
+				r.observeBatchConfig(bc.ConfigIndex, fmt.Sprintf(
+					"start=%d threshold=%d keypers=%s", bc.StartBatchIndex, bc.Threshold, addressesString(bc.Keypers),
+				))
+			}
+		}
+	}
+	for _, eonIndex := range observe.SortedKeys(changes.Eons) {
+		eon, err := shutter.FindEon(eonIndex)
+		if err != nil {
+			continue
+		}
+		for _, c := range eon.GetPolyCommitments(since) {
+			if c.Sender == r.address {
+				r.observeMessage(fmt.Sprintf("poly commitment, eon %d", c.Eon), "", c.Height)
+			}
+		}
+		for _, e := range eon.GetPolyEvals(since) {
+			if e.Sender == r.address {
+				for _, receiver := range e.Receivers {
+					r.observeMessage(fmt.Sprintf("poly eval, eon %d, receiver %s", e.Eon, receiver.Hex()), "", e.Height)
+				}
+			}
+		}
+		for _, a := range eon.GetAccusations(since) {
+			if a.Sender == r.address {
+				r.observeMessage(fmt.Sprintf("accusation, eon %d", a.Eon), addressesString(a.Accused), a.Height)
+			}
+		}
+		for _, a := range eon.GetApologies(since) {
+			if a.Sender == r.address {
+				r.observeMessage(fmt.Sprintf("apology, eon %d", a.Eon), addressesString(a.Accusers), a.Height)
+			}
+		}
+	}
+	for _, eonIndex := range observe.SortedKeys(changes.Shares) {
+		eon, err := shutter.FindEon(eonIndex)
+		if err != nil {
+			continue
+		}
+		for _, s := range eon.GetEpochSecretKeyShares(since) {
+			if s.Sender != r.address {
+				continue
+			}
+			share, err := s.Share.GobEncode()
+			if err != nil {
+				continue
+			}
+			value := hex.EncodeToString(share)
+			if r.ownDealings[s.Eon] {
+				value = ""
+			}
+			key := fmt.Sprintf("epoch secret key share, eon %d, epoch %d", s.Eon, s.Epoch)
+			r.observeMessage(key, value, s.Height)
+		}
+	}
+	for _, batchIndex := range observe.SortedKeys(changes.Signatures) {
+		batch, ok := shutter.Batches[batchIndex]
+		if !ok {
+			continue
+		}
+		for _, s := range batch.DecryptionSignatures {
+			if s.Sender == r.address && s.Height >= since {
+				key := fmt.Sprintf("decryption signature, batch %d", s.BatchIndex)
+				r.observeMessage(key, hex.EncodeToString(s.Signature), s.Height)
+			}
+		}
+	}
+}
+
+// observeBatchConfig handles a new batch config. Batch configs are the result of the keypers'
+// votes, so unlike the other messages they don't tell us what we've sent. We only check that the
+// config we would vote for has been accepted.
+func (r *shadowRecorder) observeBatchConfig(configIndex uint64, value string) {
+	key := fmt.Sprintf("batch config %d", configIndex)
+	if rec, ok := r.messages[key]; ok {
+		delete(r.messages, key)
+		if rec.entry.value != value {
+			r.diverged("%s: %s accepted on chain, but would vote for %s", key, value, rec.entry.value)
+		}
+	}
+}
+
+// checkTX checks if the effect of the given transaction shows up in the main chain state. It
+// returns false if it doesn't yet.
+func (r *shadowRecorder) checkTX(mainChain *observe.MainChain, action fx.MainChainTX) bool {
+	switch a := action.(type) {
+	case *fx.ExecuteCipherBatch:
+		halfStep := 2 * a.BatchIndex
+		if mainChain.NumExecutionHalfSteps <= halfStep {
+			return false
+		}
+		receipt, ok := mainChain.CipherExecutionReceipts[halfStep]
+		if !ok || receipt == nil {
+			r.diverged("%s: cipher batch has been skipped on chain", a)
+			return true
+		}
+		batchHash := transactionsHash(a.Transactions)
+		if receipt.CipherBatchHash != a.CipherBatchHash || !bytes.Equal(receipt.BatchHash[:], batchHash) {
+			r.diverged(
+				"%s: executed with batch hash %s on chain, but would execute with %s",
+				a, hex.EncodeToString(receipt.BatchHash[:]), hex.EncodeToString(batchHash),
+			)
+		}
+		return true
+	case *fx.ExecutePlainBatch:
+		if mainChain.NumExecutionHalfSteps <= 2*a.BatchIndex+1 {
+			return false
+		}
+		// The contract only accepts the transactions submitted for the batch, so the executed
+		// transactions are the ones the batch hash commits to.
+		var plainBatchHash common.Hash
+		if batch, ok := mainChain.Batches[a.BatchIndex]; ok {
+			plainBatchHash = batch.PlainBatchHash
+		}
+		batchHash := transactionsHash(a.Transactions)
+		if !bytes.Equal(plainBatchHash[:], batchHash) {
+			r.diverged(
+				"%s: executed with batch hash %s on chain, but would execute with %s",
+				a, plainBatchHash.Hex(), hex.EncodeToString(batchHash),
+			)
+		}
+		if config, ok := mainChain.ConfigForBatchIndex(a.BatchIndex); ok && config.TransactionGasLimit != a.TransactionGasLimit {
+			r.diverged(
+				"%s: config has transaction gas limit %d on chain, but would execute with %d",
+				a, config.TransactionGasLimit, a.TransactionGasLimit,
+			)
+		}
+		return true
+	case *fx.SkipCipherBatch:
+		halfStep := 2 * a.BatchIndex
+		if mainChain.NumExecutionHalfSteps <= halfStep {
+			return false
+		}
+		if receipt, ok := mainChain.CipherExecutionReceipts[halfStep]; ok && receipt != nil {
+			r.diverged("%s: cipher batch has been executed on chain", a)
+		}
+		return true
+	case *fx.Accuse:
+		_, ok := mainChain.Accusations[a.HalfStep]
+		return ok
+	case *fx.Appeal:
+		accusation, ok := mainChain.Accusations[a.Authorization.HalfStep]
+		return ok && accusation.Appealed
+	case *fx.Slash:
+		// someone else may have been faster or the executor may have appealed in the meantime
+		accusation, ok := mainChain.Accusations[a.HalfStep]
+		return ok && (accusation.Slashed || accusation.Appealed)
+	default:
+		return true
+	}
+}
+
+// observeAccusations checks that we've recorded the accusations sent from our address on chain.
+func (r *shadowRecorder) observeAccusations(mainChain *observe.MainChain, changes observe.ChangeSet) {
+	for _, halfStep := range observe.SortedKeys(changes.Accusations) {
+		accusation, ok := mainChain.Accusations[halfStep]
+		if !ok || accusation.Accuser != r.address || accusation.Appealed || accusation.Slashed {
+			continue
+		}
+		recorded := false
+		for _, tx := range r.txs {
+			if a, ok := tx.action.(*fx.Accuse); ok && a.HalfStep == halfStep {
+				recorded = true
+			}
+		}
+		if !recorded {
+			r.diverged("accused executor of half step %d on chain, but wouldn't", halfStep)
+		}
+	}
+}
+
+// Check compares the recorded actions with the given world and reports divergences. The
+// decider's state is used to tell which DKGs we've taken part in on our own.
+func (r *shadowRecorder) Check(world observe.World, state *State) {
+	r.checkDealings(state.DKGs)
+	if !r.started {
+		r.started = true
+		r.checkedIn = world.Shutter.IsCheckedIn(r.address)
+		r.syncedHeight = world.Shutter.CurrentBlock + 1
+		r.syncedBlock = world.MainChain.CurrentBlock + 1
+		return
+	}
+	r.observeMessages(world.Shutter)
+	if world.MainChain.CurrentBlock >= r.syncedBlock {
+		r.observeAccusations(world.MainChain, world.MainChain.ChangesSince(r.syncedBlock))
+		r.syncedBlock = world.MainChain.CurrentBlock + 1
+	}
+
+	timeout := world.Shutter.CurrentBlock - shadowShuttermintTimeout
+	for key, rec := range r.messages {
+		if rec.height < timeout {
+			delete(r.messages, key)
+			r.diverged("%s: would send %s, but nothing has been sent on chain", key, rec.entry.value)
+		}
+	}
+	for key, obs := range r.observed {
+		if obs.height < timeout {
+			delete(r.observed, key)
+			r.diverged("%s: sent %s on chain, but wouldn't send anything", key, obs.value)
+		}
+	}
+
+	var pending []recordedTX
+	for _, tx := range r.txs {
+		if r.checkTX(world.MainChain, tx.action) {
+			continue
+		}
+		if world.MainChain.CurrentBlock >= tx.block+shadowMainChainTimeout {
+			r.diverged("%s: would send transaction, but it hasn't shown any effect on chain", tx.action)
+			continue
+		}
+		pending = append(pending, tx)
+	}
+	r.txs = pending
+}
@@ -0,0 +1,144 @@
+package keyper
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shlib/puredkg"
+	"github.com/shutter-network/shutter/shlib/shcrypto"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+	"github.com/shutter-network/shutter/shuttermint/keyper/shutterevents"
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+func TestShadowRecorder(t *testing.T) {
+	address := common.Address{1}
+	shutter := observe.NewShutter()
+	shutter.CurrentBlock = 10
+	world := observe.World{Shutter: shutter, MainChain: observe.NewMainChain(0)}
+
+	signDecryption := func(batchIndex uint64, signature []byte) []fx.IAction {
+		return []fx.IAction{&fx.SendShuttermintMessage{
+			Description: "decryption signature",
+			Msg:         shmsg.NewDecryptionSignature(batchIndex, signature),
+		}}
+	}
+	observeSignature := func(batchIndex uint64, signature []byte) {
+		shutter.Batches[batchIndex] = &observe.BatchData{
+			BatchIndex: batchIndex,
+			DecryptionSignatures: []shutterevents.DecryptionSignature{{
+				Height:     shutter.CurrentBlock,
+				BatchIndex: batchIndex,
+				Sender:     address,
+				Signature:  signature,
+			}},
+		}
+		shutter.Changes = append(shutter.Changes, observe.Change{
+			Height: shutter.CurrentBlock,
+			Kind:   observe.SignaturesChanged,
+			Index:  batchIndex,
+		})
+	}
+
+	state := NewState()
+	r := newShadowRecorder(address)
+	// actions decided before the first check are ignored
+	r.Record(world, signDecryption(1, []byte("a")))
+	r.Check(world, state)
+	assert.Equal(t, r.NumRecorded, uint64(0))
+
+	r.Record(world, signDecryption(2, []byte("b")))
+	r.Record(world, signDecryption(3, []byte("c")))
+	shutter.CurrentBlock++
+	observeSignature(2, []byte("b"))
+	observeSignature(3, []byte("x"))
+	r.Check(world, state)
+	assert.Equal(t, r.NumRecorded, uint64(2))
+	assert.Equal(t, r.NumDivergences, uint64(1))
+	assert.Equal(t, len(r.messages), 0)
+
+	// an action that never shows up on chain and a message we wouldn't have sent are reported
+	// after the timeout
+	r.Record(world, signDecryption(4, []byte("d")))
+	shutter.CurrentBlock++
+	observeSignature(5, []byte("e"))
+	r.Check(world, state)
+	assert.Equal(t, r.NumDivergences, uint64(1))
+	shutter.CurrentBlock += shadowShuttermintTimeout + 1
+	r.Check(world, state)
+	assert.Equal(t, r.NumDivergences, uint64(3))
+	assert.Equal(t, len(r.messages), 0)
+	assert.Equal(t, len(r.observed), 0)
+}
+
+func TestShadowRecorderOwnDealing(t *testing.T) {
+	address := common.Address{1}
+	shutter := observe.NewShutter()
+	shutter.CurrentBlock = 10
+	mainChain := observe.NewMainChain(0)
+	world := observe.World{Shutter: shutter, MainChain: mainChain}
+	state := NewState()
+
+	// we've dealt a different polynomial than the one the production keyper has committed to
+	pure := puredkg.NewPureDKG(1, 3, 2, 0)
+	_, _, err := pure.StartPhase1Dealing()
+	assert.NilError(t, err)
+	committed, err := shcrypto.RandomPolynomial(rand.Reader, 1)
+	assert.NilError(t, err)
+	pure.Commitments[0] = committed.Gammas()
+	state.DKGs = append(state.DKGs, DKG{Eon: 1, Pure: &pure})
+
+	r := newShadowRecorder(address)
+	r.Check(world, state)
+	assert.Assert(t, r.ownDealings[1])
+
+	epochID := shcrypto.ComputeEpochID([]byte{5})
+	recorded := shcrypto.ComputeEpochSecretKeyShare((*shcrypto.EonSecretKeyShare)(big.NewInt(1)), epochID)
+	sent := shcrypto.ComputeEpochSecretKeyShare((*shcrypto.EonSecretKeyShare)(big.NewInt(2)), epochID)
+	r.Record(world, []fx.IAction{&fx.SendShuttermintMessage{
+		Description: "epoch secret key share",
+		Msg:         shmsg.NewEpochSecretKeyShare(1, 5, recorded),
+	}})
+	shutter.CurrentBlock++
+	shutter.Eons = append(shutter.Eons, observe.Eon{
+		Eon: 1,
+		EpochSecretKeyShares: []shutterevents.EpochSecretKeyShare{{
+			Height: shutter.CurrentBlock,
+			Sender: address,
+			Eon:    1,
+			Epoch:  5,
+			Share:  sent,
+		}},
+	})
+	shutter.Changes = append(shutter.Changes, observe.Change{
+		Height: shutter.CurrentBlock,
+		Kind:   observe.SharesChanged,
+		Index:  1,
+	})
+	r.Check(world, state)
+	assert.Equal(t, r.NumDivergences, uint64(0))
+	assert.Equal(t, len(r.messages), 0)
+
+	// plain batches must be executed with the transactions submitted for them
+	mainChain.BatchConfigs = []contract.BatchConfig{{BatchSpan: 1, TransactionGasLimit: 100}}
+	mainChain.Batches[3] = &observe.Batch{
+		BatchIndex:        3,
+		PlainTransactions: [][]byte{[]byte("a")},
+		PlainBatchHash:    common.BytesToHash(transactionsHash([][]byte{[]byte("a")})),
+	}
+	r.Record(world, []fx.IAction{
+		&fx.ExecutePlainBatch{BatchIndex: 3, Transactions: [][]byte{[]byte("a")}, TransactionGasLimit: 100},
+		&fx.ExecutePlainBatch{BatchIndex: 4, Transactions: [][]byte{[]byte("b")}, TransactionGasLimit: 100},
+	})
+	mainChain.NumExecutionHalfSteps = 10
+	r.Check(world, state)
+	assert.Equal(t, r.NumDivergences, uint64(1))
+	assert.Equal(t, len(r.txs), 0)
+}
@@ -233,10 +233,13 @@ type CheckIn struct {
 	Height              int64
 	Sender              common.Address
 	EncryptionPublicKey *ecies.PublicKey
+	ValidatorPublicKey  []byte // 32 byte ed25519 public key
 }
 
+// MakeABCIEvent creates the event. The validator key is left out if it's empty, like in the
+// check-ins that happened before it was added.
 func (msg CheckIn) MakeABCIEvent() abcitypes.Event {
-	return abcitypes.Event{
+	ev := abcitypes.Event{
 		Type: evtype.CheckIn,
 		Attributes: []abcitypes.EventAttribute{
 			newAddressPair("Sender", msg.Sender),
@@ -246,6 +249,13 @@ func (msg CheckIn) MakeABCIEvent() abcitypes.Event {
 			},
 		},
 	}
+	if len(msg.ValidatorPublicKey) > 0 {
+		ev.Attributes = append(ev.Attributes, abcitypes.EventAttribute{
+			Key:   []byte("ValidatorPublicKey"),
+			Value: encodeBytes(msg.ValidatorPublicKey),
+		})
+	}
+	return ev
 }
 
 // makeCheckIn creates a CheckInEvent from the given tendermint event of type "shutter.check-in".
@@ -264,9 +274,25 @@ func makeCheckIn(ev abcitypes.Event, height int64) (*CheckIn, error) {
 		return nil, err
 	}
 
+	// Check-ins that happened before the validator key was part of the event don't have it
+	var validatorPublicKey []byte
+	if len(ev.Attributes) > 2 {
+		if err := expectAttributes(ev, "Sender", "EncryptionPublicKey", "ValidatorPublicKey"); err != nil {
+			return nil, err
+		}
+		validatorPublicKey, err = decodeBytes(ev.Attributes[2].Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(validatorPublicKey) == 0 {
+			validatorPublicKey = nil
+		}
+	}
+
 	return &CheckIn{
 		Sender:              sender,
 		EncryptionPublicKey: publicKey,
+		ValidatorPublicKey:  validatorPublicKey,
 		Height:              height,
 	}, nil
 }
@@ -335,21 +361,29 @@ func makeDecryptionSignature(ev abcitypes.Event, height int64) (*DecryptionSigna
 }
 
 // EonStarted is generated by shuttermint when a new eon is started.  The batch index identifies
-// the first batch that belongs to that eon.
+// the first batch that belongs to that eon. Attempt counts the previous eons started for the same
+// batch config whose DKG failed.
 type EonStarted struct {
 	Height     int64
 	Eon        uint64
 	BatchIndex uint64
+	Attempt    uint64
 }
 
+// MakeABCIEvent creates the event. The attempt is left out if it's zero, like in the events
+// created before DKG retries existed.
 func (msg EonStarted) MakeABCIEvent() abcitypes.Event {
-	return abcitypes.Event{
+	ev := abcitypes.Event{
 		Type: evtype.EonStarted,
 		Attributes: []abcitypes.EventAttribute{
 			newUintPair("Eon", msg.Eon),
 			newUintPair("BatchIndex", msg.BatchIndex),
 		},
 	}
+	if msg.Attempt != 0 {
+		ev.Attributes = append(ev.Attributes, newUintPair("Attempt", msg.Attempt))
+	}
+	return ev
 }
 
 // PolyCommitment represents a broadcasted polynomial commitment message.
@@ -531,11 +565,91 @@ func makeEonStarted(ev abcitypes.Event, height int64) (*EonStarted, error) {
 	if err != nil {
 		return nil, err
 	}
+	// events created before DKG retries existed don't have the attempt attribute
+	var attempt uint64
+	if expectAttributes(ev, "Eon", "BatchIndex", "Attempt") == nil {
+		attempt, err = decodeUint64(ev.Attributes[2].Value)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &EonStarted{
 		Height:     height,
 		Eon:        eon,
 		BatchIndex: batchIndex,
+		Attempt:    attempt,
+	}, nil
+}
+
+// KeyRotation is emitted by shuttermint when a keyper schedules new keys. The keys are used from
+// ActivationHeight on. Keys that don't change are nil.
+type KeyRotation struct {
+	Height              int64
+	Sender              common.Address
+	ActivationHeight    int64
+	EncryptionPublicKey *ecies.PublicKey
+	ValidatorPublicKey  []byte // 32 byte ed25519 public key
+}
+
+func (msg KeyRotation) MakeABCIEvent() abcitypes.Event {
+	var encryptionPublicKey []byte
+	if msg.EncryptionPublicKey != nil {
+		encryptionPublicKey = encodeECIESPublicKey(msg.EncryptionPublicKey)
+	}
+	return abcitypes.Event{
+		Type: evtype.KeyRotation,
+		Attributes: []abcitypes.EventAttribute{
+			newAddressPair("Sender", msg.Sender),
+			newUintPair("ActivationHeight", uint64(msg.ActivationHeight)),
+			{
+				Key:   []byte("EncryptionPublicKey"),
+				Value: encryptionPublicKey,
+			},
+			{
+				Key:   []byte("ValidatorPublicKey"),
+				Value: encodeBytes(msg.ValidatorPublicKey),
+			},
+		},
+	}
+}
+
+// makeKeyRotation creates a KeyRotation event from the given tendermint event of type
+// "shutter.key-rotation".
+func makeKeyRotation(ev abcitypes.Event, height int64) (*KeyRotation, error) {
+	err := expectAttributes(ev, "Sender", "ActivationHeight", "EncryptionPublicKey", "ValidatorPublicKey")
+	if err != nil {
+		return nil, err
+	}
+	sender, err := decodeAddress(ev.Attributes[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	activationHeight, err := decodeUint64(ev.Attributes[1].Value)
+	if err != nil {
+		return nil, err
+	}
+	var encryptionPublicKey *ecies.PublicKey
+	if len(ev.Attributes[2].Value) > 0 {
+		encryptionPublicKey, err = decodeECIESPublicKey(ev.Attributes[2].Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	validatorPublicKey, err := decodeBytes(ev.Attributes[3].Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(validatorPublicKey) == 0 {
+		validatorPublicKey = nil
+	}
+
+	return &KeyRotation{
+		Height:              height,
+		Sender:              sender,
+		ActivationHeight:    int64(activationHeight),
+		EncryptionPublicKey: encryptionPublicKey,
+		ValidatorPublicKey:  validatorPublicKey,
 	}, nil
 }
 
@@ -560,6 +674,8 @@ func MakeEvent(ev abcitypes.Event, height int64) (IEvent, error) {
 		return makeApology(ev, height)
 	case evtype.EpochSecretKeyShare:
 		return makeEpochSecretKeyShare(ev, height)
+	case evtype.KeyRotation:
+		return makeKeyRotation(ev, height)
 	default:
 		return nil, errors.Errorf("cannot make event from type %s", ev.Type)
 	}
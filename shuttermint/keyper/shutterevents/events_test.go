@@ -1,6 +1,7 @@
 package shutterevents_test
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
 	"math/big"
@@ -111,6 +112,20 @@ func TestCheckIn(t *testing.T) {
 	publicKey := ecies.ImportECDSAPublic(&privateKeyECDSA.PublicKey)
 	ev := &shutterevents.CheckIn{Sender: sender, EncryptionPublicKey: publicKey}
 	roundtrip(t, ev)
+	ev.ValidatorPublicKey = bytes.Repeat([]byte("x"), 32)
+	roundtrip(t, ev)
+}
+
+func TestKeyRotation(t *testing.T) {
+	privateKeyECDSA, err := ethcrypto.GenerateKey()
+	assert.NilError(t, err)
+	publicKey := ecies.ImportECDSAPublic(&privateKeyECDSA.PublicKey)
+	ev := &shutterevents.KeyRotation{Sender: sender, ActivationHeight: 1000, EncryptionPublicKey: publicKey}
+	roundtrip(t, ev)
+	ev.ValidatorPublicKey = bytes.Repeat([]byte("x"), 32)
+	roundtrip(t, ev)
+	ev.EncryptionPublicKey = nil
+	roundtrip(t, ev)
 }
 
 func TestDecryptionSignature(t *testing.T) {
@@ -125,6 +140,8 @@ func TestDecryptionSignature(t *testing.T) {
 func TestEonStarted(t *testing.T) {
 	ev := &shutterevents.EonStarted{Eon: eon, BatchIndex: 9999}
 	roundtrip(t, ev)
+	ev.Attempt = 3
+	roundtrip(t, ev)
 }
 
 func TestPolyCommitment(t *testing.T) {
@@ -11,4 +11,5 @@ var (
 	PolyCommitment      = "shutter.poly-commitment-registered"
 	PolyEval            = "shutter.poly-eval-registered"
 	EpochSecretKeyShare = "shutter.epoch-secret-key-share"
+	KeyRotation         = "shutter.key-rotation"
 )
@@ -0,0 +1,27 @@
+package shutterevents
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// EventsHash computes the hash of the given events. Tendermint doesn't include the events of a
+// transaction in the results hash of a block, so shuttermint puts the hash of the events into the
+// data field of the transaction result, which is included. This allows keypers to verify the
+// events they receive from a shuttermint node against the signed block headers.
+func EventsHash(events []abcitypes.Event) []byte {
+	h := sha256.New()
+	for i := range events {
+		b, err := events[i].Marshal()
+		if err != nil {
+			panic(err)
+		}
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(b)))
+		h.Write(length[:])
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
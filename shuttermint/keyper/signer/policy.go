@@ -0,0 +1,84 @@
+package signer
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// selectorLength is the length of the method selector at the start of a transaction's data.
+const selectorLength = 4
+
+// Policy restricts what an external signer signs, so that a client of the signer cannot use the
+// key for anything but the keyper's own business. Transactions are only signed if they call one
+// of the allowed methods of the allowed contracts without sending any ether, or if they cancel a
+// pending transaction by sending nothing to the signer itself. Decryption signatures are only
+// given for the configured batcher contract.
+type Policy struct {
+	batcherContract common.Address
+	methods         map[common.Address]map[[selectorLength]byte]ArgsCheck
+}
+
+// ArgsCheck checks the encoded arguments of an allowed method call.
+type ArgsCheck func(args []byte) error
+
+// NewPolicy creates a new Policy that doesn't allow any contract calls yet.
+func NewPolicy(batcherContract common.Address) *Policy {
+	return &Policy{
+		batcherContract: batcherContract,
+		methods:         make(map[common.Address]map[[selectorLength]byte]ArgsCheck),
+	}
+}
+
+// Allow allows calls of the method with the given selector on the given contract. If check is not
+// nil, the call is only allowed if check accepts its arguments.
+func (p *Policy) Allow(contract common.Address, selector []byte, check ArgsCheck) {
+	var sel [selectorLength]byte
+	copy(sel[:], selector)
+	if p.methods[contract] == nil {
+		p.methods[contract] = make(map[[selectorLength]byte]ArgsCheck)
+	}
+	if check == nil {
+		check = func([]byte) error { return nil }
+	}
+	p.methods[contract][sel] = check
+}
+
+// CheckTx checks that the transaction sent by from may be signed.
+func (p *Policy) CheckTx(tx *types.Transaction, from common.Address) error {
+	to := tx.To()
+	if to == nil {
+		return errors.New("refusing to sign contract creation")
+	}
+	if tx.Value().Cmp(big.NewInt(0)) != 0 {
+		return errors.Errorf("refusing to sign transaction sending %s wei", tx.Value())
+	}
+	if *to == from && len(tx.Data()) == 0 {
+		return nil
+	}
+	if len(tx.Data()) < selectorLength {
+		return errors.Errorf("refusing to sign transaction to %s without method call", to.Hex())
+	}
+	var sel [selectorLength]byte
+	copy(sel[:], tx.Data())
+	check, ok := p.methods[*to][sel]
+	if !ok {
+		return errors.Errorf("refusing to sign call of method %x on %s", sel, to.Hex())
+	}
+	if err := check(tx.Data()[selectorLength:]); err != nil {
+		return errors.Wrapf(err, "refusing to sign call of method %x on %s", sel, to.Hex())
+	}
+	return nil
+}
+
+// CheckDecryption checks that the decryption may be signed.
+func (p *Policy) CheckDecryption(d Decryption) error {
+	if d.BatcherContract != p.batcherContract {
+		return errors.Errorf(
+			"refusing to sign decryption for batcher contract %s", d.BatcherContract.Hex(),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package signer
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+// The signer protocol consists of the following JSON-RPC methods:
+//
+//	signer_address() -> address
+//	signer_signMessage(marshaledMsg) -> signature
+//	signer_signDecryption(decryption) -> signature
+//	signer_signTransaction(rawTx, chainID) -> signedRawTx
+//
+// Transactions are passed in their binary encoding as returned by types.Transaction.MarshalBinary.
+// The signer computes the hashes it signs itself, so that it never signs a hash that could be the
+// one of a transaction its Policy doesn't allow.
+const rpcNamespace = "signer"
+
+const rpcTimeout = 30 * time.Second
+
+// RPCSigner is a Signer that delegates to an external signer process. The signatures returned by
+// the external signer are checked before they are used.
+type RPCSigner struct {
+	client  *rpc.Client
+	address common.Address
+}
+
+var _ Signer = &RPCSigner{}
+
+// DialRPCSigner connects to the external signer at the given URL. The URL is either an http(s)
+// URL or the path of a Unix socket.
+func DialRPCSigner(ctx context.Context, rawurl string) (*RPCSigner, error) {
+	client, err := rpc.DialContext(ctx, rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to signer at %s", rawurl)
+	}
+	s := &RPCSigner{client: client}
+	if err := client.CallContext(ctx, &s.address, rpcNamespace+"_address"); err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "failed to query address of signer at %s", rawurl)
+	}
+	return s, nil
+}
+
+// Close closes the connection to the external signer.
+func (s *RPCSigner) Close() {
+	s.client.Close()
+}
+
+// Address returns the address of the external signer's account. It is queried once when
+// connecting.
+func (s *RPCSigner) Address() common.Address {
+	return s.address
+}
+
+// SignMessage asks the external signer to sign the given shuttermint message.
+func (s *RPCSigner) SignMessage(marshaledMsg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	var sig hexutil.Bytes
+	if err := s.client.CallContext(ctx, &sig, rpcNamespace+"_signMessage", hexutil.Bytes(marshaledMsg)); err != nil {
+		return nil, errors.Wrap(err, "external signer failed to sign message")
+	}
+	if err := checkHashSignature(shmsg.MessageHash(marshaledMsg), sig, s.address); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// SignDecryption asks the external signer to sign the given decryption.
+func (s *RPCSigner) SignDecryption(d Decryption) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	var sig hexutil.Bytes
+	if err := s.client.CallContext(ctx, &sig, rpcNamespace+"_signDecryption", newRPCDecryption(d)); err != nil {
+		return nil, errors.Wrap(err, "external signer failed to sign decryption")
+	}
+	if err := checkHashSignature(DecryptionSignatureHash(d), sig, s.address); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// SignTx asks the external signer to sign the given transaction.
+func (s *RPCSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	rawTx, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var signedRawTx hexutil.Bytes
+	err = s.client.CallContext(
+		ctx,
+		&signedRawTx,
+		rpcNamespace+"_signTransaction",
+		hexutil.Bytes(rawTx),
+		(*hexutil.Big)(chainID),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "external signer failed to sign transaction")
+	}
+
+	signedTx := new(types.Transaction)
+	if err := signedTx.UnmarshalBinary(signedRawTx); err != nil {
+		return nil, errors.Wrap(err, "external signer returned invalid transaction")
+	}
+	txSigner := types.LatestSignerForChainID(chainID)
+	if txSigner.Hash(signedTx) != txSigner.Hash(tx) {
+		return nil, errors.New("external signer modified the transaction")
+	}
+	if err := checkTxSignature(signedTx, chainID, s.address); err != nil {
+		return nil, err
+	}
+	return signedTx, nil
+}
+
+// rpcDecryption is the JSON encoding of a Decryption.
+type rpcDecryption struct {
+	BatcherContract common.Address `json:"batcherContract"`
+	BatchIndex      hexutil.Uint64 `json:"batchIndex"`
+	CipherBatchHash common.Hash    `json:"cipherBatchHash"`
+	BatchHash       hexutil.Bytes  `json:"batchHash"`
+}
+
+func newRPCDecryption(d Decryption) rpcDecryption {
+	return rpcDecryption{
+		BatcherContract: d.BatcherContract,
+		BatchIndex:      hexutil.Uint64(d.BatchIndex),
+		CipherBatchHash: d.CipherBatchHash,
+		BatchHash:       d.BatchHash,
+	}
+}
+
+func (d rpcDecryption) decryption() Decryption {
+	return Decryption{
+		BatcherContract: d.BatcherContract,
+		BatchIndex:      uint64(d.BatchIndex),
+		CipherBatchHash: d.CipherBatchHash,
+		BatchHash:       d.BatchHash,
+	}
+}
+
+// Service exposes a Signer via JSON-RPC. It is meant to be registered with an rpc.Server under
+// the "signer" namespace. Transactions and decryptions are only signed if the policy allows them.
+type Service struct {
+	signer Signer
+	policy *Policy
+}
+
+// NewService creates a new Service for the given signer and policy.
+func NewService(s Signer, policy *Policy) *Service {
+	return &Service{signer: s, policy: policy}
+}
+
+// Address implements signer_address.
+func (svc *Service) Address() common.Address {
+	return svc.signer.Address()
+}
+
+// SignMessage implements signer_signMessage.
+func (svc *Service) SignMessage(marshaledMsg hexutil.Bytes) (hexutil.Bytes, error) {
+	return svc.signer.SignMessage(marshaledMsg)
+}
+
+// SignDecryption implements signer_signDecryption.
+func (svc *Service) SignDecryption(d rpcDecryption) (hexutil.Bytes, error) {
+	if err := svc.policy.CheckDecryption(d.decryption()); err != nil {
+		return nil, err
+	}
+	return svc.signer.SignDecryption(d.decryption())
+}
+
+// SignTransaction implements signer_signTransaction.
+func (svc *Service) SignTransaction(rawTx hexutil.Bytes, chainID *hexutil.Big) (hexutil.Bytes, error) {
+	if chainID == nil {
+		return nil, errors.New("missing chain id")
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(rawTx); err != nil {
+		return nil, err
+	}
+	if err := svc.policy.CheckTx(tx, svc.signer.Address()); err != nil {
+		return nil, err
+	}
+	signedTx, err := svc.signer.SignTx(tx, chainID.ToInt())
+	if err != nil {
+		return nil, err
+	}
+	return signedTx.MarshalBinary()
+}
+
+// NewServer creates a JSON-RPC server serving the given signer with the given policy.
+func NewServer(s Signer, policy *Policy) (*rpc.Server, error) {
+	if policy == nil {
+		return nil, errors.New("missing signing policy")
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcNamespace, NewService(s, policy)); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Serve serves the given signer at the given endpoint until the context is canceled. The
+// endpoint is either an http URL or the path of a Unix socket, mirroring the URLs accepted by
+// DialRPCSigner. The endpoint doesn't authenticate its clients, so http URLs must point to a
+// loopback address.
+func Serve(ctx context.Context, s Signer, policy *Policy, endpoint string) error {
+	server, err := NewServer(s, policy)
+	if err != nil {
+		return err
+	}
+	defer server.Stop()
+
+	if strings.HasPrefix(endpoint, "http://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		if err := checkLoopback(u.Host); err != nil {
+			return err
+		}
+		return serveHTTP(ctx, server, u.Host)
+	}
+	return serveUnixSocket(ctx, server, endpoint)
+}
+
+// checkLoopback checks that the given host:port address can only be reached from this machine.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid listen address %s", addr)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.Errorf(
+		"refusing to serve the signer on %s, use a Unix socket or a loopback address, as anyone who can reach it can sign with the key",
+		addr,
+	)
+}
+
+func serveHTTP(ctx context.Context, server *rpc.Server, addr string) error {
+	httpServer := &http.Server{Addr: addr, Handler: server}
+	errorgroup, ctx := errgroup.WithContext(ctx)
+	errorgroup.Go(func() error {
+		err := httpServer.ListenAndServe()
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	})
+	errorgroup.Go(func() error {
+		<-ctx.Done()
+		return httpServer.Shutdown(context.Background())
+	})
+	return errorgroup.Wait()
+}
+
+func serveUnixSocket(ctx context.Context, server *rpc.Server, path string) error {
+	// Only the owner of the socket should be able to request signatures. The socket has to be
+	// created with the right permissions right away, as changing them afterwards leaves a window
+	// in which anyone can connect.
+	oldUmask := syscall.Umask(0o077)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldUmask)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", path)
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	err = server.ServeListener(listener)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+// Package signer abstracts over the way the keyper signs main chain transactions and shuttermint
+// messages. The signing key can either be held by the keyper process itself (LocalSigner) or by
+// an external signer process that is accessed via JSON-RPC over HTTP or a Unix socket
+// (RPCSigner).
+package signer
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+// Signer signs transactions and message hashes on behalf of a single Ethereum account.
+type Signer interface {
+	// Address returns the address of the account.
+	Address() common.Address
+	// SignMessage signs the shuttermint message with the given protobuf encoding, i.e. its
+	// shmsg.MessageHash. The signature is in the [R || S || V] format returned by crypto.Sign.
+	SignMessage(marshaledMsg []byte) ([]byte, error)
+	// SignDecryption signs the DecryptionSignatureHash of the given batch in the same format.
+	SignDecryption(d Decryption) ([]byte, error)
+	// SignTx signs the given transaction for the chain with the given id.
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// Decryption identifies the decryption of a batch a keyper vouches for.
+type Decryption struct {
+	BatcherContract common.Address
+	BatchIndex      uint64
+	CipherBatchHash common.Hash
+	BatchHash       []byte
+}
+
+// Add a prefix to avoid accidentally signing data with special meaning in different context, in
+// particular Ethereum transactions (c.f. EIP191 https://eips.ethereum.org/EIPS/eip-191).
+var decryptionHashPrefix = []byte{0x19, 'd', 'e', 'c', 't', 'x'}
+
+// DecryptionSignatureHash computes a cryptographic hash over the encrypted transactions, the
+// decrypted transactions, the batcher contracts address and the batch index.
+// It's the same hash we compute in the KeyperSlasher.sol's verifyAuthorization.
+func DecryptionSignatureHash(d Decryption) []byte {
+	var batchIndex [8]byte
+	binary.BigEndian.PutUint64(batchIndex[:], d.BatchIndex)
+
+	keccak := sha3.NewLegacyKeccak256()
+	for _, data := range [][]byte{
+		decryptionHashPrefix,
+		d.BatcherContract.Bytes(),
+		batchIndex[:],
+		d.CipherBatchHash.Bytes(),
+		d.BatchHash,
+	} {
+		if _, err := keccak.Write(data); err != nil {
+			panic(err)
+		}
+	}
+	return keccak.Sum(nil)
+}
+
+// LocalSigner signs with a private key held in memory.
+type LocalSigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+var _ Signer = &LocalSigner{}
+
+// NewLocalSigner creates a new LocalSigner for the given private key.
+func NewLocalSigner(key *ecdsa.PrivateKey) *LocalSigner {
+	return &LocalSigner{
+		key:     key,
+		address: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// Address returns the address of the signer's account.
+func (s *LocalSigner) Address() common.Address {
+	return s.address
+}
+
+// SignMessage signs the given shuttermint message.
+func (s *LocalSigner) SignMessage(marshaledMsg []byte) ([]byte, error) {
+	return crypto.Sign(shmsg.MessageHash(marshaledMsg), s.key)
+}
+
+// SignDecryption signs the given decryption.
+func (s *LocalSigner) SignDecryption(d Decryption) ([]byte, error) {
+	return crypto.Sign(DecryptionSignatureHash(d), s.key)
+}
+
+// SignTx signs the given transaction.
+func (s *LocalSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), s.key)
+}
+
+// checkHashSignature checks that sig is a valid signature of hash by address.
+func checkHashSignature(hash []byte, sig []byte, address common.Address) error {
+	pubkey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return errors.Wrap(err, "invalid signature")
+	}
+	if crypto.PubkeyToAddress(*pubkey) != address {
+		return errors.Errorf("signature is not from %s", address.Hex())
+	}
+	return nil
+}
+
+// checkTxSignature checks that tx has been signed by address for the given chain.
+func checkTxSignature(tx *types.Transaction, chainID *big.Int, address common.Address) error {
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return errors.Wrap(err, "invalid transaction signature")
+	}
+	if sender != address {
+		return errors.Errorf("transaction is signed by %s instead of %s", sender.Hex(), address.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package signer
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/shmsg"
+)
+
+var (
+	testContract = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testBatcher  = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	testSelector = []byte{1, 2, 3, 4}
+)
+
+func newTestTx() *types.Transaction {
+	return newTestTxTo(testContract, big.NewInt(0), append(testSelector, 5))
+}
+
+func newTestTxTo(to common.Address, value *big.Int, data []byte) *types.Transaction {
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(5),
+		Nonce:     7,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(100),
+		Gas:       21000,
+		To:        &to,
+		Value:     value,
+		Data:      data,
+	})
+}
+
+func newTestPolicy() *Policy {
+	policy := NewPolicy(testBatcher)
+	policy.Allow(testContract, testSelector, func(args []byte) error {
+		if len(args) != 1 || args[0] != 5 {
+			return errors.New("wrong argument")
+		}
+		return nil
+	})
+	return policy
+}
+
+func newTestDecryption() Decryption {
+	return Decryption{
+		BatcherContract: testBatcher,
+		BatchIndex:      3,
+		CipherBatchHash: common.HexToHash("0x44"),
+		BatchHash:       []byte{5, 5},
+	}
+}
+
+// serveTestSigner serves s on a Unix socket and returns an RPCSigner connected to it.
+func serveTestSigner(t *testing.T, s Signer) *RPCSigner {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	path := filepath.Join(t.TempDir(), "signer.ipc")
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, s, newTestPolicy(), path)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NilError(t, <-done)
+	})
+
+	var rpcSigner *RPCSigner
+	var err error
+	for i := 0; i < 100; i++ {
+		rpcSigner, err = DialRPCSigner(ctx, path)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NilError(t, err)
+	t.Cleanup(rpcSigner.Close)
+	return rpcSigner
+}
+
+func checkSigner(t *testing.T, s Signer, address common.Address) {
+	t.Helper()
+	assert.Equal(t, s.Address(), address)
+
+	decryption := newTestDecryption()
+	sig, err := s.SignDecryption(decryption)
+	assert.NilError(t, err)
+	assert.NilError(t, checkHashSignature(DecryptionSignatureHash(decryption), sig, address))
+
+	chainID := big.NewInt(5)
+	tx := newTestTx()
+	signedTx, err := s.SignTx(tx, chainID)
+	assert.NilError(t, err)
+	assert.NilError(t, checkTxSignature(signedTx, chainID, address))
+	assert.Equal(t, signedTx.Nonce(), tx.Nonce())
+
+	signedMessage, err := shmsg.SignMessageWith(shmsg.NewEonStartVote(1), s.SignMessage)
+	assert.NilError(t, err)
+	signerAddress, err := shmsg.GetSigner(signedMessage)
+	assert.NilError(t, err)
+	assert.Equal(t, signerAddress, address)
+}
+
+func TestLocalSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	checkSigner(t, NewLocalSigner(key), crypto.PubkeyToAddress(key.PublicKey))
+}
+
+func TestRPCSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	s := serveTestSigner(t, NewLocalSigner(key))
+	checkSigner(t, s, crypto.PubkeyToAddress(key.PublicKey))
+}
+
+// lyingSigner claims to sign for another address than the one of its key.
+type lyingSigner struct {
+	*LocalSigner
+	address common.Address
+}
+
+func (s *lyingSigner) Address() common.Address {
+	return s.address
+}
+
+func TestRPCSignerChecksSignatures(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	s := serveTestSigner(t, &lyingSigner{
+		LocalSigner: NewLocalSigner(key),
+		address:     common.HexToAddress("0x2222222222222222222222222222222222222222"),
+	})
+
+	_, err = s.SignMessage([]byte("message"))
+	assert.Assert(t, err != nil)
+	_, err = s.SignDecryption(newTestDecryption())
+	assert.Assert(t, err != nil)
+	_, err = s.SignTx(newTestTx(), big.NewInt(5))
+	assert.Assert(t, err != nil)
+}
+
+func TestServeRefusesPublicHTTP(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	s := NewLocalSigner(key)
+	for _, endpoint := range []string{"http://0.0.0.0:8555", "http://:8555", "http://192.168.1.1:8555"} {
+		err := Serve(context.Background(), s, newTestPolicy(), endpoint)
+		assert.ErrorContains(t, err, "loopback")
+	}
+	assert.NilError(t, checkLoopback("127.0.0.1:8555"))
+	assert.NilError(t, checkLoopback("[::1]:8555"))
+	assert.NilError(t, checkLoopback("localhost:8555"))
+}
+
+func TestRPCSignerPolicy(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	s := serveTestSigner(t, NewLocalSigner(key))
+	chainID := big.NewInt(5)
+
+	// cancellation transactions are allowed
+	_, err = s.SignTx(newTestTxTo(s.Address(), big.NewInt(0), nil), chainID)
+	assert.NilError(t, err)
+
+	for _, tx := range []*types.Transaction{
+		newTestTxTo(testContract, big.NewInt(1), append(testSelector, 5)),
+		newTestTxTo(testContract, big.NewInt(0), []byte{1, 2, 3, 5, 5}),
+		newTestTxTo(testContract, big.NewInt(0), append(testSelector, 6)),
+		newTestTxTo(testBatcher, big.NewInt(0), append(testSelector, 5)),
+		newTestTxTo(testContract, big.NewInt(0), nil),
+		newTestTxTo(s.Address(), big.NewInt(1), nil),
+	} {
+		_, err = s.SignTx(tx, chainID)
+		assert.ErrorContains(t, err, "refusing to sign")
+	}
+
+	decryption := newTestDecryption()
+	decryption.BatcherContract = testContract
+	_, err = s.SignDecryption(decryption)
+	assert.ErrorContains(t, err, "refusing to sign")
+}
+
+func TestServeUnixSocketPermissions(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	path := filepath.Join(t.TempDir(), "signer.ipc")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, NewLocalSigner(key), newTestPolicy(), path)
+	}()
+	defer func() {
+		cancel()
+		assert.NilError(t, <-done)
+	}()
+
+	var info os.FileInfo
+	for i := 0; i < 100; i++ {
+		info, err = os.Stat(path)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm()&0o077, os.FileMode(0))
+}
@@ -0,0 +1,290 @@
+package keyper
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/notify"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+)
+
+const (
+	// appealRetryBlocks is the number of main chain blocks we wait for an appeal we've sent to
+	// show up before sending it again.
+	appealRetryBlocks = 30
+	// slashRetryBlocks is the number of main chain blocks we wait for a slashing we've sent to
+	// show up before sending it again.
+	slashRetryBlocks = 60
+	// finishedSlashingRetentionBlocks is the number of main chain blocks for which we keep a
+	// slashing case after it has reached a final stage.
+	finishedSlashingRetentionBlocks = 50_000
+)
+
+// SlashingStatus is the stage an accusation in the keyper slasher has reached.
+type SlashingStatus int
+
+const (
+	// SlashingAccused means the executor has been accused and can still appeal.
+	SlashingAccused SlashingStatus = iota
+	// SlashingAppealed means the executor has appealed successfully. This is final.
+	SlashingAppealed
+	// SlashingAppealWindowExpired means the executor hasn't appealed within the appeal period and
+	// can be slashed.
+	SlashingAppealWindowExpired
+	// SlashingSlashed means the executor has been slashed. This is final.
+	SlashingSlashed
+)
+
+func (s SlashingStatus) String() string {
+	switch s {
+	case SlashingAccused:
+		return "accused"
+	case SlashingAppealed:
+		return "appealed"
+	case SlashingAppealWindowExpired:
+		return "appeal window expired"
+	case SlashingSlashed:
+		return "slashed"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(s))
+	}
+}
+
+// IsFinal checks if nothing will happen to an accusation in this stage anymore.
+func (s SlashingStatus) IsFinal() bool {
+	return s == SlashingAppealed || s == SlashingSlashed
+}
+
+// SlashingEvent is an entry in the history of a slashing case.
+type SlashingEvent struct {
+	MainChainBlock uint64
+	Description    string
+}
+
+// SlashingCase tracks an accusation in the keyper slasher from the accusation until it has been
+// appealed or the executor has been slashed. History records what has happened to the
+// accusation, including the transactions we've sent.
+type SlashingCase struct {
+	HalfStep        uint64
+	Executor        common.Address
+	Accuser         common.Address
+	AccusedBlock    uint64
+	Status          SlashingStatus
+	AppealSentBlock uint64 // main chain block at which we've sent our last appeal, zero if none
+	NumAppealsSent  int
+	SlashSentBlock  uint64 // main chain block at which we've sent our last slashing, zero if none
+	NumSlashesSent  int
+	History         []SlashingEvent
+}
+
+func (c *SlashingCase) String() string {
+	return fmt.Sprintf(
+		"half step %d, executor %s: %s", c.HalfStep, c.Executor.Hex(), c.Status,
+	)
+}
+
+func (c *SlashingCase) record(block uint64, format string, args ...interface{}) {
+	ev := SlashingEvent{MainChainBlock: block, Description: fmt.Sprintf(format, args...)}
+	c.History = append(c.History, ev)
+	log.Printf("Slashing case for half step %d at block %d: %s", c.HalfStep, block, ev.Description)
+}
+
+// appealDue checks if we should send an appeal, either because we haven't sent one yet or because
+// the last one didn't make it into the chain in time.
+func (c *SlashingCase) appealDue(currentBlock uint64) bool {
+	if c.Status != SlashingAccused && c.Status != SlashingAppealWindowExpired {
+		return false
+	}
+	return c.AppealSentBlock == 0 || currentBlock >= c.AppealSentBlock+appealRetryBlocks
+}
+
+// slashDue checks if we should send a slashing transaction, given that we wait for delay blocks
+// after the appeal window has expired.
+func (c *SlashingCase) slashDue(currentBlock uint64, appealBlocks uint64, delay uint64) bool {
+	if c.Status != SlashingAppealWindowExpired {
+		return false
+	}
+	if currentBlock < c.AccusedBlock+appealBlocks+delay {
+		return false
+	}
+	return c.SlashSentBlock == 0 || currentBlock >= c.SlashSentBlock+slashRetryBlocks
+}
+
+// finishedBlock returns the main chain block at which the case has reached its final stage.
+func (c *SlashingCase) finishedBlock() uint64 {
+	if len(c.History) == 0 {
+		return c.AccusedBlock
+	}
+	return c.History[len(c.History)-1].MainChainBlock
+}
+
+// accusationStatus determines the stage of the given accusation.
+func accusationStatus(accusation *observe.Accusation, mainChain *observe.MainChain) SlashingStatus {
+	switch {
+	case accusation.Slashed:
+		return SlashingSlashed
+	case accusation.Appealed:
+		return SlashingAppealed
+	case mainChain.AppealBlocks != 0 &&
+		mainChain.CurrentBlock >= accusation.BlockNumber+mainChain.AppealBlocks:
+		return SlashingAppealWindowExpired
+	default:
+		return SlashingAccused
+	}
+}
+
+// slashingCase returns the slashing case for the given half step, creating it if it doesn't exist
+// yet.
+func (dcdr *Decider) slashingCase(accusation *observe.Accusation) *SlashingCase {
+	if dcdr.State.Slashings == nil {
+		dcdr.State.Slashings = make(map[uint64]*SlashingCase) // state from before slashings existed
+	}
+	c, ok := dcdr.State.Slashings[accusation.HalfStep]
+	if !ok {
+		c = &SlashingCase{
+			HalfStep:     accusation.HalfStep,
+			Executor:     accusation.Executor,
+			Accuser:      accusation.Accuser,
+			AccusedBlock: accusation.BlockNumber,
+			Status:       SlashingAccused,
+		}
+		dcdr.State.Slashings[accusation.HalfStep] = c
+		c.record(accusation.BlockNumber, "%s accused by %s", accusation.Executor.Hex(), accusation.Accuser.Hex())
+	}
+	return c
+}
+
+// updateSlashingCase moves the slashing case of the given accusation to the stage the accusation
+// has reached on the main chain.
+func (dcdr *Decider) updateSlashingCase(accusation *observe.Accusation) {
+	c := dcdr.slashingCase(accusation)
+	status := accusationStatus(accusation, dcdr.MainChain)
+	if status == c.Status {
+		return
+	}
+	c.record(dcdr.MainChain.CurrentBlock, "%s -> %s", c.Status, status)
+	c.Status = status
+
+	if status == SlashingAppealWindowExpired && accusation.Executor == dcdr.Config.Address() {
+		dcdr.notify(
+			notify.KindAppealDeadline,
+			fmt.Sprint(accusation.HalfStep),
+			"appeal deadline for accusation for half step %d passed at block %d without appeal",
+			accusation.HalfStep,
+			accusation.BlockNumber+dcdr.MainChain.AppealBlocks,
+		)
+	}
+}
+
+// slashingDelay returns the number of main chain blocks we wait after the appeal window of the
+// given case has expired before sending a slashing. A single slashing is enough, so the accuser
+// goes first and the keypers of the batch's config follow one after the other in case it
+// doesn't, staggered like executions.
+func (dcdr *Decider) slashingDelay(c *SlashingCase) uint64 {
+	address := dcdr.Config.Address()
+	if c.Accuser == address {
+		return 0
+	}
+	staggering := dcdr.Config.ExecutionStaggering
+	config, ok := dcdr.MainChain.ConfigForBatchIndex(c.HalfStep / 2)
+	if !ok || len(config.Keypers) == 0 {
+		return staggering
+	}
+	numKeypers := uint64(len(config.Keypers))
+	keyperIndex, ok := config.KeyperIndex(address)
+	if !ok {
+		return (numKeypers + 1) * staggering
+	}
+	place := (c.HalfStep+keyperIndex)%numKeypers + 1
+	return place * staggering
+}
+
+// pruneSlashingCases removes the cases that have reached a final stage long ago.
+func (dcdr *Decider) pruneSlashingCases() {
+	for halfStep, c := range dcdr.State.Slashings {
+		if c.Status.IsFinal() && c.finishedBlock()+finishedSlashingRetentionBlocks < dcdr.MainChain.CurrentBlock {
+			delete(dcdr.State.Slashings, halfStep)
+		}
+	}
+}
+
+// openSlashingCases returns the half steps of the slashing cases which haven't reached a final
+// stage yet, sorted.
+func (dcdr *Decider) openSlashingCases() []uint64 {
+	halfSteps := []uint64{}
+	for halfStep, c := range dcdr.State.Slashings {
+		if !c.Status.IsFinal() {
+			halfSteps = append(halfSteps, halfStep)
+		}
+	}
+	sort.Slice(halfSteps, func(i, j int) bool { return halfSteps[i] < halfSteps[j] })
+	return halfSteps
+}
+
+// handleSlashings tracks the accusations in the keyper slasher through their lifecycle and sends
+// slashing transactions for those that have not been appealed in time. Appeals are sent by
+// maybeAppeal.
+func (dcdr *Decider) handleSlashings() {
+	allAccusations := func() []uint64 {
+		var halfSteps []uint64
+		for halfStep := range dcdr.MainChain.Accusations {
+			halfSteps = append(halfSteps, halfStep)
+		}
+		return halfSteps
+	}
+	for _, halfStep := range dcdr.changedKeys(dcdr.Changes.Accusations, allAccusations) {
+		accusation, ok := dcdr.MainChain.Accusations[halfStep]
+		if !ok {
+			continue
+		}
+		// don't bring back the cases we've pruned already
+		_, known := dcdr.State.Slashings[halfStep]
+		if !known && accusationStatus(accusation, dcdr.MainChain).IsFinal() &&
+			accusation.BlockNumber+finishedSlashingRetentionBlocks < dcdr.MainChain.CurrentBlock {
+			continue
+		}
+		dcdr.updateSlashingCase(accusation)
+	}
+
+	// Open cases change their stage when the appeal window expires, even if the accusation
+	// itself doesn't change.
+	for _, halfStep := range dcdr.openSlashingCases() {
+		c := dcdr.State.Slashings[halfStep]
+		accusation, ok := dcdr.MainChain.Accusations[halfStep]
+		if !ok {
+			// this can happen after a reorg
+			log.Printf("Accusation for half step %d disappeared from main chain, dropping slashing case", halfStep)
+			delete(dcdr.State.Slashings, halfStep)
+			continue
+		}
+		dcdr.updateSlashingCase(accusation)
+
+		if c.Executor == dcdr.Config.Address() {
+			continue
+		}
+		if !c.slashDue(dcdr.MainChain.CurrentBlock, dcdr.MainChain.AppealBlocks, dcdr.slashingDelay(c)) {
+			continue
+		}
+		if c.SlashSentBlock != 0 {
+			c.record(dcdr.MainChain.CurrentBlock, "slashing not included since block %d, retrying", c.SlashSentBlock)
+		}
+		c.SlashSentBlock = dcdr.MainChain.CurrentBlock
+		c.NumSlashesSent++
+		c.record(dcdr.MainChain.CurrentBlock, "sending slashing")
+		dcdr.addAction(&fx.Slash{HalfStep: halfStep})
+	}
+	dcdr.pruneSlashingCases()
+}
+
+// resetPendingSlashingTXs forgets about the appeals and slashings we've sent so that they are
+// sent again if necessary.
+func (st *State) resetPendingSlashingTXs() {
+	for _, c := range st.Slashings {
+		c.AppealSentBlock = 0
+		c.SlashSentBlock = 0
+	}
+}
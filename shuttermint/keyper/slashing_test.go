@@ -0,0 +1,111 @@
+package keyper
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gotest.tools/v3/assert"
+
+	"github.com/shutter-network/shutter/shuttermint/contract"
+	"github.com/shutter-network/shutter/shuttermint/keyper/fx"
+	"github.com/shutter-network/shutter/shuttermint/keyper/observe"
+)
+
+func TestSlashingLifecycle(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	config := Config{SigningKey: key, ExecutionStaggering: 5}
+	state := NewState()
+	mainChain := observe.NewMainChain(0)
+	mainChain.BatchConfigs = []contract.BatchConfig{{
+		BatchSpan: 1,
+		Keypers:   []common.Address{config.Address(), {3}},
+	}}
+	mainChain.AppealBlocks = 10
+	mainChain.CurrentBlock = 100
+	mainChain.Accusations[4] = &observe.Accusation{
+		Executor:    common.Address{1},
+		Accuser:     common.Address{2},
+		HalfStep:    4,
+		BlockNumber: 100,
+	}
+
+	step := func() *Decider {
+		dcdr := &Decider{
+			Config:    config,
+			State:     state,
+			MainChain: mainChain,
+			Changes:   observe.NewChangeSet(),
+		}
+		dcdr.Changes.Full = true
+		dcdr.handleSlashings()
+		return dcdr
+	}
+
+	dcdr := step()
+	c := state.Slashings[4]
+	assert.Equal(t, c.Status, SlashingAccused)
+	assert.Equal(t, len(dcdr.Actions), 0)
+
+	// once the appeal window expired, we give the accuser and the keypers before us some time to
+	// send a slashing, then send one ourselves and retry if it doesn't get included
+	mainChain.CurrentBlock = 110
+	dcdr = step()
+	assert.Equal(t, c.Status, SlashingAppealWindowExpired)
+	assert.Equal(t, len(dcdr.Actions), 0)
+	mainChain.CurrentBlock = 115
+	dcdr = step()
+	assert.DeepEqual(t, dcdr.Actions, []fx.IAction{&fx.Slash{HalfStep: 4}})
+	mainChain.CurrentBlock = 116
+	assert.Equal(t, len(step().Actions), 0)
+	mainChain.CurrentBlock = 115 + slashRetryBlocks
+	assert.Equal(t, len(step().Actions), 1)
+	assert.Equal(t, c.NumSlashesSent, 2)
+
+	mainChain.Accusations[4] = &observe.Accusation{
+		Executor:    common.Address{1},
+		Accuser:     common.Address{2},
+		HalfStep:    4,
+		BlockNumber: 100,
+		Slashed:     true,
+	}
+	step()
+	assert.Equal(t, c.Status, SlashingSlashed)
+	assert.Assert(t, !c.appealDue(mainChain.CurrentBlock))
+	assert.Equal(t, len(dcdr.openSlashingCases()), 0)
+	assert.Assert(t, len(c.History) >= 5)
+
+	// finished cases are dropped eventually and not brought back
+	mainChain.CurrentBlock += finishedSlashingRetentionBlocks + 1
+	step()
+	assert.Equal(t, len(state.Slashings), 0)
+}
+
+func TestSlashingDelay(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	config := Config{SigningKey: key, ExecutionStaggering: 5}
+	mainChain := observe.NewMainChain(0)
+	mainChain.BatchConfigs = []contract.BatchConfig{{
+		BatchSpan: 1,
+		Keypers:   []common.Address{{1}, {2}, config.Address()},
+	}}
+	dcdr := &Decider{Config: config, State: NewState(), MainChain: mainChain}
+
+	// the accuser doesn't wait
+	assert.Equal(t, dcdr.slashingDelay(&SlashingCase{HalfStep: 4, Accuser: config.Address()}), uint64(0))
+	// the keypers take turns depending on the half step
+	assert.Equal(t, dcdr.slashingDelay(&SlashingCase{HalfStep: 4, Accuser: common.Address{1}}), uint64(5))
+	assert.Equal(t, dcdr.slashingDelay(&SlashingCase{HalfStep: 5, Accuser: common.Address{1}}), uint64(10))
+}
+
+func TestAppealDue(t *testing.T) {
+	c := &SlashingCase{Status: SlashingAccused}
+	assert.Assert(t, c.appealDue(5))
+	c.AppealSentBlock = 5
+	assert.Assert(t, !c.appealDue(6))
+	assert.Assert(t, c.appealDue(5+appealRetryBlocks))
+	c.Status = SlashingAppealed
+	assert.Assert(t, !c.appealDue(5+appealRetryBlocks))
+}
@@ -0,0 +1,39 @@
+package medley
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseTokenAmount parses a decimal token amount such as "1.5" and returns it in the token's
+// smallest unit given the number of decimals of the token.
+func ParseTokenAmount(s string, decimals uint8) (*big.Int, error) {
+	amount, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, errors.Errorf("invalid amount '%s'", s)
+	}
+	if amount.Sign() < 0 {
+		return nil, errors.Errorf("amount '%s' must not be negative", s)
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	amount.Mul(amount, new(big.Rat).SetInt(unit))
+	if !amount.IsInt() {
+		return nil, errors.Errorf("amount '%s' has more than %d decimals", s, decimals)
+	}
+	return new(big.Int).Set(amount.Num()), nil
+}
+
+// FormatTokenAmount formats an amount given in the token's smallest unit as a decimal number.
+func FormatTokenAmount(amount *big.Int, decimals uint8) string {
+	if amount == nil {
+		amount = new(big.Int)
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	s := new(big.Rat).SetFrac(amount, unit).FloatString(int(decimals))
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
@@ -0,0 +1,24 @@
+package medley
+
+import (
+	"math/big"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestTokenAmount(t *testing.T) {
+	amount, err := ParseTokenAmount("1.5", 18)
+	assert.NilError(t, err)
+	assert.Equal(t, amount.String(), "1500000000000000000")
+	assert.Equal(t, FormatTokenAmount(amount, 18), "1.5")
+	assert.Equal(t, FormatTokenAmount(big.NewInt(100), 0), "100")
+	assert.Equal(t, FormatTokenAmount(nil, 18), "0")
+
+	_, err = ParseTokenAmount("0.001", 2)
+	assert.ErrorContains(t, err, "decimals")
+	_, err = ParseTokenAmount("-1", 18)
+	assert.ErrorContains(t, err, "negative")
+	_, err = ParseTokenAmount("abc", 18)
+	assert.ErrorContains(t, err, "invalid")
+}
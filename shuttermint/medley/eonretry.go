@@ -0,0 +1,25 @@
+package medley
+
+const (
+	// EonRetryBaseDelay is the number of shuttermint blocks between the start of an eon and the
+	// start of the first retry if its DKG fails.
+	EonRetryBaseDelay int64 = 50
+	// EonRetryMaxDelay is the maximum number of shuttermint blocks between two attempts to
+	// generate an eon key for the same batch config.
+	EonRetryMaxDelay int64 = 50 << 6
+)
+
+// EonRetryDelay returns the number of shuttermint blocks that have to pass after the start of the
+// given attempt to generate an eon key for a batch config before the next attempt can be started.
+// Attempts are counted from zero. The delay doubles with every attempt up to EonRetryMaxDelay.
+// Both shuttermint and the keypers use this to agree on when a failed DKG is retried.
+func EonRetryDelay(attempt uint64) int64 {
+	delay := EonRetryBaseDelay
+	for i := uint64(0); i < attempt && delay < EonRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > EonRetryMaxDelay {
+		return EonRetryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,15 @@
+package medley
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestEonRetryDelay(t *testing.T) {
+	assert.Equal(t, EonRetryDelay(0), EonRetryBaseDelay)
+	assert.Equal(t, EonRetryDelay(1), 2*EonRetryBaseDelay)
+	assert.Equal(t, EonRetryDelay(3), 8*EonRetryBaseDelay)
+	assert.Equal(t, EonRetryDelay(6), EonRetryMaxDelay)
+	assert.Equal(t, EonRetryDelay(1000), EonRetryMaxDelay)
+}
@@ -16,26 +16,39 @@ import (
 // particular Ethereum transactions (c.f. EIP191 https://eips.ethereum.org/EIPS/eip-191).
 var hashPrefix = []byte{0x19, 's', 'h', 'm', 's', 'g'}
 
+// MessageHash returns the hash that is signed for the given marshaled message.
+func MessageHash(marshaled []byte) []byte {
+	hash := sha3.New256()
+	if _, err := hash.Write(hashPrefix); err != nil {
+		panic(err)
+	}
+	if _, err := hash.Write(marshaled); err != nil {
+		panic(err)
+	}
+	return hash.Sum(nil)
+}
+
 // SignMessage signs the given Message with the given private key.
 func SignMessage(msg proto.Message, privkey *ecdsa.PrivateKey) ([]byte, error) {
+	return SignMessageWith(msg, func(marshaled []byte) ([]byte, error) {
+		return crypto.Sign(MessageHash(marshaled), privkey)
+	})
+}
+
+// SignMessageWith signs the given Message with the given function, which is passed the marshaled
+// message and must return a signature of its MessageHash in the format produced by crypto.Sign.
+// This allows signing with keys that are not held in memory.
+func SignMessageWith(msg proto.Message, sign func(marshaled []byte) ([]byte, error)) ([]byte, error) {
 	marshaled, err := proto.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
-	hash := sha3.New256()
-	_, err = hash.Write(hashPrefix)
-	if err != nil {
-		return nil, err
-	}
-	_, err = hash.Write(marshaled)
+	signature, err := sign(marshaled)
 	if err != nil {
 		return nil, err
 	}
-
-	h := hash.Sum(nil)
-	signature, err := crypto.Sign(h, privkey)
-	if err != nil {
-		return nil, err
+	if len(signature) != crypto.SignatureLength {
+		return nil, errors.Errorf("invalid signature length %d", len(signature))
 	}
 
 	return append(signature, marshaled...), nil
@@ -47,17 +60,7 @@ func GetSigner(signedMessage []byte) (common.Address, error) {
 	if len(signedMessage) < crypto.SignatureLength {
 		return signer, errors.New("message too short")
 	}
-	hash := sha3.New256()
-	_, err := hash.Write(hashPrefix)
-	if err != nil {
-		return common.Address{}, err
-	}
-
-	_, err = hash.Write(signedMessage[crypto.SignatureLength:])
-	if err != nil {
-		return common.Address{}, err
-	}
-	h := hash.Sum(nil)
+	h := MessageHash(signedMessage[crypto.SignatureLength:])
 	pubkey, err := crypto.SigToPub(h, signedMessage[:crypto.SignatureLength])
 	if err != nil {
 		return signer, err
@@ -165,6 +165,24 @@ func NewCheckIn(validatorPublicKey []byte, encryptionKey *ecies.PublicKey) *Mess
 	}
 }
 
+// NewKeyRotation creates a new KeyRotation message scheduling new keys from the given shuttermint
+// block height on. Keys that should not change can be nil.
+func NewKeyRotation(activationHeight uint64, validatorPublicKey []byte, encryptionKey *ecies.PublicKey) *Message {
+	var encryptionKeyBytes []byte
+	if encryptionKey != nil {
+		encryptionKeyBytes = crypto.CompressPubkey(encryptionKey.ExportECDSA())
+	}
+	return &Message{
+		Payload: &Message_KeyRotation{
+			KeyRotation: &KeyRotation{
+				ActivationHeight:    activationHeight,
+				ValidatorPublicKey:  validatorPublicKey,
+				EncryptionPublicKey: encryptionKeyBytes,
+			},
+		},
+	}
+}
+
 func NewEpochSecretKeyShare(eon, epoch uint64, share *shcrypto.EpochSecretKeyShare) *Message {
 	encoded, _ := share.GobEncode()
 	return &Message{
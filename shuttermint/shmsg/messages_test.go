@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
 	"gotest.tools/v3/assert"
 
 	"github.com/shutter-network/shutter/shlib/shcrypto"
@@ -42,3 +44,19 @@ func TestNewPolyEvalMsg(t *testing.T) {
 	assert.Equal(t, eon, msg.Eon)
 	assert.DeepEqual(t, receiver.Bytes(), msg.Receivers[0])
 }
+
+func TestNewKeyRotationMsg(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NilError(t, err)
+	encryptionKey := ecies.ImportECDSAPublic(&key.PublicKey)
+
+	msg := NewKeyRotation(100, nil, encryptionKey).GetKeyRotation()
+	assert.Assert(t, msg != nil)
+	assert.Equal(t, msg.ActivationHeight, uint64(100))
+	assert.Equal(t, len(msg.ValidatorPublicKey), 0)
+	assert.DeepEqual(t, msg.EncryptionPublicKey, crypto.CompressPubkey(&key.PublicKey))
+
+	msg = NewKeyRotation(100, []byte("validator key"), nil).GetKeyRotation()
+	assert.DeepEqual(t, msg.ValidatorPublicKey, []byte("validator key"))
+	assert.Equal(t, len(msg.EncryptionPublicKey), 0)
+}